@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/AlexAkulov/clickhouse-backup/pkg/chbackup"
 
@@ -11,7 +16,8 @@ import (
 )
 
 const (
-	defaultConfigPath = "/etc/clickhouse-backup/config.yml"
+	defaultConfigPath    = "/etc/clickhouse-backup/config.yml"
+	defaultKubeStatusDir = "/var/run/clickhouse-backup"
 )
 
 var (
@@ -28,6 +34,8 @@ func main() {
 	cliapp.UsageText = "clickhouse-backup <command> [-t, --tables=<db>.<table>] <backup_name>"
 	cliapp.Description = "Run as 'root' or 'clickhouse' user"
 	cliapp.Version = version
+	cliapp.EnableBashCompletion = true
+	chbackup.Build = chbackup.BuildInfo{Version: version, GitCommit: gitCommit, BuildDate: buildDate}
 
 	cliapp.Flags = []cli.Flag{
 		cli.StringFlag{
@@ -36,12 +44,32 @@ func main() {
 			Usage:  "Config `FILE` name.",
 			EnvVar: "CLICKHOUSE_BACKUP_CONFIG",
 		},
+		cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Don't show progress bars, they are shown only when output is terminal",
+		},
+		cli.BoolFlag{
+			Name:   "kube",
+			Usage:  "Write status of create, upload and restore to status file and exit with 75 when operation may succeed if it's repeated",
+			EnvVar: "CLICKHOUSE_BACKUP_KUBE",
+		},
+		cli.StringFlag{
+			Name:   "kube-status-dir",
+			Value:  defaultKubeStatusDir,
+			Usage:  "Directory of status files written in kube mode",
+			EnvVar: "CLICKHOUSE_BACKUP_KUBE_STATUS_DIR",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "Backup profile from profiles section of config, its tables, remote path and retention are used",
+		},
 	}
 	cliapp.CommandNotFound = func(c *cli.Context, command string) {
 		fmt.Printf("Error. Unknown command: '%s'\n\n", command)
 		cli.ShowAppHelpAndExit(c, 1)
 	}
 
+	cli.AppHelpTemplate += "\n" + chbackup.ExitCodesHelp
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Println("Version:\t", c.App.Version)
 		fmt.Println("Git Commit:\t", gitCommit)
@@ -51,7 +79,7 @@ func main() {
 	cliapp.Commands = []cli.Command{
 		{
 			Name:      "tables",
-			Usage:     "Print list of tables",
+			Usage:     "Print list of tables with engine, size, count of rows and partitions",
 			UsageText: "clickhouse-backup tables",
 			Action: func(c *cli.Context) error {
 				return chbackup.PrintTables(*getConfig(c))
@@ -61,51 +89,138 @@ func main() {
 		{
 			Name:        "create",
 			Usage:       "Create new backup",
-			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] <backup_name>",
+			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--consistency=strict] [--diff-from=<backup_name>] [--include-detached] [--include-system-logs=<tables>] [--continue-on-error] <backup_name>",
 			Description: "Create new backup",
-			Action: func(c *cli.Context) error {
-				return chbackup.CreateBackup(*getConfig(c), c.Args().First(), c.String("t"))
-			},
+			Action: operationAction("create", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				return chbackup.CreateBackup(context.Background(), config, backupName, c.String("t"), chbackup.CreateOptions{
+					Consistency:       c.String("consistency"),
+					DiffFrom:          c.String("diff-from"),
+					IncludeDetached:   c.Bool("include-detached"),
+					IncludeSystemLogs: c.String("include-system-logs"),
+					ContinueOnError:   c.Bool("continue-on-error"),
+				})
+			}),
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "consistency",
+					Hidden: false,
+					Usage:  "Set 'strict' to stop merges and flush Buffer tables while tables are frozen",
+				},
+				cli.StringFlag{
+					Name:   "diff-from",
+					Hidden: false,
+					Usage:  "Hard link parts unchanged since local backup instead of copying them",
+				},
+				cli.BoolFlag{
+					Name:   "include-detached",
+					Hidden: false,
+					Usage:  "Save detached parts of tables to 'detached' directory of backup",
+				},
+				includeSystemLogsFlag,
+				continueOnErrorFlag,
 			),
 		},
 		{
-			Name:      "upload",
-			Usage:     "Upload backup to remote storage",
-			UsageText: "clickhouse-backup upload [--diff-from=<backup_name>] <backup_name>",
-			Action: func(c *cli.Context) error {
-				return chbackup.Upload(*getConfig(c), c.Args().First(), c.String("diff-from"))
-			},
+			Name:         "upload",
+			Usage:        "Upload backup to remote storage",
+			UsageText:    "clickhouse-backup upload [-t, --tables=<db>.<table>] [--diff-from=<backup_name>] [--to=<all|primary|target_name>] <backup_name>",
+			BashComplete: completeBackups(false),
+			Action: operationAction("upload", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				return chbackup.Upload(context.Background(), config, backupName, c.String("t"), c.String("diff-from"), c.String("to"))
+			}),
 			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+				},
 				cli.StringFlag{
 					Name:   "diff-from",
 					Hidden: false,
 				},
+				cli.StringFlag{
+					Name:   "to, target",
+					Hidden: false,
+					Usage:  "Upload to 'primary' remote storage, to named remote target or to 'all' of them",
+				},
+			),
+		},
+		{
+			Name:      "create_remote",
+			Usage:     "Create new backup, upload it and remove old local and remote backups",
+			UsageText: "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--consistency=strict] [--diff-from=<backup_name>] [--include-detached] [--include-system-logs=<tables>] [--continue-on-error] [--to=<all|primary|target_name>] [--delete-local] <backup_name>",
+			Action: operationAction("create_remote", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				return chbackup.CreateRemoteBackup(context.Background(), config, backupName, c.String("t"), chbackup.CreateRemoteOptions{
+					CreateOptions: chbackup.CreateOptions{
+						Consistency:       c.String("consistency"),
+						DiffFrom:          c.String("diff-from"),
+						IncludeDetached:   c.Bool("include-detached"),
+						IncludeSystemLogs: c.String("include-system-logs"),
+						ContinueOnError:   c.Bool("continue-on-error"),
+					},
+					Target:      c.String("to"),
+					DeleteLocal: c.Bool("delete-local"),
+				})
+			}),
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "consistency",
+					Hidden: false,
+					Usage:  "Set 'strict' to stop merges and flush Buffer tables while tables are frozen",
+				},
+				cli.StringFlag{
+					Name:   "diff-from",
+					Hidden: false,
+					Usage:  "Hard link parts unchanged since local backup instead of copying them",
+				},
+				cli.BoolFlag{
+					Name:   "include-detached",
+					Hidden: false,
+					Usage:  "Save detached parts of tables to 'detached' directory of backup",
+				},
+				includeSystemLogsFlag,
+				continueOnErrorFlag,
+				cli.StringFlag{
+					Name:   "to, target",
+					Hidden: false,
+					Usage:  "Upload to 'primary' remote storage, to named remote target or to 'all' of them",
+				},
+				cli.BoolFlag{
+					Name:   "delete-local",
+					Hidden: false,
+					Usage:  "Remove local backup after successful upload",
+				},
 			),
 		},
 		{
 			Name:      "list",
 			Usage:     "Print list of backups",
-			UsageText: "clickhouse-backup list [all|local|remote] [latest|penult]",
+			UsageText: "clickhouse-backup list [--target=<all|primary|target_name>] [--cluster] [all|local|remote] [latest|penult]",
 			Action: func(c *cli.Context) error {
 				config := getConfig(c)
+				if c.Bool("cluster") {
+					return chbackup.PrintClusterBackups(*config, c.String("target"))
+				}
 				switch c.Args().Get(0) {
 				case "local":
 					return chbackup.PrintLocalBackups(*config, c.Args().Get(1))
 				case "remote":
-					return chbackup.PrintRemoteBackups(*config, c.Args().Get(1))
+					return chbackup.PrintRemoteBackups(*config, c.Args().Get(1), c.String("target"))
 				case "all", "":
 					fmt.Println("Local backups:")
 					if err := chbackup.PrintLocalBackups(*config, c.Args().Get(1)); err != nil {
 						return err
 					}
-					if config.General.RemoteStorage != "none" {
+					if config.General.RemoteStorage != "none" || c.String("target") != "" {
 						fmt.Println("Remote backups:")
-						if err := chbackup.PrintRemoteBackups(*config, c.Args().Get(1)); err != nil {
+						if err := chbackup.PrintRemoteBackups(*config, c.Args().Get(1), c.String("target")); err != nil {
 							return err
 						}
 					}
@@ -115,24 +230,27 @@ func main() {
 				}
 				return nil
 			},
-			Flags: cliapp.Flags,
-		},
-		{
-			Name:      "download",
-			Usage:     "Download backup from remote storage",
-			UsageText: "clickhouse-backup download <backup_name>",
-			Action: func(c *cli.Context) error {
-				return chbackup.Download(*getConfig(c), c.Args().First())
-			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "List backups on 'primary' remote storage, on named remote target or on 'all' of them",
+				},
+				cli.BoolFlag{
+					Name:   "cluster",
+					Hidden: false,
+					Usage:  "List remote backups of all shards uploaded to '<path>/<shard>' and show which shards have them",
+				},
+			),
 		},
 		{
-			Name:      "restore",
-			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore [--schema] [--data] [-t, --tables=<db>.<table>] <backup_name>",
-			Action: func(c *cli.Context) error {
-				return chbackup.Restore(*getConfig(c), c.Args().First(), c.String("t"), c.Bool("s"), c.Bool("d"), c.Bool("rm"))
-			},
+			Name:         "download",
+			Usage:        "Download backup from remote storage",
+			UsageText:    "clickhouse-backup download [-t, --tables=<db>.<table>] [--schema] <backup_name>",
+			BashComplete: completeBackups(true),
+			Action: operationAction("download", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				return chbackup.Download(context.Background(), config, backupName, c.String("t"), c.Bool("s"))
+			}),
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
 					Name:   "table, tables, t",
@@ -141,41 +259,254 @@ func main() {
 				cli.BoolFlag{
 					Name:   "schema, s",
 					Hidden: false,
-					Usage:  "Restore schema only",
-				},
-				cli.BoolFlag{
-					Name:   "data, d",
-					Hidden: false,
-					Usage:  "Restore data only",
+					Usage:  "Download schema only",
 				},
-				cli.BoolFlag{
-					Name:   "rm, drop",
+			),
+		},
+		{
+			Name:         "restore",
+			Usage:        "Create schema and restore data from backup",
+			UsageText:    "clickhouse-backup restore [--schema] [--data] [-t, --tables=<db>.<table>] [--convert-engine=<plain|replicated>] [--substitute-macros] [--rm, --drop [--drop-replica]] [--rewrite-ddl] [--validate] [--rehearsal] [--continue-on-error] [--reshard=<backup_name>,...] <backup_name>",
+			BashComplete: completeBackups(false),
+			Action: operationAction("restore", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				if err := confirmRestore(c, config, backupName); err != nil {
+					return err
+				}
+				_, err := chbackup.Restore(context.Background(), config, backupName, c.String("t"), getRestoreOptions(c))
+				return err
+			}),
+			Flags: append(cliapp.Flags, restoreFlags...),
+		},
+		{
+			Name:         "restore_remote",
+			Usage:        "Download backup unless it exists locally and restore it",
+			UsageText:    "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [--convert-engine=<plain|replicated>] [--substitute-macros] [--rm, --drop [--drop-replica]] [--rewrite-ddl] [--validate] [--rehearsal] [--continue-on-error] [--reshard=<backup_name>,...] [--wait=<duration>] <backup_name>",
+			BashComplete: completeBackups(true),
+			Action: operationAction("restore_remote", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				if wait := c.Duration("wait"); wait > 0 {
+					if err := chbackup.WaitForRemoteBackup(config, backupName, wait); err != nil {
+						return err
+					}
+				}
+				if err := confirmRestore(c, config, backupName); err != nil {
+					return err
+				}
+				_, err := chbackup.RestoreRemoteBackup(context.Background(), config, backupName, c.String("t"), getRestoreOptions(c))
+				return err
+			}),
+			Flags: append(append(cliapp.Flags, restoreFlags...),
+				cli.DurationFlag{
+					Name:   "wait",
 					Hidden: false,
-					Usage:  "Drop table before restore",
+					Usage:  "Wait until backup is uploaded to remote storage, e.g. when restore runs in init container",
+					EnvVar: "CLICKHOUSE_BACKUP_WAIT",
 				},
 			),
 		},
+		{
+			Name:         "restore_keeper",
+			Usage:        "Restore snapshots and logs of Keeper from local backup to empty directories of stopped Keeper",
+			UsageText:    "clickhouse-backup restore_keeper <backup_name>",
+			BashComplete: completeBackups(false),
+			Action: operationAction("restore_keeper", func(c *cli.Context, config chbackup.Config, backupName string) error {
+				return chbackup.RestoreKeeper(config, backupName)
+			}),
+			Flags: cliapp.Flags,
+		},
 		{
 			Name:      "delete",
 			Usage:     "Delete specific backup",
-			UsageText: "clickhouse-backup delete <local|remote> <backup_name>",
+			UsageText: "clickhouse-backup delete [--target=<all|primary|target_name>] [--force] <local|remote> <backup_name>",
 			Action: func(c *cli.Context) error {
 				config := getConfig(c)
 				if c.Args().Get(1) == "" {
 					log.Println("Backup name must be defined")
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
+				if c.Args().Get(0) == "local" || c.Args().Get(0) == "remote" {
+					message, err := chbackup.DeleteMessage(*config, c.Args().Get(0), c.Args().Get(1), c.String("target"), c.Bool("force"))
+					if err != nil {
+						return err
+					}
+					if err := confirm(c, message); err != nil {
+						return err
+					}
+				}
 				switch c.Args().Get(0) {
 				case "local":
-					return chbackup.RemoveBackupLocal(*config, c.Args().Get(1))
+					return withLock(*config, "delete", c.Args().Get(1), func() error {
+						return chbackup.RemoveBackupLocal(*config, c.Args().Get(1), c.Bool("force"))
+					})
 				case "remote":
-					return chbackup.RemoveBackupRemote(*config, c.Args().Get(1))
+					return withLock(*config, "delete", c.Args().Get(1), func() error {
+						return chbackup.RemoveBackupRemote(*config, c.Args().Get(1), c.String("target"), c.Bool("force"))
+					})
 				default:
 					log.Printf("Unknown command '%s'\n", c.Args().Get(0))
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
 				return nil
 			},
+			BashComplete: func(c *cli.Context) {
+				switch {
+				case completeFlags(c):
+				case c.NArg() == 0:
+					fmt.Println("local")
+					fmt.Println("remote")
+				case c.NArg() == 1:
+					printBackupNames(c, c.Args().First() == "remote")
+				}
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Delete remote backup from 'primary' remote storage, from named remote target or from 'all' of them",
+				},
+				cli.BoolFlag{
+					Name:   "force",
+					Hidden: false,
+					Usage:  "Delete backup even if other backups require it",
+				},
+				yesFlag,
+			),
+		},
+		{
+			Name:      "gc-remote",
+			Usage:     "Remove objects which don't belong to any backup from remote storage",
+			UsageText: "clickhouse-backup gc-remote [--target=<all|primary|target_name>] [--dry-run]",
+			Action: func(c *cli.Context) error {
+				config := getConfig(c)
+				return withLock(*config, "gc-remote", "", func() error {
+					return chbackup.GarbageCollectRemote(*config, c.String("target"), c.Bool("dry-run"))
+				})
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Clean 'primary' remote storage, named remote target or 'all' of them",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print objects which would be removed without removing them",
+				},
+			),
+		},
+		{
+			Name:      "remote-reindex",
+			Usage:     "Rebuild missing or corrupted manifests of remote backups from their archives",
+			UsageText: "clickhouse-backup remote-reindex [--target=<all|primary|target_name>] [--all] [--dry-run]",
+			Action: func(c *cli.Context) error {
+				return chbackup.ReindexRemote(*getConfig(c), c.String("target"), c.Bool("all"), c.Bool("dry-run"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Reindex 'primary' remote storage, named remote target or 'all' of them",
+				},
+				cli.BoolFlag{
+					Name:   "all",
+					Hidden: false,
+					Usage:  "Rebuild manifests of all backups including valid ones and uploads which are not completed",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Read archives and print manifests which would be uploaded without uploading them",
+				},
+			),
+		},
+		{
+			Name:      "chain",
+			Usage:     "Print backups required by backup and backups which require it",
+			UsageText: "clickhouse-backup chain [--remote] [--target=<primary|target_name>] <backup_name>",
+			Action: func(c *cli.Context) error {
+				return chbackup.PrintBackupChain(*getConfig(c), c.Args().First(), c.Bool("remote"), c.String("target"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Print chain of remote backup",
+				},
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Look for remote backup in 'primary' remote storage or in named remote target",
+				},
+			),
+		},
+		{
+			Name:      "describe",
+			Usage:     "Print tables, partitions and sizes of backup",
+			UsageText: "clickhouse-backup describe [--remote] [--target=<primary|target_name>] <backup_name>",
+			Action: func(c *cli.Context) error {
+				return chbackup.PrintBackupDescription(*getConfig(c), c.Args().First(), c.Bool("remote"), c.String("target"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "remote",
+					Hidden: false,
+					Usage:  "Describe remote backup even if it exists locally",
+				},
+				cli.StringFlag{
+					Name:   "target",
+					Hidden: false,
+					Usage:  "Look for remote backup in 'primary' remote storage or in named remote target",
+				},
+			),
+		},
+		{
+			Name:  "catalog",
+			Usage: "Export catalog of local and remote backups or import remote backups to re-provisioned host",
+			Subcommands: []cli.Command{
+				{
+					Name:      "export",
+					Usage:     "Write names, sizes, chains and checksums of local and remote backups as JSON",
+					UsageText: "clickhouse-backup catalog export [--target=<primary|target_name|all>] [--output=<file>]",
+					Action: func(c *cli.Context) error {
+						return chbackup.ExportCatalog(*getConfig(c), c.String("target"), c.String("output"))
+					},
+					Flags: append(cliapp.Flags,
+						cli.StringFlag{
+							Name:   "target",
+							Hidden: false,
+							Usage:  "Export backups of 'primary' remote storage, of named remote target or of 'all' (default)",
+						},
+						cli.StringFlag{
+							Name:   "output, o",
+							Hidden: false,
+							Usage:  "Write catalog to file instead of stdout",
+						},
+					),
+				},
+				{
+					Name:      "import",
+					Usage:     "Download schema of remote backups which don't exist locally",
+					UsageText: "clickhouse-backup catalog import [--target=<primary|target_name|all>] [<catalog_file>]",
+					Action: func(c *cli.Context) error {
+						return chbackup.ImportCatalog(*getConfig(c), c.String("target"), c.Args().First())
+					},
+					Flags: append(cliapp.Flags,
+						cli.StringFlag{
+							Name:   "target",
+							Hidden: false,
+							Usage:  "Import backups from 'primary' remote storage, from named remote target or from 'all'",
+						},
+					),
+				},
+			},
+		},
+		{
+			Name:      "migrate-format",
+			Usage:     "Convert backup created by previous versions to current format",
+			UsageText: "clickhouse-backup migrate-format <backup_name>",
+			Action: func(c *cli.Context) error {
+				return chbackup.MigrateBackupFormat(*getConfig(c), c.Args().First())
+			},
 			Flags: cliapp.Flags,
 		},
 		{
@@ -192,7 +523,10 @@ func main() {
 			UsageText:   "clickhouse-backup freeze [-t, --tables=<db>.<table>] <backup_name>",
 			Description: "Freeze tables",
 			Action: func(c *cli.Context) error {
-				return chbackup.Freeze(*getConfig(c), c.String("t"))
+				config := getConfig(c)
+				return withLock(*config, "freeze", "", func() error {
+					return chbackup.Freeze(context.Background(), *config, c.String("t"))
+				})
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -205,7 +539,42 @@ func main() {
 			Name:  "clean",
 			Usage: "Remove data in 'shadow' folder",
 			Action: func(c *cli.Context) error {
-				return chbackup.Clean(*getConfig(c))
+				config := getConfig(c)
+				return withLock(*config, "clean", "", func() error {
+					return chbackup.Clean(*config)
+				})
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:      "completion",
+			Usage:     "Print completion script of shell",
+			UsageText: "clickhouse-backup completion <bash|zsh|fish>",
+			Action: func(c *cli.Context) error {
+				script, err := chbackup.CompletionScript(c.Args().First())
+				if err != nil {
+					return err
+				}
+				fmt.Print(script)
+				return nil
+			},
+			BashComplete: func(c *cli.Context) {
+				if c.NArg() == 0 {
+					fmt.Println(strings.Join(chbackup.CompletionShells(), "\n"))
+				}
+			},
+		},
+		{
+			Name:        "doctor",
+			Usage:       "Check environment of clickhouse-backup",
+			Description: "Check config, connection to ClickHouse and its version, clock skew, permissions on disks, leftovers in shadow directories and access to remote storages",
+			Action: func(c *cli.Context) error {
+				report := chbackup.Doctor(getConfigPath(c))
+				report.Print(os.Stdout)
+				if failed := report.Count(chbackup.DoctorFail); failed > 0 {
+					return fmt.Errorf("%d checks failed", failed)
+				}
+				return nil
 			},
 			Flags: cliapp.Flags,
 		},
@@ -213,25 +582,299 @@ func main() {
 			Name:  "server",
 			Usage: "Run API server",
 			Action: func(c *cli.Context) error {
-				return chbackup.Server(cliapp, *getConfig(c))
+				switch c.String("service") {
+				case "":
+					return chbackup.Server(cliapp, *getConfig(c))
+				case chbackup.ServiceInstall:
+					return chbackup.InstallService(getConfigPath(c))
+				case chbackup.ServiceUninstall:
+					return chbackup.UninstallService()
+				case chbackup.ServiceRun:
+					return chbackup.RunService(cliapp, *getConfig(c))
+				}
+				return fmt.Errorf("unknown service action '%s', must be '%s' or '%s'", c.String("service"), chbackup.ServiceInstall, chbackup.ServiceUninstall)
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "service",
+					Hidden: false,
+					Usage:  "Set 'install' or 'uninstall' to register or remove Windows service which runs API server with this config",
+				},
+			),
 		},
 	}
 	if err := cliapp.Run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		os.Exit(chbackup.ExitCode(err))
 	}
 }
 
-func getConfig(ctx *cli.Context) *chbackup.Config {
+func getConfigPath(ctx *cli.Context) string {
 	configPath := ctx.String("config")
 	if configPath == defaultConfigPath {
 		configPath = ctx.GlobalString("config")
 	}
+	return configPath
+}
 
-	config, err := chbackup.LoadConfig(configPath)
+func getConfig(ctx *cli.Context) *chbackup.Config {
+	config, err := chbackup.LoadConfig(getConfigPath(ctx))
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		os.Exit(chbackup.ExitConfigError)
+	}
+	if ctx.Bool("no-progress") || ctx.GlobalBool("no-progress") {
+		config.General.DisableProgressBar = true
+	}
+	profile := ctx.String("profile")
+	if profile == "" {
+		profile = ctx.GlobalString("profile")
+	}
+	if err := chbackup.ApplyProfile(config, profile); err != nil {
+		log.Println(err)
+		os.Exit(chbackup.ExitConfigError)
+	}
+	if err := chbackup.CleanTempDir(*config); err != nil {
+		log.Printf("can't clean temp_dir: %v", err)
 	}
 	return config
 }
+
+// operationAction - action of create, upload, download and restore commands, backup name is taken from CLICKHOUSE_BACKUP_NAME
+// when argument is empty. Metrics of operation are sent to Pushgateway and statsd. In kube mode status of operation is written
+// to kube-status-dir and exit code tells whether operation may be repeated
+func operationAction(operation string, action func(c *cli.Context, config chbackup.Config, backupName string) error) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		config := *getConfig(c)
+		backupName := c.Args().First()
+		if backupName == "" {
+			backupName = os.Getenv("CLICKHOUSE_BACKUP_NAME")
+		}
+		kube := c.Bool("kube") || c.GlobalBool("kube")
+		statusDir := c.String("kube-status-dir")
+		if statusDir == defaultKubeStatusDir {
+			statusDir = c.GlobalString("kube-status-dir")
+		}
+		// name of new backup is generated before lock, because lock is taken by backup name
+		if backupName == "" && (operation == "create" || operation == "create_remote") {
+			backupName = chbackup.NewBackupName()
+		}
+		if remote, ok := selectableOperations[operation]; ok && !kube && backupName == "" && chbackup.CanPrompt() {
+			name, err := chbackup.SelectBackup(config, remote, c.String("target"), os.Stdin, os.Stdout)
+			if err != nil {
+				return err
+			}
+			backupName = name
+		}
+		status := chbackup.NewKubeStatus(operation, backupName)
+		if kube {
+			if err := status.Write(statusDir); err != nil {
+				log.Printf("can't write status of %s: %v", operation, err)
+			}
+		}
+		start := time.Now()
+		err := withLock(config, operation, backupName, func() error {
+			return action(c, config, backupName)
+		})
+		chbackup.ReportOperation(config, operation, backupName, start, err)
+		var tablesErr *chbackup.TablesError
+		if errors.As(err, &tablesErr) {
+			tablesErr.Print(os.Stdout)
+		}
+		if !kube {
+			return err
+		}
+		status.Finish(err)
+		if err := status.Write(statusDir); err != nil {
+			log.Printf("can't write status of %s: %v", operation, err)
+		}
+		if err != nil {
+			return cli.NewExitError(err.Error(), status.ExitCode())
+		}
+		return nil
+	}
+}
+
+// withLock - run action while lock of backup is held, it fails when another command or server runs conflicting operation.
+// Operation without backup name locks all backups
+func withLock(config chbackup.Config, operation, backupName string, action func() error) error {
+	release, err := chbackup.AcquireOperationLock(config, operation, backupName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return action()
+}
+
+// selectableOperations - operations which ask for backup name on terminal when it isn't set, value is true when remote backup is selected
+var selectableOperations = map[string]bool{
+	"upload":         false,
+	"download":       true,
+	"restore":        false,
+	"restore_remote": true,
+}
+
+// completeBackups - complete flags or the first argument of command by names of local or remote backups
+func completeBackups(remote bool) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		if !completeFlags(c) && c.NArg() == 0 {
+			printBackupNames(c, remote)
+		}
+	}
+}
+
+// completeFlags - complete flags of command when completed word starts with '-'
+func completeFlags(c *cli.Context) bool {
+	if len(os.Args) > 2 && strings.HasPrefix(os.Args[len(os.Args)-2], "-") {
+		cli.DefaultCompleteWithFlags(c.App.Command(c.Command.Name))(c)
+		return true
+	}
+	return false
+}
+
+// printBackupNames - print names of backups for completion, logs and errors are suppressed to keep output clean
+func printBackupNames(c *cli.Context, remote bool) {
+	log.SetOutput(ioutil.Discard)
+	config, err := chbackup.LoadConfig(getConfigPath(c))
+	if err != nil {
+		return
+	}
+	names, err := chbackup.BackupNames(*config, remote, c.String("target"))
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// yesFlag - flag of destructive commands which skips confirmation
+var yesFlag = cli.BoolFlag{
+	Name:   "yes, y",
+	Hidden: false,
+	Usage:  "Don't ask for confirmation on terminal",
+	EnvVar: "CLICKHOUSE_BACKUP_YES",
+}
+
+// continueOnErrorFlag - flag of create and restore commands which skips failed tables and reports them at the end
+var continueOnErrorFlag = cli.BoolFlag{
+	Name:   "continue-on-error",
+	Hidden: false,
+	Usage:  "Process remaining tables when some of tables fail and print status of every table",
+}
+
+// includeSystemLogsFlag - flag of create commands which backs up selected system log tables despite clickhouse.skip_tables
+var includeSystemLogsFlag = cli.StringFlag{
+	Name:   "include-system-logs",
+	Hidden: false,
+	Usage:  "Back up comma separated system log tables like 'query_log,metric_log' skipped by clickhouse.skip_tables",
+}
+
+// confirm - ask for confirmation of destructive operation when it runs on terminal without --yes
+func confirm(c *cli.Context, message string) error {
+	if c.Bool("yes") || !chbackup.CanPrompt() {
+		return nil
+	}
+	ok, err := chbackup.Confirm(os.Stdin, os.Stdout, message)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("aborted by user")
+	}
+	return nil
+}
+
+// confirmRestore - ask for confirmation of restore which drops tables
+func confirmRestore(c *cli.Context, config chbackup.Config, backupName string) error {
+	if !c.Bool("rm") || c.Bool("yes") || backupName == "" || !chbackup.CanPrompt() {
+		return nil
+	}
+	message, err := chbackup.RestoreDropMessage(config, backupName, c.String("t"))
+	if err != nil {
+		return err
+	}
+	return confirm(c, message)
+}
+
+// restoreFlags - flags of restore and restore_remote
+var restoreFlags = []cli.Flag{
+	yesFlag,
+	cli.StringFlag{
+		Name:   "table, tables, t",
+		Hidden: false,
+	},
+	cli.BoolFlag{
+		Name:   "schema, s",
+		Hidden: false,
+		Usage:  "Restore schema only",
+	},
+	cli.BoolFlag{
+		Name:   "data, d",
+		Hidden: false,
+		Usage:  "Restore data only",
+	},
+	cli.BoolFlag{
+		Name:   "rm, drop",
+		Hidden: false,
+		Usage:  "Drop table before restore",
+	},
+	cli.StringFlag{
+		Name:   "convert-engine",
+		Hidden: false,
+		Usage:  "Convert Replicated*MergeTree tables to 'plain' *MergeTree or *MergeTree tables to 'replicated' in restored schema",
+	},
+	cli.BoolFlag{
+		Name:   "substitute-macros",
+		Hidden: false,
+		Usage:  "Replace macros of source server by macros of this server in ZooKeeper paths of Replicated tables",
+	},
+	cli.BoolFlag{
+		Name:   "drop-replica",
+		Hidden: false,
+		Usage:  "Remove stale replica metadata from ZooKeeper before creating Replicated tables, requires --drop",
+	},
+	cli.BoolFlag{
+		Name:   "rewrite-ddl",
+		Hidden: false,
+		Usage:  "Convert deprecated MergeTree syntax in restored schema to PARTITION BY/ORDER BY syntax",
+	},
+	cli.BoolFlag{
+		Name:   "validate",
+		Hidden: false,
+		Usage:  "Compare rows and checksums of parts of restored tables with backup",
+	},
+	cli.BoolFlag{
+		Name:   "rehearsal",
+		Hidden: false,
+		Usage:  "Restore tables into temporary database, validate them and drop the database, existing tables are not changed",
+	},
+	continueOnErrorFlag,
+	cli.BoolFlag{
+		Name:   "stop-ttl-merges",
+		Hidden: false,
+		Usage:  "Stop TTL merges of restored tables with TTL before attach and keep them stopped, so expired rows are not removed",
+	},
+	cli.StringFlag{
+		Name:   "reshard",
+		Hidden: false,
+		Usage:  "Attach parts of comma separated backups of other shards to restored tables too, to consolidate shards on one server",
+	},
+}
+
+func getRestoreOptions(c *cli.Context) chbackup.RestoreOptions {
+	return chbackup.RestoreOptions{
+		SchemaOnly:       c.Bool("s"),
+		DataOnly:         c.Bool("d"),
+		DropTable:        c.Bool("rm"),
+		ConvertEngine:    c.String("convert-engine"),
+		SubstituteMacros: c.Bool("substitute-macros"),
+		DropReplica:      c.Bool("drop-replica"),
+		RewriteDDL:       c.Bool("rewrite-ddl"),
+		Validate:         c.Bool("validate"),
+		Rehearsal:        c.Bool("rehearsal"),
+		ContinueOnError:  c.Bool("continue-on-error"),
+		StopTTLMerges:    c.Bool("stop-ttl-merges"),
+		Reshard:          c.String("reshard"),
+	}
+}
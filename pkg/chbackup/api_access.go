@@ -0,0 +1,158 @@
+package chbackup
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// RoleReadOnly - API role allowed to call GET endpoints only, except reading of config
+	RoleReadOnly = "readonly"
+	// RoleOperator - API role allowed to create, upload, download, freeze and clean additionally
+	RoleOperator = "operator"
+	// RoleAdmin - API role allowed to delete and restore backups, to read and update config additionally
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+type apiContextKey string
+
+const apiRoleKey apiContextKey = "role"
+
+// parseAllowCIDR - parse api.allow_cidr, single addresses are allowed without mask
+func parseAllowCIDR(allowCIDR []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(allowCIDR))
+	for _, cidr := range allowCIDR {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("can't parse allow_cidr '%s'", cidr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse allow_cidr '%s': %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func validateAPIAccess(config APIConfig) error {
+	if _, err := parseAllowCIDR(config.AllowCIDR); err != nil {
+		return err
+	}
+	usernames := map[string]bool{}
+	if config.Username != "" {
+		usernames[config.Username] = true
+	}
+	for _, user := range config.Users {
+		if user.Username == "" {
+			return fmt.Errorf("api users must have username")
+		}
+		if usernames[user.Username] {
+			return fmt.Errorf("api user '%s' is defined twice", user.Username)
+		}
+		usernames[user.Username] = true
+		if _, ok := roleLevels[user.Role]; !ok {
+			return fmt.Errorf("unknown role '%s' of api user '%s', must be '%s', '%s' or '%s'", user.Role, user.Username, RoleReadOnly, RoleOperator, RoleAdmin)
+		}
+	}
+	return nil
+}
+
+// allowCIDRMiddleware - refuse connections from addresses which are not in api.allow_cidr, connections to unix domain socket are allowed
+func allowCIDRMiddleware(networks []*net.IPNet, next http.Handler) http.Handler {
+	if len(networks) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// access to unix domain socket is restricted by its permissions
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		writeError(w, http.StatusForbidden, "", fmt.Errorf("address %s is not allowed", host))
+	})
+}
+
+func equalCredential(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requestCredentials - return credentials from basic authentication or from 'user' and 'pass' query arguments used by ClickHouse integration tables
+func requestCredentials(r *http.Request) (string, string) {
+	user, pass, _ := r.BasicAuth()
+	query := r.URL.Query()
+	if u, exist := query["user"]; exist {
+		user = u[0]
+	}
+	if p, exist := query["pass"]; exist {
+		pass = p[0]
+	}
+	return user, pass
+}
+
+// authenticate - return role of user or empty string when credentials are wrong
+func authenticate(config APIConfig, user, pass string) string {
+	if (config.Username != "" || config.Password != "") && equalCredential(user, config.Username) && equalCredential(pass, config.Password) {
+		return RoleAdmin
+	}
+	for _, u := range config.Users {
+		if equalCredential(user, u.Username) && equalCredential(pass, u.Password) {
+			return u.Role
+		}
+	}
+	return ""
+}
+
+// hasRole - check that role of authenticated request is not lower than role, any request has 'admin' role when authentication is disabled
+func hasRole(r *http.Request, role string) bool {
+	requestRole, ok := r.Context().Value(apiRoleKey).(string)
+	if !ok {
+		requestRole = RoleAdmin
+	}
+	return roleLevels[requestRole] >= roleLevels[role]
+}
+
+func withRole(r *http.Request, role string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiRoleKey, role))
+}
+
+// requireRole - call handler only for requests with role not lower than role
+func requireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(r, role) {
+			writeError(w, http.StatusForbidden, "", fmt.Errorf("'%s' role is required", role))
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,55 @@
+package chbackup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowCIDRMiddleware(t *testing.T) {
+	networks, err := parseAllowCIDR([]string{"10.0.0.0/8", "192.168.1.10", "::1"})
+	assert.NoError(t, err)
+	_, err = parseAllowCIDR([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	handler := allowCIDRMiddleware(networks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for remoteAddr, code := range map[string]int{
+		"10.1.2.3:5000":     http.StatusOK,
+		"192.168.1.10:5000": http.StatusOK,
+		"[::1]:5000":        http.StatusOK,
+		"192.168.1.11:5000": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/backup/list", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, code, w.Code, remoteAddr)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	config := APIConfig{
+		Username: "admin",
+		Password: "secret",
+		Users:    []APIUser{{Username: "monitoring", Password: "monitoring", Role: RoleReadOnly}},
+	}
+	assert.NoError(t, validateAPIAccess(config))
+	assert.Equal(t, RoleAdmin, authenticate(config, "admin", "secret"))
+	assert.Equal(t, RoleReadOnly, authenticate(config, "monitoring", "monitoring"))
+	assert.Equal(t, "", authenticate(config, "monitoring", "secret"))
+
+	handler := requireRole(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {})
+	for role, code := range map[string]int{RoleReadOnly: http.StatusForbidden, RoleOperator: http.StatusForbidden, RoleAdmin: http.StatusOK} {
+		w := httptest.NewRecorder()
+		handler(w, withRole(httptest.NewRequest(http.MethodPost, "/backup/delete/remote/backup", nil), role))
+		assert.Equal(t, code, w.Code, role)
+	}
+	// authentication is disabled
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/backup/delete/remote/backup", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	config.Users = append(config.Users, APIUser{Username: "backup", Password: "backup", Role: "root"})
+	assert.Error(t, validateAPIAccess(config))
+}
@@ -0,0 +1,72 @@
+package chbackup
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// FormatJSON, FormatTSV, FormatCSV - formats of responses of list, tables and status endpoints,
+	// TSV and CSV are sent with names of columns in the first row like TSVWithNames and CSVWithNames formats of ClickHouse
+	FormatJSON = "json"
+	FormatTSV  = "tsv"
+	FormatCSV  = "csv"
+)
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n")
+
+// getResponseFormat - get format from 'format' query argument or from Accept header, defaultFormat is used when neither is set
+func getResponseFormat(r *http.Request, defaultFormat string) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		switch strings.ToLower(format) {
+		case "json":
+			return FormatJSON, nil
+		case "tsv", "tsvwithnames", "tabseparatedwithnames":
+			return FormatTSV, nil
+		case "csv", "csvwithnames":
+			return FormatCSV, nil
+		}
+		return "", fmt.Errorf("unknown format '%s', must be '%s', '%s' or '%s'", format, FormatJSON, FormatTSV, FormatCSV)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.SplitN(accept, ";", 2)[0]) {
+		case "application/json":
+			return FormatJSON, nil
+		case "text/tab-separated-values":
+			return FormatTSV, nil
+		case "text/csv":
+			return FormatCSV, nil
+		}
+	}
+	return defaultFormat, nil
+}
+
+func writeTSVRow(w io.Writer, row []string) {
+	escaped := make([]string, len(row))
+	for i, v := range row {
+		escaped[i] = tsvEscaper.Replace(v)
+	}
+	fmt.Fprintln(w, strings.Join(escaped, "\t"))
+}
+
+// sendTable - send v as JSON or send columns and rows as TSV or CSV
+func sendTable(w http.ResponseWriter, format string, v interface{}, columns []string, rows [][]string) {
+	switch format {
+	case FormatTSV:
+		w.Header().Set("Content-Type", "text/tab-separated-values; charset=UTF-8")
+		writeTSVRow(w, columns)
+		for _, row := range rows {
+			writeTSVRow(w, row)
+		}
+	case FormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		cw.WriteAll(rows)
+	default:
+		sendResponse(w, http.StatusOK, v)
+	}
+}
@@ -0,0 +1,42 @@
+package chbackup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetResponseFormat(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"/backup/list":                     FormatJSON,
+		"/backup/list?format=TSVWithNames": FormatTSV,
+		"/backup/list?format=csv":          FormatCSV,
+	} {
+		format, err := getResponseFormat(httptest.NewRequest(http.MethodGet, uri, nil), FormatJSON)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, format, uri)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/integration/list", nil)
+	r.Header.Set("Accept", "text/html, text/csv;q=0.9")
+	format, err := getResponseFormat(r, FormatTSV)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatCSV, format)
+	_, err = getResponseFormat(httptest.NewRequest(http.MethodGet, "/backup/list?format=xml", nil), FormatJSON)
+	assert.Error(t, err)
+}
+
+func TestSendTable(t *testing.T) {
+	columns := []string{"command", "error"}
+	rows := [][]string{{"create", "can't connect:\n\ttimeout"}, {"upload", "a \"quoted\", value"}}
+	w := httptest.NewRecorder()
+	sendTable(w, FormatTSV, nil, columns, rows)
+	assert.Equal(t, "command\terror\ncreate\tcan't connect:\\n\\ttimeout\nupload\ta \"quoted\", value\n", w.Body.String())
+	w = httptest.NewRecorder()
+	sendTable(w, FormatCSV, nil, columns, rows)
+	assert.Equal(t, "command,error\ncreate,\"can't connect:\n\ttimeout\"\nupload,\"a \"\"quoted\"\", value\"\n", w.Body.String())
+	w = httptest.NewRecorder()
+	sendTable(w, FormatJSON, []CommandInfo{{ID: 1, Command: "create", Status: "success"}}, columns, rows)
+	assert.Equal(t, "[{\"id\":1,\"command\":\"create\",\"status\":\"success\"}]\n", w.Body.String())
+}
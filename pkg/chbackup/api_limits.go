@@ -0,0 +1,101 @@
+package chbackup
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter - token bucket of every client address, bucket holds up to perMinute requests and is refilled with perMinute requests per minute
+type rateLimiter struct {
+	sync.Mutex
+	perMinute int
+	buckets   map[string]*rateBucket
+	swept     time.Time
+	now       func() time.Time
+}
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		perMinute: perMinute,
+		buckets:   map[string]*rateBucket{},
+		now:       time.Now,
+	}
+}
+
+// allow - take token from bucket of address, return how long to wait for the next token when bucket is empty
+func (l *rateLimiter) allow(address string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	rate := float64(l.perMinute) / float64(time.Minute)
+	if now.Sub(l.swept) > time.Minute {
+		// buckets refilled completely are the same as missing ones
+		for a, b := range l.buckets {
+			if float64(now.Sub(b.updated))*rate+b.tokens >= float64(l.perMinute) {
+				delete(l.buckets, a)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[address]
+	if !ok {
+		b = &rateBucket{tokens: float64(l.perMinute), updated: now}
+		l.buckets[address] = b
+	}
+	b.tokens = math.Min(float64(l.perMinute), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitMiddleware - refuse POST requests of address which sent more than api.rate_limit requests per minute
+func rateLimitMiddleware(perMinute int, next http.Handler) http.Handler {
+	if perMinute <= 0 {
+		return next
+	}
+	limiter := newRateLimiter(perMinute)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ok, wait := limiter.allow(host); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "", fmt.Errorf("rate limit of %d requests per minute exceeded", perMinute))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody - refuse requests with body larger than api.max_body_size, body is not limited when it's 0
+func limitBody(maxBodySize int64, handler http.HandlerFunc) http.HandlerFunc {
+	if maxBodySize <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBodySize {
+			writeError(w, http.StatusRequestEntityTooLarge, "", fmt.Errorf("request body is larger than %d bytes", maxBodySize))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		handler(w, r)
+	}
+}
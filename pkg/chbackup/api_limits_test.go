@@ -0,0 +1,56 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2)
+	limiter.now = func() time.Time { return now }
+	ok, _ := limiter.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, wait := limiter.allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+	ok, _ = limiter.allow("10.0.0.2")
+	assert.True(t, ok)
+	now = now.Add(30 * time.Second)
+	ok, _ = limiter.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("10.0.0.1")
+	assert.False(t, ok)
+	now = now.Add(2 * time.Minute)
+	ok, _ = limiter.allow("10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(limiter.buckets))
+}
+
+func TestLimitBody(t *testing.T) {
+	handler := limitBody(10, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/integration/actions", strings.NewReader("command\n")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/integration/actions", strings.NewReader("command\ncreate backup\n")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	// body without Content-Length
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/integration/actions", ioutil.NopCloser(strings.NewReader("command\ncreate backup\n")))
+	r.ContentLength = -1
+	handler(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
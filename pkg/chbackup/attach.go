@@ -0,0 +1,85 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// RestoreConfig - restore settings section
+type RestoreConfig struct {
+	// AttachRateLimit - how many parts are attached per second, not limited when it's 0
+	AttachRateLimit int `yaml:"attach_rate_limit" envconfig:"RESTORE_ATTACH_RATE_LIMIT"`
+	// AttachBatchSize - how many parts are attached before AttachBatchPause, parts are not batched when it's 0
+	AttachBatchSize int `yaml:"attach_batch_size" envconfig:"RESTORE_ATTACH_BATCH_SIZE"`
+	// AttachBatchPause - pause after every batch of attached parts to let replication queues catch up
+	AttachBatchPause string `yaml:"attach_batch_pause" envconfig:"RESTORE_ATTACH_BATCH_PAUSE"`
+}
+
+// validateRestoreConfig - check values of restore section
+func validateRestoreConfig(config RestoreConfig) error {
+	if config.AttachRateLimit < 0 {
+		return fmt.Errorf("restore.attach_rate_limit can't be negative")
+	}
+	if config.AttachBatchSize < 0 {
+		return fmt.Errorf("restore.attach_batch_size can't be negative")
+	}
+	if config.AttachBatchPause != "" {
+		if _, err := time.ParseDuration(config.AttachBatchPause); err != nil {
+			return fmt.Errorf("can't parse restore.attach_batch_pause: %v", err)
+		}
+	}
+	return nil
+}
+
+// attachThrottle - pace of ATTACH PART of all tables of one restore, progress is reported after every batch
+type attachThrottle struct {
+	interval   time.Duration
+	batchSize  int
+	batchPause time.Duration
+	total      int
+	attached   int
+	// next - time when next part may be attached
+	next  time.Time
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newAttachThrottle(config RestoreConfig, total int) *attachThrottle {
+	t := &attachThrottle{
+		batchSize: config.AttachBatchSize,
+		total:     total,
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+	if config.AttachRateLimit > 0 {
+		t.interval = time.Second / time.Duration(config.AttachRateLimit)
+	}
+	t.batchPause, _ = time.ParseDuration(config.AttachBatchPause)
+	return t
+}
+
+// wait - sleep until next part may be attached without exceeding attach_rate_limit
+func (t *attachThrottle) wait() {
+	if t.interval <= 0 {
+		return
+	}
+	now := t.now()
+	if t.next.After(now) {
+		t.sleep(t.next.Sub(now))
+		now = t.next
+	}
+	t.next = now.Add(t.interval)
+}
+
+// done - count attached part, report progress and pause when batch is finished
+func (t *attachThrottle) done() {
+	t.attached++
+	if t.batchSize <= 0 || t.attached%t.batchSize != 0 || t.attached >= t.total {
+		return
+	}
+	log.Printf("Attached %d of %d parts", t.attached, t.total)
+	if t.batchPause > 0 {
+		t.sleep(t.batchPause)
+	}
+}
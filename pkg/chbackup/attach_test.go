@@ -0,0 +1,39 @@
+package chbackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachThrottle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	slept := []time.Duration{}
+	throttle := newAttachThrottle(RestoreConfig{AttachRateLimit: 4, AttachBatchSize: 2, AttachBatchPause: "10s"}, 5)
+	throttle.now = func() time.Time { return now }
+	throttle.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	for i := 0; i < 5; i++ {
+		throttle.wait()
+		throttle.done()
+	}
+	// the first part isn't delayed, pause after every batch except the last one is enough for the rate limit
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, 10 * time.Second, 250 * time.Millisecond, 10 * time.Second}, slept)
+	assert.Equal(t, 5, throttle.attached)
+
+	unlimited := newAttachThrottle(RestoreConfig{}, 3)
+	unlimited.sleep = func(d time.Duration) { t.Errorf("unexpected sleep %s", d) }
+	for i := 0; i < 3; i++ {
+		unlimited.wait()
+		unlimited.done()
+	}
+}
+
+func TestValidateRestoreConfig(t *testing.T) {
+	assert.NoError(t, validateRestoreConfig(RestoreConfig{AttachBatchSize: 100, AttachBatchPause: "5s"}))
+	assert.Error(t, validateRestoreConfig(RestoreConfig{AttachRateLimit: -1}))
+	assert.Error(t, validateRestoreConfig(RestoreConfig{AttachBatchPause: "5"}))
+}
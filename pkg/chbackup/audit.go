@@ -0,0 +1,193 @@
+package chbackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditLogSyslog - value of api.audit_log which sends audit records to syslog instead of file
+	AuditLogSyslog = "syslog"
+	// auditResponseLimit - how many bytes of response are kept to get job ID and result of request
+	auditResponseLimit = 64 * 1024
+)
+
+// AuditRecord - record of audit log, 'request' records are written for every POST request, 'job' records when operation started by request is finished
+type AuditRecord struct {
+	Time       time.Time         `json:"time"`
+	Event      string            `json:"event"`
+	User       string            `json:"user,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Endpoint   string            `json:"endpoint,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Command - command of /integration/actions
+	Command    string `json:"command,omitempty"`
+	JobID      int    `json:"job_id,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	// Status - 'acknowledged', 'success' or 'error'
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditLog - append-only log of API mutations, separate from operational log
+type auditLog struct {
+	sync.Mutex
+	w io.WriteCloser
+}
+
+// openAuditLog - open file in append mode or connect to syslog, audit log is disabled when location is empty
+func openAuditLog(location string) (*auditLog, error) {
+	switch location {
+	case "":
+		return nil, nil
+	case AuditLogSyslog:
+		w, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		return &auditLog{w: w}, nil
+	}
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log: %v", err)
+	}
+	return &auditLog{w: f}, nil
+}
+
+// write - write record as one JSON line
+func (a *auditLog) write(record AuditRecord) {
+	if a == nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("can't write audit log: %v", err)
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("can't write audit log: %v", err)
+	}
+}
+
+// job - write result of finished operation
+func (a *auditLog) job(c CommandInfo) {
+	a.write(AuditRecord{
+		Event:   "job",
+		Command: c.Command,
+		JobID:   c.ID,
+		Status:  c.Status,
+		Error:   c.Error,
+	})
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.w.Close()
+}
+
+// auditResponseWriter - keep status code and beginning of response to get job ID and result of request
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	if rest := auditResponseLimit - w.body.Len(); rest > 0 {
+		if len(b) < rest {
+			rest = len(b)
+		}
+		w.body.Write(b[:rest])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// result - get job ID, status and error from JSON response or from plain text response of /integration/actions
+func (w *auditResponseWriter) result(record *AuditRecord) {
+	record.StatusCode = w.statusCode
+	if record.StatusCode == 0 {
+		record.StatusCode = http.StatusOK
+	}
+	response := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		JobID  int    `json:"job_id"`
+	}{}
+	firstLine := strings.SplitN(w.body.String(), "\n", 2)[0]
+	if err := json.Unmarshal([]byte(firstLine), &response); err == nil {
+		record.Status, record.Error, record.JobID = response.Status, response.Error, response.JobID
+	}
+	if record.Status == "" {
+		record.Status = "success"
+		if record.StatusCode >= http.StatusBadRequest {
+			record.Status = "error"
+			record.Error = strings.TrimSpace(firstLine)
+		}
+	}
+}
+
+// auditMiddleware - write every POST request to audit log, credentials and body of config update are not written
+func auditMiddleware(audit *auditLog, next http.Handler) http.Handler {
+	if audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, _ := requestCredentials(r)
+		remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteAddr = r.RemoteAddr
+		}
+		record := AuditRecord{
+			Event:      "request",
+			User:       user,
+			RemoteAddr: remoteAddr,
+			Endpoint:   r.URL.Path,
+			Parameters: map[string]string{},
+		}
+		for k, v := range r.URL.Query() {
+			if k != "user" && k != "pass" {
+				record.Parameters[k] = strings.Join(v, ",")
+			}
+		}
+		if strings.TrimPrefix(r.URL.Path, apiV1Prefix) == "/integration/actions" {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, auditResponseLimit))
+			if err == nil {
+				r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+				if lines := strings.Split(string(body), "\n"); len(lines) > 1 {
+					record.Command = strings.Split(lines[1], "\t")[0]
+				}
+			}
+		}
+		aw := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		aw.result(&record)
+		audit.write(record)
+	})
+}
@@ -0,0 +1,18 @@
+// +build !windows
+
+package chbackup
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// openSyslog - connect to local syslog, audit records are sent with 'auth' facility
+func openSyslog() (io.WriteCloser, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "clickhouse-backup")
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to syslog: %v", err)
+	}
+	return w, nil
+}
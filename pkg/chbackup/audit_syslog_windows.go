@@ -0,0 +1,11 @@
+package chbackup
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog - there is no syslog on Windows, audit log is written to file there
+func openSyslog() (io.WriteCloser, error) {
+	return nil, errors.New("syslog audit log is not supported on Windows")
+}
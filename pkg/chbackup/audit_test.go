@@ -0,0 +1,61 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	audit, err := openAuditLog(path.Join(dir, "audit.log"))
+	assert.NoError(t, err)
+	handler := auditMiddleware(audit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/integration/actions" {
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, "command\nrestore_remote backup\n", string(body))
+			http.Error(w, "another operation is currently running", http.StatusLocked)
+			return
+		}
+		fmt.Fprintln(w, `{"status":"acknowledged","operation":"delete","job_id":3}`)
+	}))
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/backup/list", nil),
+		httptest.NewRequest(http.MethodPost, "/backup/delete/remote/backup?async=true&user=admin&pass=secret", nil),
+		httptest.NewRequest(http.MethodPost, "/integration/actions", strings.NewReader("command\nrestore_remote backup\n")),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	audit.job(CommandInfo{ID: 3, Command: "delete", Status: "success"})
+	assert.NoError(t, audit.Close())
+
+	content, err := ioutil.ReadFile(path.Join(dir, "audit.log"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 3, len(lines))
+	records := make([]AuditRecord, len(lines))
+	for i, line := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(line), &records[i]))
+	}
+	assert.Equal(t, "admin", records[0].User)
+	assert.Equal(t, "/backup/delete/remote/backup", records[0].Endpoint)
+	assert.Equal(t, map[string]string{"async": "true"}, records[0].Parameters)
+	assert.Equal(t, 3, records[0].JobID)
+	assert.Equal(t, "acknowledged", records[0].Status)
+	assert.Equal(t, "restore_remote backup", records[1].Command)
+	assert.Equal(t, http.StatusLocked, records[1].StatusCode)
+	assert.Equal(t, "error", records[1].Status)
+	assert.Equal(t, "another operation is currently running", records[1].Error)
+	assert.Equal(t, "job", records[2].Event)
+	assert.Equal(t, 3, records[2].JobID)
+}
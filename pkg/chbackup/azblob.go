@@ -19,6 +19,8 @@ type AzureBlob struct {
 	Container azblob.ContainerURL
 	CPK       azblob.ClientProvidedKeyOptions
 	Config    *AzureBlobConfig
+	// lowMemory - upload by smaller and fewer buffers, general.low_memory
+	lowMemory bool
 }
 
 // Connect - connect to Azure
@@ -84,6 +86,10 @@ func (s *AzureBlob) Kind() string {
 	return "azblob"
 }
 
+func (s *AzureBlob) Close() error {
+	return nil
+}
+
 func (s *AzureBlob) GetFileReader(key string) (io.ReadCloser, error) {
 	ctx := context.Background()
 	blob := s.Container.NewBlockBlobURL(key)
@@ -102,6 +108,9 @@ func (s *AzureBlob) PutFile(key string, r io.ReadCloser) error {
 
 	bufferSize := 2 * 1024 * 1024 // Configure the size of the rotating buffers that are used when uploading
 	maxBuffers := 3               // Configure the number of rotating buffers that are used when uploading
+	if s.lowMemory {
+		bufferSize, maxBuffers = lowMemoryBufferSize*4, 2
+	}
 	_, err := x.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers}, s.CPK)
 	return err
 }
@@ -1,6 +1,7 @@
 package chbackup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -9,8 +10,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,6 +25,8 @@ const (
 var (
 	// ErrUnknownClickhouseDataPath -
 	ErrUnknownClickhouseDataPath = errors.New("clickhouse data path is unknown, you can set data_path in config file")
+	// errLocalBackupNotFound - backup doesn't exist in backup directory, it's wrapped with backup name by GetLocalBackup
+	errLocalBackupNotFound = errors.New("not found")
 )
 
 func addTable(tables []Table, table Table) []Table {
@@ -51,47 +56,42 @@ func addRestoreTable(tables RestoreTables, table RestoreTable) RestoreTables {
 	return append(tables, table)
 }
 
-func parseTablePatternForFreeze(tables []Table, tablePattern string) []Table {
-	if tablePattern == "" {
-		return tables
+func parseTablePatternForFreeze(tables []Table, tablePattern string) ([]Table, error) {
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return nil, err
 	}
-	tablePatterns := strings.Split(tablePattern, ",")
 	var result []Table
 	for _, t := range tables {
-		for _, pattern := range tablePatterns {
-			if matched, _ := filepath.Match(pattern, fmt.Sprintf("%s.%s", t.Database, t.Name)); matched {
-				result = addTable(result, t)
-			}
+		if patterns.Match(t.Database, t.Name) {
+			result = addTable(result, t)
 		}
 	}
-	return result
+	return result, nil
 }
 
-func parseTablePatternForRestoreData(tables map[string]BackupTable, tablePattern string) []BackupTable {
-	tablePatterns := []string{"*"}
-	if tablePattern != "" {
-		tablePatterns = strings.Split(tablePattern, ",")
+func parseTablePatternForRestoreData(tables map[string]BackupTable, tablePattern string) ([]BackupTable, error) {
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return nil, err
 	}
 	result := BackupTables{}
 	for _, t := range tables {
-		for _, pattern := range tablePatterns {
-			tableName := fmt.Sprintf("%s.%s", t.Database, t.Name)
-			if matched, _ := filepath.Match(pattern, tableName); matched {
-				result = addBackupTable(result, t)
-			}
+		if patterns.Match(t.Database, t.Name) {
+			result = addBackupTable(result, t)
 		}
 	}
 	result.Sort()
-	return result
+	return result, nil
 }
 
 func parseSchemaPattern(metadataPath string, tablePattern string) (RestoreTables, error) {
 	regularTables := RestoreTables{}
 	distributedTables := RestoreTables{}
 	viewTables := RestoreTables{}
-	tablePatterns := []string{"*"}
-	if tablePattern != "" {
-		tablePatterns = strings.Split(tablePattern, ",")
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return nil, err
 	}
 	if err := filepath.Walk(metadataPath, func(filePath string, info os.FileInfo, err error) error {
 		if !strings.HasSuffix(filePath, ".sql") || !info.Mode().IsRegular() {
@@ -105,32 +105,29 @@ func parseSchemaPattern(metadataPath string, tablePattern string) (RestoreTables
 		}
 		database, _ := url.PathUnescape(parts[0])
 		table, _ := url.PathUnescape(parts[1])
-		tableName := fmt.Sprintf("%s.%s", database, table)
-		for _, p := range tablePatterns {
-			if matched, _ := filepath.Match(p, tableName); matched {
-				data, err := ioutil.ReadFile(filePath)
-				if err != nil {
-					return err
-				}
-				restoreTable := RestoreTable{
-					Database: database,
-					Table:    table,
-					Query:    strings.Replace(string(data), "ATTACH", "CREATE", 1),
-					Path:     filePath,
-				}
-				if strings.Contains(restoreTable.Query, "ENGINE = Distributed") {
-					distributedTables = addRestoreTable(distributedTables, restoreTable)
-					return nil
-				}
-				if strings.HasPrefix(restoreTable.Query, "CREATE VIEW") ||
-					strings.HasPrefix(restoreTable.Query, "CREATE MATERIALIZED VIEW") {
-					viewTables = addRestoreTable(viewTables, restoreTable)
-					return nil
-				}
-				regularTables = addRestoreTable(regularTables, restoreTable)
-				return nil
-			}
+		if !patterns.Match(database, table) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		restoreTable := RestoreTable{
+			Database: database,
+			Table:    table,
+			Query:    strings.Replace(string(data), "ATTACH", "CREATE", 1),
+			Path:     filePath,
 		}
+		if strings.Contains(restoreTable.Query, "ENGINE = Distributed") {
+			distributedTables = addRestoreTable(distributedTables, restoreTable)
+			return nil
+		}
+		if strings.HasPrefix(restoreTable.Query, "CREATE VIEW") ||
+			strings.HasPrefix(restoreTable.Query, "CREATE MATERIALIZED VIEW") {
+			viewTables = addRestoreTable(viewTables, restoreTable)
+			return nil
+		}
+		regularTables = addRestoreTable(regularTables, restoreTable)
 		return nil
 	}); err != nil {
 		return nil, err
@@ -144,9 +141,10 @@ func parseSchemaPattern(metadataPath string, tablePattern string) (RestoreTables
 }
 
 // getTables - get all tables for use by PrintTables and API
-func getTables(config Config) ([]Table, error) {
+func getTables(ctx context.Context, config Config) ([]Table, error) {
 	ch := &ClickHouse{
 		Config: &config.ClickHouse,
+		ctx:    ctx,
 	}
 
 	if err := ch.Connect(); err != nil {
@@ -158,76 +156,194 @@ func getTables(config Config) ([]Table, error) {
 	if err != nil {
 		return []Table{}, fmt.Errorf("can't get tables: %v", err)
 	}
+	if err := ch.GetTablesSize(allTables); err != nil {
+		return []Table{}, err
+	}
 	return allTables, nil
 }
 
+// getServerInfo - save hostname, version and macros of ClickHouse server to backup metadata
+func getServerInfo(ctx context.Context, config Config, metadata *BackupMetadata) error {
+	ch := &ClickHouse{
+		Config: &config.ClickHouse,
+		ctx:    ctx,
+	}
+	if err := ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	version, err := ch.GetVersion()
+	if err != nil {
+		return err
+	}
+	macros, err := ch.GetMacros()
+	if err != nil {
+		return err
+	}
+	host, err := ch.GetHostname()
+	if err != nil {
+		return err
+	}
+	metadata.Host = host
+	metadata.ClickHouseVersion = version
+	metadata.Macros = macros
+	return nil
+}
+
 // PrintTables - print all tables suitable for backup
 func PrintTables(config Config) error {
-	allTables, err := getTables(config)
+	allTables, err := getTables(context.Background(), config)
 	if err != nil {
 		return err
 	}
+	var totalBytes uint64
 	for _, table := range allTables {
+		status := ""
+		if table.LastModified != "" {
+			status = fmt.Sprintf("\t(modified at %s)", table.LastModified)
+		}
 		if table.Skip {
-			fmt.Printf("%s.%s\t(ignored)\n", table.Database, table.Name)
+			status += "\t(ignored)"
 		} else {
-			fmt.Printf("%s.%s\n", table.Database, table.Name)
+			totalBytes += table.TotalBytes
 		}
+		fmt.Printf("%s.%s\t%s\t%s\t%d rows\t%d partitions%s\n", table.Database, table.Name, table.Engine, FormatBytes(int64(table.TotalBytes)), table.TotalRows, table.Partitions, status)
 	}
+	fmt.Printf("total size of not ignored tables: %s\n", FormatBytes(int64(totalBytes)))
 	return nil
 }
 
-func restoreSchema(config Config, backupName string, tablePattern string, dropTable bool) error {
+// restoreSchema - create databases and tables of backup, tables which can't be created are marked in report
+func restoreSchema(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions, report *tableReport) ([]string, error) {
 	if backupName == "" {
 		PrintLocalBackups(config, "all")
-		return fmt.Errorf("select backup for restore")
+		return nil, fmt.Errorf("select backup for restore")
 	}
 	dataPath := getDataPath(config)
 	if dataPath == "" {
-		return ErrUnknownClickhouseDataPath
+		return nil, ErrUnknownClickhouseDataPath
 	}
 	metadataPath := path.Join(dataPath, "backup", backupName, "metadata")
 	info, err := os.Stat(metadataPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("%s is not a dir", metadataPath)
+		return nil, fmt.Errorf("%s is not a dir", metadataPath)
 	}
 	tablesForRestore, err := parseSchemaPattern(metadataPath, tablePattern)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(tablesForRestore) == 0 {
-		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
+		return nil, fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
 	ch := &ClickHouse{
-		Config: &config.ClickHouse,
+		Config:   &config.ClickHouse,
+		ctx:      ctx,
+		settings: config.ClickHouse.RestoreSettings,
 	}
 	if err := ch.Connect(); err != nil {
-		return fmt.Errorf("can't connect to clickhouse: %v", err)
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer ch.Close()
 
+	backupMetadata := getLocalBackupMetadata(path.Join(dataPath, "backup", backupName))
+	targetVersion, err := ch.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	var targetMacros map[string]string
+	if options.SubstituteMacros || options.DropReplica {
+		if targetMacros, err = ch.GetMacros(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range tablesForRestore {
+		schema := &tablesForRestore[i]
+		if err := prepareSchema(config, schema, options, backupMetadata.Macros, targetMacros); err != nil {
+			if err := report.fail(schema.Database, schema.Table, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := checkRestoreCompatibility(tablesForRestore, backupMetadata.ClickHouseVersion, targetVersion); err != nil {
+		return nil, err
+	}
+	restored := []string{}
 	for _, schema := range tablesForRestore {
-		if err := ch.CreateDatabase(schema.Database); err != nil {
-			return fmt.Errorf("can't create database '%s': %v", schema.Database, err)
+		if report.failed(schema.Database, schema.Table) {
+			continue
+		}
+		if err := createSchema(ch, schema, options, targetMacros); err != nil {
+			if err := report.fail(schema.Database, schema.Table, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		report.succeed(schema.Database, schema.Table)
+		restored = append(restored, fmt.Sprintf("%s.%s", schema.Database, schema.Table))
+	}
+	return restored, nil
+}
+
+// prepareSchema - convert engine, substitute macros and rewrite syntax of schema by options
+func prepareSchema(config Config, schema *RestoreTable, options RestoreOptions, sourceMacros, targetMacros map[string]string) error {
+	var err error
+	if options.ConvertEngine != "" {
+		if schema.Query, err = convertEngine(schema.Query, schema.Database, schema.Table, options.ConvertEngine, config.ClickHouse.DefaultReplicaPath, config.ClickHouse.DefaultReplicaName); err != nil {
+			return fmt.Errorf("can't convert engine of '%s.%s': %v", schema.Database, schema.Table, err)
+		}
+	}
+	if options.SubstituteMacros {
+		if schema.Query, err = substituteMacros(schema.Query, sourceMacros, targetMacros); err != nil {
+			return fmt.Errorf("can't substitute macros for '%s.%s': %v", schema.Database, schema.Table, err)
 		}
-		if err := ch.CreateTable(schema, dropTable); err != nil {
-			return fmt.Errorf("can't create table '%s.%s': %v", schema.Database, schema.Table, err)
+	}
+	if options.RewriteDDL {
+		if schema.Query, err = rewriteOldMergeTreeSyntax(schema.Query); err != nil {
+			return fmt.Errorf("can't rewrite schema of '%s.%s': %v", schema.Database, schema.Table, err)
 		}
 	}
 	return nil
 }
 
+// createSchema - create database and table of schema, stale replica of table is dropped before when options.DropReplica is set
+func createSchema(ch *ClickHouse, schema RestoreTable, options RestoreOptions, targetMacros map[string]string) error {
+	if options.DropReplica {
+		if zkPath, replicaName, ok := getReplicaPath(schema.Query, schema.Database, schema.Table, targetMacros); ok {
+			if err := ch.DropTable(schema.Database, schema.Table); err != nil {
+				return fmt.Errorf("can't drop table '%s.%s': %v", schema.Database, schema.Table, err)
+			}
+			if err := ch.DropReplica(zkPath, replicaName); err != nil {
+				return fmt.Errorf("can't drop replica of '%s.%s': %v", schema.Database, schema.Table, err)
+			}
+		}
+	}
+	if err := ch.CreateDatabase(schema.Database); err != nil {
+		return fmt.Errorf("can't create database '%s': %v", schema.Database, err)
+	}
+	s := startSpan(ch.ctx, "create table", "table", schema.Database+"."+schema.Table)
+	start := time.Now()
+	err := ch.CreateTable(schema, options.DropTable)
+	observeTable(tableStepCreate, start)
+	s.finish(err)
+	if err != nil {
+		return fmt.Errorf("can't create table '%s.%s': %v", schema.Database, schema.Table, err)
+	}
+	return nil
+}
+
 func printBackups(backupList []Backup, format string, printSize bool) error {
 	switch format {
 	case "latest", "last", "l":
+		backupList = getCompleteBackups(backupList)
 		if len(backupList) < 1 {
 			return fmt.Errorf("no backups found")
 		}
 		fmt.Println(backupList[len(backupList)-1].Name)
 	case "penult", "prev", "previous", "p":
+		backupList = getCompleteBackups(backupList)
 		if len(backupList) < 2 {
 			return fmt.Errorf("no penult backup is found")
 		}
@@ -237,10 +353,20 @@ func printBackups(backupList []Backup, format string, printSize bool) error {
 			fmt.Println("no backups found")
 		}
 		for _, backup := range backupList {
+			status := ""
+			if backup.RequiredBackup != "" {
+				status += fmt.Sprintf("\trequired '%s'", backup.RequiredBackup)
+			}
+			if backup.TablePattern != "" {
+				status += fmt.Sprintf("\ttables '%s'", backup.TablePattern)
+			}
+			if backup.Broken != "" {
+				status += fmt.Sprintf("\tbroken (%s)", backup.Broken)
+			}
 			if printSize {
-				fmt.Printf("- '%s'\t%s\t(created at %s)\n", backup.Name, FormatBytes(backup.Size), backup.Date.Format("02-01-2006 15:04:05"))
+				fmt.Printf("- '%s'\t%s\t(created at %s)%s\n", backup.Name, FormatBytes(backup.Size), backup.Date.Format("02-01-2006 15:04:05"), status)
 			} else {
-				fmt.Printf("- '%s'\t(created at %s)\n", backup.Name, backup.Date.Format("02-01-2006 15:04:05"))
+				fmt.Printf("- '%s'\t(created at %s)%s\n", backup.Name, backup.Date.Format("02-01-2006 15:04:05"), status)
 			}
 		}
 	default:
@@ -249,6 +375,17 @@ func printBackups(backupList []Backup, format string, printSize bool) error {
 	return nil
 }
 
+// getCompleteBackups - return backups which are not marked as broken
+func getCompleteBackups(backupList []Backup) []Backup {
+	result := []Backup{}
+	for _, backup := range backupList {
+		if backup.Broken == "" {
+			result = append(result, backup)
+		}
+	}
+	return result
+}
+
 // PrintLocalBackups - print all backups stored locally
 func PrintLocalBackups(config Config, format string) error {
 	backupList, err := ListLocalBackups(config)
@@ -283,9 +420,19 @@ func ListLocalBackups(config Config) ([]Backup, error) {
 		if !info.IsDir() {
 			continue
 		}
+		backupPath := path.Join(backupsPath, name)
+		metadata := getLocalBackupMetadata(backupPath)
+		// backups of other profiles are managed independently
+		if config.General.Profile != "" && metadata.Profile != config.General.Profile {
+			continue
+		}
 		result = append(result, Backup{
-			Name: name,
-			Date: info.ModTime(),
+			Name:           name,
+			Date:           info.ModTime(),
+			RequiredBackup: metadata.RequiredBackup,
+			Broken:         getLocalBackupBrokenReason(backupPath),
+			TableCount:     len(metadata.Tables),
+			Checksums:      metadata.ChecksumStatus(),
 		})
 	}
 	sort.SliceStable(result, func(i, j int) bool {
@@ -308,6 +455,7 @@ func getRemoteBackups(config Config) ([]Backup, error) {
 	if err != nil {
 		return []Backup{}, err
 	}
+	defer bd.Close()
 
 	backupList, err := bd.BackupList()
 	if err != nil {
@@ -316,56 +464,233 @@ func getRemoteBackups(config Config) ([]Backup, error) {
 	return backupList, err
 }
 
-// PrintRemoteBackups - print all backups stored on remote storage
-func PrintRemoteBackups(config Config, format string) error {
-	backupList, err := getRemoteBackups(config)
+// PrintRemoteBackups - print all backups stored on remote storages selected by target
+func PrintRemoteBackups(config Config, format string, target string) error {
+	targets, err := GetRemoteTargets(config, target)
 	if err != nil {
 		return err
 	}
-	return printBackups(backupList, format, true)
+	for _, t := range targets {
+		if len(targets) > 1 {
+			fmt.Printf("Target '%s':\n", t.Name)
+		}
+		backupList, err := getRemoteBackups(t.Config)
+		if err != nil {
+			return err
+		}
+		if err := printBackups(backupList, format, true); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Freeze - freeze tables by tablePattern
-func Freeze(config Config, tablePattern string) error {
+func Freeze(ctx context.Context, config Config, tablePattern string) error {
+	_, err := freeze(ctx, config, tablePattern, "", "", newTableReport("freeze", false))
+	return err
+}
+
+// freezeResult - tables frozen by freeze
+type freezeResult struct {
+	// shadowName - shadow directory of FREEZE WITH NAME, it's empty when tables are frozen to shadow/<increment>
+	shadowName string
+	// freezeTimes - time when every table was frozen by '<database>.<table>'
+	freezeTimes map[string]time.Time
+	// corrupted - tables with corrupted data found by CHECK TABLE by '<database>.<table>'
+	corrupted map[string]bool
+	// barrierTime - time when all shards reached barrier of cluster backup before freeze, it's zero without barrier
+	barrierTime time.Time
+}
+
+// freezeNameRE - ClickHouse escapes all chars except [a-zA-Z0-9_] in name of shadow directory
+var freezeNameRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// freezeShadowName - name of shadow directory of backup for FREEZE WITH NAME
+func freezeShadowName(backupName string) string {
+	return "clickhouse_backup_" + freezeNameRE.ReplaceAllString(backupName, "_")
+}
+
+// freeze - freeze tables by tablePattern to shadow/<name> if name is set and ClickHouse supports FREEZE WITH NAME,
+// otherwise tables are frozen to shadow/<increment> and shadow must be empty. Tables which can't be frozen are marked in report.
+// Result is returned with error when some tables are already frozen, so shadow of failed freeze can be removed
+func freeze(ctx context.Context, config Config, tablePattern string, name string, consistency string, report *tableReport) (*freezeResult, error) {
 	ch := &ClickHouse{
-		Config: &config.ClickHouse,
+		Config:   &config.ClickHouse,
+		ctx:      ctx,
+		settings: config.ClickHouse.FreezeSettings,
 	}
 	if err := ch.Connect(); err != nil {
-		return fmt.Errorf("can't connect to clickhouse: %v", err)
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer ch.Close()
 
-	dataPath, err := ch.GetDataPath()
-	if err != nil || dataPath == "" {
-		return fmt.Errorf("can't get data path from clickhouse: %v\nyou can set data_path in config file", err)
+	disks, err := ch.GetDisks()
+	if err != nil || len(disks) == 0 {
+		return nil, fmt.Errorf("can't get data path from clickhouse: %v\nyou can set data_path in config file", err)
 	}
-
-	shadowPath := filepath.Join(dataPath, "shadow")
-	files, err := ioutil.ReadDir(shadowPath)
+	version, err := ch.GetVersion()
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("can't read %s directory: %v", shadowPath, err)
+		return nil, err
+	}
+	if version < FreezeWithNameVersion {
+		name = ""
+	}
+
+	// every disk has its own shadow
+	for _, disk := range disks {
+		shadowPath := filepath.Join(disk.Path, "shadow")
+		if name != "" {
+			// another backup or manual freeze can't mix parts into shadow of this backup
+			if _, err := os.Stat(filepath.Join(shadowPath, name)); err == nil {
+				return nil, fmt.Errorf("'%s' already exists, execute 'clean' command first", filepath.Join(shadowPath, name))
+			}
+		} else {
+			files, err := ioutil.ReadDir(shadowPath)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return nil, fmt.Errorf("can't read %s directory: %v", shadowPath, err)
+				}
+			} else if len(files) > 0 {
+				return nil, fmt.Errorf("'%s' is not empty, execute 'clean' command first", shadowPath)
+			}
 		}
-	} else if len(files) > 0 {
-		return fmt.Errorf("'%s' is not empty, execute 'clean' command first", shadowPath)
 	}
 
 	allTables, err := ch.GetTables()
 	if err != nil {
-		return fmt.Errorf("can't get tables from clickhouse: %v", err)
+		return nil, fmt.Errorf("can't get tables from clickhouse: %v", err)
+	}
+	backupTables, err := parseTablePatternForFreeze(allTables, tablePattern)
+	if err != nil {
+		return nil, err
 	}
-	backupTables := parseTablePatternForFreeze(allTables, tablePattern)
 	if len(backupTables) == 0 {
-		return fmt.Errorf("there are no tables in clickhouse, create something to freeze")
+		return nil, fmt.Errorf("there are no tables in clickhouse, create something to freeze")
 	}
+	tables := make([]Table, 0, len(backupTables))
+	skippedSystem := 0
 	for _, table := range backupTables {
+		if table.Skip && table.Database == "system" {
+			skippedSystem++
+			continue
+		}
 		if table.Skip {
 			log.Printf("Skip '%s.%s'", table.Database, table.Name)
 			continue
 		}
-		if err := ch.FreezeTable(table); err != nil {
+		if table.Database == "system" {
+			log.Printf("Include system log table '%s.%s'", table.Database, table.Name)
+		}
+		tables = append(tables, table)
+	}
+	if skippedSystem > 0 {
+		log.Printf("Skip %d tables of 'system' database, use --include-system-logs to back up system log tables like query_log", skippedSystem)
+	}
+	corrupted, err := checkTables(ch, tables)
+	if err != nil {
+		return nil, err
+	}
+	if consistency == ConsistencyStrict || config.General.StopMergesDuringFreeze {
+		// merges can't remove parts of tables which are frozen later
+		log.Println("Stop merges")
+		for _, table := range tables {
+			if err := ch.StopMerges(table); err != nil {
+				return nil, err
+			}
+			defer func(table Table) {
+				if err := ch.StartMerges(table); err != nil {
+					log.Println(err)
+				}
+			}(table)
+		}
+	}
+	if err := flushTables(ch, tables, config.ClickHouse.FlushDistributed, config.ClickHouse.FlushBuffer || consistency == ConsistencyStrict); err != nil {
+		return nil, err
+	}
+	freezeTimes := map[string]time.Time{}
+	var mu sync.Mutex
+	bar := StartNewBar(!config.General.DisableProgressBar, len(tables))
+	bar.SetPrefix("tables ")
+	err = runParallel(config.General.FreezeConcurrency, len(tables), func(i int) error {
+		s := startSpan(ctx, "freeze", "table", tables[i].Database+"."+tables[i].Name)
+		start := time.Now()
+		err := ch.FreezeTable(tables[i], name)
+		observeTable(tableStepFreeze, start)
+		s.finish(err)
+		bar.Increment()
+		if err != nil {
+			return report.fail(tables[i].Database, tables[i].Name, err)
+		}
+		report.succeed(tables[i].Database, tables[i].Name)
+		mu.Lock()
+		freezeTimes[tables[i].Database+"."+tables[i].Name] = time.Now().UTC()
+		mu.Unlock()
+		return nil
+	})
+	bar.Finish()
+	return &freezeResult{shadowName: name, freezeTimes: freezeTimes, corrupted: corrupted}, err
+}
+
+// checkTables - run CHECK TABLE for tables not larger than clickhouse.check_tables_max_size when clickhouse.check_tables is set,
+// return error on corrupted table when check_tables is CheckTablesFail, otherwise return corrupted tables
+func checkTables(ch *ClickHouse, tables []Table) (map[string]bool, error) {
+	corrupted := map[string]bool{}
+	if ch.Config.CheckTables == "" {
+		return corrupted, nil
+	}
+	log.Println("Check tables")
+	for _, table := range tables {
+		if ch.Config.CheckTablesMaxSize > 0 {
+			size, err := ch.GetTableSize(table)
+			if err != nil {
+				return nil, err
+			}
+			if size > ch.Config.CheckTablesMaxSize {
+				log.Printf("Skip check of '%s.%s', size %s is larger than check_tables_max_size", table.Database, table.Name, FormatBytes(size))
+				continue
+			}
+		}
+		ok, err := ch.CheckTable(table)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			continue
+		}
+		if ch.Config.CheckTables == CheckTablesFail {
+			return nil, fmt.Errorf("CHECK TABLE found corrupted data in '%s.%s'", table.Database, table.Name)
+		}
+		log.Printf("CHECK TABLE found corrupted data in '%s.%s', table is marked as corrupted in backup", table.Database, table.Name)
+		corrupted[table.Database+"."+table.Name] = true
+	}
+	return corrupted, nil
+}
+
+// flushTables - write pending data of Distributed and Buffer tables to tables before freeze,
+// Distributed tables are flushed first as they may write to Buffer tables
+func flushTables(ch *ClickHouse, tables []Table, distributed bool, buffer bool) error {
+	if distributed {
+		distributedTables, err := ch.GetDistributedTables(tables)
+		if err != nil {
 			return err
 		}
+		for _, table := range distributedTables {
+			if err := ch.FlushDistributedTable(table); err != nil {
+				return err
+			}
+		}
+	}
+	if buffer {
+		bufferTables, err := ch.GetBufferTables(tables)
+		if err != nil {
+			return err
+		}
+		for _, table := range bufferTables {
+			if err := ch.FlushBufferTable(table); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -375,114 +700,574 @@ func NewBackupName() string {
 	return time.Now().UTC().Format(BackupTimeFormat)
 }
 
+// checkFreeSpaceForCreate - check that backup path has enough free space to copy data of tables matched by tablePattern
+// Nothing is copied when backup path and all disks are on the same filesystem because hard links are used
+func checkFreeSpaceForCreate(ctx context.Context, config Config, dataPath, backupPath, tablePattern string) error {
+	ch := &ClickHouse{
+		Config: &config.ClickHouse,
+		ctx:    ctx,
+	}
+	if err := ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	disks, err := ch.GetDisks()
+	if err != nil {
+		return err
+	}
+	sameDevice := isSameDevice(dataPath, backupPath)
+	for _, disk := range disks {
+		sameDevice = sameDevice && isSameDevice(disk.Path, backupPath)
+	}
+	if sameDevice {
+		return nil
+	}
+	allTables, err := ch.GetTables()
+	if err != nil {
+		return fmt.Errorf("can't get tables from clickhouse: %v", err)
+	}
+	var required int64
+	tables, err := parseTablePatternForFreeze(allTables, tablePattern)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if table.Skip {
+			continue
+		}
+		size, err := ch.GetTableSize(table)
+		if err != nil {
+			return err
+		}
+		required += size
+	}
+	return checkFreeSpace(backupPath, required)
+}
+
+const (
+	// ConsistencyStrict - stop merges of all tables and flush Buffer tables while tables are frozen,
+	// so backup is as close to a single point in time as possible
+	ConsistencyStrict = "strict"
+	// CheckTablesFail, CheckTablesRecord - values of clickhouse.check_tables, corrupted table stops create or is marked in backup metadata
+	CheckTablesFail   = "fail"
+	CheckTablesRecord = "record"
+)
+
+// CreateOptions - settings of create set by CLI flags or API query arguments
+type CreateOptions struct {
+	// Consistency - empty or ConsistencyStrict
+	Consistency string
+	// DiffFrom - local backup, files of parts unchanged since it are hard linked from it instead of copying
+	DiffFrom string
+	// IncludeDetached - save detached parts of tables to 'detached' directory of backup, they aren't attached on restore
+	IncludeDetached bool
+	// ContinueOnError - tables which can't be backed up are left out of backup and listed in its metadata instead of aborting create
+	ContinueOnError bool
+	// IncludeSystemLogs - comma separated system log tables like 'query_log,metric_log' backed up despite clickhouse.skip_tables,
+	// clickhouse.include_system_logs is used when it's empty
+	IncludeSystemLogs string
+}
+
 // CreateBackup - create new backup of all tables matched by tablePattern
 // If backupName is empty string will use default backup name
-func CreateBackup(config Config, backupName, tablePattern string) error {
+func CreateBackup(ctx context.Context, config Config, backupName, tablePattern string, options CreateOptions) (err error) {
+	if options.Consistency != "" && options.Consistency != ConsistencyStrict {
+		return fmt.Errorf("unknown consistency '%s', must be '%s'", options.Consistency, ConsistencyStrict)
+	}
+	tablePattern = getProfileTables(config, tablePattern)
+	if options.IncludeSystemLogs != "" {
+		systemLogs, err := parseSystemLogs(options.IncludeSystemLogs)
+		if err != nil {
+			return err
+		}
+		config.ClickHouse.IncludeSystemLogs = systemLogs
+	}
 	if backupName == "" {
 		backupName = NewBackupName()
 	}
+	finishHooks, err := startHooks(config, HookEvent{Operation: "create", BackupName: backupName})
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishHooks(err) }()
+	ctx, finishTrace := startTrace(ctx, config, "create", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("create")
+	defer func() { finishTimer(err) }()
 	dataPath := getDataPath(config)
 	if dataPath == "" {
 		return ErrUnknownClickhouseDataPath
 	}
 	backupPath := path.Join(dataPath, "backup", backupName)
+	setLocalIOLimits(config.General)
 	if _, err := os.Stat(backupPath); err == nil || !os.IsNotExist(err) {
 		return fmt.Errorf("can't create backup '%s' already exists", backupPath)
 	}
-	if err := os.MkdirAll(backupPath, os.ModePerm); err != nil {
+	var linker *diffFromLinker
+	if options.DiffFrom != "" {
+		var err error
+		if linker, err = newDiffFromLinker(path.Join(dataPath, "backup", options.DiffFrom)); err != nil {
+			return err
+		}
+	}
+	if err := checkFreeSpaceForCreate(ctx, config, dataPath, backupPath, tablePattern); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(backupPath, dirMode(os.ModePerm)); err != nil {
 		return fmt.Errorf("can't create backup: %v", err)
 	}
+	if err := markBackupIncomplete(backupPath); err != nil {
+		removePartialBackup(config, backupPath)
+		return err
+	}
 	log.Printf("Create backup '%s'", backupName)
-	if err := Freeze(config, tablePattern); err != nil {
+	report := newTableReport("create", options.ContinueOnError)
+	frozen, partDisks, err := createBackup(ctx, config, dataPath, backupPath, tablePattern, options, linker, report)
+	if err != nil {
+		removePartialBackup(config, backupPath)
 		return err
 	}
-	log.Println("Copy metadata")
-	schemaList, err := parseSchemaPattern(path.Join(dataPath, "metadata"), tablePattern)
+	// backup is kept when some of tables failed, it's removed when all of them failed
+	tablesErr := report.err()
+	if _, ok := tablesErr.(*TablesError); tablesErr != nil && !ok {
+		removePartialBackup(config, backupPath)
+		return tablesErr
+	}
+	metadata := BackupMetadata{
+		BackupName:   backupName,
+		CreationDate: time.Now().UTC(),
+		Consistency:  options.Consistency,
+		FailedTables: report.failedTables(),
+		Profile:      config.General.Profile,
+	}
+	if !frozen.barrierTime.IsZero() {
+		metadata.BarrierTime = &frozen.barrierTime
+	}
+	if err := getServerInfo(ctx, config, &metadata); err != nil {
+		log.Printf("ClickHouse version and macros are not saved to backup: %v", err)
+	}
+	tables, size, err := getBackupTablesMetadata(backupPath)
 	if err != nil {
+		removePartialBackup(config, backupPath)
 		return err
 	}
-	for _, schema := range schemaList {
-		skip := false
-		for _, filter := range config.ClickHouse.SkipTables {
-			if matched, _ := filepath.Match(filter, fmt.Sprintf("%s.%s", schema.Database, schema.Table)); matched {
-				skip = true
-				break
+	for i, t := range tables {
+		if query, err := ioutil.ReadFile(path.Join(backupPath, "metadata", TablePathEncode(t.Database), TablePathEncode(t.Table)+".sql")); err == nil {
+			tables[i].TTL, tables[i].ColumnsTTL = getTTLExpressions(string(query))
+		}
+		tables[i].FreezeTime = frozen.freezeTimes[t.Database+"."+t.Table]
+		tables[i].Corrupted = frozen.corrupted[t.Database+"."+t.Table]
+		for j, p := range t.Parts {
+			tables[i].Parts[j].Disk = partDisks[path.Join(TablePathEncode(t.Database), TablePathEncode(t.Table), p.Name)]
+		}
+	}
+	metadata.Tables, metadata.Size = tables, size
+	if options.IncludeDetached {
+		detached, err := getBackupDetachedMetadata(backupPath)
+		if err != nil {
+			removePartialBackup(config, backupPath)
+			return err
+		}
+		for i, t := range detached {
+			for j, p := range t.Parts {
+				detached[i].Parts[j].Disk = partDisks[path.Join("detached", TablePathEncode(t.Database), TablePathEncode(t.Table), p.Name)]
 			}
 		}
-		if skip {
+		metadata.Detached = detached
+	}
+	if err := backupKeeper(config.Keeper, backupPath); err != nil {
+		removePartialBackup(config, backupPath)
+		return err
+	}
+	if err := metadata.Save(backupPath); err != nil {
+		removePartialBackup(config, backupPath)
+		return err
+	}
+	if err := updateTableFreshness(dataPath, metadata); err != nil {
+		log.Printf("Freshness of tables is not updated: %v", err)
+	}
+	if err := RemoveOldBackupsLocal(config); err != nil {
+		return err
+	}
+	log.Println("  Done.")
+	return tablesErr
+}
+
+// CreateRemoteOptions - settings of create_remote, backup is created with CreateOptions and uploaded to remote storages selected by Target
+type CreateRemoteOptions struct {
+	CreateOptions
+	Target string
+	// DeleteLocal - remove local backup after successful upload
+	DeleteLocal bool
+}
+
+// CreateRemoteBackup - create backup, upload it and remove old local and remote backups as one operation
+func CreateRemoteBackup(ctx context.Context, config Config, backupName, tablePattern string, options CreateRemoteOptions) (err error) {
+	if backupName == "" {
+		backupName = NewBackupName()
+	}
+	ctx, finishTrace := startTrace(ctx, config, "create_remote", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("create_remote")
+	defer func() { finishTimer(err) }()
+	// backup without failed tables is uploaded, failed tables are reported after upload
+	var tablesErr *TablesError
+	if err := CreateBackup(ctx, config, backupName, tablePattern, options.CreateOptions); err != nil && !errors.As(err, &tablesErr) {
+		return err
+	}
+	if err := Upload(ctx, config, backupName, tablePattern, "", options.Target); err != nil {
+		return err
+	}
+	if options.DeleteLocal {
+		log.Printf("Remove local backup '%s'", backupName)
+		if err := RemoveBackupLocal(config, backupName, false); err != nil {
+			return fmt.Errorf("can't remove local backup: %v", err)
+		}
+	}
+	if tablesErr != nil {
+		return tablesErr
+	}
+	return nil
+}
+
+// createBackup - freeze tables and move data and metadata to backupPath, data of all disks is merged to shadow of backup.
+// Tables which failed are marked in report and left out of backup. Return result of freeze and disk of every part by '<database>/<table>/<part>'
+func createBackup(ctx context.Context, config Config, dataPath, backupPath, tablePattern string, options CreateOptions, linker *diffFromLinker, report *tableReport) (*freezeResult, map[string]string, error) {
+	disks, err := getDisks(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, disk := range disks {
+		if !isDir(disk.Path) {
+			return nil, nil, fmt.Errorf("path '%s' of disk '%s' is not accessible", disk.Path, disk.Name)
+		}
+	}
+	barrierTime, err := waitBarrier(ctx, config, path.Base(backupPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	frozen, err := freeze(ctx, config, tablePattern, freezeShadowName(path.Base(backupPath)), options.Consistency, report)
+	if frozen != nil && frozen.shadowName != "" {
+		// shadow of this backup is removed on failure, shadow of other backups is not touched
+		for _, disk := range disks {
+			defer os.RemoveAll(path.Join(disk.Path, "shadow", frozen.shadowName))
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	frozen.barrierTime = barrierTime
+	shadowName := frozen.shadowName
+	ch := &ClickHouse{
+		Config: &config.ClickHouse,
+		ctx:    ctx,
+	}
+	if err := ch.Connect(); err != nil {
+		return nil, nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	log.Println("Copy metadata")
+	schemaList, err := parseSchemaPattern(path.Join(dataPath, "metadata"), tablePattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	backupSchemas := RestoreTables{}
+	for _, schema := range schemaList {
+		if isSkippedTable(&config.ClickHouse, schema.Database, schema.Table) || report.failed(schema.Database, schema.Table) {
 			continue
 		}
 		relativePath := strings.Trim(strings.TrimPrefix(schema.Path, path.Join(dataPath, "metadata")), "/")
 		newPath := path.Join(backupPath, "metadata", relativePath)
-		if err := copyFile(schema.Path, newPath); err != nil {
-			return fmt.Errorf("can't backup metadata: %v", err)
+		if err := backupSchema(ch, schema, newPath); err != nil {
+			if err := report.fail(schema.Database, schema.Table, fmt.Errorf("can't backup metadata: %v", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
+		backupSchemas = append(backupSchemas, schema)
 	}
 	log.Println("  Done.")
 
 	log.Println("Move shadow")
 	backupShadowDir := path.Join(backupPath, "shadow")
-	if err := os.MkdirAll(backupShadowDir, os.ModePerm); err != nil {
-		return err
+	if err := os.MkdirAll(backupShadowDir, dirMode(os.ModePerm)); err != nil {
+		return nil, nil, err
 	}
-	shadowDir := path.Join(dataPath, "shadow")
-	if err := moveShadow(shadowDir, backupShadowDir); err != nil {
+	partDisks := map[string]string{}
+	for _, disk := range disks {
+		shadowDir := path.Join(disk.Path, "shadow")
+		if !isDir(shadowDir) {
+			continue
+		}
+		// names of parts of table are unique on all disks, so parts of different disks can't overwrite each other
+		parts, err := getShadowParts(shadowDir, shadowName)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, part := range parts {
+			partDisks[part] = disk.Name
+		}
+		if shadowName != "" {
+			err = moveNamedShadow(shadowDir, shadowName, backupShadowDir, config.General.FreezeConcurrency, linker)
+		} else {
+			err = moveShadow(shadowDir, backupShadowDir, config.General.FreezeConcurrency, linker)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if options.IncludeDetached {
+		log.Println("Link detached parts")
+		for _, schema := range backupSchemas {
+			if err := backupDetached(disks, backupPath, schema.Database, schema.Table, partDisks); err != nil {
+				if err := report.fail(schema.Database, schema.Table, err); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	// parts of failed tables are removed, so backup contains only tables which are completely backed up
+	for _, t := range report.failedTables() {
+		tablePath := path.Join(TablePathEncode(t.Database), TablePathEncode(t.Table))
+		for _, dir := range []string{path.Join(backupShadowDir, tablePath), path.Join(backupPath, "detached", tablePath), path.Join(backupPath, "metadata", tablePath+".sql")} {
+			if err := os.RemoveAll(dir); err != nil {
+				return nil, nil, fmt.Errorf("can't remove '%s' of failed table: %v", dir, err)
+			}
+		}
+	}
+	return frozen, partDisks, nil
+}
+
+// backupSchema - write canonical CREATE query of table to newPath, SETTINGS, codecs and comments are kept as ClickHouse applies them
+// and name of table is set instead of '_' of tables in Atomic databases. Metadata file is copied when credentials are hidden in the query
+func backupSchema(ch *ClickHouse, schema RestoreTable, newPath string) error {
+	query, err := ch.ShowCreateTable(schema.Database, schema.Table)
+	if err != nil {
 		return err
 	}
-	if err := RemoveOldBackupsLocal(config); err != nil {
+	if strings.Contains(query, "'[HIDDEN]'") {
+		return copyFile(schema.Path, newPath)
+	}
+	if err := os.MkdirAll(path.Dir(newPath), dirMode(os.ModePerm)); err != nil {
 		return err
 	}
-	log.Println("  Done.")
+	return ioutil.WriteFile(newPath, []byte(strings.TrimSpace(query)+"\n"), fileMode(0644))
+}
+
+// backupDetached - hard link detached parts of table on every disk to 'detached/<database>/<table>' of backup,
+// disk of every part is set in partDisks by 'detached/<database>/<table>/<part>'
+func backupDetached(disks []Disk, backupPath, database, table string, partDisks map[string]string) error {
+	tablePath := path.Join(TablePathEncode(database), TablePathEncode(table))
+	for _, disk := range disks {
+		detachedPath := path.Join(disk.Path, "data", tablePath, "detached")
+		parts, err := ioutil.ReadDir(detachedPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("can't read '%s': %v", detachedPath, err)
+		}
+		for _, part := range parts {
+			if !part.IsDir() {
+				continue
+			}
+			partDisks[path.Join("detached", tablePath, part.Name())] = disk.Name
+		}
+		if err := filepath.Walk(detachedPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			dstFilePath := path.Join(backupPath, "detached", tablePath, strings.TrimPrefix(filepath.ToSlash(filePath), detachedPath))
+			if info.IsDir() {
+				return os.MkdirAll(dstFilePath, dirMode(os.ModePerm))
+			}
+			if !info.Mode().IsRegular() {
+				log.Printf("'%s' is not a regular file, skipping", filePath)
+				return nil
+			}
+			return linkFile(filePath, dstFilePath)
+		}); err != nil {
+			return fmt.Errorf("can't backup detached parts of '%s.%s': %v", database, table, err)
+		}
+	}
 	return nil
 }
 
-// Restore - restore tables matched by tablePattern from backupName
-func Restore(config Config, backupName string, tablePattern string, schemaOnly bool, dataOnly bool, dropTable bool) error {
+// RestoreOptions - settings of restore set by CLI flags or API query arguments
+type RestoreOptions struct {
+	SchemaOnly bool
+	DataOnly   bool
+	DropTable  bool
+	// ConvertEngine - 'plain' or 'replicated' to rewrite MergeTree family engines in restored schema
+	ConvertEngine string
+	// SubstituteMacros - replace macros of source server by macros of target server in ZooKeeper paths
+	SubstituteMacros bool
+	// DropReplica - remove stale replica metadata from ZooKeeper before creating Replicated tables, works only with DropTable
+	DropReplica bool
+	// RewriteDDL - convert deprecated syntax in restored schema to syntax supported by current ClickHouse versions
+	RewriteDDL bool
+	// Validate - compare rows and checksums of parts of restored tables with metadata of backup
+	Validate bool
+	// Rehearsal - restore tables into temporary database, validate and drop it instead of restoring production tables
+	Rehearsal bool
+	// ContinueOnError - tables which can't be restored are skipped and reported instead of aborting restore
+	ContinueOnError bool
+	// StopTTLMerges - stop TTL merges of tables with TTL before attach, they are left stopped so expired rows of restored parts are kept
+	StopTTLMerges bool
+	// Reshard - comma separated local backups of other shards, their parts are attached to tables restored from backup too,
+	// so backups of N shards are consolidated on one server
+	Reshard string
+}
+
+// Restore - restore tables matched by tablePattern from backupName, return names of restored tables
+func Restore(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions) (restored []string, err error) {
+	finishHooks, err := startHooks(config, HookEvent{Operation: "restore", BackupName: backupName})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = finishHooks(err) }()
+	ctx, finishTrace := startTrace(ctx, config, "restore", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("restore")
+	defer func() { finishTimer(err) }()
+	return restore(ctx, config, backupName, tablePattern, options)
+}
+
+func restore(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions) ([]string, error) {
+	if options.ConvertEngine != "" && options.ConvertEngine != EngineConvertPlain && options.ConvertEngine != EngineConvertReplicated {
+		return nil, fmt.Errorf("unknown engine conversion '%s', must be '%s' or '%s'", options.ConvertEngine, EngineConvertPlain, EngineConvertReplicated)
+	}
+	if options.DropReplica && !options.DropTable {
+		return nil, fmt.Errorf("dropping of replica is allowed only with dropping of table")
+	}
+	if options.Rehearsal && (options.SchemaOnly || options.DataOnly || options.DropTable || options.DropReplica || options.ConvertEngine != "" || options.ContinueOnError) {
+		return nil, fmt.Errorf("rehearsal can't be combined with --schema, --data, --rm, --drop-replica, --convert-engine and --continue-on-error")
+	}
+	if options.Reshard != "" && (options.SchemaOnly || options.Validate || options.Rehearsal) {
+		return nil, fmt.Errorf("reshard can't be combined with --schema, --validate and --rehearsal")
+	}
+	if _, err := parseTablePattern(tablePattern); err != nil {
+		return nil, err
+	}
+	setLocalIOLimits(config.General)
+	if backupName != "" {
+		if err := GetLocalBackup(config, backupName); err != nil {
+			return nil, fmt.Errorf("can't restore: %v", err)
+		}
+	}
+	shardBackups := parseBackupNames(options.Reshard)
+	for _, shardBackup := range shardBackups {
+		if shardBackup == backupName {
+			return nil, fmt.Errorf("backup '%s' can't be resharded into itself", shardBackup)
+		}
+		if err := GetLocalBackup(config, shardBackup); err != nil {
+			return nil, fmt.Errorf("can't reshard: %v", err)
+		}
+	}
+	if options.Rehearsal {
+		return restoreRehearsal(ctx, config, backupName, tablePattern, options)
+	}
+	var restored []string
+	report := newTableReport("restore", options.ContinueOnError)
+	schemaOnly, dataOnly := options.SchemaOnly, options.DataOnly
 	if schemaOnly || (schemaOnly == dataOnly) {
-		if err := restoreSchema(config, backupName, tablePattern, dropTable); err != nil {
-			return err
+		tables, err := restoreSchema(ctx, config, backupName, tablePattern, options, report)
+		if err != nil {
+			return nil, err
 		}
+		restored = tables
 	}
 	if dataOnly || (schemaOnly == dataOnly) {
-		if err := RestoreData(config, backupName, tablePattern); err != nil {
-			return err
+		if getLocalBackupMetadata(path.Join(getDataPath(config), "backup", backupName)).SchemaOnly {
+			return nil, fmt.Errorf("backup '%s' was downloaded without data, use 'restore --schema' or download it again without '--schema'", backupName)
+		}
+		tables, err := restoreData(ctx, config, backupName, tablePattern, options, report)
+		if err != nil {
+			return report.filter(restored), err
+		}
+		if restored == nil {
+			restored = tables
+		}
+		for _, shardBackup := range shardBackups {
+			if err := restoreShardData(ctx, config, shardBackup, tablePattern, options, report); err != nil {
+				return report.filter(restored), err
+			}
 		}
 	}
-	return nil
+	// tables whose data failed are not restored even if their schema was created
+	return report.filter(restored), report.err()
+}
+
+// restoreShardData - attach parts of backup of another shard to tables restored from the first backup, ClickHouse assigns
+// new block numbers to attached parts, so parts with the same names in backups of different shards don't conflict
+func restoreShardData(ctx context.Context, config Config, shardBackup string, tablePattern string, options RestoreOptions, report *tableReport) error {
+	if getLocalBackupMetadata(path.Join(getDataPath(config), "backup", shardBackup)).SchemaOnly {
+		return fmt.Errorf("backup '%s' was downloaded without data, download it again without '--schema'", shardBackup)
+	}
+	log.Printf("Reshard data of '%s'", shardBackup)
+	_, err := restoreData(ctx, config, shardBackup, tablePattern, options, report)
+	return err
+}
+
+// parseBackupNames - split comma separated names of backups
+func parseBackupNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 // RestoreData - restore data for tables matched by tablePattern from backupName
 func RestoreData(config Config, backupName string, tablePattern string) error {
+	_, err := restoreData(context.Background(), config, backupName, tablePattern, RestoreOptions{}, newTableReport("restore", false))
+	return err
+}
+
+// restoreData - copy and attach parts of tables, restored tables are compared with metadata of backup when options.Validate is set.
+// Tables which failed in report are skipped
+func restoreData(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions, report *tableReport) ([]string, error) {
 	if backupName == "" {
 		PrintLocalBackups(config, "all")
-		return fmt.Errorf("select backup for restore")
+		return nil, fmt.Errorf("select backup for restore")
 	}
 	dataPath := getDataPath(config)
 	if dataPath == "" {
-		return ErrUnknownClickhouseDataPath
+		return nil, ErrUnknownClickhouseDataPath
 	}
 	ch := &ClickHouse{
-		Config: &config.ClickHouse,
+		Config:   &config.ClickHouse,
+		ctx:      ctx,
+		settings: config.ClickHouse.RestoreSettings,
 	}
 	if err := ch.Connect(); err != nil {
-		return fmt.Errorf("can't connect to clickhouse: %v", err)
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
 	defer ch.Close()
 
 	allBackupTables, err := ch.GetBackupTables(backupName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	restoreTables, err := parseTablePatternForRestoreData(allBackupTables, tablePattern)
+	if err != nil {
+		return nil, err
 	}
-	restoreTables := parseTablePatternForRestoreData(allBackupTables, tablePattern)
 	chTables, err := ch.GetTables()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(restoreTables) == 0 {
-		return fmt.Errorf("backup doesn't have tables to restore")
+		return nil, fmt.Errorf("backup doesn't have tables to restore")
 	}
 	missingTables := []string{}
+	existingTables := []BackupTable{}
 	for _, restoreTable := range restoreTables {
+		if report.failed(restoreTable.Database, restoreTable.Name) {
+			continue
+		}
 		found := false
 		for _, chTable := range chTables {
 			if (restoreTable.Database == chTable.Database) && (restoreTable.Name == chTable.Name) {
@@ -490,24 +1275,160 @@ func RestoreData(config Config, backupName string, tablePattern string) error {
 				break
 			}
 		}
-		if !found {
+		if found {
+			existingTables = append(existingTables, restoreTable)
+			continue
+		}
+		if err := report.fail(restoreTable.Database, restoreTable.Name, fmt.Errorf("table is not created")); err != nil {
 			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", restoreTable.Database, restoreTable.Name))
 		}
 	}
 	if len(missingTables) > 0 {
-		return fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
+		return nil, fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
+	}
+	return restoreTablesData(ch, config, backupName, existingTables, options, nil, report)
+}
+
+// restoreTablesData - copy and attach parts of tables of backup, targetName returns database and table where parts
+// of table are attached, tables are attached to tables with the same names when it's nil. Only Validate and StopTTLMerges
+// of options are used. Tables which failed are marked in report
+func restoreTablesData(ch *ClickHouse, config Config, backupName string, restoreTables []BackupTable, options RestoreOptions, targetName func(database, table string) (string, string), report *tableReport) ([]string, error) {
+	dataPath := getDataPath(config)
+	if !isSameDevice(path.Join(dataPath, "backup"), path.Join(dataPath, "data")) {
+		var required int64
+		for _, table := range restoreTables {
+			for _, partition := range table.Partitions {
+				size, err := getDirSize(partition.Path)
+				if err != nil {
+					return nil, err
+				}
+				required += size
+			}
+		}
+		if err := checkFreeSpace(path.Join(dataPath, "data"), required); err != nil {
+			return nil, err
+		}
+	}
+	disks, err := ch.GetDisks()
+	if err != nil {
+		return nil, err
 	}
+	setRestoreFileOwner(ch, config.General.RestoreFileOwner)
+	parts := 0
 	for _, table := range restoreTables {
-		if err := ch.CopyData(table); err != nil {
-			return fmt.Errorf("can't restore '%s.%s': %v", table.Database, table.Name, err)
+		parts += len(table.Partitions)
+	}
+	throttle := newAttachThrottle(config.Restore, parts)
+	backupTables := map[string]BackupTableMetadata{}
+	if options.Validate {
+		metadata, err := describeLocalBackupPath(path.Join(dataPath, "backup", backupName))
+		if err != nil {
+			return nil, err
 		}
-		if err := ch.AttachPatritions(table); err != nil {
-			return fmt.Errorf("can't attach partitions for table '%s.%s': %v", table.Database, table.Name, err)
+		for _, t := range metadata.Tables {
+			backupTables[t.Database+"."+t.Table] = t
 		}
 	}
+	restored := []string{}
+	validations := []TableValidation{}
+	bar := StartNewBar(!config.General.DisableProgressBar, parts)
+	bar.SetPrefix("parts ")
+	defer bar.Finish()
+	for _, table := range restoreTables {
+		target := table
+		if targetName != nil {
+			target.Database, target.Name = targetName(table.Database, table.Name)
+		}
+		var rowsBefore uint64
+		if options.Validate {
+			if rowsBefore, _, err = ch.GetTableParts(target.Database, target.Name); err != nil {
+				return nil, err
+			}
+		}
+		if err := prepareTTL(ch, target.Database, target.Name, options.StopTTLMerges); err != nil {
+			if err := report.fail(table.Database, table.Name, err); err != nil {
+				return nil, err
+			}
+			bar.Add64(int64(len(table.Partitions)))
+			continue
+		}
+		s := startSpan(ch.ctx, "restore table", "table", table.Database+"."+table.Name)
+		start := time.Now()
+		err := restoreTableData(ch, table, target, disks, throttle)
+		observeTable(tableStepRestore, start)
+		s.finish(err)
+		bar.Add64(int64(len(table.Partitions)))
+		if err != nil {
+			if err := report.fail(table.Database, table.Name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		report.succeed(table.Database, table.Name)
+		restored = append(restored, fmt.Sprintf("%s.%s", table.Database, table.Name))
+		if options.Validate {
+			metadata := backupTables[table.Database+"."+table.Name]
+			metadata.Database, metadata.Table = target.Database, target.Name
+			v, err := validateRestoredTable(ch, metadata, rowsBefore)
+			if err != nil {
+				if err := report.fail(table.Database, table.Name, fmt.Errorf("can't validate '%s.%s': %v", table.Database, table.Name, err)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			v.Database, v.Table = table.Database, table.Name
+			validations = append(validations, v)
+		}
+	}
+	if options.Validate {
+		if err := reportValidation(validations); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// prepareTTL - stop TTL merges of table with TTL when stop is set, otherwise warn that expired rows of attached parts may be removed
+func prepareTTL(ch *ClickHouse, database, table string, stop bool) error {
+	hasTTL, err := ch.HasTTL(database, table)
+	if err != nil || !hasTTL {
+		return err
+	}
+	if !stop {
+		log.Printf("'%s.%s' has TTL, expired rows of restored parts may be removed by TTL merges, use --stop-ttl-merges to keep them", database, table)
+		return nil
+	}
+	if err := ch.StopTTLMerges(database, table); err != nil {
+		return err
+	}
+	log.Printf("TTL merges of '%s.%s' are stopped until 'SYSTEM START TTL MERGES `%s`.`%s`' or restart of ClickHouse", database, table, database, table)
+	return nil
+}
+
+// restoreTableData - copy parts of table of backup to detached directory of target table and attach them
+func restoreTableData(ch *ClickHouse, table, target BackupTable, disks []Disk, throttle *attachThrottle) error {
+	if err := ch.CopyData(target, disks); err != nil {
+		return fmt.Errorf("can't restore '%s.%s': %v", table.Database, table.Name, err)
+	}
+	if err := ch.AttachPatritions(target, throttle); err != nil {
+		return fmt.Errorf("can't attach partitions for table '%s.%s': %v", target.Database, target.Name, err)
+	}
 	return nil
 }
 
+// getDisks - return disks of ClickHouse, only data_path is returned when it's set and ClickHouse isn't available
+func getDisks(config Config) ([]Disk, error) {
+	ch := &ClickHouse{Config: &config.ClickHouse}
+	if err := ch.Connect(); err != nil {
+		if config.ClickHouse.DataPath != "" {
+			return []Disk{{Name: DefaultDisk, Path: config.ClickHouse.DataPath}}, nil
+		}
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	return ch.GetDisks()
+}
+
 func getDataPath(config Config) string {
 	if config.ClickHouse.DataPath != "" {
 		return config.ClickHouse.DataPath
@@ -534,13 +1455,42 @@ func GetLocalBackup(config Config, backupName string) error {
 	}
 	for _, backup := range backupList {
 		if backup.Name == backupName {
+			if backup.Broken != "" {
+				return fmt.Errorf("backup '%s' is broken: %s", backupName, backup.Broken)
+			}
 			return nil
 		}
 	}
-	return fmt.Errorf("backup '%s' not found", backupName)
+	return fmt.Errorf("backup '%s' %w", backupName, errLocalBackupNotFound)
+}
+
+// Upload - upload tables matched by tablePattern from local backup to remote storages selected by target
+func Upload(ctx context.Context, config Config, backupName string, tablePattern string, diffFrom string, target string) (err error) {
+	if _, err := parseTablePattern(tablePattern); err != nil {
+		return err
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	finishHooks, err := startHooks(config, HookEvent{Operation: "upload", BackupName: backupName, Target: target})
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishHooks(err) }()
+	ctx, finishTrace := startTrace(ctx, config, "upload", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("upload")
+	defer func() { finishTimer(err) }()
+	return runOnTargets("upload", targets, func(t RemoteTarget) error {
+		if len(targets) > 1 {
+			log.Printf("Upload to remote target '%s'", t.Name)
+		}
+		return upload(ctx, t.Config, backupName, tablePattern, diffFrom, t.Name)
+	})
 }
 
-func Upload(config Config, backupName string, diffFrom string) error {
+func upload(ctx context.Context, config Config, backupName string, tablePattern string, diffFrom string, target string) (err error) {
 	if config.General.RemoteStorage == "none" {
 		fmt.Println("Upload aborted: RemoteStorage set to \"none\"")
 		return nil
@@ -558,24 +1508,36 @@ func Upload(config Config, backupName string, diffFrom string) error {
 	if err != nil {
 		return err
 	}
+	bd.ctx = ctx
 
 	err = bd.Connect()
 	if err != nil {
-		return fmt.Errorf("can't connect to %s: %v", bd.Kind(), err)
+		return fmt.Errorf("can't connect to remote storage %s: %v", bd.Kind(), err)
 	}
+	defer bd.Close()
 
 	if err := GetLocalBackup(config, backupName); err != nil {
 		return fmt.Errorf("can't upload: %v", err)
 	}
 	backupPath := path.Join(dataPath, "backup", backupName)
+	journal, previous, err := startJournal(dataPath, "upload", backupName, target, map[string]string{
+		"table":       tablePattern,
+		"diff-from":   diffFrom,
+		"compression": getExtension(bd.compressionFormat),
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload: %v", err)
+	}
+	defer func() { journal.finish(err) }()
 	log.Printf("Upload backup '%s'", backupName)
 	diffFromPath := ""
 	if diffFrom != "" {
 		diffFromPath = path.Join(dataPath, "backup", diffFrom)
 	}
-	if err := bd.CompressedStreamUpload(backupPath, backupName, diffFromPath); err != nil {
+	if err := bd.CompressedStreamUpload(backupPath, backupName, diffFromPath, tablePattern, journal, previous); err != nil {
 		return fmt.Errorf("can't upload: %v", err)
 	}
+	journal.phase("retention")
 	if err := bd.RemoveOldBackups(bd.BackupsToKeep()); err != nil {
 		return fmt.Errorf("can't remove old backups: %v", err)
 	}
@@ -583,57 +1545,119 @@ func Upload(config Config, backupName string, diffFrom string) error {
 	return nil
 }
 
-func Download(config Config, backupName string) error {
+// Download - download tables matched by tablePattern from remote backup, only schema of tables is downloaded when schemaOnly is set
+func Download(ctx context.Context, config Config, backupName string, tablePattern string, schemaOnly bool) (err error) {
 	if config.General.RemoteStorage == "none" {
 		fmt.Println("Download aborted: RemoteStorage set to \"none\"")
 		return nil
 	}
 	if backupName == "" {
-		PrintRemoteBackups(config, "all")
+		PrintRemoteBackups(config, "all", "")
 		return fmt.Errorf("select backup for download")
 	}
+	if _, err := parseTablePattern(tablePattern); err != nil {
+		return err
+	}
 	dataPath := getDataPath(config)
 	if dataPath == "" {
 		return ErrUnknownClickhouseDataPath
 	}
+	finishHooks, err := startHooks(config, HookEvent{Operation: "download", BackupName: backupName})
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishHooks(err) }()
+	ctx, finishTrace := startTrace(ctx, config, "download", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("download")
+	defer func() { finishTimer(err) }()
 	bd, err := NewBackupDestination(config)
 	if err != nil {
 		return err
 	}
+	bd.ctx = ctx
 
 	err = bd.Connect()
 	if err != nil {
 		return err
 	}
-	err = bd.CompressedStreamDownload(backupName, path.Join(dataPath, "backup", backupName))
+	defer bd.Close()
+	journal, previous, err := startJournal(dataPath, "download", backupName, "", nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("can't download: %v", err)
+	}
+	defer func() { journal.finish(err) }()
+	backupPath := path.Join(dataPath, "backup", backupName)
+	_, err = os.Stat(backupPath)
+	backupExists := err == nil
+	if backupExists && previous != nil && getLocalBackupBrokenReason(backupPath) != "" {
+		log.Printf("Remove files of interrupted download '%s'", backupPath)
+		if err := os.RemoveAll(backupPath); err != nil {
+			return fmt.Errorf("can't remove '%s': %v", backupPath, err)
+		}
+		backupExists = false
+	}
+	journal.phase("archive")
+	err = bd.CompressedStreamDownload(backupName, backupPath, tablePattern, schemaOnly)
+	if err != nil {
+		if !backupExists {
+			removePartialBackup(config, backupPath)
+		}
+		return fmt.Errorf("can't download: %v", err)
 	}
 	log.Println("  Done.")
 	return nil
 }
 
-// Clean - removed all data in shadow folder
-func Clean(config Config) error {
-	dataPath := getDataPath(config)
-	if dataPath == "" {
-		return ErrUnknownClickhouseDataPath
+// RestoreRemoteBackup - download backup unless it exists locally and restore it as one operation
+func RestoreRemoteBackup(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions) (restored []string, err error) {
+	if backupName == "" {
+		PrintRemoteBackups(config, "all", "")
+		return nil, fmt.Errorf("select backup for restore")
+	}
+	ctx, finishTrace := startTrace(ctx, config, "restore_remote", backupName)
+	defer func() { finishTrace(err) }()
+	finishTimer := startOperationTimer("restore_remote")
+	defer func() { finishTimer(err) }()
+	// backup imported by 'catalog import' or downloaded by 'download --schema' doesn't have data
+	schemaOnly := getLocalBackupMetadata(path.Join(getDataPath(config), "backup", backupName)).SchemaOnly
+	if err := GetLocalBackup(config, backupName); err == nil && (!schemaOnly || options.SchemaOnly) {
+		log.Printf("Backup '%s' exists locally, skip download", backupName)
+	} else if err := Download(ctx, config, backupName, tablePattern, options.SchemaOnly); err != nil {
+		return nil, err
 	}
-	shadowDir := path.Join(dataPath, "shadow")
-	if _, err := os.Stat(shadowDir); os.IsNotExist(err) {
-		log.Printf("%s directory does not exist, nothing to do", shadowDir)
-		return nil
+	for _, shardBackup := range parseBackupNames(options.Reshard) {
+		if err := GetLocalBackup(config, shardBackup); err == nil {
+			log.Printf("Backup '%s' exists locally, skip download", shardBackup)
+		} else if err := Download(ctx, config, shardBackup, tablePattern, false); err != nil {
+			return nil, err
+		}
 	}
-	log.Printf("Clean %s", shadowDir)
-	if err := cleanDir(shadowDir); err != nil {
-		return fmt.Errorf("can't clean '%s': %v", shadowDir, err)
+	return Restore(ctx, config, backupName, tablePattern, options)
+}
+
+// Clean - removed all data in shadow folder of every disk
+func Clean(config Config) error {
+	disks, err := getDisks(config)
+	if err != nil {
+		return err
+	}
+	for _, disk := range disks {
+		shadowDir := path.Join(disk.Path, "shadow")
+		if _, err := os.Stat(shadowDir); os.IsNotExist(err) {
+			log.Printf("%s directory does not exist, nothing to do", shadowDir)
+			continue
+		}
+		log.Printf("Clean %s", shadowDir)
+		if err := cleanDir(shadowDir); err != nil {
+			return fmt.Errorf("can't clean '%s': %v", shadowDir, err)
+		}
 	}
 	return nil
 }
 
-//
 func RemoveOldBackupsLocal(config Config) error {
-	if config.General.BackupsToKeepLocal < 1 {
+	if getBackupsToKeepLocal(config) < 1 {
 		return nil
 	}
 	backupList, err := ListLocalBackups(config)
@@ -644,15 +1668,29 @@ func RemoveOldBackupsLocal(config Config) error {
 	if dataPath == "" {
 		return ErrUnknownClickhouseDataPath
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, config.General.BackupsToKeepLocal)
+	backupsToDelete := GetBackupsToDelete(backupList, getBackupsToKeepLocal(config))
 	for _, backup := range backupsToDelete {
+		// backup used by another operation is removed by one of next runs
+		// it's another operation than 'delete', so it doesn't reenter lock of 'delete' of the same backup run by server
+		release, err := AcquireOperationLock(config, "retention", backup.Name)
+		if err != nil {
+			log.Printf("Old backup '%s' is kept: %v", backup.Name, err)
+			continue
+		}
 		backupPath := path.Join(dataPath, "backup", backup.Name)
 		os.RemoveAll(backupPath)
+		release()
 	}
 	return nil
 }
 
-func RemoveBackupLocal(config Config, backupName string) error {
+// RemoveBackupLocal - delete local backup, backup required by other backups is deleted only with force
+func RemoveBackupLocal(config Config, backupName string, force bool) (err error) {
+	finishHooks, err := startHooks(config, HookEvent{Operation: "delete", BackupName: backupName, Location: "local"})
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishHooks(err) }()
 	backupList, err := ListLocalBackups(config)
 	if err != nil {
 		return err
@@ -663,13 +1701,33 @@ func RemoveBackupLocal(config Config, backupName string) error {
 	}
 	for _, backup := range backupList {
 		if backup.Name == backupName {
+			if err := checkNoDependents(backupList, backupName, force); err != nil {
+				return err
+			}
+			removeJournals(dataPath, backupName)
 			return os.RemoveAll(path.Join(dataPath, "backup", backupName))
 		}
 	}
 	return fmt.Errorf("backup '%s' not found", backupName)
 }
 
-func RemoveBackupRemote(config Config, backupName string) error {
+// RemoveBackupRemote - delete backup from remote storages selected by target, backup required by other backups is deleted only with force
+func RemoveBackupRemote(config Config, backupName string, target string, force bool) (err error) {
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	finishHooks, err := startHooks(config, HookEvent{Operation: "delete", BackupName: backupName, Location: "remote", Target: target})
+	if err != nil {
+		return err
+	}
+	defer func() { err = finishHooks(err) }()
+	return runOnTargets("delete", targets, func(t RemoteTarget) error {
+		return removeBackupRemote(t.Config, backupName, force)
+	})
+}
+
+func removeBackupRemote(config Config, backupName string, force bool) error {
 	if config.General.RemoteStorage == "none" {
 		fmt.Println("RemoveBackupRemote aborted: RemoteStorage set to \"none\"")
 		return nil
@@ -687,13 +1745,23 @@ func RemoveBackupRemote(config Config, backupName string) error {
 	if err != nil {
 		return fmt.Errorf("can't connect to remote storage: %v", err)
 	}
+	defer bd.Close()
 	backupList, err := bd.BackupList()
 	if err != nil {
 		return err
 	}
 	for _, backup := range backupList {
 		if backup.Name == backupName {
-			return bd.RemoveBackup(backupName)
+			if err := checkNoDependents(backupList, backupName, force); err != nil {
+				return err
+			}
+			if err := bd.RemoveBackup(backupName); err != nil {
+				if _, ok := err.(*ObjectLockedError); ok {
+					return fmt.Errorf("can't remove backup '%s': %v, release the hold or wait until the retention period expires", backupName, err)
+				}
+				return err
+			}
+			return nil
 		}
 	}
 	return fmt.Errorf("backup '%s' not found on remote storage", backupName)
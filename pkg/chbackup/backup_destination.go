@@ -2,6 +2,8 @@ package chbackup
 
 import (
 	"archive/tar"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,10 +15,10 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mholt/archiver"
-	"gopkg.in/djherbis/buffer.v1"
 	"gopkg.in/djherbis/nio.v2"
 )
 
@@ -25,6 +27,10 @@ const (
 	MetaFileName = "meta.json"
 	// BufferSize - size of ring buffer between stream handlers
 	BufferSize = 4 * 1024 * 1024
+	// manifestSuffix - suffix of remote backup manifest, manifest of 'backup.tar.gz' is 'backup.tar.gz.json'
+	manifestSuffix = ".json"
+	// schemaSuffix - suffix of archive with metadata of tables only, schema of 'backup.tar.gz' is 'backup.tar.gz.schema'
+	schemaSuffix = ".schema"
 )
 
 // MetaFile - structure describe meta file that will be added to incremental backups archive.
@@ -46,15 +52,22 @@ type RemoteFile interface {
 	LastModified() time.Time
 }
 
-// RemoteStorage -
+// RemoteStorage - interface of remote storage backend, implementations are registered by RegisterRemoteStorage
 type RemoteStorage interface {
+	// Kind - human readable name of storage used in logs
 	Kind() string
-	GetFile(string) (RemoteFile, error)
-	DeleteFile(string) error
+	// GetFile - return info about object, ErrNotFound must be returned if object doesn't exist
+	GetFile(key string) (RemoteFile, error)
+	// DeleteFile - delete object
+	DeleteFile(key string) error
+	// Connect - check settings and open connection, called before any other method
 	Connect() error
-	Walk(string, func(RemoteFile)) error
+	// Walk - call process for each object with key starting with prefix
+	Walk(prefix string, process func(RemoteFile)) error
 	GetFileReader(key string) (io.ReadCloser, error)
 	PutFile(key string, r io.ReadCloser) error
+	// Close - release connections, storage is not used after Close
+	Close() error
 }
 
 type BackupDestination struct {
@@ -64,6 +77,20 @@ type BackupDestination struct {
 	compressionLevel   int
 	disableProgressBar bool
 	backupsToKeep      int
+	retrier            retrier
+	tempDir            string
+	maxFileSize        int64
+	compressionWorkers int
+	// signer - sign manifests on upload and verify them on download, it's nil when signing isn't configured
+	signer *manifestSigner
+	// bufferSize - size of ring buffers between archiver and remote storage
+	bufferSize int
+	// storageName - general.remote_storage, it's the storage label of transfer metrics
+	storageName string
+	// concurrency - how many files of backup directory are transferred or deleted at the same time
+	concurrency int
+	// ctx - context of traced operation, spans of transfers are children of its span, it's nil when operation isn't traced
+	ctx context.Context
 }
 
 func (bd *BackupDestination) RemoveOldBackups(keep int) error {
@@ -77,28 +104,52 @@ func (bd *BackupDestination) RemoveOldBackups(keep int) error {
 	backupsToDelete := GetBackupsToDelete(backupList, keep)
 	for _, backupToDelete := range backupsToDelete {
 		if err := bd.RemoveBackup(backupToDelete.Name); err != nil {
+			// backup is kept by retention period of object lock, it's removed by later uploads
+			if _, ok := err.(*ObjectLockedError); ok {
+				log.Printf("Backup '%s' is not removed: %v", backupToDelete.Name, err)
+				continue
+			}
 			return err
 		}
 	}
 	return nil
 }
 
+// RemoveBackup - remove all objects of backup, nothing is removed when any object is locked
 func (bd *BackupDestination) RemoveBackup(backupName string) error {
 	objects := []string{}
+	backupKey := path.Join(bd.path, backupName)
 	if err := bd.Walk(bd.path, func(f RemoteFile) {
-		if strings.HasPrefix(f.Name(), path.Join(bd.path, backupName)) {
+		if isBackupKey(f.Name(), backupKey) {
 			objects = append(objects, f.Name())
 		}
 	}); err != nil {
 		return err
 	}
-	for _, key := range objects {
-		err := bd.DeleteFile(key)
-		if err != nil {
-			return err
-		}
+	if err := bd.checkFileLocks(objects); err != nil {
+		return err
 	}
-	return nil
+	return runParallel(bd.concurrency, len(objects), func(i int) error {
+		return bd.DeleteFile(objects[i])
+	})
+}
+
+// isBackupKey - key is archive of backup, its chunk, manifest, signature of manifest or schema, or file of backup directory.
+// Backup name may be a prefix of name of another backup, of profile path or of shard, so only known suffixes of archive
+// and files inside of backup directory are matched, e.g. directory 'daily' doesn't match archive 'daily.tar.gz'
+func isBackupKey(key, backupKey string) bool {
+	if !strings.HasPrefix(key, backupKey) {
+		return false
+	}
+	suffix := strings.TrimPrefix(key, backupKey)
+	if !isArchiveName(path.Base(backupKey)) {
+		return suffix == "" || strings.HasPrefix(suffix, "/")
+	}
+	switch suffix {
+	case "", manifestSuffix, manifestSuffix + signatureSuffix, schemaSuffix:
+		return true
+	}
+	return chunkSuffixRE.FindString(suffix) == suffix
 }
 
 func (bd *BackupDestination) BackupsToKeep() int {
@@ -110,6 +161,7 @@ func (bd *BackupDestination) BackupList() ([]Backup, error) {
 		Metadata bool
 		Shadow   bool
 		Tar      bool
+		Manifest bool
 		Size     int64
 		Date     time.Time
 	}
@@ -121,17 +173,31 @@ func (bd *BackupDestination) BackupList() ([]Backup, error) {
 			key = strings.TrimPrefix(key, "/")
 			parts := strings.Split(key, "/")
 
-			if strings.HasSuffix(parts[0], ".tar") ||
-				strings.HasSuffix(parts[0], ".tar.lz4") ||
-				strings.HasSuffix(parts[0], ".tar.bz2") ||
-				strings.HasSuffix(parts[0], ".tar.gz") ||
-				strings.HasSuffix(parts[0], ".tar.sz") ||
-				strings.HasSuffix(parts[0], ".tar.xz") {
+			if isArchiveName(parts[0]) {
+				b := files[parts[0]]
 				files[parts[0]] = ClickhouseBackup{
-					Tar:  true,
-					Date: o.LastModified(),
-					Size: o.Size(),
+					Tar:      true,
+					Manifest: b.Manifest,
+					Date:     o.LastModified(),
+					Size:     o.Size(),
+				}
+			}
+
+			if name, ok := archiveChunkName(parts[0]); ok && len(parts) == 1 {
+				b := files[name]
+				b.Tar = true
+				b.Size += o.Size()
+				if o.LastModified().After(b.Date) {
+					b.Date = o.LastModified()
 				}
+				files[name] = b
+			}
+
+			if len(parts) == 1 && isArchiveName(strings.TrimSuffix(parts[0], manifestSuffix)) {
+				name := strings.TrimSuffix(parts[0], manifestSuffix)
+				b := files[name]
+				b.Manifest = true
+				files[name] = b
 			}
 
 			if len(parts) > 1 {
@@ -150,13 +216,42 @@ func (bd *BackupDestination) BackupList() ([]Backup, error) {
 	}
 	result := []Backup{}
 	for name, e := range files {
-		if e.Metadata && e.Shadow || e.Tar {
-			result = append(result, Backup{
-				Name: name,
-				Date: e.Date,
-				Size: e.Size,
-			})
+		backup := Backup{
+			Name: name,
+			Date: e.Date,
+			Size: e.Size,
+		}
+		switch {
+		case e.Tar || e.Manifest:
+			if e.Manifest {
+				metadata, err := bd.getManifest(name)
+				if err != nil {
+					backup.Broken = fmt.Sprintf("can't read manifest: %v", err)
+				} else {
+					backup.RequiredBackup = metadata.RequiredBackup
+					backup.TableCount = len(metadata.Tables)
+					backup.UploadState = metadata.UploadState
+					backup.Checksums = metadata.ChecksumStatus()
+					backup.ArchiveChecksum = metadata.ArchiveChecksum
+					backup.TablePattern = metadata.TablePattern
+					if backup.Date.IsZero() {
+						backup.Date = metadata.CreationDate
+					}
+					if metadata.UploadState != UploadStateUploaded {
+						backup.Broken = "upload is not completed"
+					}
+				}
+			}
+			if !e.Tar && backup.Broken == "" {
+				backup.Broken = "archive not found"
+			}
+		case e.Metadata && e.Shadow:
+		case e.Metadata || e.Shadow:
+			backup.Broken = "metadata or shadow is missing"
+		default:
+			continue
 		}
+		result = append(result, backup)
 	}
 	sort.SliceStable(result, func(i, j int) bool {
 		return result[i].Date.Before(result[j].Date)
@@ -164,9 +259,125 @@ func (bd *BackupDestination) BackupList() ([]Backup, error) {
 	return result, nil
 }
 
-func (bd *BackupDestination) CompressedStreamDownload(remotePath string, localPath string) error {
-	if err := os.MkdirAll(localPath, os.ModePerm); err != nil {
+// isArchiveName - check that name has extension of one of supported compression formats
+func isArchiveName(name string) bool {
+	for _, format := range []string{"tar", "lz4", "bzip2", "gzip", "sz", "xz"} {
+		if strings.HasSuffix(name, "."+getExtension(format)) {
+			return true
+		}
+	}
+	return false
+}
+
+// getSmallFile - read content of small remote file like manifest, missing file is not retried
+func (bd *BackupDestination) getSmallFile(key string) ([]byte, error) {
+	if _, err := bd.GetFile(key); err != nil {
+		return nil, err
+	}
+	var content []byte
+	s := startSpan(bd.ctx, "download file", "key", key)
+	err := bd.retrier.do(fmt.Sprintf("download of '%s'", key), func() error {
+		r, err := bd.GetFileReader(key)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		content, err = ioutil.ReadAll(r)
 		return err
+	})
+	s.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// putSmallFile - write content to remote file
+func (bd *BackupDestination) putSmallFile(key string, content []byte) error {
+	s := startSpan(bd.ctx, "upload file", "key", key)
+	err := bd.retrier.do(fmt.Sprintf("upload of '%s'", key), func() error {
+		return bd.PutFile(key, ioutil.NopCloser(bytes.NewReader(content)))
+	})
+	s.finish(err)
+	return err
+}
+
+// getManifest - read manifest of remote backup archive, backups uploaded by previous versions don't have it
+func (bd *BackupDestination) getManifest(archiveName string) (*BackupMetadata, error) {
+	content, err := bd.getSmallFile(path.Join(bd.path, archiveName+manifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var metadata BackupMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// getVerifiedManifest - read manifest of remote backup archive and verify its signature when signing is configured,
+// nil is returned for backups uploaded without manifest unless signature is required
+func (bd *BackupDestination) getVerifiedManifest(archiveName string) (*BackupMetadata, error) {
+	key := path.Join(bd.path, archiveName+manifestSuffix)
+	content, err := bd.getSmallFile(key)
+	if err == ErrNotFound {
+		if bd.signer != nil && bd.signer.required {
+			return nil, fmt.Errorf("can't verify '%s': manifest not found", archiveName)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read manifest: %v", err)
+	}
+	if bd.signer != nil {
+		signature, err := bd.getSmallFile(key + signatureSuffix)
+		if err != nil && err != ErrNotFound {
+			return nil, fmt.Errorf("can't read signature of manifest: %v", err)
+		}
+		if err := bd.signer.verify(content, signature); err != nil {
+			return nil, fmt.Errorf("can't verify '%s': %v", archiveName, err)
+		}
+	}
+	var metadata BackupMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return nil, fmt.Errorf("can't parse manifest: %v", err)
+	}
+	return &metadata, nil
+}
+
+// putManifest - write manifest of remote backup archive, it's stored next to archive with '.json' suffix,
+// signature of manifest is stored with '.json.sig' suffix when signing key is configured
+func (bd *BackupDestination) putManifest(archiveKey string, metadata BackupMetadata) error {
+	content, err := json.MarshalIndent(&metadata, "", "\t")
+	if err != nil {
+		return fmt.Errorf("can't marshal manifest: %v", err)
+	}
+	key := archiveKey + manifestSuffix
+	if err := bd.putSmallFile(key, content); err != nil {
+		return err
+	}
+	if !bd.signer.canSign() {
+		return nil
+	}
+	return bd.putSmallFile(key+signatureSuffix, bd.signer.sign(content))
+}
+
+// CompressedStreamDownload - download and extract files of tables matched by tablePattern from remote backup,
+// only metadata of tables is downloaded when schemaOnly is set
+func (bd *BackupDestination) CompressedStreamDownload(remotePath string, localPath string, tablePattern string, schemaOnly bool) error {
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(localPath, dirMode(os.ModePerm)); err != nil {
+		return err
+	}
+	// marker is removed when metadata.json is saved after all files are extracted
+	if err := markBackupIncomplete(localPath); err != nil {
+		return err
+	}
+	if schemaOnly {
+		return bd.downloadSchema(remotePath, localPath, patterns)
 	}
 	archiveName := path.Join(bd.path, fmt.Sprintf("%s.%s", remotePath, getExtension(bd.compressionFormat)))
 	if err := bd.Connect(); err != nil {
@@ -174,92 +385,443 @@ func (bd *BackupDestination) CompressedStreamDownload(remotePath string, localPa
 	}
 
 	// get this first as GetFileReader blocks the ftp control channel
-	file, err := bd.GetFile(archiveName)
+	file, err := bd.getArchive(archiveName)
+	if err == ErrNotFound {
+		if bd.signer != nil && bd.signer.required {
+			return fmt.Errorf("backup '%s' has legacy format without manifest and can't be verified", remotePath)
+		}
+		return bd.downloadLegacyBackup(remotePath, localPath, patterns)
+	}
 	if err != nil {
 		return err
 	}
-	filesize := file.Size()
-
-	reader, err := bd.GetFileReader(archiveName)
+	manifest, err := bd.getVerifiedManifest(fmt.Sprintf("%s.%s", remotePath, getExtension(bd.compressionFormat)))
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
+	filesize := file.Size()
+	// archive is decompressed on the fly into backup directory without saving it, so only size of extracted tables is required
+	if err := checkFreeSpace(localPath, bd.getExtractedSize(remotePath, patterns, filesize)); err != nil {
+		return err
+	}
 
 	bar := StartNewByteBar(!bd.disableProgressBar, filesize)
-	buf := buffer.New(BufferSize)
-	bufReader := nio.NewReader(reader, buf)
-	proxyReader := bar.NewProxyReader(bufReader)
-	z, _ := getArchiveReader(bd.compressionFormat)
-	if err := z.Open(proxyReader, 0); err != nil {
+	var metafile MetaFile
+	var backupMetadata []byte
+	var hash *archiveHash
+	// archive is read again from the beginning on retry, extracted files are overwritten
+	if err := bd.retrier.do(fmt.Sprintf("download of '%s'", archiveName), func() error {
+		metafile = MetaFile{}
+		backupMetadata = nil
+		hash = newArchiveHash()
+		bar.Set(0)
+		extracted := 0
+		reader := bd.openArchive(file)
+		defer reader.Close()
+
+		buffers := transferBuffers.get(bd.bufferSize, 1)
+		defer releaseBuffers(buffers)
+		bufReader := nio.NewReader(io.TeeReader(reader, hash), buffers[0])
+		proxyReader := bar.NewProxyReader(bufReader)
+		z, _ := getArchiveReader(bd.compressionFormat)
+		if err := z.Open(proxyReader, 0); err != nil {
+			return err
+		}
+		defer z.Close()
+		for {
+			file, err := z.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			header, ok := file.Header.(*tar.Header)
+			if !ok {
+				return fmt.Errorf("expected header to be *tar.Header but was %T", file.Header)
+			}
+			extracted++
+			bar.SetFiles(extracted, 0)
+			if header.Name == MetaFileName {
+				b, err := ioutil.ReadAll(file)
+				if err != nil {
+					return fmt.Errorf("can't read %s", MetaFileName)
+				}
+				if err := json.Unmarshal(b, &metafile); err != nil {
+					return err
+				}
+				continue
+			}
+			if header.Name == BackupMetadataFileName {
+				// metadata.json is written after all files are extracted so partial download will be marked as broken
+				if backupMetadata, err = ioutil.ReadAll(file); err != nil {
+					return fmt.Errorf("can't read %s", BackupMetadataFileName)
+				}
+				continue
+			}
+			if !patterns.MatchBackupFile(header.Name) {
+				continue
+			}
+			if err := extractArchiveFile(localPath, header.Name, file); err != nil {
+				return err
+			}
+			if err := file.Close(); err != nil {
+				return err
+			}
+		}
+		// the rest of archive after the end of tar is read to check the whole archive
+		_, err := io.Copy(ioutil.Discard, bufReader)
+		return err
+	}); err != nil {
 		return err
 	}
-	defer z.Close()
-	var metafile MetaFile
-	for {
-		file, err := z.Read()
-		if err == io.EOF {
-			break
+	// backup stays marked as incomplete when archive doesn't match manifest, so extracted files can't be restored
+	if manifest != nil {
+		if err := hash.check(archiveName, manifest.ArchiveSize, manifest.ArchiveChecksum); err != nil {
+			return err
+		}
+	}
+	if name := metafile.RequiredBackup; name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("wrong name of required backup '%s' in %s", name, MetaFileName)
+	}
+	if metafile.RequiredBackup != "" {
+		log.Printf("Backup '%s' required '%s'. Downloading.", remotePath, metafile.RequiredBackup)
+		err := bd.CompressedStreamDownload(metafile.RequiredBackup, filepath.Join(filepath.Dir(localPath), metafile.RequiredBackup), tablePattern, false)
+		if err != nil && !os.IsExist(err) {
+			return fmt.Errorf("can't download '%s': %v", metafile.RequiredBackup, err)
+		}
+	}
+	for _, hardlink := range metafile.Hardlinks {
+		if !patterns.MatchBackupFile(hardlink) {
+			continue
 		}
+		newname, err := extractPath(localPath, hardlink)
 		if err != nil {
 			return err
 		}
-		header, ok := file.Header.(*tar.Header)
-		if !ok {
-			return fmt.Errorf("expected header to be *tar.Header but was %T", file.Header)
+		extractDir := filepath.Dir(newname)
+		oldname, err := extractPath(filepath.Join(filepath.Dir(localPath), metafile.RequiredBackup), hardlink)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(extractDir); os.IsNotExist(err) {
+			os.MkdirAll(extractDir, dirMode(os.ModePerm))
+		}
+		if err := linkFile(oldname, newname); err != nil {
+			return err
+		}
+	}
+	metadata := BackupMetadata{
+		BackupName:   remotePath,
+		CreationDate: file.LastModified(),
+	}
+	if backupMetadata != nil {
+		if err := json.Unmarshal(backupMetadata, &metadata); err != nil {
+			return fmt.Errorf("can't parse %s: %v", BackupMetadataFileName, err)
 		}
-		if header.Name == MetaFileName {
-			b, err := ioutil.ReadAll(file)
+	}
+	metadata.RequiredBackup = metafile.RequiredBackup
+	metadata.Chain = nil
+	if metafile.RequiredBackup != "" {
+		requiredMetadata := getLocalBackupMetadata(filepath.Join(filepath.Dir(localPath), metafile.RequiredBackup))
+		metadata.Chain = append([]string{metafile.RequiredBackup}, requiredMetadata.Chain...)
+	}
+	tables := []BackupTableMetadata{}
+	for _, t := range metadata.Tables {
+		if patterns.Match(t.Database, t.Table) {
+			tables = append(tables, t)
+		}
+	}
+	metadata.Tables = tables
+	if err := metadata.Save(localPath); err != nil {
+		return err
+	}
+	bar.Finish()
+	return nil
+}
+
+// getExtractedSize - size of data of tables matched by patterns from manifest of remote backup,
+// size of archive is used for backups uploaded without manifest as extracted data is at least that big.
+// Most parts of incremental backup are hardlinks to required backup, archive size is used for them too
+func (bd *BackupDestination) getExtractedSize(remotePath string, patterns tablePatterns, archiveSize int64) int64 {
+	manifest, err := bd.getManifest(fmt.Sprintf("%s.%s", remotePath, getExtension(bd.compressionFormat)))
+	if err != nil || len(manifest.Tables) == 0 || manifest.RequiredBackup != "" {
+		return archiveSize
+	}
+	var size int64
+	for _, t := range manifest.Tables {
+		if patterns.Match(t.Database, t.Table) {
+			size += t.Size
+		}
+	}
+	return size
+}
+
+// extractPath - path of file of archive or of remote legacy backup in localPath, archive is checked after its files are
+// extracted, so names which resolve outside of localPath are rejected before anything is written
+func extractPath(localPath string, name string) (string, error) {
+	extractFile := filepath.Join(localPath, filepath.FromSlash(name))
+	rel, err := filepath.Rel(localPath, extractFile)
+	if err != nil || path.IsAbs(name) || filepath.IsAbs(name) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file '%s' of archive is outside of backup directory", name)
+	}
+	return extractFile, nil
+}
+
+// extractArchiveFile - write file from archive to localPath
+func extractArchiveFile(localPath string, name string, r io.Reader) error {
+	extractFile, err := extractPath(localPath, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(extractFile), dirMode(os.ModePerm)); err != nil {
+		return err
+	}
+	dst, err := createFile(extractFile)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// downloadSchema - download metadata of tables matched by patterns without data.
+// Schema is read from full archive for backups uploaded without schema archive
+func (bd *BackupDestination) downloadSchema(remotePath string, localPath string, patterns tablePatterns) error {
+	archiveName := fmt.Sprintf("%s.%s", remotePath, getExtension(bd.compressionFormat))
+	key := path.Join(bd.path, archiveName) + schemaSuffix
+	file, err := bd.getArchive(key)
+	if err == ErrNotFound {
+		key = path.Join(bd.path, archiveName)
+		file, err = bd.getArchive(key)
+	}
+	if err == ErrNotFound {
+		return fmt.Errorf("backup '%s' not found or has legacy format, download of schema only is not supported for it", remotePath)
+	}
+	if err != nil {
+		return err
+	}
+	manifest, err := bd.getVerifiedManifest(archiveName)
+	if err != nil {
+		return err
+	}
+	bar := StartNewByteBar(!bd.disableProgressBar, file.Size())
+	var hash *archiveHash
+	if err := bd.retrier.do(fmt.Sprintf("download of '%s'", key), func() error {
+		hash = newArchiveHash()
+		bar.Set(0)
+		archive := bd.openArchive(file)
+		defer archive.Close()
+		reader := io.TeeReader(archive, hash)
+		z, _ := getArchiveReader(bd.compressionFormat)
+		if err := z.Open(bar.NewProxyReader(reader), 0); err != nil {
+			return err
+		}
+		defer z.Close()
+		for {
+			f, err := z.Read()
+			if err == io.EOF {
+				// the rest of archive after the end of tar is read to check the whole archive
+				_, err := io.Copy(ioutil.Discard, reader)
+				return err
+			}
 			if err != nil {
-				return fmt.Errorf("can't read %s", MetaFileName)
+				return err
+			}
+			header, ok := f.Header.(*tar.Header)
+			if !ok {
+				return fmt.Errorf("expected header to be *tar.Header but was %T", f.Header)
+			}
+			if !strings.HasPrefix(header.Name, "metadata/") || !patterns.MatchBackupFile(header.Name) {
+				continue
 			}
-			if err := json.Unmarshal(b, &metafile); err != nil {
+			if err := extractArchiveFile(localPath, header.Name, f); err != nil {
 				return err
 			}
-			continue
 		}
-		extractFile := filepath.Join(localPath, header.Name)
-		extractDir := filepath.Dir(extractFile)
-		if _, err := os.Stat(extractDir); os.IsNotExist(err) {
-			os.MkdirAll(extractDir, os.ModePerm)
+	}); err != nil {
+		return err
+	}
+	metadata := BackupMetadata{
+		BackupName:   remotePath,
+		CreationDate: file.LastModified(),
+	}
+	if manifest != nil {
+		size, checksum := manifest.ArchiveSize, manifest.ArchiveChecksum
+		if strings.HasSuffix(key, schemaSuffix) {
+			size, checksum = manifest.SchemaSize, manifest.SchemaChecksum
 		}
-		dst, err := os.Create(extractFile)
-		if err != nil {
+		// schema stays marked as incomplete when archive doesn't match manifest
+		if err := hash.check(key, size, checksum); err != nil {
 			return err
 		}
-		if _, err := io.Copy(dst, file); err != nil {
+		metadata = *manifest
+	}
+	metadata.UploadState = ""
+	metadata.SchemaOnly = true
+	tables := []BackupTableMetadata{}
+	for _, t := range metadata.Tables {
+		if patterns.Match(t.Database, t.Table) {
+			tables = append(tables, t)
+		}
+	}
+	metadata.Tables = tables
+	if err := metadata.Save(localPath); err != nil {
+		return err
+	}
+	bar.Finish()
+	return nil
+}
+
+// putSchemaArchive - upload metadata of tables matched by patterns as separate archive next to backup archive,
+// return size and checksum of uploaded archive
+func (bd *BackupDestination) putSchemaArchive(localPath string, archiveKey string, patterns tablePatterns) (*archiveHash, error) {
+	key := archiveKey + schemaSuffix
+	var hash *archiveHash
+	s := startSpan(bd.ctx, "upload file", "key", key)
+	err := bd.retrier.do(fmt.Sprintf("upload of '%s'", key), func() error {
+		hash = newArchiveHash()
+		body, w := io.Pipe()
+		go func() {
+			w.CloseWithError(bd.writeSchemaArchive(io.MultiWriter(w, hash), localPath, patterns))
+		}()
+		if err := bd.PutFile(key, body); err != nil {
+			body.Close()
 			return err
 		}
-		if err := dst.Close(); err != nil {
+		return nil
+	})
+	s.finish(err)
+	return hash, err
+}
+
+func (bd *BackupDestination) writeSchemaArchive(w io.Writer, localPath string, patterns tablePatterns) error {
+	z, _ := getArchiveWriter(bd.compressionFormat, bd.compressionLevel, bd.compressionWorkers)
+	if err := z.Create(w); err != nil {
+		return err
+	}
+	metadataPath := filepath.Join(localPath, "metadata")
+	if err := filepath.Walk(metadataPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
-		if err := file.Close(); err != nil {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath := archiveFileName(localPath, filePath)
+		if !patterns.MatchBackupFile(relativePath) {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
 			return err
 		}
+		defer file.Close()
+		return z.Write(archiver.File{
+			FileInfo: archiver.FileInfo{
+				FileInfo:   info,
+				CustomName: relativePath,
+			},
+			ReadCloser: file,
+		})
+	}); err != nil {
+		z.Close()
+		return err
 	}
-	if metafile.RequiredBackup != "" {
-		log.Printf("Backup '%s' required '%s'. Downloading.", remotePath, metafile.RequiredBackup)
-		err := bd.CompressedStreamDownload(metafile.RequiredBackup, filepath.Join(filepath.Dir(localPath), metafile.RequiredBackup))
-		if err != nil && !os.IsExist(err) {
-			return fmt.Errorf("can't download '%s': %v", metafile.RequiredBackup, err)
+	return z.Close()
+}
+
+// downloadLegacyBackup - download backup uploaded by previous versions as separate uncompressed files
+func (bd *BackupDestination) downloadLegacyBackup(remotePath string, localPath string, patterns tablePatterns) error {
+	prefix := path.Join(bd.path, remotePath) + "/"
+	files := []RemoteFile{}
+	if err := bd.Walk(bd.path, func(f RemoteFile) {
+		if strings.HasPrefix(f.Name(), prefix) && patterns.MatchBackupFile(strings.TrimPrefix(f.Name(), prefix)) {
+			files = append(files, f)
 		}
+	}); err != nil {
+		return err
 	}
-	for _, hardlink := range metafile.Hardlinks {
-		newname := filepath.Join(localPath, hardlink)
-		extractDir := filepath.Dir(newname)
-		oldname := filepath.Join(filepath.Dir(localPath), metafile.RequiredBackup, hardlink)
-		if _, err := os.Stat(extractDir); os.IsNotExist(err) {
-			os.MkdirAll(extractDir, os.ModePerm)
+	if len(files) == 0 {
+		return fmt.Errorf("backup '%s' not found on remote storage", remotePath)
+	}
+	log.Printf("Backup '%s' has legacy format, download its files separately", remotePath)
+	var totalBytes int64
+	for _, f := range files {
+		totalBytes += f.Size()
+	}
+	if err := checkFreeSpace(localPath, totalBytes); err != nil {
+		return err
+	}
+	var creationDate time.Time
+	for _, f := range files {
+		if f.LastModified().After(creationDate) {
+			creationDate = f.LastModified()
+		}
+	}
+	bar := StartNewByteBar(!bd.disableProgressBar, totalBytes)
+	var barMutex sync.Mutex
+	downloaded := 0
+	// files are downloaded in parallel by not more than concurrency of remote storage
+	if err := runParallel(bd.concurrency, len(files), func(i int) error {
+		f := files[i]
+		extractFile, err := extractPath(localPath, strings.TrimPrefix(f.Name(), prefix))
+		if err != nil {
+			return err
 		}
-		if err := os.Link(oldname, newname); err != nil {
+		if err := os.MkdirAll(filepath.Dir(extractFile), dirMode(os.ModePerm)); err != nil {
+			return err
+		}
+		s := startSpan(bd.ctx, "download file", "key", f.Name())
+		err = bd.retrier.do(fmt.Sprintf("download of '%s'", f.Name()), func() error {
+			reader, err := bd.GetFileReader(f.Name())
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+			dst, err := createFile(extractFile)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, bar.NewProxyReader(reader)); err != nil {
+				dst.Close()
+				return err
+			}
+			return dst.Close()
+		})
+		s.finish(err)
+		if err != nil {
 			return err
 		}
+		barMutex.Lock()
+		downloaded++
+		bar.SetFiles(downloaded, len(files))
+		barMutex.Unlock()
+		return nil
+	}); err != nil {
+		return err
+	}
+	metadata := BackupMetadata{
+		BackupName:   remotePath,
+		CreationDate: creationDate,
+	}
+	if err := metadata.Save(localPath); err != nil {
+		return err
 	}
 	bar.Finish()
 	return nil
 }
 
-func (bd *BackupDestination) CompressedStreamUpload(localPath, remotePath, diffFromPath string) error {
+// CompressedStreamUpload - upload files of tables matched by tablePattern from local backup as one archive,
+// archive uploaded by interrupted upload with the same options is reused
+func (bd *BackupDestination) CompressedStreamUpload(localPath, remotePath, diffFromPath, tablePattern string, journal *operationJournal, previous *OperationJournal) error {
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return err
+	}
 	archiveName := path.Join(bd.path, fmt.Sprintf("%s.%s", remotePath, getExtension(bd.compressionFormat)))
 
 	if _, err := bd.GetFile(archiveName); err != nil {
@@ -269,9 +831,11 @@ func (bd *BackupDestination) CompressedStreamUpload(localPath, remotePath, diffF
 	}
 
 	var totalBytes int64
+	totalFiles := 0
 	filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
-		if info.Mode().IsRegular() {
+		if info.Mode().IsRegular() && patterns.MatchBackupFile(archiveFileName(localPath, filePath)) {
 			totalBytes += info.Size()
+			totalFiles++
 		}
 		return nil
 	})
@@ -285,163 +849,228 @@ func (bd *BackupDestination) CompressedStreamUpload(localPath, remotePath, diffF
 			return fmt.Errorf("'%s' is not a directory", diffFromPath)
 		}
 		if isClickhouseShadow(filepath.Join(diffFromPath, "shadow")) {
-			return fmt.Errorf("'%s' is old format backup and doesn't supports diff", filepath.Base(diffFromPath))
+			return fmt.Errorf("'%s' is old format backup and doesn't supports diff, use 'migrate-format' command first", filepath.Base(diffFromPath))
 		}
 	}
-	hardlinks := []string{}
+	requiredBackup := ""
 
-	buf := buffer.New(BufferSize)
-	body, w := nio.Pipe(buf)
-	go func() (ferr error) {
-		defer w.CloseWithError(ferr)
-		iobuf := buffer.New(BufferSize)
-		z, _ := getArchiveWriter(bd.compressionFormat, bd.compressionLevel)
-		if ferr = z.Create(w); ferr != nil {
-			return
+	manifest, err := describeLocalBackupPath(localPath)
+	if err != nil {
+		return err
+	}
+	tables := []BackupTableMetadata{}
+	manifest.Size = 0
+	for _, t := range manifest.Tables {
+		if patterns.Match(t.Database, t.Table) {
+			tables = append(tables, t)
+			manifest.Size += t.Size
 		}
-		defer z.Close()
-		if ferr = filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.Mode().IsRegular() {
-				return nil
-			}
-			bar.Add64(info.Size())
-			file, err := os.Open(filePath)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			relativePath := strings.TrimPrefix(strings.TrimPrefix(filePath, localPath), "/")
-			if diffFromPath != "" {
-				diffFromFile, err := os.Stat(filepath.Join(diffFromPath, relativePath))
-				if err == nil {
-					if os.SameFile(info, diffFromFile) {
-						hardlinks = append(hardlinks, relativePath)
-						return nil
-					}
-				}
-			}
-			bfile := nio.NewReader(file, iobuf)
-			defer bfile.Close()
-			return z.Write(archiver.File{
-				FileInfo: archiver.FileInfo{
-					FileInfo:   info,
-					CustomName: relativePath,
-				},
-				ReadCloser: bfile,
-			})
-		}); ferr != nil {
-			return
+	}
+	manifest.Tables = tables
+	detached := []BackupTableMetadata{}
+	for _, t := range manifest.Detached {
+		if patterns.Match(t.Database, t.Table) {
+			detached = append(detached, t)
 		}
-		if len(hardlinks) > 0 {
-			metafile := MetaFile{
-				RequiredBackup: filepath.Base(diffFromPath),
-				Hardlinks:      hardlinks,
-			}
-			content, err := json.MarshalIndent(&metafile, "", "\t")
-			if err != nil {
-				ferr = fmt.Errorf("can't marshal json: %v", err)
-				return
-			}
-			tmpfile, err := ioutil.TempFile("", MetaFileName)
-			if err != nil {
-				ferr = fmt.Errorf("can't create meta.info: %v", err)
-				return
-			}
-			if _, err := tmpfile.Write(content); err != nil {
-				ferr = fmt.Errorf("can't write to meta.info: %v", err)
-				return
-			}
-			tmpfile.Close()
-			tmpFileName := tmpfile.Name()
-			defer os.Remove(tmpFileName)
-			info, err := os.Stat(tmpFileName)
-			if err != nil {
-				ferr = fmt.Errorf("can't get stat: %v", err)
+	}
+	manifest.Detached = detached
+	manifest.RequiredBackup = ""
+	manifest.Chain = nil
+	manifest.TablePattern = strings.TrimSpace(tablePattern)
+	manifest.UploadState = UploadStateInProgress
+	journal.phase("manifest")
+	if err := bd.putManifest(archiveName, manifest); err != nil {
+		return fmt.Errorf("can't upload manifest: %v", err)
+	}
+
+	journal.phase("archive")
+	var hash *archiveHash
+	if _, err := bd.getArchive(archiveName); err == nil && previous.completed("archive") {
+		log.Printf("Archive '%s' was uploaded by interrupted upload, skip it", strings.TrimPrefix(archiveName, bd.path))
+		requiredBackup = previous.RequiredBackup
+		manifest.ArchiveSize, manifest.ArchiveChecksum = previous.ArchiveSize, previous.ArchiveChecksum
+	} else if err := bd.retrier.do(fmt.Sprintf("upload of '%s'", archiveName), func() error {
+		// archive is created again from local files on retry
+		links := []string{}
+		var processed int64
+		hash = newArchiveHash()
+		bar.Set(0)
+		buffers := transferBuffers.get(bd.bufferSize, 2)
+		defer releaseBuffers(buffers)
+		body, w := nio.Pipe(buffers[0])
+		go func() (ferr error) {
+			defer func() { w.CloseWithError(ferr) }()
+			iobuf := buffers[1]
+			z, _ := getArchiveWriter(bd.compressionFormat, bd.compressionLevel, bd.compressionWorkers)
+			if ferr = z.Create(w); ferr != nil {
 				return
 			}
-			mf, err := os.Open(tmpFileName)
-			if err != nil {
-				ferr = err
+			defer z.Close()
+			if ferr = filepath.Walk(localPath, func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				relativePath := archiveFileName(localPath, filePath)
+				if !patterns.MatchBackupFile(relativePath) {
+					return nil
+				}
+				bar.Add64(info.Size())
+				processed++
+				bar.SetFiles(int(processed), totalFiles)
+				journal.progress(processed)
+				file, err := os.Open(filePath)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				if diffFromPath != "" {
+					diffFromFile, err := os.Stat(filepath.Join(diffFromPath, relativePath))
+					if err == nil {
+						if os.SameFile(info, diffFromFile) {
+							links = append(links, relativePath)
+							return nil
+						}
+					}
+				}
+				bfile := nio.NewReader(file, iobuf)
+				defer bfile.Close()
+				return z.Write(archiver.File{
+					FileInfo: archiver.FileInfo{
+						FileInfo:   info,
+						CustomName: relativePath,
+					},
+					ReadCloser: bfile,
+				})
+			}); ferr != nil {
 				return
 			}
-			defer mf.Close()
-			if err := z.Write(archiver.File{
-				FileInfo: archiver.FileInfo{
-					FileInfo:   info,
-					CustomName: MetaFileName,
-				},
-				ReadCloser: mf,
-			}); err != nil {
-				ferr = fmt.Errorf("can't add mata.json to archive: %v", err)
-				return
+			if len(links) > 0 {
+				metafile := MetaFile{
+					RequiredBackup: filepath.Base(diffFromPath),
+					Hardlinks:      links,
+				}
+				content, err := json.MarshalIndent(&metafile, "", "\t")
+				if err != nil {
+					ferr = fmt.Errorf("can't marshal json: %v", err)
+					return
+				}
+				tmpfile, err := createTempFile(bd.tempDir, MetaFileName)
+				if err != nil {
+					ferr = fmt.Errorf("can't create meta.info: %v", err)
+					return
+				}
+				if _, err := tmpfile.Write(content); err != nil {
+					ferr = fmt.Errorf("can't write to meta.info: %v", err)
+					return
+				}
+				tmpfile.Close()
+				tmpFileName := tmpfile.Name()
+				defer os.Remove(tmpFileName)
+				info, err := os.Stat(tmpFileName)
+				if err != nil {
+					ferr = fmt.Errorf("can't get stat: %v", err)
+					return
+				}
+				mf, err := os.Open(tmpFileName)
+				if err != nil {
+					ferr = err
+					return
+				}
+				defer mf.Close()
+				if err := z.Write(archiver.File{
+					FileInfo: archiver.FileInfo{
+						FileInfo:   info,
+						CustomName: MetaFileName,
+					},
+					ReadCloser: mf,
+				}); err != nil {
+					ferr = fmt.Errorf("can't add mata.json to archive: %v", err)
+					return
+				}
 			}
+			return
+		}()
+		if err := bd.putArchive(archiveName, io.TeeReader(body, hash)); err != nil {
+			body.Close()
+			return err
 		}
-		return
-	}()
-
-	if err := bd.PutFile(archiveName, body); err != nil {
+		if len(links) > 0 {
+			requiredBackup = filepath.Base(diffFromPath)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if hash != nil {
+		manifest.ArchiveSize, manifest.ArchiveChecksum = hash.size, hash.checksum()
+	}
+	journal.setRequiredBackup(requiredBackup)
+	journal.setArchiveChecksum(manifest.ArchiveSize, manifest.ArchiveChecksum)
+	journal.phase("schema")
+	schemaHash, err := bd.putSchemaArchive(localPath, archiveName, patterns)
+	if err != nil {
+		return fmt.Errorf("can't upload schema: %v", err)
+	}
+	manifest.SchemaSize, manifest.SchemaChecksum = schemaHash.size, schemaHash.checksum()
+	journal.phase("finalize")
+	if requiredBackup != "" {
+		manifest.RequiredBackup = requiredBackup
+		manifest.Chain = []string{manifest.RequiredBackup}
+		if required, err := bd.getManifest(fmt.Sprintf("%s.%s", manifest.RequiredBackup, getExtension(bd.compressionFormat))); err == nil {
+			manifest.Chain = append(manifest.Chain, required.Chain...)
+		} else {
+			manifest.Chain = append(manifest.Chain, getLocalBackupMetadata(diffFromPath).Chain...)
+		}
+	}
+	manifest.UploadState = UploadStateUploaded
+	if err := bd.putManifest(archiveName, manifest); err != nil {
+		return fmt.Errorf("can't upload manifest: %v", err)
+	}
+	if err := bd.holdManifest(archiveName); err != nil {
 		return err
 	}
 	bar.Finish()
 	return nil
 }
 
+// NewBackupDestination - create BackupDestination with remote storage registered by name from general.remote_storage
 func NewBackupDestination(config Config) (*BackupDestination, error) {
-	switch config.General.RemoteStorage {
-	case "azblob":
-		azblob := &AzureBlob{Config: &config.AzureBlob}
-		return &BackupDestination{
-			azblob,
-			config.AzureBlob.Path,
-			config.AzureBlob.CompressionFormat,
-			config.AzureBlob.CompressionLevel,
-			config.General.DisableProgressBar,
-			config.General.BackupsToKeepRemote,
-		}, nil
-	case "s3":
-		s3 := &S3{Config: &config.S3}
-		return &BackupDestination{
-			s3,
-			config.S3.Path,
-			config.S3.CompressionFormat,
-			config.S3.CompressionLevel,
-			config.General.DisableProgressBar,
-			config.General.BackupsToKeepRemote,
-		}, nil
-	case "gcs":
-		gcs := &GCS{Config: &config.GCS}
-		return &BackupDestination{
-			gcs,
-			config.GCS.Path,
-			config.GCS.CompressionFormat,
-			config.GCS.CompressionLevel,
-			config.General.DisableProgressBar,
-			config.General.BackupsToKeepRemote,
-		}, nil
-	case "cos":
-		cos := &COS{Config: &config.COS}
-		return &BackupDestination{
-			cos,
-			config.COS.Path,
-			config.COS.CompressionFormat,
-			config.COS.CompressionLevel,
-			config.General.DisableProgressBar,
-			config.General.BackupsToKeepRemote,
-		}, nil
-	case "ftp":
-		ftp := &FTP{Config: &config.FTP}
-		return &BackupDestination{
-			ftp,
-			config.FTP.Path,
-			config.FTP.CompressionFormat,
-			config.FTP.CompressionLevel,
-			config.General.DisableProgressBar,
-			config.General.BackupsToKeepRemote,
-		}, nil
-	default:
+	config = config.withLowMemory()
+	transferBuffers.setLimit(config.General.BufferMemoryLimit)
+	factory, ok := getRemoteStorageFactory(config.General.RemoteStorage)
+	if !ok {
 		return nil, fmt.Errorf("storage type '%s' not supported", config.General.RemoteStorage)
 	}
+	storage, params, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+	retrier, err := newRetrier(config.General)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newManifestSigner(config.Signing)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupDestination{
+		storage,
+		getRemotePath(config, params.Path),
+		params.CompressionFormat,
+		params.CompressionLevel,
+		config.General.DisableProgressBar,
+		getBackupsToKeepRemote(config),
+		retrier,
+		getTempDir(config.General),
+		config.General.MaxFileSize,
+		config.General.CompressionWorkers,
+		signer,
+		getBufferSize(config.General),
+		config.General.RemoteStorage,
+		params.Concurrency,
+		nil,
+	}, nil
 }
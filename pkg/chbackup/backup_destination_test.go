@@ -0,0 +1,125 @@
+package chbackup
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStorage - RemoteStorage of keys in memory
+type memoryStorage struct {
+	files map[string]bool
+}
+
+type memoryFile struct {
+	name string
+}
+
+func (f *memoryFile) Size() int64             { return 0 }
+func (f *memoryFile) LastModified() time.Time { return time.Time{} }
+func (f *memoryFile) Name() string            { return f.name }
+
+func (s *memoryStorage) Kind() string   { return "memory" }
+func (s *memoryStorage) Connect() error { return nil }
+func (s *memoryStorage) Close() error   { return nil }
+
+func (s *memoryStorage) GetFile(key string) (RemoteFile, error) {
+	if !s.files[key] {
+		return nil, ErrNotFound
+	}
+	return &memoryFile{key}, nil
+}
+
+func (s *memoryStorage) DeleteFile(key string) error {
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memoryStorage) Walk(prefix string, process func(RemoteFile)) error {
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			process(&memoryFile{key})
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetFileReader(key string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+func (s *memoryStorage) PutFile(key string, r io.ReadCloser) error {
+	s.files[key] = true
+	return r.Close()
+}
+
+func (s *memoryStorage) keys() []string {
+	keys := []string{}
+	for key := range s.files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRemoveBackup(t *testing.T) {
+	storage := &memoryStorage{files: map[string]bool{
+		"backup/tenant/metadata/db/t.sql":      true,
+		"backup/tenant/shadow/db/t/all_1_1_0":  true,
+		"backup/tenant_a/backup1.tar.gz":       true,
+		"backup/tenant_a/backup1.tar.gz.json":  true,
+		"backup/backup1.tar.gz.001":            true,
+		"backup/backup1.tar.gz.002":            true,
+		"backup/backup1.tar.gz.json":           true,
+		"backup/backup1.tar.gz.json.sig":       true,
+		"backup/backup1.tar.gz.schema":         true,
+		"backup/backup1.tar.gz_old/backup.tar": true,
+		"backup/daily/metadata/db/t.sql":       true,
+		"backup/daily.tar.gz":                  true,
+		"backup/daily.v2.tar.gz":               true,
+		"backup/daily.tar.gz.json":             true,
+	}}
+	bd := &BackupDestination{RemoteStorage: storage, path: "backup"}
+
+	assert.NoError(t, bd.RemoveBackup("tenant"))
+	assert.NoError(t, bd.RemoveBackup("backup1.tar.gz"))
+	// legacy directory backup doesn't take archives with the same prefix
+	assert.NoError(t, bd.RemoveBackup("daily"))
+	assert.Equal(t, []string{
+		"backup/backup1.tar.gz_old/backup.tar",
+		"backup/daily.tar.gz",
+		"backup/daily.tar.gz.json",
+		"backup/daily.v2.tar.gz",
+		"backup/tenant_a/backup1.tar.gz",
+		"backup/tenant_a/backup1.tar.gz.json",
+	}, storage.keys())
+
+	assert.True(t, isBackupKey("backup/backup1.tar.gz", "backup/backup1.tar.gz"))
+	assert.False(t, isBackupKey("backup/backup10.tar.gz", "backup/backup1"))
+	assert.False(t, isBackupKey("backup/daily.tar.gz.1", "backup/daily.tar.gz"))
+	assert.False(t, isBackupKey("backup/daily.tar.gz.json.bak", "backup/daily.tar.gz"))
+}
+
+func TestExtractArchiveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "backup1")
+	assert.NoError(t, extractArchiveFile(localPath, "metadata/db/t.sql", strings.NewReader("ATTACH TABLE t")))
+	content, err := ioutil.ReadFile(filepath.Join(localPath, "metadata", "db", "t.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ATTACH TABLE t", string(content))
+
+	for _, name := range []string{"../backup2/metadata.json", "metadata/../../x", "/etc/x", ".."} {
+		assert.Error(t, extractArchiveFile(localPath, name, strings.NewReader("x")), name)
+	}
+	_, err = os.Stat(filepath.Join(dir, "backup2"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,116 @@
+package chbackup
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backupStats - prometheus.Collector of count of local and remote backups and age of the oldest and the newest complete
+// remote backups, they are refreshed by listing of backups. Metrics are exported after backups are listed once
+type backupStats struct {
+	mu           sync.Mutex
+	localCount   int
+	localListed  bool
+	remoteCount  int
+	remoteListed bool
+	// oldest, newest - dates of complete remote backups, they are zero when there are no complete backups
+	oldest time.Time
+	newest time.Time
+	now    func() time.Time
+
+	localDesc  *prometheus.Desc
+	remoteDesc *prometheus.Desc
+	oldestDesc *prometheus.Desc
+	newestDesc *prometheus.Desc
+}
+
+func newBackupStats() *backupStats {
+	return &backupStats{
+		now: time.Now,
+		localDesc: prometheus.NewDesc("clickhouse_backup_local_backups",
+			"Count of local backups.", nil, nil),
+		remoteDesc: prometheus.NewDesc("clickhouse_backup_remote_backups",
+			"Count of remote backups.", nil, nil),
+		oldestDesc: prometheus.NewDesc("clickhouse_backup_oldest_remote_backup_age_seconds",
+			"Age of the oldest complete remote backup, +Inf when there are no complete backups.", nil, nil),
+		newestDesc: prometheus.NewDesc("clickhouse_backup_newest_remote_backup_age_seconds",
+			"Age of the newest complete remote backup, +Inf when there are no complete backups.", nil, nil),
+	}
+}
+
+// refresh - list local backups and remote backups of remote_storage, previous values are kept when listing fails
+func (s *backupStats) refresh(config Config) error {
+	localBackups, err := ListLocalBackups(config)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't list local backups: %v", err)
+	}
+	s.mu.Lock()
+	s.localCount, s.localListed = len(localBackups), true
+	s.mu.Unlock()
+	if config.General.RemoteStorage == "none" {
+		s.mu.Lock()
+		s.remoteListed = false
+		s.mu.Unlock()
+		return nil
+	}
+	remoteBackups, err := getRemoteBackups(config)
+	if err != nil {
+		return fmt.Errorf("can't list remote backups: %v", err)
+	}
+	s.setRemote(remoteBackups)
+	return nil
+}
+
+// setRemote - set count of remote backups and dates of complete ones, backups are sorted by date
+func (s *backupStats) setRemote(backups []Backup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remoteListed = true
+	s.remoteCount = len(backups)
+	s.oldest, s.newest = time.Time{}, time.Time{}
+	if complete := getCompleteBackups(backups); len(complete) > 0 {
+		s.oldest, s.newest = complete[0].Date, complete[len(complete)-1].Date
+	}
+}
+
+// getBackupMetricsInterval - interval of listing of backups for metrics, 0 when metrics of backups are disabled
+func getBackupMetricsInterval(config APIConfig) time.Duration {
+	interval, _ := time.ParseDuration(config.BackupMetricsInterval)
+	return interval
+}
+
+// age - seconds since date, +Inf when date is zero
+func (s *backupStats) age(date time.Time) float64 {
+	if date.IsZero() {
+		return math.Inf(1)
+	}
+	return s.now().Sub(date).Seconds()
+}
+
+// Describe - implements prometheus.Collector
+func (s *backupStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.localDesc
+	ch <- s.remoteDesc
+	ch <- s.oldestDesc
+	ch <- s.newestDesc
+}
+
+// Collect - implements prometheus.Collector
+func (s *backupStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.localListed {
+		ch <- prometheus.MustNewConstMetric(s.localDesc, prometheus.GaugeValue, float64(s.localCount))
+	}
+	if !s.remoteListed {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(s.remoteDesc, prometheus.GaugeValue, float64(s.remoteCount))
+	ch <- prometheus.MustNewConstMetric(s.oldestDesc, prometheus.GaugeValue, s.age(s.oldest))
+	ch <- prometheus.MustNewConstMetric(s.newestDesc, prometheus.GaugeValue, s.age(s.newest))
+}
@@ -0,0 +1,34 @@
+package chbackup
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupStats(t *testing.T) {
+	now := time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+	stats := newBackupStats()
+	stats.now = func() time.Time { return now }
+	ch := make(chan prometheus.Metric, 10)
+	stats.Collect(ch)
+	assert.Len(t, ch, 0)
+
+	stats.setRemote([]Backup{
+		{Name: "backup1", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "backup2", Date: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Name: "backup3", Date: time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC), Broken: "broken (can't get metadata.json)"},
+	})
+	assert.Equal(t, 3, stats.remoteCount)
+	assert.Equal(t, 172800.0, stats.age(stats.oldest))
+	assert.Equal(t, 86400.0, stats.age(stats.newest))
+	stats.Collect(ch)
+	assert.Len(t, ch, 3)
+
+	stats.setRemote([]Backup{{Name: "backup3", Broken: "broken (can't get metadata.json)"}})
+	assert.Equal(t, 1, stats.remoteCount)
+	assert.True(t, math.IsInf(stats.age(stats.newest), 1))
+}
@@ -0,0 +1,148 @@
+package chbackup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BarrierConfig - barrier of cluster backups in ZooKeeper or ClickHouse Keeper, every shard waits on the barrier
+// before freeze until all shards reach it, so tables of all shards are frozen within a small time window
+type BarrierConfig struct {
+	// ZooKeeperPath - path of barriers in ZooKeeper, barrier of backup is '<zookeeper_path>/<backup_name>', barrier is disabled when it's empty
+	ZooKeeperPath string `yaml:"zookeeper_path" envconfig:"BARRIER_ZOOKEEPER_PATH"`
+	// Shards - count of shards which create backup with the same name
+	Shards int `yaml:"shards" envconfig:"BARRIER_SHARDS"`
+	// Timeout - how long shard waits for other shards, create fails when they don't reach the barrier in time
+	Timeout string `yaml:"timeout" envconfig:"BARRIER_TIMEOUT"`
+}
+
+// barrierPollInterval - how often shards of barrier are checked
+const barrierPollInterval = time.Second
+
+// validateBarrierConfig - check values of barrier section
+func validateBarrierConfig(config BarrierConfig) error {
+	if config.ZooKeeperPath == "" {
+		return nil
+	}
+	if !path.IsAbs(config.ZooKeeperPath) {
+		return fmt.Errorf("barrier.zookeeper_path must be absolute path")
+	}
+	if config.Shards < 1 {
+		return fmt.Errorf("barrier.shards must be at least 1")
+	}
+	if timeout, err := time.ParseDuration(config.Timeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("barrier.timeout must be positive duration like 5m")
+	}
+	return nil
+}
+
+// waitBarrier - register shard on barrier of backup in ZooKeeper through system.zookeeper and wait until all shards
+// are registered. Return time of ZooKeeper when the last shard was registered, it's the same on all shards.
+// Zero time is returned when barrier is disabled
+func waitBarrier(ctx context.Context, config Config, backupName string) (time.Time, error) {
+	if config.Barrier.ZooKeeperPath == "" {
+		return time.Time{}, nil
+	}
+	timeout, err := time.ParseDuration(config.Barrier.Timeout)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse barrier.timeout: %v", err)
+	}
+	ch := &ClickHouse{
+		Config: &config.ClickHouse,
+		ctx:    ctx,
+	}
+	if err := ch.Connect(); err != nil {
+		return time.Time{}, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	shard := config.General.Shard
+	if shard == "" {
+		if shard, err = ch.GetHostname(); err != nil {
+			return time.Time{}, err
+		}
+	}
+	round, err := getBarrierRound(ch, path.Join(config.Barrier.ZooKeeperPath, backupName), shard, config.Barrier.Shards, timeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	barrierPath := path.Join(config.Barrier.ZooKeeperPath, backupName, round)
+	if err := ch.EnterBarrier(barrierPath, shard); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Wait for %d shards on barrier '%s'", config.Barrier.Shards, barrierPath)
+	deadline := time.Now().Add(timeout)
+	for {
+		shards, err := ch.GetBarrierShards(barrierPath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(shards) >= config.Barrier.Shards {
+			var barrierTime time.Time
+			for _, enterTime := range shards {
+				if enterTime.After(barrierTime) {
+					barrierTime = enterTime
+				}
+			}
+			log.Printf("All %d shards reached barrier at %s", len(shards), barrierTime.Format(time.RFC3339))
+			return barrierTime.UTC(), nil
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, fmt.Errorf("only %d of %d shards reached barrier '%s' in %s", len(shards), config.Barrier.Shards, barrierPath, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(barrierPollInterval):
+		}
+	}
+}
+
+// getBarrierRound - read rounds of barrier of backup and select round for shard, see selectBarrierRound
+func getBarrierRound(ch *ClickHouse, backupPath, shard string, shards int, timeout time.Duration) (string, error) {
+	rounds, err := ch.GetBarrierShards(backupPath)
+	if err != nil {
+		return "", err
+	}
+	roundShards := make(map[string]map[string]time.Time, len(rounds))
+	for round := range rounds {
+		if _, err := strconv.Atoi(round); err != nil {
+			continue
+		}
+		if roundShards[round], err = ch.GetBarrierShards(path.Join(backupPath, round)); err != nil {
+			return "", err
+		}
+	}
+	return selectBarrierRound(rounds, roundShards, shard, shards, timeout, time.Now()), nil
+}
+
+// selectBarrierRound - every attempt to create backup with the same name enters its own round '<backup_name>/<n>',
+// because nodes of barrier are not removed. Shard joins the first round which is waited by other shards: it isn't
+// complete, it doesn't have node of this shard left by its failed attempt, and its first shard entered it less than
+// timeout ago, so it's still waiting. New round is started when there is no such round
+func selectBarrierRound(rounds map[string]time.Time, roundShards map[string]map[string]time.Time, shard string, shards int, timeout time.Duration, now time.Time) string {
+	numbers := []int{}
+	for round := range roundShards {
+		if n, err := strconv.Atoi(round); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		round := strconv.Itoa(n)
+		entered := roundShards[round]
+		if _, ok := entered[shard]; ok || len(entered) >= shards || now.Sub(rounds[round]) >= timeout {
+			continue
+		}
+		return round
+	}
+	last := 0
+	if len(numbers) > 0 {
+		last = numbers[len(numbers)-1]
+	}
+	return strconv.Itoa(last + 1)
+}
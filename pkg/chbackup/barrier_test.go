@@ -0,0 +1,41 @@
+package chbackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBarrierConfig(t *testing.T) {
+	assert.NoError(t, validateBarrierConfig(BarrierConfig{}))
+	assert.NoError(t, validateBarrierConfig(BarrierConfig{ZooKeeperPath: "/clickhouse/backup_barriers", Shards: 3, Timeout: "5m"}))
+	assert.Error(t, validateBarrierConfig(BarrierConfig{ZooKeeperPath: "backup_barriers", Shards: 3, Timeout: "5m"}))
+	assert.Error(t, validateBarrierConfig(BarrierConfig{ZooKeeperPath: "/clickhouse/backup_barriers", Timeout: "5m"}))
+	assert.Error(t, validateBarrierConfig(BarrierConfig{ZooKeeperPath: "/clickhouse/backup_barriers", Shards: 3, Timeout: "0s"}))
+
+	// disabled barrier doesn't connect to ClickHouse
+	barrierTime, err := waitBarrier(context.Background(), Config{}, "backup")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Time{}, barrierTime)
+}
+
+func TestSelectBarrierRound(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	timeout := 5 * time.Minute
+	assert.Equal(t, "1", selectBarrierRound(nil, nil, "shard1", 2, timeout, now))
+
+	rounds := map[string]time.Time{"1": now.Add(-time.Hour), "2": now.Add(-time.Minute)}
+	roundShards := map[string]map[string]time.Time{
+		// round of previous attempt which passed
+		"1": {"shard1": now.Add(-time.Hour), "shard2": now.Add(-time.Hour)},
+		// shard2 waits for shard1
+		"2": {"shard2": now.Add(-time.Minute)},
+	}
+	assert.Equal(t, "2", selectBarrierRound(rounds, roundShards, "shard1", 2, timeout, now))
+	// retry of shard2 after its wait failed starts new round
+	assert.Equal(t, "3", selectBarrierRound(rounds, roundShards, "shard2", 2, timeout, now))
+	// nobody waits on expired round
+	assert.Equal(t, "3", selectBarrierRound(rounds, roundShards, "shard1", 2, timeout, now.Add(timeout)))
+}
@@ -0,0 +1,167 @@
+package chbackup
+
+import (
+	"io"
+	"sync"
+
+	"gopkg.in/djherbis/buffer.v1"
+)
+
+// transferBuffers - buffers between archiver and remote storage of all uploads and downloads of process,
+// their total size is limited by general.buffer_memory_limit
+var transferBuffers = newBufferPool()
+
+// bufferPool - fixed-size buffers reused by transfers, transfer waits for buffers when the limit is reached
+type bufferPool struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+	free  map[int]*sync.Pool
+}
+
+func newBufferPool() *bufferPool {
+	p := &bufferPool{free: map[int]*sync.Pool{}}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// setLimit - change total size of buffers in use, it isn't limited when limit is 0
+func (p *bufferPool) setLimit(limit int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+	p.cond.Broadcast()
+}
+
+// get - take count buffers of size at once, so transfers which need several buffers can't block each other.
+// Buffers larger than the limit are given when no other buffers are in use
+func (p *bufferPool) get(size, count int) []*poolBuffer {
+	total := int64(size * count)
+	p.mu.Lock()
+	for p.limit > 0 && p.used > 0 && p.used+total > p.limit {
+		p.cond.Wait()
+	}
+	p.used += total
+	free, ok := p.free[size]
+	if !ok {
+		free = &sync.Pool{New: func() interface{} { return make([]byte, size) }}
+		p.free[size] = free
+	}
+	p.mu.Unlock()
+	buffers := make([]*poolBuffer, count)
+	for i := range buffers {
+		buffers[i] = &poolBuffer{pool: p, data: free.Get().([]byte), size: size}
+	}
+	return buffers
+}
+
+// put - return data of released buffer
+func (p *bufferPool) put(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used -= int64(len(data))
+	p.free[len(data)].Put(data)
+	p.cond.Broadcast()
+}
+
+// usage - total size of buffers in use and the limit
+func (p *bufferPool) usage() (int64, int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used, p.limit
+}
+
+var _ buffer.Buffer = (*poolBuffer)(nil)
+
+// poolBuffer - FIFO buffer.Buffer over fixed-size buffer of bufferPool. Buffer may be released while pipe still uses it
+// in background, Read and Write of released buffer fail and don't touch data which is reused by another transfer
+type poolBuffer struct {
+	mu     sync.Mutex
+	pool   *bufferPool
+	data   []byte
+	size   int
+	start  int
+	length int
+}
+
+func (b *poolBuffer) Len() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.length)
+}
+
+// Cap - size of buffer, it isn't changed by release, so writer of pipe tries to write and fails instead of waiting
+func (b *poolBuffer) Cap() int64 {
+	return int64(b.size)
+}
+
+func (b *poolBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start, b.length = 0, 0
+}
+
+func (b *poolBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.data == nil {
+		return 0, io.ErrClosedPipe
+	}
+	n := 0
+	for n < len(p) && b.length < len(b.data) {
+		end := (b.start + b.length) % len(b.data)
+		chunk := len(b.data) - b.length
+		if end+chunk > len(b.data) {
+			chunk = len(b.data) - end
+		}
+		c := copy(b.data[end:end+chunk], p[n:])
+		b.length += c
+		n += c
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (b *poolBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.data == nil {
+		return 0, io.ErrClosedPipe
+	}
+	if b.length == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && b.length > 0 {
+		chunk := b.length
+		if b.start+chunk > len(b.data) {
+			chunk = len(b.data) - b.start
+		}
+		c := copy(p[n:], b.data[b.start:b.start+chunk])
+		b.start = (b.start + c) % len(b.data)
+		b.length -= c
+		n += c
+	}
+	return n, nil
+}
+
+// release - return data to pool, it's safe to call release more than once
+func (b *poolBuffer) release() {
+	b.mu.Lock()
+	data := b.data
+	b.data, b.start, b.length = nil, 0, 0
+	b.mu.Unlock()
+	if data != nil {
+		b.pool.put(data)
+	}
+}
+
+// releaseBuffers - release all buffers taken by get
+func releaseBuffers(buffers []*poolBuffer) {
+	for _, b := range buffers {
+		b.release()
+	}
+}
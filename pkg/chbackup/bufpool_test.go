@@ -0,0 +1,63 @@
+package chbackup
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolBuffer(t *testing.T) {
+	pool := newBufferPool()
+	b := pool.get(8, 1)[0]
+	n, err := b.Write([]byte("abcdef"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	p := make([]byte, 4)
+	n, _ = b.Read(p)
+	assert.Equal(t, "abcd", string(p[:n]))
+	// data wraps around the end of buffer
+	n, err = b.Write([]byte("ghijklmn"))
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, int64(8), b.Len())
+	p = make([]byte, 10)
+	n, _ = b.Read(p)
+	assert.Equal(t, "efghijkl", string(p[:n]))
+	_, err = b.Read(p)
+	assert.Equal(t, io.EOF, err)
+
+	b.release()
+	b.release()
+	_, err = b.Write([]byte("a"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+	assert.Equal(t, int64(8), b.Cap())
+	used, _ := pool.usage()
+	assert.Equal(t, int64(0), used)
+}
+
+func TestBufferPoolLimit(t *testing.T) {
+	pool := newBufferPool()
+	pool.setLimit(16)
+	first := pool.get(8, 2)
+	used, limit := pool.usage()
+	assert.Equal(t, int64(16), used)
+	assert.Equal(t, int64(16), limit)
+	got := make(chan []*poolBuffer)
+	go func() {
+		got <- pool.get(8, 1)
+	}()
+	select {
+	case <-got:
+		t.Fatal("buffer over the limit is taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseBuffers(first)
+	second := <-got
+	used, _ = pool.usage()
+	assert.Equal(t, int64(8), used)
+	releaseBuffers(second)
+	// buffers larger than the limit are given when pool is empty
+	releaseBuffers(pool.get(32, 1))
+}
@@ -0,0 +1,254 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// Catalog - local backups and backups of remote storages written by 'catalog export'
+type Catalog struct {
+	CreationDate time.Time       `json:"creation_date"`
+	Local        []CatalogBackup `json:"local"`
+	Remote       []CatalogTarget `json:"remote"`
+}
+
+// CatalogTarget - backups of one remote storage
+type CatalogTarget struct {
+	// Target - 'primary' or name of remote target
+	Target        string          `json:"target"`
+	RemoteStorage string          `json:"remote_storage"`
+	Backups       []CatalogBackup `json:"backups"`
+}
+
+// CatalogBackup - backup in catalog, names of remote backups contain extension of archive
+type CatalogBackup struct {
+	Name           string    `json:"name"`
+	Date           time.Time `json:"date"`
+	Size           int64     `json:"size"`
+	RequiredBackup string    `json:"required_backup,omitempty"`
+	// Chain - required backups from the nearest one to the base backup
+	Chain      []string `json:"chain,omitempty"`
+	TableCount int      `json:"table_count"`
+	// Checksums - ChecksumsAll, ChecksumsPartial or ChecksumsNone depending on how many parts have checksums
+	Checksums string `json:"checksums,omitempty"`
+	// ArchiveChecksum - SHA-256 of remote archive saved in manifest
+	ArchiveChecksum string `json:"archive_checksum,omitempty"`
+	UploadState     string `json:"upload_state,omitempty"`
+	SchemaOnly      bool   `json:"schema_only,omitempty"`
+	Broken          string `json:"broken,omitempty"`
+}
+
+// getCatalogBackups - convert backups stored in the same place to catalog entries with their chains
+func getCatalogBackups(backups []Backup) []CatalogBackup {
+	result := make([]CatalogBackup, 0, len(backups))
+	for _, b := range backups {
+		entry := CatalogBackup{
+			Name:            b.Name,
+			Date:            b.Date,
+			Size:            b.Size,
+			RequiredBackup:  b.RequiredBackup,
+			TableCount:      b.TableCount,
+			Checksums:       b.Checksums,
+			ArchiveChecksum: b.ArchiveChecksum,
+			UploadState:     b.UploadState,
+			Broken:          b.Broken,
+		}
+		if chain, err := getBackupChain(backups, b.Name); err == nil {
+			entry.Chain = chain.Chain
+		} else if entry.Broken == "" {
+			entry.Broken = err.Error()
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// GetCatalog - collect local backups and backups of remote storages selected by target
+func GetCatalog(config Config, target string) (*Catalog, error) {
+	catalog := &Catalog{
+		CreationDate: time.Now().UTC(),
+		Local:        []CatalogBackup{},
+		Remote:       []CatalogTarget{},
+	}
+	localBackups, err := ListLocalBackups(config)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't get local backups: %v", err)
+	}
+	catalog.Local = getCatalogBackups(localBackups)
+	for i := range catalog.Local {
+		metadata := getLocalBackupMetadata(path.Join(getDataPath(config), "backup", catalog.Local[i].Name))
+		catalog.Local[i].Size = metadata.Size
+		catalog.Local[i].SchemaOnly = metadata.SchemaOnly
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		remoteBackups, err := getRemoteBackups(t.Config)
+		if err != nil {
+			return nil, fmt.Errorf("can't get backups of remote target '%s': %v", t.Name, err)
+		}
+		catalog.Remote = append(catalog.Remote, CatalogTarget{
+			Target:        t.Name,
+			RemoteStorage: t.Config.General.RemoteStorage,
+			Backups:       getCatalogBackups(remoteBackups),
+		})
+	}
+	return catalog, nil
+}
+
+// ExportCatalog - write catalog of local backups and backups of remote storages selected by target as JSON to file or to stdout
+func ExportCatalog(config Config, target string, output string) error {
+	if target == "" {
+		target = AllTargets
+	}
+	catalog, err := GetCatalog(config, target)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(catalog, "", "\t")
+	if err != nil {
+		return fmt.Errorf("can't marshal catalog: %v", err)
+	}
+	if output == "" || output == "-" {
+		fmt.Println(string(content))
+		return nil
+	}
+	if err := ioutil.WriteFile(output, append(content, '\n'), 0640); err != nil {
+		return fmt.Errorf("can't write catalog: %v", err)
+	}
+	log.Printf("Catalog of %d local backups and %d remote targets is written to '%s'", len(catalog.Local), len(catalog.Remote), output)
+	return nil
+}
+
+// readCatalog - read catalog written by ExportCatalog
+func readCatalog(fileName string) (*Catalog, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read catalog: %v", err)
+	}
+	var catalog Catalog
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		return nil, fmt.Errorf("can't parse catalog: %v", err)
+	}
+	return &catalog, nil
+}
+
+// getBackupsToImport - remote backups which don't exist locally, only backups of catalog are imported when catalog is set
+func getBackupsToImport(remoteBackups []Backup, localBackups []Backup, catalog *CatalogTarget) ([]string, []string) {
+	local := map[string]bool{}
+	for _, b := range localBackups {
+		local[b.Name] = true
+	}
+	remote := map[string]Backup{}
+	for _, b := range remoteBackups {
+		remote[trimArchiveExtension(b.Name)] = b
+	}
+	names := []string{}
+	if catalog == nil {
+		for _, b := range remoteBackups {
+			names = append(names, trimArchiveExtension(b.Name))
+		}
+	} else {
+		for _, b := range catalog.Backups {
+			names = append(names, trimArchiveExtension(b.Name))
+		}
+	}
+	toImport, missing := []string{}, []string{}
+	for _, name := range names {
+		b, ok := remote[name]
+		switch {
+		case !ok:
+			missing = append(missing, name)
+		case b.Broken != "" || local[name]:
+		default:
+			toImport = append(toImport, name)
+		}
+	}
+	return toImport, missing
+}
+
+// ImportCatalog - download schema of remote backups which don't exist locally, so they are listed, described and restored on
+// re-provisioned host like after 'download --schema'. Only backups from catalog written by 'catalog export' are imported when catalogFile is set
+func ImportCatalog(config Config, target string, catalogFile string) error {
+	var catalog *Catalog
+	if catalogFile != "" {
+		var err error
+		if catalog, err = readCatalog(catalogFile); err != nil {
+			return err
+		}
+	}
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return ErrUnknownClickhouseDataPath
+	}
+	if err := os.MkdirAll(path.Join(dataPath, "backup"), dirMode(os.ModePerm)); err != nil {
+		return err
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			fmt.Println("ImportCatalog aborted: RemoteStorage set to \"none\"")
+			continue
+		}
+		var catalogTarget *CatalogTarget
+		if catalog != nil {
+			for i := range catalog.Remote {
+				if catalog.Remote[i].Target == t.Name {
+					catalogTarget = &catalog.Remote[i]
+				}
+			}
+			if catalogTarget == nil {
+				continue
+			}
+		}
+		bd, err := NewBackupDestination(t.Config)
+		if err != nil {
+			return err
+		}
+		if err := bd.Connect(); err != nil {
+			return fmt.Errorf("can't connect to remote storage: %v", err)
+		}
+		remoteBackups, err := bd.BackupList()
+		if err != nil {
+			bd.Close()
+			return err
+		}
+		localBackups, err := ListLocalBackups(config)
+		if err != nil {
+			bd.Close()
+			return err
+		}
+		toImport, missing := getBackupsToImport(remoteBackups, localBackups, catalogTarget)
+		for _, name := range missing {
+			log.Printf("Backup '%s' of catalog not found on remote target '%s'", name, t.Name)
+		}
+		for _, name := range toImport {
+			log.Printf("Import '%s' from remote target '%s'", name, t.Name)
+			backupPath := path.Join(dataPath, "backup", name)
+			if err := bd.CompressedStreamDownload(name, backupPath, "", true); err != nil {
+				log.Printf("can't import '%s': %v", name, err)
+				removePartialBackup(config, backupPath)
+				failed++
+			}
+		}
+		bd.Close()
+	}
+	if failed > 0 {
+		return fmt.Errorf("can't import %d backups", failed)
+	}
+	return nil
+}
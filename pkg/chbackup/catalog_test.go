@@ -0,0 +1,37 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCatalogBackups(t *testing.T) {
+	backups := []Backup{
+		{Name: "full.tar.gz", TableCount: 2, ArchiveChecksum: "abc"},
+		{Name: "diff1.tar.gz", RequiredBackup: "full"},
+		{Name: "diff2.tar.gz", RequiredBackup: "diff1"},
+	}
+	catalog := getCatalogBackups(backups)
+	assert.Len(t, catalog, 3)
+	assert.Equal(t, "abc", catalog[0].ArchiveChecksum)
+	assert.Empty(t, catalog[0].Chain)
+	assert.Equal(t, []string{"diff1.tar.gz", "full.tar.gz"}, catalog[2].Chain)
+}
+
+func TestGetBackupsToImport(t *testing.T) {
+	remote := []Backup{
+		{Name: "full.tar.gz"},
+		{Name: "diff.tar.gz", RequiredBackup: "full"},
+		{Name: "broken.tar.gz", Broken: "archive not found"},
+	}
+	local := []Backup{{Name: "full"}}
+	toImport, missing := getBackupsToImport(remote, local, nil)
+	assert.Equal(t, []string{"diff"}, toImport)
+	assert.Empty(t, missing)
+
+	catalog := &CatalogTarget{Backups: []CatalogBackup{{Name: "diff.tar.gz"}, {Name: "removed.tar.gz"}}}
+	toImport, missing = getBackupsToImport(remote, nil, catalog)
+	assert.Equal(t, []string{"diff"}, toImport)
+	assert.Equal(t, []string{"removed"}, missing)
+}
@@ -0,0 +1,144 @@
+package chbackup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BackupChain - backups required to restore backup and backups which require it
+type BackupChain struct {
+	BackupName string `json:"backup_name"`
+	// Chain - required backups from the nearest one to the base backup
+	Chain []string `json:"chain"`
+	// Missing - required backup which doesn't exist, backup can't be restored without it
+	Missing string `json:"missing,omitempty"`
+	// Dependents - backups which require this backup directly or through other backups
+	Dependents []string `json:"dependents"`
+}
+
+// trimArchiveExtension - names of remote backups contain extension of archive, required backups are referenced without it
+func trimArchiveExtension(name string) string {
+	for _, format := range []string{"tar", "lz4", "bzip2", "gzip", "sz", "xz"} {
+		if strings.HasSuffix(name, "."+getExtension(format)) {
+			return strings.TrimSuffix(name, "."+getExtension(format))
+		}
+	}
+	return name
+}
+
+// getBackupChain - build chain of backupName from required backups of backups stored in the same place
+func getBackupChain(backups []Backup, backupName string) (*BackupChain, error) {
+	required := map[string]string{}
+	names := map[string]string{}
+	for _, b := range backups {
+		key := trimArchiveExtension(b.Name)
+		required[key] = trimArchiveExtension(b.RequiredBackup)
+		names[key] = b.Name
+	}
+	key := trimArchiveExtension(backupName)
+	if _, ok := names[key]; !ok {
+		return nil, fmt.Errorf("backup '%s' not found", backupName)
+	}
+	chain := &BackupChain{
+		BackupName: names[key],
+		Chain:      []string{},
+		Dependents: []string{},
+	}
+	visited := map[string]bool{key: true}
+	for r := required[key]; r != ""; r = required[r] {
+		if visited[r] {
+			return nil, fmt.Errorf("backup '%s' has cyclic dependency on '%s'", backupName, r)
+		}
+		visited[r] = true
+		if _, ok := names[r]; !ok {
+			chain.Missing = r
+			break
+		}
+		chain.Chain = append(chain.Chain, names[r])
+	}
+	for k, name := range names {
+		if k == key {
+			continue
+		}
+		seen := map[string]bool{}
+		for r := required[k]; r != "" && !seen[r]; r = required[r] {
+			seen[r] = true
+			if r == key {
+				chain.Dependents = append(chain.Dependents, name)
+				break
+			}
+		}
+	}
+	sort.Strings(chain.Dependents)
+	return chain, nil
+}
+
+// checkNoDependents - return error when backup is required by other backups and force is not set
+func checkNoDependents(backups []Backup, backupName string, force bool) error {
+	if force {
+		return nil
+	}
+	chain, err := getBackupChain(backups, backupName)
+	if err != nil {
+		return err
+	}
+	if len(chain.Dependents) > 0 {
+		return fmt.Errorf("backup '%s' is required by %s, use --force to delete it", backupName, strings.Join(chain.Dependents, ", "))
+	}
+	return nil
+}
+
+// GetBackupChain - return chain of local backup or of remote backup from remote storages selected by target
+func GetBackupChain(config Config, backupName string, remote bool, target string) (*BackupChain, error) {
+	if !remote {
+		backups, err := ListLocalBackups(config)
+		if err != nil {
+			return nil, err
+		}
+		return getBackupChain(backups, backupName)
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		backups, err := getRemoteBackups(t.Config)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range backups {
+			if trimArchiveExtension(b.Name) == trimArchiveExtension(backupName) {
+				return getBackupChain(backups, backupName)
+			}
+		}
+	}
+	return nil, fmt.Errorf("backup '%s' not found on remote storage", backupName)
+}
+
+// PrintBackupChain - print backups required by backup and backups which require it
+func PrintBackupChain(config Config, backupName string, remote bool, target string) error {
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	chain, err := GetBackupChain(config, backupName, remote, target)
+	if err != nil {
+		return err
+	}
+	fmt.Println(chain.BackupName)
+	indent := ""
+	for _, name := range chain.Chain {
+		indent += "  "
+		fmt.Printf("%s└─ %s\n", indent, name)
+	}
+	if chain.Missing != "" {
+		fmt.Printf("%s  └─ %s (missing)\n", indent, chain.Missing)
+	}
+	if len(chain.Dependents) > 0 {
+		fmt.Printf("required by: %s\n", strings.Join(chain.Dependents, ", "))
+	}
+	return nil
+}
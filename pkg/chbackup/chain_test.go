@@ -0,0 +1,37 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBackupChain(t *testing.T) {
+	backups := []Backup{
+		{Name: "full.tar.gz"},
+		{Name: "inc1.tar.gz", RequiredBackup: "full"},
+		{Name: "inc2.tar.gz", RequiredBackup: "inc1"},
+		{Name: "other.tar.gz"},
+	}
+	chain, err := getBackupChain(backups, "inc2.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"inc1.tar.gz", "full.tar.gz"}, chain.Chain)
+	assert.Equal(t, []string{}, chain.Dependents)
+
+	chain, err = getBackupChain(backups, "full")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, chain.Chain)
+	assert.Equal(t, []string{"inc1.tar.gz", "inc2.tar.gz"}, chain.Dependents)
+
+	chain, err = getBackupChain(backups[1:], "inc2.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"inc1.tar.gz"}, chain.Chain)
+	assert.Equal(t, "full", chain.Missing)
+
+	assert.Error(t, checkNoDependents(backups, "inc1.tar.gz", false))
+	assert.NoError(t, checkNoDependents(backups, "inc1.tar.gz", true))
+	assert.NoError(t, checkNoDependents(backups, "inc2.tar.gz", false))
+
+	_, err = getBackupChain([]Backup{{Name: "a", RequiredBackup: "b"}, {Name: "b", RequiredBackup: "a"}}, "a")
+	assert.Error(t, err)
+}
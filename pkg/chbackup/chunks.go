@@ -0,0 +1,173 @@
+package chbackup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// chunkSuffixRE - suffix of archive chunk, chunks of 'backup.tar.gz' are 'backup.tar.gz.001', 'backup.tar.gz.002', ...
+var chunkSuffixRE = regexp.MustCompile(`\.\d{3,}$`)
+
+// chunkKey - key of n-th chunk of archive, chunks are numbered from 1
+func chunkKey(archiveKey string, n int) string {
+	return fmt.Sprintf("%s.%03d", archiveKey, n)
+}
+
+// archiveChunkName - return name of archive for name of archive chunk
+func archiveChunkName(name string) (string, bool) {
+	if !chunkSuffixRE.MatchString(name) {
+		return "", false
+	}
+	archiveName := chunkSuffixRE.ReplaceAllString(name, "")
+	return archiveName, isArchiveName(archiveName)
+}
+
+// archiveFile - remote archive stored as one file or as chunks when general.max_file_size is set
+type archiveFile struct {
+	name  string
+	keys  []string
+	files []RemoteFile
+}
+
+func (a *archiveFile) Name() string {
+	return a.name
+}
+
+func (a *archiveFile) Size() int64 {
+	var size int64
+	for _, f := range a.files {
+		size += f.Size()
+	}
+	return size
+}
+
+func (a *archiveFile) LastModified() time.Time {
+	var lastModified time.Time
+	for _, f := range a.files {
+		if f.LastModified().After(lastModified) {
+			lastModified = f.LastModified()
+		}
+	}
+	return lastModified
+}
+
+// getArchive - find archive stored as one file or as chunks, return ErrNotFound if there are neither
+func (bd *BackupDestination) getArchive(archiveKey string) (*archiveFile, error) {
+	file, err := bd.GetFile(archiveKey)
+	if err == nil {
+		return &archiveFile{name: archiveKey, keys: []string{archiveKey}, files: []RemoteFile{file}}, nil
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+	archive := &archiveFile{name: archiveKey}
+	for n := 1; ; n++ {
+		key := chunkKey(archiveKey, n)
+		file, err := bd.GetFile(key)
+		if err == ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		archive.keys = append(archive.keys, key)
+		archive.files = append(archive.files, file)
+	}
+	if len(archive.keys) == 0 {
+		return nil, ErrNotFound
+	}
+	return archive, nil
+}
+
+// openArchive - return reader of archive, chunks are read one after another as one stream
+func (bd *BackupDestination) openArchive(archive *archiveFile) io.ReadCloser {
+	return &chunksReader{bd: bd, keys: archive.keys}
+}
+
+type chunksReader struct {
+	bd      *BackupDestination
+	keys    []string
+	current io.ReadCloser
+	span    *span
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			r.span = startSpan(r.bd.ctx, "download file", "key", r.keys[0])
+			reader, err := r.bd.GetFileReader(r.keys[0])
+			if err != nil {
+				r.span.finish(err)
+				return 0, err
+			}
+			r.current = reader
+			r.keys = r.keys[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			err = r.current.Close()
+			r.current = nil
+			r.span.finish(err)
+			if n == 0 && err == nil {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (r *chunksReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	r.span.finish(fmt.Errorf("archive is closed before end of file"))
+	return err
+}
+
+// putArchive - upload archive as one file or as chunks of general.max_file_size bytes,
+// chunks of archive with the same name left by previous upload are removed
+func (bd *BackupDestination) putArchive(archiveKey string, body io.Reader) error {
+	if bd.maxFileSize <= 0 {
+		s := startSpan(bd.ctx, "upload file", "key", archiveKey)
+		err := bd.PutFile(archiveKey, ioutil.NopCloser(body))
+		s.finish(err)
+		return err
+	}
+	r := bufio.NewReader(body)
+	n := 1
+	for ; ; n++ {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		s := startSpan(bd.ctx, "upload file", "key", chunkKey(archiveKey, n))
+		err := bd.PutFile(chunkKey(archiveKey, n), ioutil.NopCloser(io.LimitReader(r, bd.maxFileSize)))
+		s.finish(err)
+		if err != nil {
+			return fmt.Errorf("can't upload chunk %d: %v", n, err)
+		}
+	}
+	for ; ; n++ {
+		key := chunkKey(archiveKey, n)
+		if _, err := bd.GetFile(key); err != nil {
+			if err == ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		if err := bd.DeleteFile(key); err != nil {
+			return fmt.Errorf("can't remove '%s': %v", strings.TrimPrefix(key, bd.path), err)
+		}
+	}
+}
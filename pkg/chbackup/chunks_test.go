@@ -0,0 +1,23 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveChunkName(t *testing.T) {
+	name, ok := archiveChunkName("backup.tar.gz.001")
+	assert.True(t, ok)
+	assert.Equal(t, "backup.tar.gz", name)
+	name, ok = archiveChunkName("backup.tar.lz4.1234")
+	assert.True(t, ok)
+	assert.Equal(t, "backup.tar.lz4", name)
+	_, ok = archiveChunkName("backup.tar.gz")
+	assert.False(t, ok)
+	_, ok = archiveChunkName("backup.tar.gz.json")
+	assert.False(t, ok)
+	_, ok = archiveChunkName("2020-01-01T00-00-00.001")
+	assert.False(t, ok)
+	assert.Equal(t, "backup.tar.gz.002", chunkKey("backup.tar.gz", 2))
+}
@@ -1,41 +1,69 @@
 package chbackup
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go"
 	"github.com/jmoiron/sqlx"
 )
 
+// FreezeWithNameVersion - first version of ClickHouse supporting ALTER TABLE ... FREEZE WITH NAME
+const FreezeWithNameVersion = 20001000
+
 // ClickHouse - provide
 type ClickHouse struct {
 	Config *ClickHouseConfig
 	conn   *sqlx.DB
 	uid    *int
 	gid    *int
+	// pathMap - paths seen by ClickHouse and paths on host, it's built by hostPath on first use
+	pathMap map[string]string
+	// ctx - context of operation, context.Background() is used when it's nil
+	ctx context.Context
+	// settings - settings sent with every query of connection, they override settings set by Connect
+	settings map[string]string
 }
 
 // Table - ClickHouse table struct
 type Table struct {
 	Database string `db:"database" json:"database"`
 	Name     string `db:"name" json:"table"`
-	Skip     bool   `json:"skip"`
+	Engine   string `db:"engine" json:"engine"`
+	// TotalBytes, TotalRows, Partitions, LastModified - size of active parts, they are set by GetTablesSize only
+	TotalBytes   uint64 `db:"-" json:"total_bytes"`
+	TotalRows    uint64 `db:"-" json:"total_rows"`
+	Partitions   uint64 `db:"-" json:"partitions"`
+	LastModified string `db:"-" json:"last_modified,omitempty"`
+	Skip         bool   `json:"skip"`
+}
+
+// DefaultDisk - name of disk with data_path of ClickHouse
+const DefaultDisk = "default"
+
+// Disk - ClickHouse disk from system.disks
+type Disk struct {
+	Name string `db:"name" json:"name"`
+	Path string `db:"path" json:"path"`
 }
 
 // BackupPartition - struct representing Clickhouse partition
 type BackupPartition struct {
 	Name string
 	Path string
+	// Disk - disk where part was stored when backup was created, it's empty for backups created by previous versions
+	Disk string
 }
 
 // BackupTable - struct to store additional information on partitions
@@ -82,17 +110,82 @@ func (ch *ClickHouse) Connect() error {
 
 	timeoutSeconds := fmt.Sprintf("%d", int(timeout.Seconds()))
 	params := url.Values{}
-	params.Add("username", ch.Config.Username)
-	params.Add("password", ch.Config.Password)
 	params.Add("database", "system")
 	params.Add("receive_timeout", timeoutSeconds)
 	params.Add("send_timeout", timeoutSeconds)
+	if ch.Config.SkipVerify {
+		params.Add("skip_verify", "true")
+	}
 
-	connectionString := fmt.Sprintf("tcp://%v:%v?%s", ch.Config.Host, ch.Config.Port, params.Encode())
-	if ch.conn, err = sqlx.Open("clickhouse", connectionString); err != nil {
+	for name, value := range ch.settings {
+		params.Set(name, value)
+	}
+
+	driverName := "clickhouse"
+	var connectionString string
+	if ch.Config.Protocol == ProtocolHTTP {
+		// the whole request must finish in timeout, including result of query
+		params.Add("timeout", timeout.String())
+		if ch.Config.Compression {
+			// responses are decompressed by http.Client which asks for gzip by itself
+			params.Add("enable_http_compression", "1")
+		}
+		u := url.URL{
+			Scheme:   "http",
+			User:     url.UserPassword(ch.Config.Username, ch.Config.Password),
+			Host:     net.JoinHostPort(ch.Config.Host, strconv.Itoa(int(ch.Config.Port))),
+			Path:     "/",
+			RawQuery: params.Encode(),
+		}
+		if ch.Config.Secure {
+			u.Scheme = "https"
+		}
+		driverName, connectionString = httpDriverName, u.String()
+	} else {
+		params.Add("username", ch.Config.Username)
+		params.Add("password", ch.Config.Password)
+		// driver closes connection when socket isn't read or written for 1 minute by default, it breaks queries longer than that
+		params.Add("read_timeout", timeoutSeconds)
+		params.Add("write_timeout", timeoutSeconds)
+		if ch.Config.Secure {
+			params.Add("secure", "true")
+		}
+		if ch.Config.Compression {
+			params.Add("compress", "true")
+		}
+		connectionString = fmt.Sprintf("tcp://%v:%v?%s", ch.Config.Host, ch.Config.Port, params.Encode())
+	}
+	if ch.conn, err = sqlx.Open(driverName, connectionString); err != nil {
 		return err
 	}
-	return ch.conn.Ping()
+	return ch.conn.PingContext(ch.queryContext())
+}
+
+// connectionParams - arguments of DSN which are not settings, they can't be overridden by clickhouse.freeze_settings and restore_settings
+var connectionParams = map[string]bool{
+	"database": true, "username": true, "password": true, "secure": true, "skip_verify": true, "compress": true,
+	"timeout": true, "read_timeout": true, "write_timeout": true, "debug": true, "alt_hosts": true, "connection_open_strategy": true,
+	"block_size": true, "pool_size": true, "tls_config": true, "no_delay": true,
+}
+
+var settingNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateQuerySettings - settings must be valid names of settings of ClickHouse
+func validateQuerySettings(section string, settings map[string]string) error {
+	for name := range settings {
+		if !settingNameRE.MatchString(name) || connectionParams[name] {
+			return fmt.Errorf("'%s' of clickhouse.%s is not a setting of query", name, section)
+		}
+	}
+	return nil
+}
+
+// queryContext - context of queries, they are canceled when it's done
+func (ch *ClickHouse) queryContext() context.Context {
+	if ch.ctx == nil {
+		return context.Background()
+	}
+	return ch.ctx
 }
 
 // GetDataPath - return ClickHouse data_path
@@ -103,13 +196,38 @@ func (ch *ClickHouse) GetDataPath() (string, error) {
 	var result []struct {
 		MetadataPath string `db:"metadata_path"`
 	}
-	if err := ch.conn.Select(&result, "SELECT metadata_path FROM system.tables WHERE database == 'system' LIMIT 1;"); err != nil {
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, "SELECT metadata_path FROM system.tables WHERE database == 'system' LIMIT 1;"); err != nil {
 		return "/var/lib/clickhouse", err
 	}
 	metadataPath := result[0].MetadataPath
 	dataPathArray := strings.Split(metadataPath, "/")
 	clickhouseData := path.Join(dataPathArray[:len(dataPathArray)-3]...)
-	return path.Join("/", clickhouseData), nil
+	return ch.hostPath(path.Join("/", clickhouseData))
+}
+
+// GetDisks - return disks from system.disks, path of 'default' disk is data_path,
+// only data_path is returned by versions without system.disks
+func (ch *ClickHouse) GetDisks() ([]Disk, error) {
+	dataPath, err := ch.GetDataPath()
+	if err != nil {
+		return nil, err
+	}
+	var disks []Disk
+	if err := ch.conn.SelectContext(ch.queryContext(), &disks, "SELECT name, path FROM `system`.`disks`"); err != nil {
+		log.Printf("can't get disks, only '%s' is used: %v", dataPath, err)
+		return []Disk{{Name: DefaultDisk, Path: dataPath}}, nil
+	}
+	for i := range disks {
+		// data_path from config may differ from path seen by ClickHouse, e.g. when ClickHouse runs in container
+		if disks[i].Name == DefaultDisk {
+			disks[i].Path = dataPath
+			continue
+		}
+		if disks[i].Path, err = ch.hostPath(disks[i].Path); err != nil {
+			return nil, err
+		}
+	}
+	return disks, nil
 }
 
 // Close - closing connection to ClickHouse
@@ -120,19 +238,239 @@ func (ch *ClickHouse) Close() error {
 // GetTables - return slice of all tables suitable for backup
 func (ch *ClickHouse) GetTables() ([]Table, error) {
 	tables := make([]Table, 0)
-	if err := ch.conn.Select(&tables, "SELECT database, name FROM system.tables WHERE is_temporary = 0 AND engine LIKE '%MergeTree';"); err != nil {
+	if err := ch.conn.SelectContext(ch.queryContext(), &tables, "SELECT database, name, engine FROM system.tables WHERE is_temporary = 0 AND engine LIKE '%MergeTree';"); err != nil {
 		return nil, err
 	}
 	for i, t := range tables {
-		for _, filter := range ch.Config.SkipTables {
-			if matched, _ := filepath.Match(filter, fmt.Sprintf("%s.%s", t.Database, t.Name)); matched {
-				t.Skip = true
-				tables[i] = t
-				break
+		tables[i].Skip = isSkippedTable(ch.Config, t.Database, t.Name)
+	}
+	return tables, nil
+}
+
+// isSkippedTable - table is matched by clickhouse.skip_tables and isn't system log table selected by clickhouse.include_system_logs
+func isSkippedTable(config *ClickHouseConfig, database, table string) bool {
+	if database == "system" {
+		for _, filter := range config.IncludeSystemLogs {
+			if matched, _ := filepath.Match(filter, table); matched {
+				return false
 			}
 		}
 	}
-	return tables, nil
+	for _, filter := range config.SkipTables {
+		if matched, _ := filepath.Match(filter, fmt.Sprintf("%s.%s", database, table)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSystemLogs - split comma separated patterns of system log tables like 'query_log,metric_log' or '*_log'
+func parseSystemLogs(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "system."); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, validateSystemLogs(patterns)
+}
+
+// validateSystemLogs - patterns may select only tables of system database with '_log' suffix like query_log
+func validateSystemLogs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("can't parse system log table pattern '%s': %v", pattern, err)
+		}
+		if !strings.HasSuffix(pattern, "_log") || strings.Contains(pattern, ".") {
+			return fmt.Errorf("'%s' isn't system log table, names of tables of system database with '_log' suffix like 'query_log' are expected", pattern)
+		}
+	}
+	return nil
+}
+
+// GetTablesSize - set size, count of rows and partitions and time of last modification of active parts of tables
+func (ch *ClickHouse) GetTablesSize(tables []Table) error {
+	var rows []struct {
+		Database     string    `db:"database"`
+		Table        string    `db:"table"`
+		Bytes        uint64    `db:"bytes"`
+		Rows         uint64    `db:"rows"`
+		Partitions   uint64    `db:"partitions"`
+		LastModified time.Time `db:"last_modified"`
+	}
+	q := "SELECT database, table, sum(bytes_on_disk) AS bytes, sum(rows) AS rows, uniqExact(partition) AS partitions, max(modification_time) AS last_modified FROM `system`.`parts` WHERE active GROUP BY database, table"
+	if err := ch.conn.SelectContext(ch.queryContext(), &rows, q); err != nil {
+		return fmt.Errorf("can't get size of tables: %v", err)
+	}
+	index := map[string]int{}
+	for i, t := range tables {
+		index[t.Database+"."+t.Name] = i
+	}
+	for _, row := range rows {
+		if i, ok := index[row.Database+"."+row.Table]; ok {
+			tables[i].TotalBytes = row.Bytes
+			tables[i].TotalRows = row.Rows
+			tables[i].Partitions = row.Partitions
+			tables[i].LastModified = row.LastModified.Format(APITimeFormat)
+		}
+	}
+	return nil
+}
+
+// GetBufferTables - return Buffer tables which flush data to one of tables
+func (ch *ClickHouse) GetBufferTables(tables []Table) ([]Table, error) {
+	return ch.getTablesWritingTo(tables, "Buffer", getBufferDestination)
+}
+
+// GetDistributedTables - return Distributed tables which send data to one of tables
+func (ch *ClickHouse) GetDistributedTables(tables []Table) ([]Table, error) {
+	return ch.getTablesWritingTo(tables, "Distributed", getDistributedDestination)
+}
+
+// getTablesWritingTo - return tables with engine which write data to one of tables, destination is parsed from engine_full
+func (ch *ClickHouse) getTablesWritingTo(tables []Table, engine string, destination func(string) (string, string, bool)) ([]Table, error) {
+	var rows []struct {
+		Database   string `db:"database"`
+		Name       string `db:"name"`
+		EngineFull string `db:"engine_full"`
+	}
+	q := fmt.Sprintf("SELECT database, name, engine_full FROM `system`.`tables` WHERE engine = '%s'", engine)
+	if err := ch.conn.SelectContext(ch.queryContext(), &rows, q); err != nil {
+		return nil, fmt.Errorf("can't get %s tables: %v", engine, err)
+	}
+	destinations := map[string]bool{}
+	for _, t := range tables {
+		destinations[t.Database+"."+t.Name] = true
+	}
+	result := []Table{}
+	for _, row := range rows {
+		database, table, ok := destination(row.EngineFull)
+		if !ok {
+			continue
+		}
+		if database == "" {
+			database = row.Database
+		}
+		if destinations[database+"."+table] {
+			result = append(result, Table{Database: row.Database, Name: row.Name})
+		}
+	}
+	return result, nil
+}
+
+// FlushBufferTable - write data from memory of Buffer table to its destination table
+func (ch *ClickHouse) FlushBufferTable(table Table) error {
+	log.Printf("Flush '%s.%s'", table.Database, table.Name)
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("OPTIMIZE TABLE `%s`.`%s`", table.Database, table.Name)); err != nil {
+		return fmt.Errorf("can't flush '%s.%s': %v", table.Database, table.Name, err)
+	}
+	return nil
+}
+
+// FlushDistributedTable - send data queued by Distributed table to shards
+func (ch *ClickHouse) FlushDistributedTable(table Table) error {
+	log.Printf("Flush '%s.%s'", table.Database, table.Name)
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("SYSTEM FLUSH DISTRIBUTED `%s`.`%s`", table.Database, table.Name)); err != nil {
+		return fmt.Errorf("can't flush '%s.%s': %v", table.Database, table.Name, err)
+	}
+	return nil
+}
+
+// StopMerges - stop background merges of table until StartMerges
+func (ch *ClickHouse) StopMerges(table Table) error {
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("SYSTEM STOP MERGES `%s`.`%s`", table.Database, table.Name)); err != nil {
+		return fmt.Errorf("can't stop merges of '%s.%s': %v", table.Database, table.Name, err)
+	}
+	return nil
+}
+
+// StartMerges - start background merges of table stopped by StopMerges
+func (ch *ClickHouse) StartMerges(table Table) error {
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("SYSTEM START MERGES `%s`.`%s`", table.Database, table.Name)); err != nil {
+		return fmt.Errorf("can't start merges of '%s.%s': %v", table.Database, table.Name, err)
+	}
+	return nil
+}
+
+// ShowCreateTable - canonical CREATE query of table with settings, codecs and comments as they are applied by ClickHouse
+func (ch *ClickHouse) ShowCreateTable(database, table string) (string, error) {
+	var result []string
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", database, table)); err != nil {
+		return "", fmt.Errorf("can't show create table '%s.%s': %v", database, table, err)
+	}
+	if len(result) == 0 {
+		return "", fmt.Errorf("can't show create table '%s.%s': empty result", database, table)
+	}
+	return result[0], nil
+}
+
+// HasTTL - table or some of its columns has TTL, expired rows of attached parts are removed by TTL merges
+func (ch *ClickHouse) HasTTL(database, table string) (bool, error) {
+	var result []string
+	q := fmt.Sprintf("SELECT create_table_query FROM system.tables WHERE database = '%s' AND name = '%s'", escapeString(database), escapeString(table))
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, q); err != nil {
+		return false, fmt.Errorf("can't get schema of '%s.%s': %v", database, table, err)
+	}
+	return len(result) > 0 && ttlRE.MatchString(result[0]), nil
+}
+
+// StopTTLMerges - stop merges of table which remove expired rows, they are started by SYSTEM START TTL MERGES or by restart of ClickHouse
+func (ch *ClickHouse) StopTTLMerges(database, table string) error {
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("SYSTEM STOP TTL MERGES `%s`.`%s`", database, table)); err != nil {
+		return fmt.Errorf("can't stop TTL merges of '%s.%s': %v", database, table, err)
+	}
+	return nil
+}
+
+// GetTableSize - return size in bytes of all active parts of table
+func (ch *ClickHouse) GetTableSize(table Table) (int64, error) {
+	var result []uint64
+	q := fmt.Sprintf("SELECT sum(bytes_on_disk) FROM `system`.`parts` WHERE active AND database='%s' AND table='%s'", table.Database, table.Name)
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, q); err != nil {
+		return 0, fmt.Errorf("can't get size of '%s.%s': %v", table.Database, table.Name, err)
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return int64(result[0]), nil
+}
+
+// CheckTable - run CHECK TABLE, return false when data of table is corrupted
+func (ch *ClickHouse) CheckTable(table Table) (bool, error) {
+	var result []uint8
+	q := fmt.Sprintf("CHECK TABLE `%s`.`%s`", table.Database, table.Name)
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, q); err != nil {
+		return false, fmt.Errorf("can't check '%s.%s': %v", table.Database, table.Name, err)
+	}
+	for _, r := range result {
+		if r == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GetTableParts - return count of rows and paths of active parts of table
+func (ch *ClickHouse) GetTableParts(database, table string) (uint64, []string, error) {
+	var parts []struct {
+		Path string `db:"path"`
+		Rows uint64 `db:"rows"`
+	}
+	q := fmt.Sprintf("SELECT path, rows FROM `system`.`parts` WHERE active AND database='%s' AND table='%s'", escapeString(database), escapeString(table))
+	if err := ch.conn.SelectContext(ch.queryContext(), &parts, q); err != nil {
+		return 0, nil, fmt.Errorf("can't get parts of '%s.%s': %v", database, table, err)
+	}
+	var rows uint64
+	paths := make([]string, 0, len(parts))
+	for _, p := range parts {
+		rows += p.Rows
+		partPath, err := ch.hostPath(p.Path)
+		if err != nil {
+			return 0, nil, err
+		}
+		paths = append(paths, partPath)
+	}
+	return rows, paths, nil
 }
 
 // GetVersion - returned ClickHouse version in number format
@@ -140,7 +478,7 @@ func (ch *ClickHouse) GetTables() ([]Table, error) {
 func (ch *ClickHouse) GetVersion() (int, error) {
 	var result []string
 	q := "SELECT value FROM `system`.`build_options` where name='VERSION_INTEGER'"
-	if err := ch.conn.Select(&result, q); err != nil {
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, q); err != nil {
 		return 0, fmt.Errorf("can't get сlickHouse version: %v", err)
 	}
 	if len(result) == 0 {
@@ -149,14 +487,42 @@ func (ch *ClickHouse) GetVersion() (int, error) {
 	return strconv.Atoi(result[0])
 }
 
+// GetHostname - return hostName() of ClickHouse server
+func (ch *ClickHouse) GetHostname() (string, error) {
+	var result []string
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, "SELECT hostName()"); err != nil {
+		return "", fmt.Errorf("can't get hostname: %v", err)
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	return result[0], nil
+}
+
+// GetMacros - return macros of ClickHouse server from system.macros
+func (ch *ClickHouse) GetMacros() (map[string]string, error) {
+	var rows []struct {
+		Macro        string `db:"macro"`
+		Substitution string `db:"substitution"`
+	}
+	if err := ch.conn.SelectContext(ch.queryContext(), &rows, "SELECT macro, substitution FROM `system`.`macros`"); err != nil {
+		return nil, fmt.Errorf("can't get macros: %v", err)
+	}
+	macros := make(map[string]string, len(rows))
+	for _, row := range rows {
+		macros[row.Macro] = row.Substitution
+	}
+	return macros, nil
+}
+
 // FreezeTableOldWay - freeze all partitions in table one by one
 // This way using for ClickHouse below v19.1
-func (ch *ClickHouse) FreezeTableOldWay(table Table) error {
+func (ch *ClickHouse) FreezeTableOldWay(table Table, name string) error {
 	var partitions []struct {
 		PartitionID string `db:"partition_id"`
 	}
 	q := fmt.Sprintf("SELECT DISTINCT partition_id FROM `system`.`parts` WHERE database='%s' AND table='%s'", table.Database, table.Name)
-	if err := ch.conn.Select(&partitions, q); err != nil {
+	if err := ch.conn.SelectContext(ch.queryContext(), &partitions, q); err != nil {
 		return fmt.Errorf("can't get partitions for '%s.%s': %v", table.Database, table.Name, err)
 	}
 	log.Printf("Freeze '%v.%v'", table.Database, table.Name)
@@ -173,7 +539,10 @@ func (ch *ClickHouse) FreezeTableOldWay(table Table) error {
 				table.Database,
 				table.Name)
 		}
-		if _, err := ch.conn.Exec(query); err != nil {
+		if name != "" {
+			query = fmt.Sprintf("%s WITH NAME '%s';", strings.TrimSuffix(query, ";"), name)
+		}
+		if _, err := ch.conn.ExecContext(ch.queryContext(), query); err != nil {
 			return fmt.Errorf("can't freeze partition '%s' on '%s.%s': %v", item.PartitionID, table.Database, table.Name, err)
 		}
 	}
@@ -182,17 +551,21 @@ func (ch *ClickHouse) FreezeTableOldWay(table Table) error {
 
 // FreezeTable - freeze all partitions for table
 // This way available for ClickHouse sience v19.1
-func (ch *ClickHouse) FreezeTable(table Table) error {
+// Data is frozen to shadow/<name> instead of shadow/<increment> when name is set, it requires FreezeWithNameVersion
+func (ch *ClickHouse) FreezeTable(table Table, name string) error {
 	version, err := ch.GetVersion()
 	if err != nil {
 		return err
 	}
 	if version < 19001005 || ch.Config.FreezeByPart {
-		return ch.FreezeTableOldWay(table)
+		return ch.FreezeTableOldWay(table, name)
 	}
 	log.Printf("Freeze '%s.%s'", table.Database, table.Name)
 	query := fmt.Sprintf("ALTER TABLE `%s`.`%s` FREEZE;", table.Database, table.Name)
-	if _, err := ch.conn.Exec(query); err != nil {
+	if name != "" {
+		query = fmt.Sprintf("ALTER TABLE `%s`.`%s` FREEZE WITH NAME '%s';", table.Database, table.Name, name)
+	}
+	if _, err := ch.conn.ExecContext(ch.queryContext(), query); err != nil {
 		return fmt.Errorf("can't freeze '%s.%s': %v", table.Database, table.Name, err)
 	}
 	return nil
@@ -205,6 +578,14 @@ func (ch *ClickHouse) GetBackupTables(backupName string) (map[string]BackupTable
 		return nil, err
 	}
 	backupShadowPath := filepath.Join(dataPath, "backup", backupName, "shadow")
+	partDisks := map[string]string{}
+	if metadata, err := readBackupMetadata(filepath.Join(dataPath, "backup", backupName)); err == nil {
+		for _, t := range metadata.Tables {
+			for _, p := range t.Parts {
+				partDisks[t.Database+"."+t.Table+"/"+p.Name] = p.Disk
+			}
+		}
+	}
 	dbNum := 0
 	tableNum := 1
 	partNum := 2
@@ -235,13 +616,14 @@ func (ch *ClickHouse) GetBackupTables(backupName string) (map[string]BackupTable
 			if len(parts) != totalNum {
 				return nil
 			}
+			tDB, _ := url.PathUnescape(parts[dbNum])
+			tName, _ := url.PathUnescape(parts[tableNum])
+			fullTableName := fmt.Sprintf("%s.%s", tDB, tName)
 			partition := BackupPartition{
 				Name: parts[partNum],
 				Path: filePath,
+				Disk: partDisks[fullTableName+"/"+parts[partNum]],
 			}
-			tDB, _ := url.PathUnescape(parts[dbNum])
-			tName, _ := url.PathUnescape(parts[tableNum])
-			fullTableName := fmt.Sprintf("%s.%s", tDB, tName)
 			if t, ok := result[fullTableName]; ok {
 				t.Partitions = append(t.Partitions, partition)
 				result[fullTableName] = t
@@ -274,27 +656,37 @@ func (ch *ClickHouse) Chown(filename string) error {
 		if err != nil {
 			return err
 		}
-		stat := info.Sys().(*syscall.Stat_t)
-		uid := int(stat.Uid)
-		gid := int(stat.Gid)
+		uid, gid, ok := fileOwner(info)
+		if !ok {
+			// owner of files isn't changed on Windows
+			return nil
+		}
 		ch.uid = &uid
 		ch.gid = &gid
 	}
 	return os.Chown(filename, *ch.uid, *ch.gid)
 }
 
-// CopyData - copy partitions for specific table to detached folder
-func (ch *ClickHouse) CopyData(table BackupTable) error {
+// CopyData - copy partitions for specific table to detached folder on disk where part was stored when backup was created,
+// detached folder in data_path is used when table has no data on this disk or disk doesn't exist
+func (ch *ClickHouse) CopyData(table BackupTable, disks []Disk) error {
 	log.Printf("Prepare data for restoring '%s.%s'", table.Database, table.Name)
 	dataPath, err := ch.GetDataPath()
 	if err != nil {
 		return err
 	}
-	detachedParentDir := filepath.Join(dataPath, "data", TablePathEncode(table.Database), TablePathEncode(table.Name), "detached")
-	os.MkdirAll(detachedParentDir, 0750)
-	ch.Chown(detachedParentDir)
-
+	tablePath := filepath.Join("data", TablePathEncode(table.Database), TablePathEncode(table.Name))
 	for _, partition := range table.Partitions {
+		diskPath := dataPath
+		for _, disk := range disks {
+			if disk.Name == partition.Disk && isDir(filepath.Join(disk.Path, tablePath)) {
+				diskPath = disk.Path
+				break
+			}
+		}
+		detachedParentDir := filepath.Join(diskPath, tablePath, "detached")
+		os.MkdirAll(detachedParentDir, 0750)
+		ch.Chown(detachedParentDir)
 		detachedPath := filepath.Join(detachedParentDir, partition.Name)
 		info, err := os.Stat(detachedPath)
 		if err != nil {
@@ -318,13 +710,16 @@ func (ch *ClickHouse) CopyData(table BackupTable) error {
 			dstFilePath := filepath.Join(detachedPath, filename)
 			if info.IsDir() {
 				os.MkdirAll(dstFilePath, 0750)
+				if err := preserveXattrs(filePath, dstFilePath); err != nil {
+					return err
+				}
 				return ch.Chown(dstFilePath)
 			}
 			if !info.Mode().IsRegular() {
 				log.Printf("'%s' is not a regular file, skipping.", filePath)
 				return nil
 			}
-			if err := os.Link(filePath, dstFilePath); err != nil {
+			if err := linkFile(filePath, dstFilePath); err != nil {
 				return fmt.Errorf("failed to crete hard link '%s' -> '%s': %v", filePath, dstFilePath, err)
 			}
 			return ch.Chown(dstFilePath)
@@ -335,14 +730,16 @@ func (ch *ClickHouse) CopyData(table BackupTable) error {
 	return nil
 }
 
-// AttachPatritions - execute ATTACH command for specific table
-func (ch *ClickHouse) AttachPatritions(table BackupTable) error {
+// AttachPatritions - execute ATTACH command for specific table, parts are attached with pace of throttle
+func (ch *ClickHouse) AttachPatritions(table BackupTable, throttle *attachThrottle) error {
 	for _, partition := range table.Partitions {
+		throttle.wait()
 		query := fmt.Sprintf("ALTER TABLE `%s`.`%s` ATTACH PART '%s'", table.Database, table.Name, partition.Name)
 		log.Println(query)
-		if _, err := ch.conn.Exec(query); err != nil {
+		if _, err := ch.conn.ExecContext(ch.queryContext(), query); err != nil {
 			return err
 		}
+		throttle.done()
 	}
 	return nil
 }
@@ -350,28 +747,88 @@ func (ch *ClickHouse) AttachPatritions(table BackupTable) error {
 // CreateDatabase - create ClickHouse database
 func (ch *ClickHouse) CreateDatabase(database string) error {
 	createQuery := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", database)
-	_, err := ch.conn.Exec(createQuery)
+	_, err := ch.conn.ExecContext(ch.queryContext(), createQuery)
 	return err
 }
 
 // CreateTable - create ClickHouse table
 func (ch *ClickHouse) CreateTable(table RestoreTable, dropTable bool) error {
-	if _, err := ch.conn.Exec(fmt.Sprintf("USE `%s`", table.Database)); err != nil {
+	if _, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("USE `%s`", table.Database)); err != nil {
 		return err
 	}
 	log.Printf("Create table '%s.%s'", table.Database, table.Table)
 	if dropTable {
-		dropQuery := fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", table.Database, table.Table)
-		if _, err := ch.conn.Exec(dropQuery); err != nil {
+		if err := ch.DropTable(table.Database, table.Table); err != nil {
 			return err
 		}
 	}
-	if _, err := ch.conn.Exec(table.Query); err != nil {
+	if _, err := ch.conn.ExecContext(ch.queryContext(), table.Query); err != nil {
 		return err
 	}
 	return nil
 }
 
+// DropTable - drop ClickHouse table if exists
+func (ch *ClickHouse) DropTable(database, table string) error {
+	_, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", database, table))
+	return err
+}
+
+// DropDatabase - drop ClickHouse database with all its tables if exists
+func (ch *ClickHouse) DropDatabase(database string) error {
+	_, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", database))
+	return err
+}
+
+// DropReplica - remove metadata of replica from ZooKeeper if it exists, used when replica is left after table was lost.
+// Requires ClickHouse 20.6+
+func (ch *ClickHouse) DropReplica(zkPath, replicaName string) error {
+	var count []uint64
+	q := fmt.Sprintf("SELECT count() FROM `system`.`zookeeper` WHERE path='%s/replicas' AND name='%s'", escapeString(zkPath), escapeString(replicaName))
+	if err := ch.conn.SelectContext(ch.queryContext(), &count, q); err != nil {
+		return fmt.Errorf("can't check replica in zookeeper: %v", err)
+	}
+	if len(count) == 0 || count[0] == 0 {
+		return nil
+	}
+	log.Printf("Drop replica '%s' from '%s'", replicaName, zkPath)
+	_, err := ch.conn.ExecContext(ch.queryContext(), fmt.Sprintf("SYSTEM DROP REPLICA '%s' FROM ZKPATH '%s'", escapeString(replicaName), escapeString(zkPath)))
+	return err
+}
+
+// EnterBarrier - create node of shard in barrier path of ZooKeeper, parent nodes are created by ClickHouse.
+// Requires ClickHouse which supports INSERT into system.zookeeper, INSERT SELECT is used because native driver
+// executes INSERT VALUES only in batches. Existing node of shard is kept, e.g. when INSERT is retried after lost connection
+func (ch *ClickHouse) EnterBarrier(barrierPath, shard string) error {
+	q := fmt.Sprintf("INSERT INTO `system`.`zookeeper` (name, path, value) SELECT '%s', '%s', '%s'", escapeString(shard), escapeString(barrierPath), escapeString(shard))
+	if _, err := ch.conn.ExecContext(ch.queryContext(), q); err != nil {
+		if shards, getErr := ch.GetBarrierShards(barrierPath); getErr == nil {
+			if _, ok := shards[shard]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("can't enter barrier '%s': %v", barrierPath, err)
+	}
+	return nil
+}
+
+// GetBarrierShards - return shards registered in barrier path of ZooKeeper with creation time of their nodes
+func (ch *ClickHouse) GetBarrierShards(barrierPath string) (map[string]time.Time, error) {
+	var rows []struct {
+		Name  string    `db:"name"`
+		CTime time.Time `db:"ctime"`
+	}
+	q := fmt.Sprintf("SELECT name, ctime FROM `system`.`zookeeper` WHERE path='%s'", escapeString(barrierPath))
+	if err := ch.conn.SelectContext(ch.queryContext(), &rows, q); err != nil {
+		return nil, fmt.Errorf("can't get shards of barrier '%s': %v", barrierPath, err)
+	}
+	shards := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		shards[row.Name] = row.CTime
+	}
+	return shards, nil
+}
+
 // GetConn - return current connection
 func (ch *ClickHouse) GetConn() *sqlx.DB {
 	return ch.conn
@@ -0,0 +1,304 @@
+package chbackup
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// ProtocolNative, ProtocolHTTP - values of clickhouse.protocol, HTTP is used when ClickHouse exposes only HTTP(S) interface
+	ProtocolNative = "native"
+	ProtocolHTTP   = "http"
+	// httpDriverName - name of database/sql driver for HTTP interface of ClickHouse
+	httpDriverName = "clickhouse-http"
+	// httpFormat - format of results of queries, types of columns are used to return DateTime as time.Time
+	httpFormat = "TabSeparatedWithNamesAndTypes"
+)
+
+func init() {
+	sql.Register(httpDriverName, httpDriver{})
+}
+
+// httpDriver - database/sql driver which runs queries by HTTP interface of ClickHouse. DSN is URL of HTTP interface
+// with user and password, query arguments are sent as settings with every query except 'timeout' of requests and 'skip_verify'
+type httpDriver struct{}
+
+func (httpDriver) Open(dsn string) (driver.Conn, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse dsn: %v", err)
+	}
+	params := u.Query()
+	c := &httpConn{
+		client:   &http.Client{},
+		params:   url.Values{},
+		database: params.Get("database"),
+	}
+	if timeout := params.Get("timeout"); timeout != "" {
+		if c.client.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("can't parse timeout: %v", err)
+		}
+	}
+	if params.Get("skip_verify") == "true" {
+		c.client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	for name, values := range params {
+		switch name {
+		case "timeout", "skip_verify", "database":
+		default:
+			c.params[name] = values
+		}
+	}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	u.User, u.RawQuery = nil, ""
+	c.url = u.String()
+	return c, nil
+}
+
+// useRE - USE statement changes database of connection, it's sent as 'database' argument of next requests
+var useRE = regexp.MustCompile("(?i)^\\s*USE\\s+`?([^`;\\s]+)`?\\s*;?\\s*$")
+
+// httpConn - connection of httpDriver, every query is a request, database set by USE is kept in connection
+type httpConn struct {
+	client   *http.Client
+	url      string
+	user     string
+	password string
+	params   url.Values
+	database string
+}
+
+// do - send query and return response of ClickHouse, results are sent in httpFormat when format is set
+func (c *httpConn) do(ctx context.Context, query string, format bool) (*http.Response, error) {
+	params := url.Values{}
+	for name, values := range c.params {
+		params[name] = values
+	}
+	if c.database != "" {
+		params.Set("database", c.database)
+	}
+	if format {
+		params.Set("default_format", httpFormat)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url+"?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("clickhouse returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *httpConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.exec(context.Background(), query, len(args))
+}
+
+// ExecContext - run statement, request is canceled when ctx is done
+func (c *httpConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(ctx, query, len(args))
+}
+
+func (c *httpConn) exec(ctx context.Context, query string, args int) (driver.Result, error) {
+	if args > 0 {
+		return nil, fmt.Errorf("arguments of queries are not supported by %s protocol", ProtocolHTTP)
+	}
+	if m := useRE.FindStringSubmatch(query); m != nil {
+		c.database = m[1]
+		return driver.RowsAffected(0), nil
+	}
+	resp, err := c.do(ctx, query, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *httpConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.query(context.Background(), query, len(args))
+}
+
+// QueryContext - run query, request and reading of rows are canceled when ctx is done
+func (c *httpConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(ctx, query, len(args))
+}
+
+func (c *httpConn) query(ctx context.Context, query string, args int) (driver.Rows, error) {
+	if args > 0 {
+		return nil, fmt.Errorf("arguments of queries are not supported by %s protocol", ProtocolHTTP)
+	}
+	resp, err := c.do(ctx, query, true)
+	if err != nil {
+		return nil, err
+	}
+	rows := &httpRows{body: resp.Body, r: bufio.NewReader(resp.Body), location: time.Local}
+	if tz := resp.Header.Get("X-ClickHouse-Timezone"); tz != "" {
+		if location, err := time.LoadLocation(tz); err == nil {
+			rows.location = location
+		}
+	}
+	// names and types of columns are sent before rows, they are absent in response of statement without result
+	for _, header := range []*[]string{&rows.columns, &rows.types} {
+		values, err := rows.readRow()
+		if err != nil && err != io.EOF {
+			rows.Close()
+			return nil, err
+		}
+		for _, v := range values {
+			*header = append(*header, unescapeTSV(v))
+		}
+	}
+	return rows, nil
+}
+
+func (c *httpConn) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, "SELECT 1", true)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *httpConn) Prepare(query string) (driver.Stmt, error) {
+	return &httpStmt{conn: c, query: query}, nil
+}
+
+func (c *httpConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported by %s protocol", ProtocolHTTP)
+}
+
+func (c *httpConn) Close() error {
+	return nil
+}
+
+type httpStmt struct {
+	conn  *httpConn
+	query string
+}
+
+func (s *httpStmt) Close() error {
+	return nil
+}
+
+func (s *httpStmt) NumInput() int {
+	return -1
+}
+
+func (s *httpStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.Exec(s.query, args)
+}
+
+func (s *httpStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.Query(s.query, args)
+}
+
+// httpRows - rows of result in httpFormat, values are strings except NULL and Date and DateTime columns
+type httpRows struct {
+	body     io.Closer
+	r        *bufio.Reader
+	columns  []string
+	types    []string
+	location *time.Location
+}
+
+// readRow - read escaped values of one row
+func (rows *httpRows) readRow() ([]string, error) {
+	line, err := rows.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(line, "\n"), "\t"), nil
+}
+
+func (rows *httpRows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *httpRows) Close() error {
+	return rows.body.Close()
+}
+
+func (rows *httpRows) Next(dest []driver.Value) error {
+	values, err := rows.readRow()
+	if err != nil {
+		return err
+	}
+	if len(values) != len(dest) {
+		return fmt.Errorf("expected %d values in row, got %d", len(dest), len(values))
+	}
+	for i, v := range values {
+		if v == `\N` {
+			dest[i] = nil
+			continue
+		}
+		if dest[i], err = parseHTTPValue(rows.types[i], unescapeTSV(v), rows.location); err != nil {
+			return fmt.Errorf("can't parse '%s' of column '%s': %v", v, rows.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// tsvUnescaper - escape sequences of TabSeparated format, NULL is '\N'
+var tsvUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r", `\0`, "\x00", `\b`, "\b", `\f`, "\f", `\'`, "'")
+
+func unescapeTSV(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	return tsvUnescaper.Replace(v)
+}
+
+// parseHTTPValue - convert value of column of type to driver.Value, database/sql converts strings to numbers on scan
+func parseHTTPValue(columnType, v string, location *time.Location) (driver.Value, error) {
+	for _, wrapper := range []string{"Nullable(", "LowCardinality("} {
+		if strings.HasPrefix(columnType, wrapper) {
+			columnType = strings.TrimSuffix(strings.TrimPrefix(columnType, wrapper), ")")
+		}
+	}
+	switch {
+	case columnType == "Date":
+		return time.ParseInLocation("2006-01-02", v, location)
+	case strings.HasPrefix(columnType, "DateTime"):
+		// timezone of column like DateTime('UTC') or DateTime64(3, 'UTC') overrides timezone of server
+		if m := timezoneRE.FindStringSubmatch(columnType); m != nil {
+			if columnLocation, err := time.LoadLocation(m[1]); err == nil {
+				location = columnLocation
+			}
+		}
+		return time.ParseInLocation("2006-01-02 15:04:05.999999999", v, location)
+	}
+	return v, nil
+}
+
+var timezoneRE = regexp.MustCompile(`'([^']+)'`)
@@ -0,0 +1,142 @@
+package chbackup
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPDriver(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		body, _ := ioutil.ReadAll(r.Body)
+		query := string(body)
+		queries = append(queries, r.URL.Query().Get("database")+": "+query)
+		switch {
+		case user != "default" || password != "p@ss":
+			http.Error(w, "Code: 516. Authentication failed", http.StatusUnauthorized)
+		case query == "SELECT 1":
+			w.Write([]byte("1\nUInt8\n1\n"))
+		case strings.HasPrefix(query, "SELECT database"):
+			assert.Equal(t, httpFormat, r.URL.Query().Get("default_format"))
+			assert.Equal(t, "300", r.URL.Query().Get("receive_timeout"))
+			w.Header().Set("X-ClickHouse-Timezone", "UTC")
+			w.Write([]byte("database\tname\tbytes\tmodified\tcomment\nString\tString\tUInt64\tDateTime\tNullable(String)\n" +
+				"db\tevents\\tlog\t1024\t2020-01-02 03:04:05\t\\N\n"))
+		case strings.HasPrefix(query, "CREATE"):
+		case strings.HasPrefix(query, "SYSTEM"):
+			// long query, it's finished when request is canceled
+			<-r.Context().Done()
+		default:
+			http.Error(w, "Code: 62. Syntax error", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	dsn := strings.Replace(server.URL, "http://", "http://default:p%40ss@", 1) + "/?database=system&receive_timeout=300&timeout=1m"
+	db, err := sql.Open(httpDriverName, dsn)
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	assert.NoError(t, db.Ping())
+
+	var database, name string
+	var bytes uint64
+	var modified time.Time
+	var comment sql.NullString
+	assert.NoError(t, db.QueryRow("SELECT database, name, bytes, modified, comment FROM t").Scan(&database, &name, &bytes, &modified, &comment))
+	assert.Equal(t, "db", database)
+	assert.Equal(t, "events\tlog", name)
+	assert.Equal(t, uint64(1024), bytes)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), modified.UTC())
+	assert.False(t, comment.Valid)
+
+	_, err = db.Exec("USE `db`")
+	assert.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE events (id UInt64) ENGINE = MergeTree ORDER BY id")
+	assert.NoError(t, err)
+	assert.Equal(t, "db: CREATE TABLE events (id UInt64) ENGINE = MergeTree ORDER BY id", queries[len(queries)-1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = db.ExecContext(ctx, "SYSTEM SYNC REPLICA `db`.`events`")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+
+	_, err = db.Exec("DROP")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Code: 62. Syntax error")
+}
+
+func TestConnectSettings(t *testing.T) {
+	var params []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = append(params, r.URL.Query())
+		w.Write([]byte("1\nUInt8\n1\n"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	ch := &ClickHouse{
+		Config:   &ClickHouseConfig{Host: u.Hostname(), Port: uint(port), Timeout: "5m", Protocol: ProtocolHTTP},
+		settings: map[string]string{"max_execution_time": "3600", "receive_timeout": "3600"},
+	}
+	assert.NoError(t, ch.Connect())
+	defer ch.Close()
+	assert.Equal(t, "3600", params[0].Get("max_execution_time"))
+	assert.Equal(t, "3600", params[0].Get("receive_timeout"))
+	assert.Equal(t, "300", params[0].Get("send_timeout"))
+
+	assert.NoError(t, validateQuerySettings("restore_settings", map[string]string{"distributed_ddl_task_timeout": "3600"}))
+	assert.Error(t, validateQuerySettings("restore_settings", map[string]string{"password": "secret"}))
+	assert.Error(t, validateQuerySettings("freeze_settings", map[string]string{"max execution time": "1"}))
+}
+
+func TestBackupSchema(t *testing.T) {
+	statement := "CREATE TABLE db.events\\n(\\n    `id` UInt64 CODEC(Delta, ZSTD(1)) COMMENT 'id of event'\\n)\\n" +
+		"ENGINE = MergeTree\\nORDER BY id\\nSETTINGS index_granularity = 1024\\nCOMMENT 'events'"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch query := string(body); {
+		case query == "SHOW CREATE TABLE `db`.`events`":
+			w.Write([]byte("statement\nString\n" + statement + "\n"))
+		case query == "SHOW CREATE TABLE `db`.`mysql`":
+			w.Write([]byte("statement\nString\nCREATE TABLE db.mysql (`id` UInt64) ENGINE = MySQL('host:3306', 'db', 'mysql', 'user', '[HIDDEN]')\n"))
+		default:
+			w.Write([]byte("1\nUInt8\n1\n"))
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	ch := &ClickHouse{Config: &ClickHouseConfig{Host: u.Hostname(), Port: uint(port), Timeout: "5m", Protocol: ProtocolHTTP}}
+	assert.NoError(t, ch.Connect())
+	defer ch.Close()
+
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, backupSchema(ch, RestoreTable{Database: "db", Table: "events"}, path.Join(dir, "backup", "db", "events.sql")))
+	content, err := ioutil.ReadFile(path.Join(dir, "backup", "db", "events.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, unescapeTSV(statement)+"\n", string(content))
+
+	// metadata file is copied when password is hidden by SHOW CREATE TABLE
+	attach := "ATTACH TABLE _ UUID '00000000-0000-0000-0000-000000000001'\n(\n    `id` UInt64\n)\nENGINE = MySQL('host:3306', 'db', 'mysql', 'user', 'secret')\n"
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "mysql.sql"), []byte(attach), 0644))
+	assert.NoError(t, backupSchema(ch, RestoreTable{Database: "db", Table: "mysql", Path: path.Join(dir, "mysql.sql")}, path.Join(dir, "backup", "db", "mysql.sql")))
+	content, err = ioutil.ReadFile(path.Join(dir, "backup", "db", "mysql.sql"))
+	assert.NoError(t, err)
+	assert.Equal(t, attach, string(content))
+}
@@ -0,0 +1,160 @@
+package chbackup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClusterBackup - backups with the same name uploaded by shards of cluster to '<path>/<shard>' of remote storage
+type ClusterBackup struct {
+	Name string
+	// Date - date of the newest backup of shards
+	Date time.Time
+	// Size - total size of backups of shards
+	Size int64
+	// Shards - shards which have complete backup
+	Shards []string
+	// Broken - shards whose backup is broken
+	Broken []string
+	// Missing - shards which don't have backup
+	Missing []string
+}
+
+// Complete - every shard of cluster has complete backup
+func (b ClusterBackup) Complete() bool {
+	return len(b.Broken) == 0 && len(b.Missing) == 0
+}
+
+// getShardPath - path of backups of shard in remote storage, path isn't changed when shard is empty
+func getShardPath(remotePath, shard string) string {
+	if shard == "" {
+		return remotePath
+	}
+	return path.Join(remotePath, shard)
+}
+
+// getClusterBackups - list backups of every shard found in remote path and group them by name, shards are directories
+// of remote path which don't look like backups. Shards are returned sorted
+func getClusterBackups(config Config) ([]ClusterBackup, []string, error) {
+	config.General.Shard = ""
+	bd, err := NewBackupDestination(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bd.Connect(); err != nil {
+		return nil, nil, err
+	}
+	defer bd.Close()
+	rootPath := bd.path
+	shardSet := map[string]bool{}
+	err = bd.Walk(rootPath, func(o RemoteFile) {
+		if !strings.HasPrefix(o.Name(), rootPath) {
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(o.Name(), rootPath), "/"), "/")
+		// archives are in root of path of shard, '<backup>/metadata/...' and '<backup>/shadow/...' are backups uploaded without shard
+		if len(parts) > 1 && parts[1] != "metadata" && parts[1] != "shadow" {
+			shardSet[parts[0]] = true
+		}
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't list shards: %v", err)
+	}
+	shards := make([]string, 0, len(shardSet))
+	for shard := range shardSet {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	backupsByShard := map[string][]Backup{}
+	for _, shard := range shards {
+		bd.path = getShardPath(rootPath, shard)
+		if backupsByShard[shard], err = bd.BackupList(); err != nil {
+			return nil, nil, fmt.Errorf("can't list backups of shard '%s': %v", shard, err)
+		}
+	}
+	return groupClusterBackups(shards, backupsByShard), shards, nil
+}
+
+// groupClusterBackups - group backups of shards by name, result is sorted by date
+func groupClusterBackups(shards []string, backupsByShard map[string][]Backup) []ClusterBackup {
+	byName := map[string]*ClusterBackup{}
+	found := map[string]map[string]bool{}
+	for _, shard := range shards {
+		for _, backup := range backupsByShard[shard] {
+			b, ok := byName[backup.Name]
+			if !ok {
+				b = &ClusterBackup{Name: backup.Name}
+				byName[backup.Name] = b
+				found[backup.Name] = map[string]bool{}
+			}
+			found[backup.Name][shard] = true
+			if backup.Date.After(b.Date) {
+				b.Date = backup.Date
+			}
+			b.Size += backup.Size
+			if backup.Broken != "" {
+				b.Broken = append(b.Broken, shard)
+			} else {
+				b.Shards = append(b.Shards, shard)
+			}
+		}
+	}
+	result := make([]ClusterBackup, 0, len(byName))
+	for _, b := range byName {
+		for _, shard := range shards {
+			if !found[b.Name][shard] {
+				b.Missing = append(b.Missing, shard)
+			}
+		}
+		result = append(result, *b)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Date.Equal(result[j].Date) {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Date.Before(result[j].Date)
+	})
+	return result
+}
+
+// PrintClusterBackups - print backups of all shards stored on remote storages selected by target
+func PrintClusterBackups(config Config, target string) error {
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if len(targets) > 1 {
+			fmt.Printf("Target '%s':\n", t.Name)
+		}
+		if t.Config.General.RemoteStorage == "none" {
+			return fmt.Errorf("remote_storage is 'none'")
+		}
+		backups, shards, err := getClusterBackups(t.Config)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Println("no backups found")
+			continue
+		}
+		fmt.Printf("Shards: %s\n", strings.Join(shards, ", "))
+		for _, backup := range backups {
+			status := "complete"
+			if !backup.Complete() {
+				status = "incomplete"
+				if len(backup.Missing) > 0 {
+					status += fmt.Sprintf(", missing on %s", strings.Join(backup.Missing, ", "))
+				}
+				if len(backup.Broken) > 0 {
+					status += fmt.Sprintf(", broken on %s", strings.Join(backup.Broken, ", "))
+				}
+			}
+			fmt.Printf("- '%s'\t%s\t(created at %s)\t%s\n", backup.Name, FormatBytes(backup.Size), backup.Date.Format("02-01-2006 15:04:05"), status)
+		}
+	}
+	return nil
+}
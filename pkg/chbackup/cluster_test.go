@@ -0,0 +1,31 @@
+package chbackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupClusterBackups(t *testing.T) {
+	day := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	backups := groupClusterBackups([]string{"shard1", "shard2", "shard3"}, map[string][]Backup{
+		"shard1": {{Name: "daily", Date: day, Size: 10}, {Name: "hourly", Date: day.Add(time.Hour), Size: 1}},
+		"shard2": {{Name: "daily", Date: day.Add(time.Minute), Size: 20}},
+		"shard3": {{Name: "daily", Date: day, Size: 30, Broken: "upload is not completed"}},
+	})
+	assert.Len(t, backups, 2)
+	assert.Equal(t, "daily", backups[0].Name)
+	assert.Equal(t, day.Add(time.Minute), backups[0].Date)
+	assert.Equal(t, int64(60), backups[0].Size)
+	assert.Equal(t, []string{"shard1", "shard2"}, backups[0].Shards)
+	assert.Equal(t, []string{"shard3"}, backups[0].Broken)
+	assert.Nil(t, backups[0].Missing)
+	assert.False(t, backups[0].Complete())
+	assert.Equal(t, []string{"shard2", "shard3"}, backups[1].Missing)
+
+	complete := groupClusterBackups([]string{"shard1"}, map[string][]Backup{"shard1": {{Name: "daily"}}})
+	assert.True(t, complete[0].Complete())
+	assert.Equal(t, "backup/shard1", getShardPath("backup", "shard1"))
+	assert.Equal(t, "backup", getShardPath("backup", ""))
+}
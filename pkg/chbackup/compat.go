@@ -0,0 +1,222 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// versionAllowDeprecatedMergeTreeSyntax - since this version old MergeTree syntax requires allow_deprecated_syntax_for_merge_tree setting
+const versionAllowDeprecatedMergeTreeSyntax = 22007000
+
+// schemaFeature - syntax of CREATE query which is supported since minVersion of ClickHouse,
+// data types are matched with types of columns only, other features with the rest of query
+type schemaFeature struct {
+	name       string
+	re         *regexp.Regexp
+	minVersion int
+	dataType   bool
+}
+
+var schemaFeatures = []schemaFeature{
+	{name: "LowCardinality data type", re: regexp.MustCompile(`\bLowCardinality\(`), minVersion: 19000000, dataType: true},
+	{name: "TTL expressions", re: regexp.MustCompile(`\bTTL\b`), minVersion: 19006000},
+	{name: "data skipping indices", re: regexp.MustCompile(`\bINDEX \S+ .+ TYPE \w+`), minVersion: 19006000},
+	{name: "column compression codecs", re: regexp.MustCompile(`\bCODEC\(`), minVersion: 19010000},
+	{name: "DateTime64 data type", re: regexp.MustCompile(`\bDateTime64\(`), minVersion: 20001000, dataType: true},
+	{name: "Map data type", re: regexp.MustCompile(`\bMap\(`), minVersion: 21001000, dataType: true},
+	{name: "projections", re: regexp.MustCompile(`\bPROJECTION \S+ \(`), minVersion: 21006000},
+	{name: "Date32 data type", re: regexp.MustCompile(`\bDate32\b`), minVersion: 21009000, dataType: true},
+	{name: "Bool data type", re: regexp.MustCompile(`\bBool\b`), minVersion: 21012000, dataType: true},
+	{name: "Object data type", re: regexp.MustCompile(`\bObject\(`), minVersion: 22003000, dataType: true},
+}
+
+var integerRE = regexp.MustCompile(`^\d+$`)
+
+var (
+	columnListPrefixRE = regexp.MustCompile(`\b(AS|ENGINE)\b`)
+	columnElementRE    = regexp.MustCompile(`^(INDEX|PROJECTION|CONSTRAINT)\b`)
+	columnClauseRE     = regexp.MustCompile(`\s(DEFAULT|MATERIALIZED|ALIAS|EPHEMERAL|COMMENT|CODEC|TTL)\b`)
+)
+
+// blankQuoted - remove content of string literals and quoted identifiers, so names and comments aren't matched as syntax
+func blankQuoted(query string) string {
+	result := make([]byte, 0, len(query))
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote == 0:
+			if c == '\'' || c == '"' || c == '`' {
+				quote = c
+			}
+			result = append(result, c)
+		case c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+			result = append(result, c)
+		}
+	}
+	return string(result)
+}
+
+// splitSchema - split CREATE query to types of columns and the rest of query without names of columns,
+// query without column list like CREATE VIEW is returned as is
+func splitSchema(query string) (types []string, clauses string) {
+	query = blankQuoted(query)
+	start := strings.Index(query, "(")
+	if start == -1 || columnListPrefixRE.MatchString(query[:start]) {
+		return nil, query
+	}
+	elements, n, err := splitEngineArgs(query[start:])
+	if err != nil {
+		return nil, query
+	}
+	parts := []string{query[:start]}
+	for _, element := range elements {
+		if columnElementRE.MatchString(element) {
+			parts = append(parts, element)
+			continue
+		}
+		// element is 'name [type] [DEFAULT expr] [COMMENT ...] [CODEC(...)] [TTL expr]'
+		i := strings.IndexAny(element, " \t\n")
+		if i == -1 {
+			continue
+		}
+		columnType := strings.TrimSpace(element[i:])
+		if loc := columnClauseRE.FindStringIndex(" " + columnType); loc != nil {
+			parts = append(parts, columnType[loc[0]:])
+			columnType = columnType[:loc[0]]
+		}
+		types = append(types, columnType)
+	}
+	return types, strings.Join(append(parts, query[start+n:]), " ")
+}
+
+// schemaIssue - problem with CREATE query found by checkSchemaCompatibility
+type schemaIssue struct {
+	Database string
+	Table    string
+	Message  string
+	// Fatal - query will fail on target server
+	Fatal bool
+}
+
+func (i schemaIssue) String() string {
+	return fmt.Sprintf("'%s.%s': %s", i.Database, i.Table, i.Message)
+}
+
+// formatVersion - convert VERSION_INTEGER like 19001005 to 19.1.5
+func formatVersion(version int) string {
+	if version == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d.%d", version/1000000, version/1000%1000, version%1000)
+}
+
+// checkSchemaCompatibility - find syntax in CREATE query which is not supported or deprecated on ClickHouse of targetVersion
+func checkSchemaCompatibility(table RestoreTable, targetVersion int) []schemaIssue {
+	issues := []schemaIssue{}
+	types, clauses := splitSchema(table.Query)
+	for _, feature := range schemaFeatures {
+		matched := feature.re.MatchString(clauses)
+		if feature.dataType {
+			matched = false
+			for _, columnType := range types {
+				matched = matched || feature.re.MatchString(columnType)
+			}
+		}
+		if targetVersion < feature.minVersion && matched {
+			issues = append(issues, schemaIssue{
+				Database: table.Database,
+				Table:    table.Table,
+				Message:  fmt.Sprintf("%s is supported since ClickHouse %s, but server version is %s", feature.name, formatVersion(feature.minVersion), formatVersion(targetVersion)),
+				Fatal:    true,
+			})
+		}
+	}
+	if e, err := parseMergeTreeEngine(table.Query); err == nil && e != nil && e.isOldSyntax() {
+		issue := schemaIssue{
+			Database: table.Database,
+			Table:    table.Table,
+			Message:  "deprecated MergeTree syntax, use --rewrite-ddl to convert it to PARTITION BY/ORDER BY syntax",
+		}
+		if targetVersion >= versionAllowDeprecatedMergeTreeSyntax {
+			issue.Message = "deprecated MergeTree syntax is not allowed since ClickHouse " + formatVersion(versionAllowDeprecatedMergeTreeSyntax) + ", use --rewrite-ddl to convert it"
+			issue.Fatal = true
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// isOldSyntax - check that engine is defined like MergeTree(date, (key), 8192) without ORDER BY clause
+func (e *mergeTreeEngine) isOldSyntax() bool {
+	return len(e.engineArgs()) >= 3 && !strings.Contains(e.suffix, "ORDER BY")
+}
+
+// engineArgs - arguments of engine except ZooKeeper path and replica name
+func (e *mergeTreeEngine) engineArgs() []string {
+	if e.replicated && len(e.args) >= 2 {
+		return e.args[2:]
+	}
+	return e.args
+}
+
+// rewriteOldMergeTreeSyntax - convert MergeTree(date[, sampling], key, granularity[, params]) engine
+// to MergeTree([params]) PARTITION BY toYYYYMM(date) ORDER BY key [SAMPLE BY sampling] SETTINGS index_granularity = granularity
+func rewriteOldMergeTreeSyntax(query string) (string, error) {
+	e, err := parseMergeTreeEngine(query)
+	if err != nil || e == nil || !e.isOldSyntax() {
+		return query, err
+	}
+	args := e.engineArgs()
+	var date, sampling, key, granularity string
+	var params []string
+	switch {
+	case integerRE.MatchString(args[2]):
+		date, key, granularity, params = args[0], args[1], args[2], args[3:]
+	case len(args) >= 4 && integerRE.MatchString(args[3]):
+		date, sampling, key, granularity, params = args[0], args[1], args[2], args[3], args[4:]
+	default:
+		return "", fmt.Errorf("can't parse deprecated MergeTree syntax: %s", strings.Join(args, ", "))
+	}
+	clauses := fmt.Sprintf(" PARTITION BY toYYYYMM(%s) ORDER BY %s", date, key)
+	if sampling != "" {
+		clauses += " SAMPLE BY " + sampling
+	}
+	clauses += " SETTINGS index_granularity = " + granularity
+	if e.replicated {
+		e.args = append(e.args[:2:2], params...)
+	} else {
+		e.args = params
+	}
+	e.suffix = clauses + e.suffix
+	return e.String(), nil
+}
+
+// checkRestoreCompatibility - log compatibility issues of schema and return error if restore will fail
+func checkRestoreCompatibility(tables RestoreTables, sourceVersion, targetVersion int) error {
+	if targetVersion == 0 {
+		return nil
+	}
+	if sourceVersion > targetVersion {
+		log.Printf("Warning: backup was created on ClickHouse %s, server version is %s", formatVersion(sourceVersion), formatVersion(targetVersion))
+	}
+	fatal := []string{}
+	for _, table := range tables {
+		for _, issue := range checkSchemaCompatibility(table, targetVersion) {
+			if issue.Fatal {
+				fatal = append(fatal, issue.String())
+				continue
+			}
+			log.Printf("Warning: %s", issue.String())
+		}
+	}
+	if len(fatal) > 0 {
+		return fmt.Errorf("schema of backup is not compatible with ClickHouse %s:\n  %s", formatVersion(targetVersion), strings.Join(fatal, "\n  "))
+	}
+	return nil
+}
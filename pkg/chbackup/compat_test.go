@@ -0,0 +1,65 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteOldMergeTreeSyntax(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree(d, (d, id), 8192)",
+			expected: "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree() PARTITION BY toYYYYMM(d) ORDER BY (d, id) SETTINGS index_granularity = 8192",
+		},
+		{
+			query:    "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree(d, intHash32(id), (d, intHash32(id)), 8192)",
+			expected: "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree() PARTITION BY toYYYYMM(d) ORDER BY (d, intHash32(id)) SAMPLE BY intHash32(id) SETTINGS index_granularity = 8192",
+		},
+		{
+			query:    "CREATE TABLE db.t (d Date, id UInt64, v UInt32) ENGINE = ReplicatedReplacingMergeTree('/zk/t', '{replica}', d, (d, id), 8192, v)",
+			expected: "CREATE TABLE db.t (d Date, id UInt64, v UInt32) ENGINE = ReplicatedReplacingMergeTree('/zk/t', '{replica}', v) PARTITION BY toYYYYMM(d) ORDER BY (d, id) SETTINGS index_granularity = 8192",
+		},
+		{
+			query:    "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree() PARTITION BY d ORDER BY id",
+			expected: "CREATE TABLE db.t (d Date, id UInt64) ENGINE = MergeTree() PARTITION BY d ORDER BY id",
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := rewriteOldMergeTreeSyntax(tc.query)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestCheckSchemaCompatibility(t *testing.T) {
+	table := RestoreTable{
+		Database: "db",
+		Table:    "t",
+		Query:    "CREATE TABLE db.t (id UInt64, m Map(String, UInt64)) ENGINE = MergeTree() ORDER BY id",
+	}
+	issues := checkSchemaCompatibility(table, 20008000)
+	assert.Len(t, issues, 1)
+	assert.True(t, issues[0].Fatal)
+	assert.Empty(t, checkSchemaCompatibility(table, 21008000))
+	assert.Equal(t, "19.1.5", formatVersion(19001005))
+
+	table.Query = "CREATE TABLE db.t (id UInt64, v UInt64, INDEX v_idx v TYPE minmax GRANULARITY 1, PROJECTION p (SELECT v, count() GROUP BY v)) ENGINE = MergeTree() ORDER BY id"
+	issues = checkSchemaCompatibility(table, 21003000)
+	assert.Len(t, issues, 1)
+	assert.Contains(t, issues[0].Message, "projections")
+	assert.Empty(t, checkSchemaCompatibility(table, 21008000))
+
+	table.Query = "CREATE TABLE db.t (id UInt64, `Bool` UInt8 COMMENT 'TTL of Date32 rows', Date32 Date) ENGINE = MergeTree() ORDER BY id"
+	assert.Empty(t, checkSchemaCompatibility(table, 19001000))
+	table.Query = "CREATE TABLE db.t (id UInt64, d Nullable(Date32), f Bool DEFAULT true, v UInt64 TTL toDate(d) + INTERVAL 1 DAY) ENGINE = MergeTree() ORDER BY id"
+	issues = checkSchemaCompatibility(table, 19001000)
+	assert.Len(t, issues, 3)
+	table.Query = "CREATE TABLE db.t (id UInt64, d Date) ENGINE = MergeTree() ORDER BY id TTL d + INTERVAL 1 MONTH"
+	issues = checkSchemaCompatibility(table, 19001000)
+	assert.Len(t, issues, 1)
+	assert.Contains(t, issues[0].Message, "TTL")
+}
@@ -0,0 +1,118 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// completionScripts - shell scripts which complete commands, flags and backup names by '--generate-bash-completion' of CLI
+var completionScripts = map[string]string{
+	"bash": `_clickhouse_backup_complete() {
+    local cur opts
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    if [[ "$cur" == "-"* ]]; then
+        opts=$("${COMP_WORDS[@]:0:$COMP_CWORD}" "$cur" --generate-bash-completion 2>/dev/null)
+    else
+        opts=$("${COMP_WORDS[@]:0:$COMP_CWORD}" --generate-bash-completion 2>/dev/null)
+    fi
+    COMPREPLY=($(compgen -W "$opts" -- "$cur"))
+    return 0
+}
+complete -o bashdefault -o default -F _clickhouse_backup_complete clickhouse-backup
+`,
+	"zsh": `#compdef clickhouse-backup
+_clickhouse_backup() {
+    local -a opts
+    local cur="${words[CURRENT]}"
+    if [[ "$cur" == -* ]]; then
+        opts=("${(@f)$(${words[@]:0:$((CURRENT-1))} "$cur" --generate-bash-completion 2>/dev/null)}")
+    else
+        opts=("${(@f)$(${words[@]:0:$((CURRENT-1))} --generate-bash-completion 2>/dev/null)}")
+    fi
+    compadd -a opts
+}
+compdef _clickhouse_backup clickhouse-backup
+`,
+	"fish": `function __clickhouse_backup_complete
+    set -l args (commandline -opc)
+    set -l cur (commandline -ct)
+    if string match -q -- '-*' $cur
+        $args $cur --generate-bash-completion 2>/dev/null
+    else
+        $args --generate-bash-completion 2>/dev/null
+    end
+end
+complete -c clickhouse-backup -f -a '(__clickhouse_backup_complete)'
+`,
+}
+
+// CompletionShells - shells supported by completion command
+func CompletionShells() []string {
+	shells := make([]string, 0, len(completionScripts))
+	for shell := range completionScripts {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+	return shells
+}
+
+// CompletionScript - completion script of shell which is loaded by 'source <(clickhouse-backup completion bash)'
+func CompletionScript(shell string) (string, error) {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unknown shell '%s', supported shells are %v", shell, CompletionShells())
+	}
+	return script, nil
+}
+
+// listBackups - complete local backups or remote backups of target sorted by date, names of remote backups don't have archive extension
+func listBackups(config Config, remote bool, target string) ([]Backup, error) {
+	if !remote {
+		backups, err := ListLocalBackups(config)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return getCompleteBackups(backups), nil
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return nil, err
+	}
+	result := []Backup{}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		backups, err := getRemoteBackups(t.Config)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range getCompleteBackups(backups) {
+			b.Name = trimArchiveExtension(b.Name)
+			result = append(result, b)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+	return result, nil
+}
+
+// BackupNames - names of local backups or remote backups of target for completion of backup name
+func BackupNames(config Config, remote bool, target string) ([]string, error) {
+	backups, err := listBackups(config, remote, target)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(backups))
+	seen := map[string]bool{}
+	for _, b := range backups {
+		if !seen[b.Name] {
+			seen[b.Name] = true
+			names = append(names, b.Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,43 @@
+package chbackup
+
+import (
+	"io"
+
+	"github.com/klauspost/pgzip"
+	"github.com/mholt/archiver"
+)
+
+// compressionBlockSize - size of block compressed by one gzip worker
+const compressionBlockSize = 1024 * 1024
+
+// tarGzWorkers - tar.gz writer which compresses not more than workers blocks at the same time,
+// archiver.TarGz compresses up to 16 blocks in parallel and uses all cores of most hosts
+type tarGzWorkers struct {
+	*archiver.Tar
+	level   int
+	workers int
+	gzw     *pgzip.Writer
+}
+
+func (t *tarGzWorkers) Create(out io.Writer) error {
+	gzw, err := pgzip.NewWriterLevel(out, t.level)
+	if err != nil {
+		return err
+	}
+	if err := gzw.SetConcurrency(compressionBlockSize, t.workers); err != nil {
+		return err
+	}
+	t.gzw = gzw
+	return t.Tar.Create(gzw)
+}
+
+func (t *tarGzWorkers) Close() error {
+	err := t.Tar.Close()
+	if t.gzw != nil {
+		if gzErr := t.gzw.Close(); err == nil {
+			err = gzErr
+		}
+		t.gzw = nil
+	}
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -20,6 +22,103 @@ type Config struct {
 	API        APIConfig        `yaml:"api"`
 	FTP        FTPConfig        `yaml:"ftp"`
 	AzureBlob  AzureBlobConfig  `yaml:"azblob"`
+	Rclone     RcloneConfig     `yaml:"rclone"`
+	Hooks      HooksConfig      `yaml:"hooks"`
+	Signing    SigningConfig    `yaml:"signing"`
+	Restore    RestoreConfig    `yaml:"restore"`
+	Verify     VerifyConfig     `yaml:"verify"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Barrier    BarrierConfig    `yaml:"barrier"`
+	Keeper     KeeperConfig     `yaml:"keeper"`
+	// Profiles - backup profiles of tenants by name
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+	// Custom - settings of remote storages registered by RegisterRemoteStorage
+	Custom map[string]string `yaml:"custom"`
+	// RemoteTargets - additional named remote storages, remote storage from general section is named 'primary'
+	RemoteTargets map[string]RemoteTargetConfig `yaml:"remote_targets"`
+}
+
+// RemoteTargetConfig - named remote storage settings section
+type RemoteTargetConfig struct {
+	RemoteStorage string            `yaml:"remote_storage"`
+	S3            S3Config          `yaml:"s3"`
+	GCS           GCSConfig         `yaml:"gcs"`
+	COS           COSConfig         `yaml:"cos"`
+	FTP           FTPConfig         `yaml:"ftp"`
+	AzureBlob     AzureBlobConfig   `yaml:"azblob"`
+	Rclone        RcloneConfig      `yaml:"rclone"`
+	Custom        map[string]string `yaml:"custom"`
+}
+
+// UnmarshalYAML - fill storage settings with default values before parsing
+func (t *RemoteTargetConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	defaultConfig := DefaultConfig()
+	*t = RemoteTargetConfig{
+		S3:        defaultConfig.S3,
+		GCS:       defaultConfig.GCS,
+		COS:       defaultConfig.COS,
+		FTP:       defaultConfig.FTP,
+		AzureBlob: defaultConfig.AzureBlob,
+		Rclone:    defaultConfig.Rclone,
+	}
+	type plain RemoteTargetConfig
+	return unmarshal((*plain)(t))
+}
+
+const (
+	// PrimaryTarget - name of remote storage defined in general section
+	PrimaryTarget = "primary"
+	// AllTargets - select primary and all named remote storages
+	AllTargets = "all"
+)
+
+// RemoteTarget - named remote storage and config for work with it
+type RemoteTarget struct {
+	Name   string
+	Config Config
+}
+
+// GetRemoteTargets - return remote storages selected by target name
+// Empty string or 'primary' selects remote storage from general section, 'all' selects primary and all named remote targets
+func GetRemoteTargets(config Config, target string) ([]RemoteTarget, error) {
+	primary := RemoteTarget{Name: PrimaryTarget, Config: config}
+	switch target {
+	case "", PrimaryTarget:
+		return []RemoteTarget{primary}, nil
+	case AllTargets:
+		result := []RemoteTarget{}
+		if config.General.RemoteStorage != "none" {
+			result = append(result, primary)
+		}
+		names := make([]string, 0, len(config.RemoteTargets))
+		for name := range config.RemoteTargets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, RemoteTarget{Name: name, Config: config.withRemoteTarget(config.RemoteTargets[name])})
+		}
+		return result, nil
+	}
+	t, ok := config.RemoteTargets[target]
+	if !ok {
+		return nil, fmt.Errorf("remote target '%s' is not defined in remote_targets", target)
+	}
+	return []RemoteTarget{{Name: target, Config: config.withRemoteTarget(t)}}, nil
+}
+
+// withRemoteTarget - return copy of config where remote storage settings are replaced by settings of target
+func (config Config) withRemoteTarget(t RemoteTargetConfig) Config {
+	config.General.RemoteStorage = t.RemoteStorage
+	config.S3 = t.S3
+	config.GCS = t.GCS
+	config.COS = t.COS
+	config.FTP = t.FTP
+	config.AzureBlob = t.AzureBlob
+	config.Rclone = t.Rclone
+	config.Custom = t.Custom
+	return config
 }
 
 // GeneralConfig - general setting section
@@ -28,6 +127,43 @@ type GeneralConfig struct {
 	DisableProgressBar  bool   `yaml:"disable_progress_bar" envconfig:"DISABLE_PROGRESS_BAR"`
 	BackupsToKeepLocal  int    `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
 	BackupsToKeepRemote int    `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
+	CleanupOnFailure    bool   `yaml:"cleanup_on_failure" envconfig:"CLEANUP_ON_FAILURE"`
+	// RetriesOnFailure - how many times transfer of one file to or from remote storage is retried
+	RetriesOnFailure int `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
+	// RetriesPause - pause before first retry, it's doubled on every next retry
+	RetriesPause string `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
+	// TempDir - directory for temporary files of archiving and extraction, system temporary directory is used by default
+	TempDir string `yaml:"temp_dir" envconfig:"TEMP_DIR"`
+	// MaxFileSize - size in bytes of chunks which archive is split into on upload, archive isn't split when it's 0
+	MaxFileSize int64 `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
+	// FreezeConcurrency - how many tables are frozen and how many files are moved from shadow at the same time on create
+	FreezeConcurrency int `yaml:"freeze_concurrency" envconfig:"FREEZE_CONCURRENCY"`
+	// StopMergesDuringFreeze - run SYSTEM STOP MERGES for all frozen tables before the first freeze and SYSTEM START MERGES after the last one
+	StopMergesDuringFreeze bool `yaml:"stop_merges_during_freeze" envconfig:"STOP_MERGES_DURING_FREEZE"`
+	// IONice - IO scheduling class of local file copy on create and restore, 'idle' or 'best-effort', not changed when it's empty
+	IONice string `yaml:"ionice" envconfig:"IONICE"`
+	// IOThrottleMbps - limit of local file copy on create and restore in megabytes per second, not limited when it's 0
+	IOThrottleMbps int `yaml:"io_throttle_mbps" envconfig:"IO_THROTTLE_MBPS"`
+	// CompressionWorkers - how many cores are used by gzip compression on upload regardless of GOMAXPROCS, default is used when it's 0
+	CompressionWorkers int `yaml:"compression_workers" envconfig:"COMPRESSION_WORKERS"`
+	// LowMemory - use small buffers and parts of uploads and compress by one core, for hosts with 1GB of memory
+	LowMemory bool `yaml:"low_memory" envconfig:"LOW_MEMORY"`
+	// BufferMemoryLimit - total size in bytes of buffers of all uploads and downloads, transfers wait for free buffers when it's reached,
+	// not limited when it's 0
+	BufferMemoryLimit int64 `yaml:"buffer_memory_limit" envconfig:"BUFFER_MEMORY_LIMIT"`
+	// RestoreFileOwner - 'user:group' or 'uid:gid' of restored files, they are owned by owner of ClickHouse data directory when it's empty
+	RestoreFileOwner string `yaml:"restore_file_owner" envconfig:"RESTORE_FILE_OWNER"`
+	// BackupDirMode, BackupFileMode - octal modes of directories and files created for backups and temporary files,
+	// modes are limited by umask of process, modes of parts hard linked from ClickHouse aren't changed
+	BackupDirMode  string `yaml:"backup_dir_mode" envconfig:"BACKUP_DIR_MODE"`
+	BackupFileMode string `yaml:"backup_file_mode" envconfig:"BACKUP_FILE_MODE"`
+	// PreserveXattrs - copy extended attributes like POSIX ACLs and SELinux context of files and directories of parts
+	// copied to and from backups, Linux only
+	PreserveXattrs bool `yaml:"preserve_xattrs" envconfig:"PRESERVE_XATTRS"`
+	// Shard - name of shard of cluster, backups are uploaded to '<path>/<shard>' of remote storage, so shards can share one bucket
+	Shard string `yaml:"shard" envconfig:"SHARD"`
+	// Profile - profile of backups from profiles section, it's selected by --profile as well
+	Profile string `yaml:"profile" envconfig:"PROFILE"`
 }
 
 // GCSConfig - GCS settings section
@@ -38,6 +174,9 @@ type GCSConfig struct {
 	Path              string `yaml:"path" envconfig:"GCS_PATH"`
 	CompressionLevel  int    `yaml:"compression_level" envconfig:"GCS_COMPRESSION_LEVEL"`
 	CompressionFormat string `yaml:"compression_format" envconfig:"GCS_COMPRESSION_FORMAT"`
+	// TemporaryHold, EventBasedHold - holds of uploaded objects, objects under hold or retention policy of locked bucket can't be deleted
+	TemporaryHold  bool `yaml:"temporary_hold" envconfig:"GCS_TEMPORARY_HOLD"`
+	EventBasedHold bool `yaml:"event_based_hold" envconfig:"GCS_EVENT_BASED_HOLD"`
 }
 
 // AzureBlobConfig - Azure Blob settings section
@@ -70,6 +209,12 @@ type S3Config struct {
 	SSE                     string `yaml:"sse" envconfig:"S3_SSE"`
 	DisableCertVerification bool   `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
 	Debug                   bool   `yaml:"debug" envconfig:"S3_DEBUG"`
+	// ObjectLockMode - GOVERNANCE or COMPLIANCE retention of uploaded objects, bucket must be created with Object Lock enabled
+	ObjectLockMode string `yaml:"object_lock_mode" envconfig:"S3_OBJECT_LOCK_MODE"`
+	// ObjectLockDays - objects can't be deleted during this number of days after upload
+	ObjectLockDays int `yaml:"object_lock_days" envconfig:"S3_OBJECT_LOCK_DAYS"`
+	// ObjectLockLegalHold - put legal hold on uploaded objects, it's released manually
+	ObjectLockLegalHold bool `yaml:"object_lock_legal_hold" envconfig:"S3_OBJECT_LOCK_LEGAL_HOLD"`
 }
 
 // COSConfig - cos settings section
@@ -79,6 +224,8 @@ type COSConfig struct {
 	SecretID          string `yaml:"secret_id" envconfig:"COS_SECRET_ID"`
 	SecretKey         string `yaml:"secret_key" envconfig:"COS_SECRET_KEY"`
 	Path              string `yaml:"path" envconfig:"COS_PATH"`
+	PartSize          int64  `yaml:"part_size" envconfig:"COS_PART_SIZE"`
+	MaxRetries        int    `yaml:"max_retries" envconfig:"COS_MAX_RETRIES"`
 	CompressionFormat string `yaml:"compression_format" envconfig:"COS_COMPRESSION_FORMAT"`
 	CompressionLevel  int    `yaml:"compression_level" envconfig:"COS_COMPRESSION_LEVEL"`
 	Debug             bool   `yaml:"debug" envconfig:"COS_DEBUG"`
@@ -86,15 +233,29 @@ type COSConfig struct {
 
 // FTPConfig - ftp settings section
 type FTPConfig struct {
-	Address           string `yaml:"address" envconfig:"FTP_ADDRESS"`
-	Timeout           string `yaml:"timeout" envconfig:"FTP_TIMEOUT"`
-	Username          string `yaml:"username" envconfig:"FTP_USERNAME"`
-	Password          string `yaml:"password" envconfig:"FTP_PASSWORD"`
-	TLS               bool   `yaml:"tls" envconfig:"FTP_TLS"`
-	Path              string `yaml:"path" envconfig:"FTP_PATH"`
-	CompressionFormat string `yaml:"compression_format" envconfig:"FTP_COMPRESSION_FORMAT"`
-	CompressionLevel  int    `yaml:"compression_level" envconfig:"FTP_COMPRESSION_LEVEL"`
-	Debug             bool   `yaml:"debug" envconfig:"FTP_DEBUG"`
+	Address                 string `yaml:"address" envconfig:"FTP_ADDRESS"`
+	Timeout                 string `yaml:"timeout" envconfig:"FTP_TIMEOUT"`
+	Username                string `yaml:"username" envconfig:"FTP_USERNAME"`
+	Password                string `yaml:"password" envconfig:"FTP_PASSWORD"`
+	TLS                     bool   `yaml:"tls" envconfig:"FTP_TLS"`
+	TLSExplicit             bool   `yaml:"tls_explicit" envconfig:"FTP_TLS_EXPLICIT"`
+	DisableCertVerification bool   `yaml:"disable_cert_verification" envconfig:"FTP_DISABLE_CERT_VERIFICATION"`
+	Concurrency             int    `yaml:"concurrency" envconfig:"FTP_CONCURRENCY"`
+	Path                    string `yaml:"path" envconfig:"FTP_PATH"`
+	CompressionFormat       string `yaml:"compression_format" envconfig:"FTP_COMPRESSION_FORMAT"`
+	CompressionLevel        int    `yaml:"compression_level" envconfig:"FTP_COMPRESSION_LEVEL"`
+	Debug                   bool   `yaml:"debug" envconfig:"FTP_DEBUG"`
+}
+
+// RcloneConfig - rclone settings section, remote must be configured in rclone config file
+type RcloneConfig struct {
+	Binary            string `yaml:"binary" envconfig:"RCLONE_STORAGE_BINARY"`
+	ConfigFile        string `yaml:"config_file" envconfig:"RCLONE_STORAGE_CONFIG_FILE"`
+	Remote            string `yaml:"remote" envconfig:"RCLONE_STORAGE_REMOTE"`
+	Path              string `yaml:"path" envconfig:"RCLONE_STORAGE_PATH"`
+	CompressionFormat string `yaml:"compression_format" envconfig:"RCLONE_STORAGE_COMPRESSION_FORMAT"`
+	CompressionLevel  int    `yaml:"compression_level" envconfig:"RCLONE_STORAGE_COMPRESSION_LEVEL"`
+	Debug             bool   `yaml:"debug" envconfig:"RCLONE_STORAGE_DEBUG"`
 }
 
 // ClickHouseConfig - clickhouse settings section
@@ -107,14 +268,76 @@ type ClickHouseConfig struct {
 	SkipTables   []string `yaml:"skip_tables" envconfig:"CLICKHOUSE_SKIP_TABLES"`
 	Timeout      string   `yaml:"timeout" envconfig:"CLICKHOUSE_TIMEOUT"`
 	FreezeByPart bool     `yaml:"freeze_by_part" envconfig:"CLICKHOUSE_FREEZE_BY_PART"`
+	// IncludeSystemLogs - patterns of system log tables like query_log which are backed up even when skip_tables matches them
+	IncludeSystemLogs []string `yaml:"include_system_logs" envconfig:"CLICKHOUSE_INCLUDE_SYSTEM_LOGS"`
+	// FlushDistributed, FlushBuffer - send pending data of Distributed and Buffer tables writing to backed up tables before freeze
+	FlushDistributed bool `yaml:"flush_distributed" envconfig:"CLICKHOUSE_FLUSH_DISTRIBUTED"`
+	FlushBuffer      bool `yaml:"flush_buffer" envconfig:"CLICKHOUSE_FLUSH_BUFFER"`
+	// CheckTables - run CHECK TABLE before freeze, CheckTablesFail stops create on corrupted table, CheckTablesRecord saves result to backup metadata
+	CheckTables string `yaml:"check_tables" envconfig:"CLICKHOUSE_CHECK_TABLES"`
+	// CheckTablesMaxSize - tables larger than this size in bytes aren't checked, size isn't limited when it's 0
+	CheckTablesMaxSize int64 `yaml:"check_tables_max_size" envconfig:"CLICKHOUSE_CHECK_TABLES_MAX_SIZE"`
+	// DefaultReplicaPath, DefaultReplicaName - arguments of Replicated*MergeTree engines created by 'restore --convert-engine=replicated'
+	DefaultReplicaPath string `yaml:"default_replica_path" envconfig:"CLICKHOUSE_DEFAULT_REPLICA_PATH"`
+	DefaultReplicaName string `yaml:"default_replica_name" envconfig:"CLICKHOUSE_DEFAULT_REPLICA_NAME"`
+	// DataPathMap - paths seen by ClickHouse and paths of the same directories on host, used when ClickHouse runs in container
+	DataPathMap map[string]string `yaml:"data_path_map" envconfig:"CLICKHOUSE_DATA_PATH_MAP"`
+	// DockerContainer - container of ClickHouse, its mounts read by 'docker inspect' are added to DataPathMap
+	DockerContainer string `yaml:"docker_container" envconfig:"CLICKHOUSE_DOCKER_CONTAINER"`
+	// Protocol - ProtocolNative or ProtocolHTTP, port must be port of HTTP interface for ProtocolHTTP
+	Protocol string `yaml:"protocol" envconfig:"CLICKHOUSE_PROTOCOL"`
+	// Secure, SkipVerify - connect by TLS and don't verify certificate of server, they work for both protocols
+	Secure     bool `yaml:"secure" envconfig:"CLICKHOUSE_SECURE"`
+	SkipVerify bool `yaml:"skip_verify" envconfig:"CLICKHOUSE_SKIP_VERIFY"`
+	// Compression - compress data of queries and results, LZ4 for native protocol and gzip for HTTP
+	Compression bool `yaml:"compression" envconfig:"CLICKHOUSE_COMPRESSION"`
+	// FreezeSettings, RestoreSettings - settings of queries run by freeze and by restore of schema and data, e.g. max_execution_time
+	FreezeSettings  map[string]string `yaml:"freeze_settings" envconfig:"CLICKHOUSE_FREEZE_SETTINGS"`
+	RestoreSettings map[string]string `yaml:"restore_settings" envconfig:"CLICKHOUSE_RESTORE_SETTINGS"`
 }
 
 type APIConfig struct {
+	// ListenAddr - TCP address or 'unix://<path>' to listen on unix domain socket
 	ListenAddr    string `yaml:"listen" envconfig:"API_LISTEN"`
 	EnableMetrics bool   `yaml:"enable_metrics" envconfig:"API_ENABLE_METRICS"`
 	EnablePprof   bool   `yaml:"enable_pprof" envconfig:"API_ENABLE_PPROF"`
 	Username      string `yaml:"username" envconfig:"API_USERNAME"`
 	Password      string `yaml:"password" envconfig:"API_PASSWORD"`
+	// CORSAllowedOrigins - origins of browser applications allowed to call API, '*' allows any origin, patterns like 'https://*.example.com' are supported
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins" envconfig:"API_CORS_ALLOWED_ORIGINS"`
+	// AllowCIDR - networks and addresses allowed to connect to API, any address is allowed when it's empty
+	AllowCIDR []string `yaml:"allow_cidr" envconfig:"API_ALLOW_CIDR"`
+	// Users - additional credentials with restricted role, credentials set by username and password have 'admin' role
+	Users []APIUser `yaml:"users"`
+	// AuditLog - file where POST requests and results of operations started by them are appended, 'syslog' sends them to syslog
+	AuditLog string `yaml:"audit_log" envconfig:"API_AUDIT_LOG"`
+	// RateLimit - how many POST requests per minute one address may send, not limited when it's 0
+	RateLimit int `yaml:"rate_limit" envconfig:"API_RATE_LIMIT"`
+	// MaxBodySize - max size in bytes of body of POST /backup/config and /integration/actions, not limited when it's 0
+	MaxBodySize int64 `yaml:"max_body_size" envconfig:"API_MAX_BODY_SIZE"`
+	// ReadOnly - register only GET endpoints, backups can't be created, restored, deleted and config can't be updated by API
+	ReadOnly bool `yaml:"read_only" envconfig:"API_READ_ONLY"`
+	// MetricsListenAddr - address of separate server of /metrics and /debug/pprof, they are served by API server when it's empty
+	MetricsListenAddr string `yaml:"metrics_listen_addr" envconfig:"API_METRICS_LISTEN_ADDR"`
+	// BackupMetricsInterval - how often backups are listed and disks are checked for metrics of backups, free space and shadow, 0 disables them
+	BackupMetricsInterval string `yaml:"backup_metrics_interval" envconfig:"API_BACKUP_METRICS_INTERVAL"`
+	// MaxOperations - how many operations of different backups server runs at the same time, not limited when it's 0
+	MaxOperations int `yaml:"max_operations" envconfig:"API_MAX_OPERATIONS"`
+	// ReadTimeout, WriteTimeout - how long server reads request and writes response, IdleTimeout - how long keep-alive connection
+	// waits for next request, read_timeout is used when it's 0. Timeouts aren't limited when they are 0
+	ReadTimeout  string `yaml:"read_timeout" envconfig:"API_READ_TIMEOUT"`
+	WriteTimeout string `yaml:"write_timeout" envconfig:"API_WRITE_TIMEOUT"`
+	IdleTimeout  string `yaml:"idle_timeout" envconfig:"API_IDLE_TIMEOUT"`
+	// MaxHeaderBytes - max size of headers of request, 1MB is used when it's 0
+	MaxHeaderBytes int `yaml:"max_header_bytes" envconfig:"API_MAX_HEADER_BYTES"`
+}
+
+// APIUser - API credentials with role
+type APIUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Role - 'readonly', 'operator' or 'admin'
+	Role string `yaml:"role"`
 }
 
 // LoadConfig - load config from file
@@ -122,8 +345,11 @@ func LoadConfig(configLocation string) (*Config, error) {
 	config := DefaultConfig()
 	configYaml, err := ioutil.ReadFile(configLocation)
 	if os.IsNotExist(err) {
-		err := envconfig.Process("", config)
-		return config, err
+		if err := envconfig.Process("", config); err != nil {
+			return config, err
+		}
+		setBackupModes(config.General)
+		return config, normalizeLocalPaths(&config.ClickHouse)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("can't open config file: %v", err)
@@ -134,25 +360,188 @@ func LoadConfig(configLocation string) (*Config, error) {
 	if err := envconfig.Process("", config); err != nil {
 		return nil, err
 	}
-	return config, validateConfig(config)
+	if err := normalizeLocalPaths(&config.ClickHouse); err != nil {
+		return nil, err
+	}
+	if err := validateConfig(config); err != nil {
+		return config, err
+	}
+	setBackupModes(config.General)
+	return config, nil
 }
 
 func validateConfig(config *Config) error {
-	if _, err := getArchiveWriter(config.S3.CompressionFormat, config.S3.CompressionLevel); err != nil {
+	if _, err := getArchiveWriter(config.S3.CompressionFormat, config.S3.CompressionLevel, config.General.CompressionWorkers); err != nil {
+		return err
+	}
+	if _, err := getArchiveWriter(config.GCS.CompressionFormat, config.GCS.CompressionLevel, config.General.CompressionWorkers); err != nil {
+		return err
+	}
+	switch config.S3.ObjectLockMode {
+	case "":
+		if config.S3.ObjectLockDays != 0 {
+			return fmt.Errorf("s3.object_lock_days requires s3.object_lock_mode")
+		}
+	case ObjectLockGovernance, ObjectLockCompliance:
+		if config.S3.ObjectLockDays < 1 {
+			return fmt.Errorf("s3.object_lock_days must be at least 1")
+		}
+	default:
+		return fmt.Errorf("s3.object_lock_mode must be '%s' or '%s'", ObjectLockGovernance, ObjectLockCompliance)
+	}
+	if config.General.MaxFileSize != 0 && config.General.MaxFileSize < 1024*1024 {
+		return fmt.Errorf("max_file_size must be 0 or at least 1MB")
+	}
+	if err := validateSystemLogs(config.ClickHouse.IncludeSystemLogs); err != nil {
+		return fmt.Errorf("clickhouse.include_system_logs: %v", err)
+	}
+	if config.General.FreezeConcurrency < 1 {
+		return fmt.Errorf("freeze_concurrency must be at least 1")
+	}
+	if err := validateIONice(config.General.IONice); err != nil {
+		return err
+	}
+	if config.General.IOThrottleMbps < 0 {
+		return fmt.Errorf("io_throttle_mbps can't be negative")
+	}
+	if err := validateRestoreConfig(config.Restore); err != nil {
+		return err
+	}
+	if err := validateVerifyConfig(config.Verify, config.RemoteTargets); err != nil {
 		return err
 	}
-	if _, err := getArchiveWriter(config.GCS.CompressionFormat, config.GCS.CompressionLevel); err != nil {
+	if err := validateMetricsConfig(config.Metrics); err != nil {
+		return err
+	}
+	if err := validateTracingConfig(config.Tracing); err != nil {
+		return err
+	}
+	if err := validateBarrierConfig(config.Barrier); err != nil {
+		return err
+	}
+	if err := validateProfiles(config); err != nil {
+		return err
+	}
+	if config.General.BufferMemoryLimit != 0 && config.General.BufferMemoryLimit < int64(2*getBufferSize(config.General)) {
+		return fmt.Errorf("buffer_memory_limit must be 0 or at least %d bytes, buffers of one upload", 2*getBufferSize(config.General))
+	}
+	if config.General.CompressionWorkers < 0 {
+		return fmt.Errorf("compression_workers can't be negative")
+	}
+	if config.General.RetriesOnFailure < 0 {
+		return fmt.Errorf("retries_on_failure can't be negative")
+	}
+	if _, err := newRetrier(config.General); err != nil {
 		return err
 	}
 	if _, err := time.ParseDuration(config.ClickHouse.Timeout); err != nil {
 		return err
 	}
+	switch config.ClickHouse.Protocol {
+	case "", ProtocolNative, ProtocolHTTP:
+	default:
+		return fmt.Errorf("unknown clickhouse protocol '%s', must be '%s' or '%s'", config.ClickHouse.Protocol, ProtocolNative, ProtocolHTTP)
+	}
+	if config.ClickHouse.CheckTables != "" && config.ClickHouse.CheckTables != CheckTablesFail && config.ClickHouse.CheckTables != CheckTablesRecord {
+		return fmt.Errorf("unknown check_tables '%s', must be '%s' or '%s'", config.ClickHouse.CheckTables, CheckTablesFail, CheckTablesRecord)
+	}
+	if err := validateDataPathMap(config.ClickHouse.DataPathMap); err != nil {
+		return err
+	}
+	if err := validateQuerySettings("freeze_settings", config.ClickHouse.FreezeSettings); err != nil {
+		return err
+	}
+	if err := validateQuerySettings("restore_settings", config.ClickHouse.RestoreSettings); err != nil {
+		return err
+	}
+	if config.ClickHouse.CheckTablesMaxSize < 0 {
+		return fmt.Errorf("check_tables_max_size can't be negative")
+	}
 	if _, err := time.ParseDuration(config.COS.Timeout); err != nil {
 		return err
 	}
+	if config.COS.PartSize < 1024*1024 {
+		return fmt.Errorf("cos part_size must be at least 1MB")
+	}
 	if _, err := time.ParseDuration(config.FTP.Timeout); err != nil {
 		return err
 	}
+	if _, err := time.ParseDuration(config.Hooks.Timeout); err != nil {
+		return fmt.Errorf("can't parse hooks timeout: %v", err)
+	}
+	if _, err := newManifestSigner(config.Signing); err != nil {
+		return err
+	}
+	if err := validateAPIAccess(config.API); err != nil {
+		return err
+	}
+	for _, addr := range []string{config.API.ListenAddr, config.API.MetricsListenAddr} {
+		if addr == unixSocketPrefix {
+			return fmt.Errorf("path of unix domain socket is required in '%s'", addr)
+		}
+	}
+	if config.API.MetricsListenAddr != "" && config.API.MetricsListenAddr == config.API.ListenAddr {
+		return fmt.Errorf("api metrics_listen_addr must be different from listen")
+	}
+	if config.API.RateLimit < 0 {
+		return fmt.Errorf("api rate_limit can't be negative")
+	}
+	if config.API.MaxBodySize < 0 {
+		return fmt.Errorf("api max_body_size can't be negative")
+	}
+	if config.API.MaxOperations < 0 {
+		return fmt.Errorf("api max_operations can't be negative")
+	}
+	for name, timeout := range map[string]string{
+		"read_timeout":  config.API.ReadTimeout,
+		"write_timeout": config.API.WriteTimeout,
+		"idle_timeout":  config.API.IdleTimeout,
+	} {
+		if timeout == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(timeout); err != nil || d < 0 {
+			return fmt.Errorf("api %s must be duration like 1m, 0 disables it", name)
+		}
+	}
+	if config.API.MaxHeaderBytes < 0 {
+		return fmt.Errorf("api max_header_bytes can't be negative")
+	}
+	if _, err := parseFileMode("backup_dir_mode", config.General.BackupDirMode); err != nil {
+		return err
+	}
+	if strings.Contains(config.General.Shard, "/") {
+		return fmt.Errorf("shard can't contain '/'")
+	}
+	if _, err := parseFileMode("backup_file_mode", config.General.BackupFileMode); err != nil {
+		return err
+	}
+	if _, _, err := splitFileOwner(config.General.RestoreFileOwner); err != nil {
+		return err
+	}
+	if config.API.BackupMetricsInterval != "" {
+		if _, err := time.ParseDuration(config.API.BackupMetricsInterval); err != nil {
+			return fmt.Errorf("can't parse api backup_metrics_interval: %v", err)
+		}
+	}
+	for name, target := range config.RemoteTargets {
+		if name == PrimaryTarget || name == AllTargets {
+			return fmt.Errorf("remote target can't be named '%s'", name)
+		}
+		if err := validateConfig(&Config{
+			General:    config.General,
+			ClickHouse: config.ClickHouse,
+			Hooks:      config.Hooks,
+			S3:         target.S3,
+			GCS:        target.GCS,
+			COS:        target.COS,
+			FTP:        target.FTP,
+			AzureBlob:  target.AzureBlob,
+			Rclone:     target.Rclone,
+		}); err != nil {
+			return fmt.Errorf("remote target '%s': %v", name, err)
+		}
+	}
 	return nil
 }
 
@@ -169,16 +558,23 @@ func DefaultConfig() *Config {
 			RemoteStorage:       "s3",
 			BackupsToKeepLocal:  0,
 			BackupsToKeepRemote: 0,
+			CleanupOnFailure:    true,
+			RetriesOnFailure:    3,
+			RetriesPause:        "30s",
+			FreezeConcurrency:   1,
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
 			Password: "",
 			Host:     "localhost",
 			Port:     9000,
+			Protocol: ProtocolNative,
 			SkipTables: []string{
 				"system.*",
 			},
-			Timeout: "5m",
+			Timeout:            "5m",
+			DefaultReplicaPath: "/clickhouse/tables/{shard}/{database}/{table}",
+			DefaultReplicaName: "{replica}",
 		},
 		AzureBlob: AzureBlobConfig{
 			EndpointSuffix:    "core.windows.net",
@@ -204,12 +600,21 @@ func DefaultConfig() *Config {
 			SecretID:          "",
 			SecretKey:         "",
 			Path:              "",
+			PartSize:          100 * 1024 * 1024,
+			MaxRetries:        5,
 			CompressionFormat: "gzip",
 			CompressionLevel:  1,
 			Debug:             false,
 		},
 		API: APIConfig{
-			ListenAddr: "localhost:7171",
+			ListenAddr:            "localhost:7171",
+			MaxBodySize:           1024 * 1024,
+			BackupMetricsInterval: "5m",
+			MaxOperations:         4,
+			ReadTimeout:           "1m",
+			WriteTimeout:          "0s",
+			IdleTimeout:           "2m",
+			MaxHeaderBytes:        1024 * 1024,
 		},
 		FTP: FTPConfig{
 			Address:           "",
@@ -217,9 +622,28 @@ func DefaultConfig() *Config {
 			Username:          "",
 			Password:          "",
 			TLS:               false,
+			Concurrency:       3,
 			CompressionFormat: "gzip",
 			CompressionLevel:  1,
 			Debug:             false,
 		},
+		Rclone: RcloneConfig{
+			Binary:            "rclone",
+			CompressionFormat: "gzip",
+			CompressionLevel:  1,
+		},
+		Hooks: HooksConfig{
+			Timeout: "5m",
+		},
+		Metrics: MetricsConfig{
+			PushgatewayJob: "clickhouse-backup",
+			StatsdPrefix:   "clickhouse_backup",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "clickhouse-backup",
+		},
+		Barrier: BarrierConfig{
+			Timeout: "5m",
+		},
 	}
 }
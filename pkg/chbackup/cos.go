@@ -1,8 +1,12 @@
 package chbackup
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -50,20 +54,23 @@ func (c *COS) Kind() string {
 	return "COS"
 }
 
+func (c *COS) Close() error {
+	return nil
+}
+
 func (c *COS) GetFile(key string) (RemoteFile, error) {
-	// file max size is 5Gb
-	resp, err := c.client.Object.Get(context.Background(), key, nil)
+	resp, err := c.client.Object.Head(context.Background(), key, nil)
 	if err != nil {
 		cosErr, ok := err.(*cos.ErrorResponse)
-		if ok && cosErr.Code == "NoSuchKey" {
+		if ok && (cosErr.Code == "NoSuchKey" || (cosErr.Response != nil && cosErr.Response.StatusCode == http.StatusNotFound)) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	modifiedTime, _ := parseTime(resp.Response.Header.Get("Date"))
+	modifiedTime, _ := parseTime(resp.Response.Header.Get("Last-Modified"))
 	return &cosFile{
 		size:         resp.Response.ContentLength,
-		name:         resp.Request.URL.Path,
+		name:         key,
 		lastModified: modifiedTime,
 	}, nil
 }
@@ -99,9 +106,94 @@ func (c *COS) GetFileReader(key string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// PutFile - upload file with multipart upload, single PUT request is limited by 5GB
 func (c *COS) PutFile(key string, r io.ReadCloser) error {
-	_, err := c.client.Object.Put(context.Background(), key, r, nil)
-	return err
+	ctx := context.Background()
+	buf := make([]byte, c.Config.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// small file, upload it by one request
+		return c.retry(func() error {
+			_, err := c.client.Object.Put(ctx, key, bytes.NewReader(buf[:n]), nil)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	var uploadID string
+	if err := c.retry(func() error {
+		result, _, err := c.client.Object.InitiateMultipartUpload(ctx, key, nil)
+		if err != nil {
+			return err
+		}
+		uploadID = result.UploadID
+		return nil
+	}); err != nil {
+		return fmt.Errorf("can't initiate multipart upload: %v", err)
+	}
+	complete := &cos.CompleteMultipartUploadOptions{}
+	for partNumber := 1; n > 0; partNumber++ {
+		var etag string
+		if err := c.retry(func() error {
+			resp, err := c.client.Object.UploadPart(ctx, key, uploadID, partNumber, bytes.NewReader(buf[:n]), nil)
+			if err != nil {
+				return err
+			}
+			etag = resp.Header.Get("ETag")
+			return nil
+		}); err != nil {
+			c.abortMultipartUpload(key, uploadID)
+			return fmt.Errorf("can't upload part %d: %v", partNumber, err)
+		}
+		complete.Parts = append(complete.Parts, cos.Object{PartNumber: partNumber, ETag: etag})
+		if n < len(buf) {
+			break
+		}
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			c.abortMultipartUpload(key, uploadID)
+			return err
+		}
+	}
+	if err := c.retry(func() error {
+		_, _, err := c.client.Object.CompleteMultipartUpload(ctx, key, uploadID, complete)
+		return err
+	}); err != nil {
+		c.abortMultipartUpload(key, uploadID)
+		return fmt.Errorf("can't complete multipart upload: %v", err)
+	}
+	return nil
+}
+
+// abortMultipartUpload - remove uploaded parts of failed multipart upload
+func (c *COS) abortMultipartUpload(key string, uploadID string) {
+	if _, err := c.client.Object.AbortMultipartUpload(context.Background(), key, uploadID); err != nil {
+		log.Printf("can't abort multipart upload of '%s': %v", key, err)
+	}
+}
+
+// retry - execute request again with exponential backoff when it failed with server error or timeout
+func (c *COS) retry(request func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = request(); err == nil || attempt >= c.Config.MaxRetries || !isCOSRetriableError(err) {
+			return err
+		}
+		pause := time.Duration(1<<uint(attempt)) * time.Second
+		log.Printf("COS request failed: %v, retry in %s", err, pause)
+		time.Sleep(pause)
+	}
+}
+
+func isCOSRetriableError(err error) bool {
+	if cosErr, ok := err.(*cos.ErrorResponse); ok {
+		return cosErr.Response != nil && cosErr.Response.StatusCode >= http.StatusInternalServerError
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
 }
 
 type cosFile struct {
@@ -0,0 +1,301 @@
+package chbackup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// EngineConvertPlain - convert Replicated*MergeTree engines to *MergeTree
+	EngineConvertPlain = "plain"
+	// EngineConvertReplicated - convert *MergeTree engines to Replicated*MergeTree
+	EngineConvertReplicated = "replicated"
+)
+
+var mergeTreeEngineRE = regexp.MustCompile(`ENGINE = (Replicated)?(\w*MergeTree)\b`)
+
+// mergeTreeEngine - ENGINE clause of MergeTree family table parsed from CREATE query
+type mergeTreeEngine struct {
+	prefix     string
+	replicated bool
+	name       string
+	args       []string
+	suffix     string
+}
+
+// parseMergeTreeEngine - find MergeTree family engine in query, nil is returned when query has other engine
+func parseMergeTreeEngine(query string) (*mergeTreeEngine, error) {
+	loc := mergeTreeEngineRE.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return nil, nil
+	}
+	e := &mergeTreeEngine{
+		prefix:     query[:loc[0]],
+		replicated: loc[2] != -1,
+		name:       query[loc[4]:loc[5]],
+		args:       []string{},
+		suffix:     query[loc[1]:],
+	}
+	if strings.HasPrefix(e.suffix, "(") {
+		args, n, err := splitEngineArgs(e.suffix)
+		if err != nil {
+			return nil, err
+		}
+		e.args = args
+		e.suffix = e.suffix[n:]
+	}
+	return e, nil
+}
+
+func (e *mergeTreeEngine) String() string {
+	name := e.name
+	if e.replicated {
+		name = "Replicated" + name
+	}
+	return fmt.Sprintf("%sENGINE = %s(%s)%s", e.prefix, name, strings.Join(e.args, ", "), e.suffix)
+}
+
+// convertEngine - rewrite MergeTree family engine in CREATE query to plain or replicated variant.
+// {database} and {table} in replicaPath are replaced by names of table, other macros are kept as is
+func convertEngine(query, database, table, to, replicaPath, replicaName string) (string, error) {
+	e, err := parseMergeTreeEngine(query)
+	if err != nil || e == nil {
+		return query, err
+	}
+	switch to {
+	case EngineConvertPlain:
+		if !e.replicated {
+			return query, nil
+		}
+		if len(e.args) >= 2 {
+			e.args = e.args[2:]
+		}
+		e.replicated = false
+	case EngineConvertReplicated:
+		if e.replicated {
+			return query, nil
+		}
+		zkPath := strings.NewReplacer("{database}", database, "{table}", table).Replace(replicaPath)
+		e.args = append([]string{quoteString(zkPath), quoteString(replicaName)}, e.args...)
+		e.replicated = true
+	default:
+		return "", fmt.Errorf("unknown engine conversion '%s', must be '%s' or '%s'", to, EngineConvertPlain, EngineConvertReplicated)
+	}
+	return e.String(), nil
+}
+
+// substituteMacros - replace macros placeholders and values of source server macros in ZooKeeper path and
+// replica name of Replicated*MergeTree engine by values of target server macros.
+// Values of source macros are replaced only when they match the whole path element
+func substituteMacros(query string, sourceMacros, targetMacros map[string]string) (string, error) {
+	e, err := parseMergeTreeEngine(query)
+	if err != nil || e == nil || !e.replicated || len(e.args) < 2 {
+		return query, err
+	}
+	zkPath, ok := unquoteString(e.args[0])
+	if !ok {
+		return query, nil
+	}
+	replicaName, ok := unquoteString(e.args[1])
+	if !ok {
+		return query, nil
+	}
+	macroByValue := map[string]string{}
+	ambiguous := map[string]bool{}
+	for name, value := range sourceMacros {
+		if value == "" {
+			continue
+		}
+		if _, exists := macroByValue[value]; exists {
+			ambiguous[value] = true
+		}
+		macroByValue[value] = name
+	}
+	substitute := func(s string) string {
+		for name, value := range targetMacros {
+			s = strings.Replace(s, "{"+name+"}", value, -1)
+		}
+		if name, ok := macroByValue[s]; ok && !ambiguous[s] {
+			if value, ok := targetMacros[name]; ok {
+				return value
+			}
+		}
+		return s
+	}
+	parts := strings.Split(zkPath, "/")
+	for i := range parts {
+		parts[i] = substitute(parts[i])
+	}
+	e.args[0] = quoteString(strings.Join(parts, "/"))
+	e.args[1] = quoteString(substitute(replicaName))
+	return e.String(), nil
+}
+
+// getReplicaPath - return ZooKeeper path and replica name of Replicated*MergeTree engine with expanded macros,
+// false is returned when table is not replicated or path contains unknown macros
+func getReplicaPath(query, database, table string, macros map[string]string) (string, string, bool) {
+	e, err := parseMergeTreeEngine(query)
+	if err != nil || e == nil || !e.replicated || len(e.args) < 2 {
+		return "", "", false
+	}
+	zkPath, ok := unquoteString(e.args[0])
+	if !ok {
+		return "", "", false
+	}
+	replicaName, ok := unquoteString(e.args[1])
+	if !ok {
+		return "", "", false
+	}
+	replacements := []string{"{database}", database, "{table}", table}
+	for name, value := range macros {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	zkPath, replicaName = replacer.Replace(zkPath), replacer.Replace(replicaName)
+	if strings.Contains(zkPath, "{") || strings.Contains(replicaName, "{") {
+		return "", "", false
+	}
+	return zkPath, replicaName, true
+}
+
+// splitEngineArgs - split arguments list which starts at s[0] == '(', return arguments and length of list
+func splitEngineArgs(s string) ([]string, int, error) {
+	args := []string{}
+	depth := 0
+	start := 1
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+			if depth == 0 {
+				if arg := strings.TrimSpace(s[start:i]); arg != "" || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, i + 1, nil
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("unbalanced parentheses in engine definition")
+}
+
+// unquoteIdentifier - return name from identifier or string literal argument of engine
+func unquoteIdentifier(arg string) string {
+	if v, ok := unquoteString(arg); ok {
+		return v
+	}
+	return strings.Trim(arg, "`\"")
+}
+
+// getBufferDestination - return database and table where Buffer engine flushes data, engineFull is from system.tables.
+// Empty database means database of Buffer table
+func getBufferDestination(engineFull string) (string, string, bool) {
+	if !strings.HasPrefix(engineFull, "Buffer(") {
+		return "", "", false
+	}
+	args, _, err := splitEngineArgs(strings.TrimPrefix(engineFull, "Buffer"))
+	if err != nil || len(args) < 2 {
+		return "", "", false
+	}
+	return unquoteIdentifier(args[0]), unquoteIdentifier(args[1]), true
+}
+
+// getDistributedDestination - return database and table where Distributed engine writes data, engineFull is from system.tables.
+// Empty database means database of Distributed table
+func getDistributedDestination(engineFull string) (string, string, bool) {
+	if !strings.HasPrefix(engineFull, "Distributed(") {
+		return "", "", false
+	}
+	args, _, err := splitEngineArgs(strings.TrimPrefix(engineFull, "Distributed"))
+	if err != nil || len(args) < 3 {
+		return "", "", false
+	}
+	database := unquoteIdentifier(args[1])
+	if database == "currentDatabase()" {
+		database = ""
+	}
+	return database, unquoteIdentifier(args[2]), true
+}
+
+// unquoteString - return value of single quoted string literal
+func unquoteString(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), true
+}
+
+// escapeString - escape string for use inside single quoted literal
+func escapeString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func quoteString(s string) string {
+	return "'" + escapeString(s) + "'"
+}
+
+// ttlRE - TTL of table or of column in CREATE query
+var ttlRE = regexp.MustCompile(`\bTTL\b`)
+
+// tableTTLRE - TTL clause in the end of MergeTree engine definition, it's followed only by SETTINGS
+var tableTTLRE = regexp.MustCompile(`(?s)\sTTL\s+(.+?)(?:\s+SETTINGS\s.*)?$`)
+
+// columnTTLRE - TTL is the last clause of column definition
+var columnTTLRE = regexp.MustCompile("(?s)^(`[^`]+`|\\S+)\\s.*\\sTTL\\s+(.+)$")
+
+// getTTLExpressions - return TTL expression of table and TTL expressions of columns by name of column from CREATE query
+func getTTLExpressions(query string) (string, map[string]string) {
+	if !ttlRE.MatchString(query) {
+		return "", nil
+	}
+	tableTTL := ""
+	if e, err := parseMergeTreeEngine(query); err == nil && e != nil {
+		if m := tableTTLRE.FindStringSubmatch(e.suffix); m != nil {
+			tableTTL = strings.TrimSpace(m[1])
+		}
+	}
+	var columnsTTL map[string]string
+	if i := strings.Index(query, "("); i != -1 {
+		columns, _, err := splitEngineArgs(query[i:])
+		if err != nil {
+			return tableTTL, nil
+		}
+		for _, column := range columns {
+			m := columnTTLRE.FindStringSubmatch(column)
+			if m == nil || strings.HasPrefix(column, "INDEX ") || strings.HasPrefix(column, "PROJECTION ") || strings.HasPrefix(column, "CONSTRAINT ") {
+				continue
+			}
+			if columnsTTL == nil {
+				columnsTTL = map[string]string{}
+			}
+			columnsTTL[strings.Trim(m[1], "`")] = strings.TrimSpace(m[2])
+		}
+	}
+	return tableTTL, columnsTTL
+}
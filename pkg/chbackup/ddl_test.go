@@ -0,0 +1,112 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertEngine(t *testing.T) {
+	testCases := []struct {
+		query    string
+		to       string
+		expected string
+	}{
+		{
+			query:    "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db.t', '{replica}') ORDER BY id",
+			to:       EngineConvertPlain,
+			expected: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id",
+		},
+		{
+			query:    "CREATE TABLE db.t (d Date, v UInt64) ENGINE = ReplicatedReplacingMergeTree('/zk/t', 'r1', d, (d, v), 8192)",
+			to:       EngineConvertPlain,
+			expected: "CREATE TABLE db.t (d Date, v UInt64) ENGINE = ReplacingMergeTree(d, (d, v), 8192)",
+		},
+		{
+			query:    "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id",
+			to:       EngineConvertReplicated,
+			expected: "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+		},
+		{
+			query:    "CREATE TABLE db.t (id UInt64, v Int8) ENGINE = CollapsingMergeTree(v) ORDER BY id",
+			to:       EngineConvertReplicated,
+			expected: "CREATE TABLE db.t (id UInt64, v Int8) ENGINE = ReplicatedCollapsingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', v) ORDER BY id",
+		},
+		{
+			query:    "CREATE TABLE db.t (id UInt64) ENGINE = Distributed('cluster', 'db', 't_local')",
+			to:       EngineConvertPlain,
+			expected: "CREATE TABLE db.t (id UInt64) ENGINE = Distributed('cluster', 'db', 't_local')",
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := convertEngine(tc.query, "db", "t", tc.to, "/clickhouse/tables/{shard}/{database}/{table}", "{replica}")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestSubstituteMacros(t *testing.T) {
+	source := map[string]string{"shard": "01", "replica": "ch-1"}
+	target := map[string]string{"shard": "02", "replica": "ch-4"}
+	actual, err := substituteMacros("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/01/db/t', 'ch-1') ORDER BY id", source, target)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/02/db/t', 'ch-4') ORDER BY id", actual)
+
+	actual, err = substituteMacros("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id", nil, target)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/02/db/t', 'ch-4') ORDER BY id", actual)
+}
+
+func TestGetReplicaPath(t *testing.T) {
+	zkPath, replicaName, ok := getReplicaPath("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY id", "db", "t", map[string]string{"shard": "01", "replica": "ch-1"})
+	assert.True(t, ok)
+	assert.Equal(t, "/clickhouse/tables/01/db/t", zkPath)
+	assert.Equal(t, "ch-1", replicaName)
+
+	_, _, ok = getReplicaPath("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id", "db", "t", nil)
+	assert.False(t, ok)
+}
+
+func TestGetBufferDestination(t *testing.T) {
+	database, table, ok := getBufferDestination("Buffer(default, hits, 16, 10, 100, 10000, 1000000, 10000000, 100000000)")
+	assert.True(t, ok)
+	assert.Equal(t, "default", database)
+	assert.Equal(t, "hits", table)
+
+	database, table, ok = getBufferDestination("Buffer('', `hits.local`, 16, 10, 100, 10000, 1000000, 10000000, 100000000)")
+	assert.True(t, ok)
+	assert.Equal(t, "", database)
+	assert.Equal(t, "hits.local", table)
+
+	_, _, ok = getBufferDestination("MergeTree ORDER BY id")
+	assert.False(t, ok)
+}
+
+func TestGetDistributedDestination(t *testing.T) {
+	database, table, ok := getDistributedDestination("Distributed('cluster', 'default', 'hits_local', rand())")
+	assert.True(t, ok)
+	assert.Equal(t, "default", database)
+	assert.Equal(t, "hits_local", table)
+
+	database, table, ok = getDistributedDestination("Distributed(cluster, currentDatabase(), hits_local)")
+	assert.True(t, ok)
+	assert.Equal(t, "", database)
+	assert.Equal(t, "hits_local", table)
+
+	_, _, ok = getDistributedDestination("Buffer(default, hits, 16, 10, 100, 10000, 1000000, 10000000, 100000000)")
+	assert.False(t, ok)
+}
+
+func TestGetTTLExpressions(t *testing.T) {
+	query := "ATTACH TABLE _ UUID 'a7f1c0e2-0000-4000-8000-000000000000'\n(\n    `d` DateTime,\n    `id` UInt64,\n" +
+		"    `payload` String CODEC(ZSTD(1)) TTL d + toIntervalDay(7),\n    INDEX id_idx id TYPE minmax GRANULARITY 1\n)\n" +
+		"ENGINE = MergeTree\nPARTITION BY toYYYYMM(d)\nORDER BY id\nTTL d + toIntervalMonth(1) DELETE, d + toIntervalDay(7) TO DISK 'cold'\n" +
+		"SETTINGS index_granularity = 8192\n"
+	tableTTL, columnsTTL := getTTLExpressions(query)
+	assert.Equal(t, "d + toIntervalMonth(1) DELETE, d + toIntervalDay(7) TO DISK 'cold'", tableTTL)
+	assert.Equal(t, map[string]string{"payload": "d + toIntervalDay(7)"}, columnsTTL)
+
+	tableTTL, columnsTTL = getTTLExpressions("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id")
+	assert.Equal(t, "", tableTTL)
+	assert.Nil(t, columnsTTL)
+}
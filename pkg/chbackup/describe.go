@@ -0,0 +1,186 @@
+package chbackup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// describeLocalBackupPath - return metadata of local backup, tables are collected from shadow for backups created by previous versions
+func describeLocalBackupPath(backupPath string) (BackupMetadata, error) {
+	metadata := getLocalBackupMetadata(backupPath)
+	if len(metadata.Tables) == 0 {
+		tables, size, err := getBackupTablesMetadata(backupPath)
+		if err != nil {
+			return metadata, err
+		}
+		metadata.Tables = tables
+		metadata.Size = size
+	}
+	return metadata, nil
+}
+
+// DescribeLocalBackup - return tables, partitions and sizes of local backup
+func DescribeLocalBackup(config Config, backupName string) (*BackupMetadata, error) {
+	if err := GetLocalBackup(config, backupName); err != nil {
+		return nil, err
+	}
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return nil, ErrUnknownClickhouseDataPath
+	}
+	metadata, err := describeLocalBackupPath(path.Join(dataPath, "backup", backupName))
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// DescribeRemoteBackup - return tables, partitions and sizes of remote backup from its manifest
+func DescribeRemoteBackup(config Config, backupName string, target string) (*BackupMetadata, error) {
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		metadata, err := describeRemoteBackup(t.Config, backupName)
+		if err == ErrNotFound {
+			continue
+		}
+		return metadata, err
+	}
+	return nil, fmt.Errorf("backup '%s' not found on remote storage", backupName)
+}
+
+func describeRemoteBackup(config Config, backupName string) (*BackupMetadata, error) {
+	bd, err := NewBackupDestination(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := bd.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer bd.Close()
+	extension := "." + getExtension(bd.compressionFormat)
+	archiveName := strings.TrimSuffix(backupName, extension) + extension
+	file, err := bd.getArchive(path.Join(bd.path, archiveName))
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := bd.getManifest(archiveName)
+	if err != nil {
+		// backups uploaded by previous versions have no manifest
+		return &BackupMetadata{
+			BackupName:   strings.TrimSuffix(backupName, extension),
+			CreationDate: file.LastModified(),
+			Size:         file.Size(),
+		}, nil
+	}
+	return metadata, nil
+}
+
+// PrintBackupDescription - print tables, partitions and sizes of local or remote backup
+func PrintBackupDescription(config Config, backupName string, remote bool, target string) error {
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	var metadata *BackupMetadata
+	var err error
+	location := "local"
+	if !remote {
+		// backup is looked up on remote storage only when it doesn't exist locally, broken local backup is reported
+		if metadata, err = DescribeLocalBackup(config, backupName); err != nil && !errors.Is(err, errLocalBackupNotFound) {
+			return err
+		}
+	}
+	if remote || err != nil {
+		location = "remote"
+		if metadata, err = DescribeRemoteBackup(config, backupName, target); err != nil {
+			return err
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", metadata.BackupName)
+	fmt.Fprintf(w, "location:\t%s\n", location)
+	fmt.Fprintf(w, "created:\t%s\n", metadata.CreationDate.Format("02-01-2006 15:04:05"))
+	fmt.Fprintf(w, "host:\t%s\n", metadata.Host)
+	fmt.Fprintf(w, "clickhouse version:\t%s\n", formatVersion(metadata.ClickHouseVersion))
+	fmt.Fprintf(w, "size:\t%s\n", FormatBytes(metadata.Size))
+	if metadata.RequiredBackup != "" {
+		fmt.Fprintf(w, "required backup:\t%s\n", metadata.RequiredBackup)
+	}
+	if metadata.UploadState != "" {
+		fmt.Fprintf(w, "upload state:\t%s\n", metadata.UploadState)
+	}
+	if metadata.TablePattern != "" {
+		fmt.Fprintf(w, "tables filter:\t%s\n", metadata.TablePattern)
+	}
+	if metadata.Consistency != "" {
+		fmt.Fprintf(w, "consistency:\t%s\n", metadata.Consistency)
+	}
+	if metadata.BarrierTime != nil {
+		fmt.Fprintf(w, "barrier:\t%s\n", metadata.BarrierTime.Format("02-01-2006 15:04:05"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(metadata.FailedTables) > 0 {
+		fmt.Println("failed tables:")
+		for _, t := range metadata.FailedTables {
+			fmt.Printf("  %s.%s\t%s\n", t.Database, t.Table, t.Error)
+		}
+	}
+	if len(metadata.Tables) == 0 {
+		return nil
+	}
+	fmt.Println("tables:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range metadata.Tables {
+		frozen := ""
+		if !t.FreezeTime.IsZero() {
+			frozen = "frozen " + t.FreezeTime.Format("02-01-2006 15:04:05")
+		}
+		if t.Corrupted {
+			frozen += " (corrupted)"
+		}
+		projections := ""
+		if len(t.Projections) > 0 {
+			projections = "\tprojections: " + strings.Join(t.Projections, ", ")
+		}
+		fmt.Fprintf(w, "  %s.%s\t%s\t%d parts\t%s\tpartitions: %s%s\n", t.Database, t.Table, FormatBytes(t.Size), len(t.Parts), frozen, strings.Join(t.Partitions, ", "), projections)
+		if t.TTL != "" {
+			fmt.Fprintf(w, "    ttl: %s\n", t.TTL)
+		}
+		columns := make([]string, 0, len(t.ColumnsTTL))
+		for column := range t.ColumnsTTL {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for _, column := range columns {
+			fmt.Fprintf(w, "    ttl of column '%s': %s\n", column, t.ColumnsTTL[column])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(metadata.Detached) == 0 {
+		return nil
+	}
+	fmt.Println("detached:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range metadata.Detached {
+		parts := make([]string, 0, len(t.Parts))
+		for _, p := range t.Parts {
+			parts = append(parts, p.Name)
+		}
+		fmt.Fprintf(w, "  %s.%s\t%s\t%d parts\tparts: %s\n", t.Database, t.Table, FormatBytes(t.Size), len(t.Parts), strings.Join(parts, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,98 @@
+package chbackup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartChecksumFileName - file with checksums of all files of data part, its hash identifies content of part
+const PartChecksumFileName = "checksums.txt"
+
+// getPartChecksum - return hash of checksums.txt of part or empty string if part doesn't have it
+func getPartChecksum(partPath string) (string, error) {
+	f, err := os.Open(filepath.Join(partPath, PartChecksumFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffFromLinker - find files of parts which are unchanged since previous local backup,
+// they are hard linked from previous backup instead of copying from shadow
+type diffFromLinker struct {
+	backupPath string
+	// checksums - checksums of parts of previous backup by '<database>.<table>/<part>'
+	checksums map[string]string
+	// current - checksums of parts in shadow by path of part
+	current map[string]string
+}
+
+// newDiffFromLinker - read checksums of parts from metadata of local backup in backupPath
+func newDiffFromLinker(backupPath string) (*diffFromLinker, error) {
+	if reason := getLocalBackupBrokenReason(backupPath); reason != "" {
+		return nil, fmt.Errorf("can't use '%s' as diff-from backup: %s", filepath.Base(backupPath), reason)
+	}
+	metadata := getLocalBackupMetadata(backupPath)
+	l := &diffFromLinker{
+		backupPath: backupPath,
+		checksums:  map[string]string{},
+		current:    map[string]string{},
+	}
+	for _, t := range metadata.Tables {
+		for _, part := range t.Parts {
+			if part.Checksum != "" {
+				l.checksums[t.Database+"."+t.Table+"/"+part.Name] = part.Checksum
+			}
+		}
+	}
+	if len(l.checksums) == 0 {
+		return nil, fmt.Errorf("backup '%s' has no checksums of parts, it was created by previous version", filepath.Base(backupPath))
+	}
+	return l, nil
+}
+
+// source - return file in previous backup to link instead of filePath from shadow,
+// relativePath is '<database>/<table>/<part>/<file>'
+func (l *diffFromLinker) source(filePath, relativePath string) (string, bool, error) {
+	pathParts := strings.SplitN(relativePath, "/", 4)
+	if len(pathParts) != 4 {
+		return "", false, nil
+	}
+	database, _ := url.PathUnescape(pathParts[0])
+	table, _ := url.PathUnescape(pathParts[1])
+	expected, ok := l.checksums[database+"."+table+"/"+pathParts[2]]
+	if !ok {
+		return "", false, nil
+	}
+	partPath := strings.TrimSuffix(strings.TrimSuffix(filePath, pathParts[3]), "/")
+	checksum, ok := l.current[partPath]
+	if !ok {
+		var err error
+		if checksum, err = getPartChecksum(partPath); err != nil {
+			return "", false, err
+		}
+		l.current[partPath] = checksum
+	}
+	if checksum == "" || checksum != expected {
+		return "", false, nil
+	}
+	previousFile := filepath.Join(l.backupPath, "shadow", relativePath)
+	if _, err := os.Stat(previousFile); err != nil {
+		return "", false, nil
+	}
+	return previousFile, true, nil
+}
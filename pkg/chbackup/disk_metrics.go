@@ -0,0 +1,87 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// diskStats - prometheus.Collector of free space of disks of ClickHouse and of backup directory and size of shadow
+// directories of disks, they are refreshed by checking of disks. Metrics are exported after disks are checked once
+type diskStats struct {
+	mu         sync.Mutex
+	free       map[string]int64
+	shadowSize map[string]int64
+	backupFree int64
+	checked    bool
+
+	freeDesc       *prometheus.Desc
+	shadowDesc     *prometheus.Desc
+	backupFreeDesc *prometheus.Desc
+}
+
+func newDiskStats() *diskStats {
+	return &diskStats{
+		freeDesc: prometheus.NewDesc("clickhouse_backup_disk_free_bytes",
+			"Free space of disk of ClickHouse.", []string{"disk"}, nil),
+		shadowDesc: prometheus.NewDesc("clickhouse_backup_shadow_size_bytes",
+			"Size of files in shadow directory of disk of ClickHouse.", []string{"disk"}, nil),
+		backupFreeDesc: prometheus.NewDesc("clickhouse_backup_backup_path_free_bytes",
+			"Free space of directory of local backups.", nil, nil),
+	}
+}
+
+// refresh - check free space and size of shadow directories of disks, previous values are kept when check fails
+func (s *diskStats) refresh(config Config) error {
+	disks, err := getDisks(config)
+	if err != nil {
+		return err
+	}
+	free, shadowSize := map[string]int64{}, map[string]int64{}
+	var backupFree int64
+	for _, disk := range disks {
+		if free[disk.Name], err = getFreeSpace(disk.Path); err != nil {
+			return fmt.Errorf("can't get free space of '%s': %v", disk.Path, err)
+		}
+		shadowDir := path.Join(disk.Path, "shadow")
+		if shadowSize[disk.Name], err = getDirSize(shadowDir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't get size of '%s': %v", shadowDir, err)
+		}
+		if disk.Name == DefaultDisk {
+			backupDir := path.Join(disk.Path, "backup")
+			if backupFree, err = getFreeSpace(backupDir); err != nil {
+				return fmt.Errorf("can't get free space of '%s': %v", backupDir, err)
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.free, s.shadowSize, s.backupFree, s.checked = free, shadowSize, backupFree, true
+	return nil
+}
+
+// Describe - implements prometheus.Collector
+func (s *diskStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.freeDesc
+	ch <- s.shadowDesc
+	ch <- s.backupFreeDesc
+}
+
+// Collect - implements prometheus.Collector
+func (s *diskStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checked {
+		return
+	}
+	for disk, free := range s.free {
+		ch <- prometheus.MustNewConstMetric(s.freeDesc, prometheus.GaugeValue, float64(free), disk)
+	}
+	for disk, size := range s.shadowSize {
+		ch <- prometheus.MustNewConstMetric(s.shadowDesc, prometheus.GaugeValue, float64(size), disk)
+	}
+	ch <- prometheus.MustNewConstMetric(s.backupFreeDesc, prometheus.GaugeValue, float64(s.backupFree))
+}
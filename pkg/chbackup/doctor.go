@@ -0,0 +1,224 @@
+package chbackup
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// DoctorPass, DoctorWarn, DoctorFail - results of doctor checks
+	DoctorPass = "pass"
+	DoctorWarn = "warn"
+	DoctorFail = "fail"
+	// doctorClockSkewWarn - difference of clocks of host and ClickHouse which is reported as warning
+	doctorClockSkewWarn = 10 * time.Second
+	// doctorClockSkewFail - difference of clocks which breaks signed requests of S3 and other cloud storages
+	doctorClockSkewFail = 15 * time.Minute
+)
+
+// DoctorCheck - result of one check of doctor command
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// DoctorReport - results of all checks of doctor command
+type DoctorReport struct {
+	Checks []DoctorCheck `json:"checks"`
+}
+
+func (r *DoctorReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// Count - number of checks with status
+func (r *DoctorReport) Count(status string) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Print - write report as one line per check and summary
+func (r *DoctorReport) Print(w io.Writer) {
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "[%s] %-16s %s\n", strings.ToUpper(c.Status), c.Name, c.Message)
+	}
+	fmt.Fprintf(w, "%d passed, %d warnings, %d failed\n", r.Count(DoctorPass), r.Count(DoctorWarn), r.Count(DoctorFail))
+}
+
+// Doctor - check config, connection to ClickHouse and its version, clock skew, permissions on disks and backup directory,
+// leftovers in shadow directories and access to remote storages. Checks which need ClickHouse are skipped when it isn't available
+func Doctor(configPath string) *DoctorReport {
+	report := &DoctorReport{}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		report.add("config", DoctorFail, "%v", err)
+		return report
+	}
+	checkDoctorConfig(report, configPath, config)
+
+	ch := &ClickHouse{Config: &config.ClickHouse}
+	if err := ch.Connect(); err != nil {
+		report.add("clickhouse", DoctorFail, "can't connect to clickhouse %s:%d: %v", config.ClickHouse.Host, config.ClickHouse.Port, err)
+	} else {
+		defer ch.Close()
+		report.add("clickhouse", DoctorPass, "connected to %s:%d", config.ClickHouse.Host, config.ClickHouse.Port)
+		checkDoctorVersion(report, ch)
+		checkDoctorClock(report, ch)
+		disks, err := ch.GetDisks()
+		if err != nil {
+			report.add("disks", DoctorFail, "can't get disks: %v", err)
+		} else {
+			checkDoctorDisks(report, disks)
+		}
+	}
+	checkDoctorRemoteStorages(report, *config)
+	return report
+}
+
+func checkDoctorConfig(report *DoctorReport, configPath string, config *Config) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		report.add("config", DoctorWarn, "'%s' doesn't exist, default config and environment variables are used", configPath)
+	} else {
+		report.add("config", DoctorPass, "'%s' is valid", configPath)
+	}
+	if config.General.RemoteStorage == "none" && len(config.RemoteTargets) == 0 {
+		report.add("config", DoctorWarn, "general.remote_storage is 'none', backups are kept on local disks only")
+	}
+	if getBackupsToKeepLocal(*config) == 0 {
+		report.add("config", DoctorWarn, "general.backups_to_keep_local is 0, local backups are never removed")
+	}
+	if owner := config.General.RestoreFileOwner; owner != "" {
+		if _, _, err := getRestoreFileOwner(owner); err != nil {
+			report.add("file owner", DoctorWarn, "general.restore_file_owner '%s' is ignored on restore: %v", owner, err)
+		} else {
+			report.add("file owner", DoctorPass, "restored files are owned by '%s'", owner)
+		}
+	}
+}
+
+func checkDoctorVersion(report *DoctorReport, ch *ClickHouse) {
+	version, err := ch.GetVersion()
+	switch {
+	case err != nil:
+		report.add("version", DoctorFail, "%v", err)
+	case version == 0:
+		report.add("version", DoctorWarn, "ClickHouse version is unknown")
+	case version < FreezeWithNameVersion:
+		report.add("version", DoctorWarn, "ClickHouse %s doesn't support FREEZE WITH NAME, backups can't be created concurrently with other freezes", formatVersion(version))
+	default:
+		report.add("version", DoctorPass, "ClickHouse %s", formatVersion(version))
+	}
+}
+
+func checkDoctorClock(report *DoctorReport, ch *ClickHouse) {
+	var result []uint32
+	before := time.Now()
+	if err := ch.conn.SelectContext(ch.queryContext(), &result, "SELECT toUnixTimestamp(now())"); err != nil || len(result) == 0 {
+		report.add("clock", DoctorWarn, "can't get time of ClickHouse: %v", err)
+		return
+	}
+	local := before.Add(time.Since(before) / 2)
+	skew := local.Sub(time.Unix(int64(result[0]), 0)).Truncate(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	switch {
+	case skew > doctorClockSkewFail:
+		report.add("clock", DoctorFail, "clocks of host and ClickHouse differ by %s, requests to remote storage may be rejected", skew)
+	case skew > doctorClockSkewWarn:
+		report.add("clock", DoctorWarn, "clocks of host and ClickHouse differ by %s", skew)
+	default:
+		report.add("clock", DoctorPass, "clocks of host and ClickHouse are in sync")
+	}
+}
+
+// checkWritable - create and remove temporary file in dir
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func checkDoctorDisks(report *DoctorReport, disks []Disk) {
+	for _, disk := range disks {
+		info, err := os.Stat(disk.Path)
+		if err != nil {
+			report.add("disk "+disk.Name, DoctorFail, "%v", err)
+			continue
+		}
+		if uid, _, ok := fileOwner(info); ok && os.Geteuid() != 0 && os.Geteuid() != uid {
+			report.add("disk "+disk.Name, DoctorWarn, "'%s' is owned by uid %d, run as 'root' or owner of ClickHouse data", disk.Path, uid)
+		} else if err := checkWritable(disk.Path); err != nil {
+			report.add("disk "+disk.Name, DoctorFail, "'%s' is not writable: %v", disk.Path, err)
+		} else {
+			report.add("disk "+disk.Name, DoctorPass, "'%s' is writable", disk.Path)
+		}
+		checkDoctorShadow(report, disk)
+		if disk.Name == DefaultDisk {
+			backupDir := path.Join(disk.Path, "backup")
+			if _, err := os.Stat(backupDir); os.IsNotExist(err) {
+				report.add("backup dir", DoctorPass, "'%s' doesn't exist, it will be created by first backup", backupDir)
+			} else if err := checkWritable(backupDir); err != nil {
+				report.add("backup dir", DoctorFail, "'%s' is not writable: %v", backupDir, err)
+			} else {
+				report.add("backup dir", DoctorPass, "'%s' is writable", backupDir)
+			}
+		}
+	}
+}
+
+func checkDoctorShadow(report *DoctorReport, disk Disk) {
+	shadowDir := path.Join(disk.Path, "shadow")
+	entries, err := ioutil.ReadDir(shadowDir)
+	switch {
+	case os.IsNotExist(err):
+		report.add("shadow "+disk.Name, DoctorPass, "'%s' doesn't exist", shadowDir)
+	case err != nil:
+		report.add("shadow "+disk.Name, DoctorFail, "can't read '%s': %v", shadowDir, err)
+	case len(entries) > 0:
+		report.add("shadow "+disk.Name, DoctorWarn, "'%s' contains %d leftovers of freeze, remove them by 'clickhouse-backup clean'", shadowDir, len(entries))
+	default:
+		report.add("shadow "+disk.Name, DoctorPass, "'%s' is empty", shadowDir)
+	}
+}
+
+func checkDoctorRemoteStorages(report *DoctorReport, config Config) {
+	targets, err := GetRemoteTargets(config, AllTargets)
+	if err != nil {
+		report.add("remote storage", DoctorFail, "%v", err)
+		return
+	}
+	for _, t := range targets {
+		name := "remote " + t.Name
+		bd, err := NewBackupDestination(t.Config)
+		if err != nil {
+			report.add(name, DoctorFail, "%v", err)
+			continue
+		}
+		if err := bd.Connect(); err != nil {
+			report.add(name, DoctorFail, "can't connect to remote storage '%s': %v", t.Config.General.RemoteStorage, err)
+			continue
+		}
+		backups, err := bd.BackupList()
+		bd.Close()
+		if err != nil {
+			report.add(name, DoctorFail, "can't list backups on '%s': %v", t.Config.General.RemoteStorage, err)
+			continue
+		}
+		report.add(name, DoctorPass, "'%s' is available, %d backups", t.Config.General.RemoteStorage, len(backups))
+	}
+}
@@ -0,0 +1,36 @@
+package chbackup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoctorShadow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	report := &DoctorReport{}
+	disk := Disk{Name: DefaultDisk, Path: dir}
+	checkDoctorShadow(report, disk)
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "shadow"), 0755))
+	checkDoctorShadow(report, disk)
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "shadow", "1"), 0755))
+	checkDoctorShadow(report, disk)
+	checkDoctorDisks(report, []Disk{disk})
+
+	assert.Equal(t, 4, report.Count(DoctorPass))
+	assert.Equal(t, 2, report.Count(DoctorWarn))
+	assert.Equal(t, 0, report.Count(DoctorFail))
+	assert.Equal(t, "shadow default", report.Checks[2].Name)
+	assert.Equal(t, DoctorWarn, report.Checks[2].Status)
+
+	var out bytes.Buffer
+	report.Print(&out)
+	assert.Contains(t, out.String(), "[WARN] shadow default")
+	assert.Contains(t, out.String(), "4 passed, 2 warnings, 0 failed\n")
+}
@@ -0,0 +1,109 @@
+package chbackup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	// ExitError - exit code of failure which doesn't belong to any class below
+	ExitError = 1
+	// ExitConfigError - config file can't be read or has wrong values
+	ExitConfigError = 3
+	// ExitLocked - another operation with the same backup or another operation of server is running
+	ExitLocked = 4
+	// ExitClickHouseUnavailable - ClickHouse can't be connected or its data path is unknown
+	ExitClickHouseUnavailable = 5
+	// ExitStorageError - remote storage can't be connected or transfer of backup failed
+	ExitStorageError = 6
+	// ExitPartialFailure - operation succeeded on some of remote targets or tables and failed on others
+	ExitPartialFailure = 7
+)
+
+// ExitCodesHelp - description of exit codes for help of CLI
+const ExitCodesHelp = `EXIT CODES:
+   0  success
+   1  error which doesn't belong to any class below
+   3  config file can't be read or has wrong values
+   4  another operation is running
+   5  ClickHouse is not available
+   6  remote storage is not available or transfer of backup failed
+   7  operation failed on some of remote targets or tables and succeeded on others
+   75 operation may succeed if it's repeated, only in kube mode
+`
+
+// exitCodeMessages - errors are wrapped with %v, so class of error is found by text of error message
+var exitCodeMessages = []struct {
+	code     int
+	messages []string
+}{
+	{ExitLocked, []string{"another operation is currently running", "another operation in progress", "is running"}},
+	{ExitClickHouseUnavailable, []string{"can't connect to clickhouse", ErrUnknownClickhouseDataPath.Error()}},
+	{ExitStorageError, []string{"can't connect to remote storage", "not found on remote storage", "can't upload", "can't download",
+		"can't get remote backups", "remote target '"}},
+}
+
+// PartialError - operation failed on some of remote targets and succeeded on others
+type PartialError struct {
+	Operation string
+	Total     int
+	Errors    []error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%s failed on %d of %d remote targets: %s", e.Operation, len(e.Errors), e.Total, joinErrors(e.Errors))
+}
+
+func joinErrors(errs []error) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// runOnTargets - run operation on every remote target even if it fails on some of them, PartialError is returned
+// when operation failed on some of targets
+func runOnTargets(operation string, targets []RemoteTarget, fn func(t RemoteTarget) error) error {
+	if len(targets) == 1 {
+		return fn(targets[0])
+	}
+	failed := []error{}
+	for _, t := range targets {
+		if err := fn(t); err != nil {
+			err = fmt.Errorf("remote target '%s': %v", t.Name, err)
+			log.Println(err)
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case len(targets):
+		return fmt.Errorf("%s failed on all remote targets: %s", operation, joinErrors(failed))
+	}
+	return &PartialError{Operation: operation, Total: len(targets), Errors: failed}
+}
+
+// ExitCode - exit code of CLI for class of err, 0 for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var partial *PartialError
+	var tablesErr *TablesError
+	if errors.As(err, &partial) || errors.As(err, &tablesErr) {
+		return ExitPartialFailure
+	}
+	message := err.Error()
+	for _, c := range exitCodeMessages {
+		for _, m := range c.messages {
+			if strings.Contains(message, m) {
+				return c.code
+			}
+		}
+	}
+	return ExitError
+}
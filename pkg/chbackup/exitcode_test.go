@@ -0,0 +1,44 @@
+package chbackup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, ExitError, ExitCode(fmt.Errorf("unknown consistency")))
+	assert.Equal(t, ExitLocked, ExitCode(fmt.Errorf("another upload of 'backup1' is running")))
+	assert.Equal(t, ExitClickHouseUnavailable, ExitCode(fmt.Errorf("can't connect to clickhouse: connection refused")))
+	assert.Equal(t, ExitClickHouseUnavailable, ExitCode(ErrUnknownClickhouseDataPath))
+	assert.Equal(t, ExitStorageError, ExitCode(fmt.Errorf("can't upload: RequestError: send request failed")))
+	assert.Equal(t, ExitPartialFailure, ExitCode(fmt.Errorf("upload: %w", &PartialError{Operation: "upload", Total: 2})))
+}
+
+func TestRunOnTargets(t *testing.T) {
+	targets := []RemoteTarget{{Name: "primary"}, {Name: "s3b"}}
+	calls := 0
+	err := runOnTargets("upload", targets, func(t RemoteTarget) error {
+		calls++
+		if t.Name == "primary" {
+			return fmt.Errorf("can't upload")
+		}
+		return nil
+	})
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, ExitPartialFailure, ExitCode(err))
+	assert.Equal(t, "upload failed on 1 of 2 remote targets: remote target 'primary': can't upload", err.Error())
+
+	err = runOnTargets("upload", targets, func(t RemoteTarget) error {
+		return fmt.Errorf("can't upload")
+	})
+	assert.Equal(t, ExitStorageError, ExitCode(err))
+	assert.Equal(t, "upload failed on all remote targets: remote target 'primary': can't upload; remote target 's3b': can't upload", err.Error())
+
+	err = runOnTargets("upload", targets[:1], func(t RemoteTarget) error {
+		return fmt.Errorf("can't upload")
+	})
+	assert.Equal(t, "can't upload", err.Error())
+}
@@ -0,0 +1,91 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// splitFileOwner - user and group of 'user[:group]', group is empty when it isn't set
+func splitFileOwner(owner string) (string, string, error) {
+	if owner == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(owner, ":")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return "", "", fmt.Errorf("general.restore_file_owner must be 'user:group', got '%s'", owner)
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// lookupFileOwner - uid and gid of 'user[:group]', user and group are names or numeric ids,
+// primary group of user is used when group isn't set
+func lookupFileOwner(owner string) (int, int, error) {
+	userName, groupName, err := splitFileOwner(owner)
+	if err != nil {
+		return 0, 0, err
+	}
+	primaryGroup := ""
+	uid, err := strconv.Atoi(userName)
+	if err != nil {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't find user '%s': %v", userName, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("can't parse uid of user '%s': %v", userName, err)
+		}
+		primaryGroup = u.Gid
+	} else if u, err := user.LookupId(userName); err == nil {
+		primaryGroup = u.Gid
+	}
+	if groupName == "" {
+		if primaryGroup == "" {
+			return 0, 0, fmt.Errorf("group of uid %d is unknown, set it as '%d:<group>'", uid, uid)
+		}
+		groupName = primaryGroup
+	}
+	gid, err := strconv.Atoi(groupName)
+	if err != nil {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't find group '%s': %v", groupName, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("can't parse gid of group '%s': %v", groupName, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// getRestoreFileOwner - uid and gid of general.restore_file_owner, error is returned when owner can't be found
+// or process can't give files to it
+func getRestoreFileOwner(owner string) (int, int, error) {
+	uid, gid, err := lookupFileOwner(owner)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := checkChangeOwner(uid, gid); err != nil {
+		return 0, 0, fmt.Errorf("owner of files can't be changed to %d:%d: %v", uid, gid, err)
+	}
+	return uid, gid, nil
+}
+
+// setRestoreFileOwner - files restored by ch are owned by general.restore_file_owner, it's ignored with warning
+// when ownership can't be changed, so files are owned by owner of ClickHouse data directory
+func setRestoreFileOwner(ch *ClickHouse, owner string) {
+	if owner == "" {
+		return
+	}
+	uid, gid, err := getRestoreFileOwner(owner)
+	if err != nil {
+		log.Printf("Warning: general.restore_file_owner '%s' is ignored: %v", owner, err)
+		return
+	}
+	ch.uid, ch.gid = &uid, &gid
+}
@@ -0,0 +1,34 @@
+package chbackup
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupFileOwner(t *testing.T) {
+	userName, groupName, err := splitFileOwner("clickhouse:clickhouse")
+	assert.NoError(t, err)
+	assert.Equal(t, "clickhouse", userName)
+	assert.Equal(t, "clickhouse", groupName)
+	for _, owner := range []string{"clickhouse:", ":clickhouse", "clickhouse:clickhouse:clickhouse"} {
+		_, _, err := splitFileOwner(owner)
+		assert.Error(t, err)
+	}
+
+	uid, gid, err := lookupFileOwner("54321:54322")
+	assert.NoError(t, err)
+	assert.Equal(t, 54321, uid)
+	assert.Equal(t, 54322, gid)
+	_, _, err = lookupFileOwner("54321")
+	assert.Error(t, err)
+	_, _, err = lookupFileOwner("clickhouse-backup-missing-user:0")
+	assert.Error(t, err)
+
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		assert.Error(t, checkChangeOwner(os.Geteuid()+1, os.Getegid()))
+		assert.NoError(t, checkChangeOwner(os.Geteuid(), os.Getegid()))
+	}
+}
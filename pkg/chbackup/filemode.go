@@ -0,0 +1,75 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// backupModes - general.backup_dir_mode and general.backup_file_mode of loaded config, they are 0 when they aren't set,
+// xattrs is general.preserve_xattrs
+var backupModes struct {
+	sync.RWMutex
+	dir    os.FileMode
+	file   os.FileMode
+	xattrs bool
+}
+
+// parseFileMode - octal mode like '0750', 0 is returned for empty string
+func parseFileMode(name, mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, fmt.Errorf("general.%s must be octal mode like '0750', got '%s'", name, mode)
+	}
+	return os.FileMode(v), nil
+}
+
+// setBackupModes - use modes and preserve_xattrs of config for directories and files created by next operations,
+// config is validated already
+func setBackupModes(general GeneralConfig) {
+	dir, _ := parseFileMode("backup_dir_mode", general.BackupDirMode)
+	file, _ := parseFileMode("backup_file_mode", general.BackupFileMode)
+	backupModes.Lock()
+	defer backupModes.Unlock()
+	backupModes.dir, backupModes.file, backupModes.xattrs = dir, file, general.PreserveXattrs
+}
+
+// dirMode - mode of created directories of backups and temporary files, defaultMode is used when backup_dir_mode isn't set
+func dirMode(defaultMode os.FileMode) os.FileMode {
+	backupModes.RLock()
+	defer backupModes.RUnlock()
+	if backupModes.dir != 0 {
+		return backupModes.dir
+	}
+	return defaultMode
+}
+
+// fileMode - mode of created files of backups, defaultMode is used when backup_file_mode isn't set
+func fileMode(defaultMode os.FileMode) os.FileMode {
+	backupModes.RLock()
+	defer backupModes.RUnlock()
+	if backupModes.file != 0 {
+		return backupModes.file
+	}
+	return defaultMode
+}
+
+// createFile - create or truncate file with mode of backup files
+func createFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode(0666))
+}
+
+// preserveXattrs - copy extended attributes of copied file or directory src to dst when general.preserve_xattrs is set
+func preserveXattrs(src, dst string) error {
+	backupModes.RLock()
+	enabled := backupModes.xattrs
+	backupModes.RUnlock()
+	if !enabled {
+		return nil
+	}
+	return copyXattrs(src, dst)
+}
@@ -0,0 +1,41 @@
+package chbackup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupModes(t *testing.T) {
+	mode, err := parseFileMode("backup_dir_mode", "0750")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), mode)
+	for _, mode := range []string{"750x", "0789", "01777"} {
+		_, err := parseFileMode("backup_dir_mode", mode)
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, os.ModePerm, dirMode(os.ModePerm))
+	setBackupModes(GeneralConfig{BackupDirMode: "0700", BackupFileMode: "0600"})
+	defer setBackupModes(GeneralConfig{})
+	assert.Equal(t, os.FileMode(0700), dirMode(os.ModePerm))
+	assert.Equal(t, os.FileMode(0600), fileMode(0644))
+
+	dir, err := ioutil.TempDir("", "modes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, extractArchiveFile(dir, "shadow/db/t/all_1_1_0/data.bin", bytes.NewReader([]byte("data"))))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path.Join(dir, "shadow/db/t/all_1_1_0/data.bin"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		info, err = os.Stat(path.Join(dir, "shadow/db/t/all_1_1_0"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
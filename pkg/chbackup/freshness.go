@@ -0,0 +1,247 @@
+package chbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// freshnessFileName - the last backups of tables in backup directory, it's hidden file, so it isn't listed as backup
+const freshnessFileName = ".table_freshness.json"
+
+const (
+	// freshnessLockRetries - how many times lock of freshness file held by another process is checked, it's held shortly by update
+	freshnessLockRetries = 50
+	freshnessLockDelay   = 100 * time.Millisecond
+)
+
+// freshnessLock - serialize updates of freshness by creates of this process, lock file is reentrant inside the process,
+// so it serializes only updates of different processes
+var freshnessLock sync.Mutex
+
+// TableFreshness - the last local backup created with table, LastBackup is empty when table was never backed up
+type TableFreshness struct {
+	Database       string     `json:"database"`
+	Table          string     `json:"table"`
+	LastBackup     string     `json:"last_backup,omitempty"`
+	LastBackupDate *time.Time `json:"last_backup_date,omitempty"`
+}
+
+// readTableFreshness - read the last backups of tables by '<database>.<table>', it's empty before the first backup
+func readTableFreshness(dataPath string) (map[string]TableFreshness, error) {
+	freshness := map[string]TableFreshness{}
+	content, err := ioutil.ReadFile(path.Join(dataPath, "backup", freshnessFileName))
+	if os.IsNotExist(err) {
+		return freshness, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read freshness of tables: %v", err)
+	}
+	var tables []TableFreshness
+	if err := json.Unmarshal(content, &tables); err != nil {
+		return nil, fmt.Errorf("can't parse '%s': %v", freshnessFileName, err)
+	}
+	for _, t := range tables {
+		freshness[t.Database+"."+t.Table] = t
+	}
+	return freshness, nil
+}
+
+// updateTableFreshness - save created backup as the last backup of its tables, tables left out by --continue-on-error
+// keep their previous backup. Dropped tables stay in the file, they aren't reported because they aren't in ClickHouse
+func updateTableFreshness(dataPath string, metadata BackupMetadata) error {
+	tables, err := parseSchemaPattern(path.Join(dataPath, "backup", metadata.BackupName, "metadata"), "")
+	if err != nil {
+		return fmt.Errorf("can't read tables of backup: %v", err)
+	}
+	failed := map[string]bool{}
+	for _, t := range metadata.FailedTables {
+		failed[t.Database+"."+t.Table] = true
+	}
+	freshnessLock.Lock()
+	defer freshnessLock.Unlock()
+	release, err := lockFreshness(dataPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	freshness, err := readTableFreshness(dataPath)
+	if err != nil {
+		return err
+	}
+	date := metadata.CreationDate
+	for _, t := range tables {
+		if failed[t.Database+"."+t.Table] {
+			continue
+		}
+		freshness[t.Database+"."+t.Table] = TableFreshness{
+			Database:       t.Database,
+			Table:          t.Table,
+			LastBackup:     metadata.BackupName,
+			LastBackupDate: &date,
+		}
+	}
+	result := make([]TableFreshness, 0, len(freshness))
+	for _, t := range freshness {
+		result = append(result, t)
+	}
+	sortTableFreshness(result)
+	content, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		return err
+	}
+	// file is replaced by rename, so reader never sees partial file
+	return writeFreshnessFile(path.Join(dataPath, "backup", freshnessFileName), content)
+}
+
+// lockFreshness - lock freshness file exclusively against updates of other processes and other operations of server,
+// lock is waited for a while
+func lockFreshness(dataPath string) (func(), error) {
+	lockName := path.Join(dataPath, "backup", freshnessFileName+".lock")
+	for i := 0; ; i++ {
+		release, err := lockFile(lockName, true, "", "")
+		if err == nil {
+			return release, nil
+		}
+		if i >= freshnessLockRetries {
+			return nil, fmt.Errorf("can't lock freshness of tables: %v", err)
+		}
+		time.Sleep(freshnessLockDelay)
+	}
+}
+
+// writeFreshnessFile - write content to unique temporary file next to fileName and rename it to fileName
+func writeFreshnessFile(fileName string, content []byte) error {
+	f, err := ioutil.TempFile(path.Dir(fileName), path.Base(fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't write freshness of tables: %v", err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0640)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("can't write freshness of tables: %v", err)
+	}
+	return nil
+}
+
+// GetTableFreshness - the last backups of tables of ClickHouse, tables matched by clickhouse.skip_tables are left out,
+// so table which is missed by table pattern of backups is returned without backup
+func GetTableFreshness(ctx context.Context, config Config) ([]TableFreshness, error) {
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return nil, ErrUnknownClickhouseDataPath
+	}
+	ch := &ClickHouse{
+		Config: &config.ClickHouse,
+		ctx:    ctx,
+	}
+	if err := ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+	tables, err := ch.GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("can't get tables: %v", err)
+	}
+	freshness, err := readTableFreshness(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	return joinTableFreshness(tables, freshness), nil
+}
+
+// joinTableFreshness - freshness of every table which isn't skipped
+func joinTableFreshness(tables []Table, freshness map[string]TableFreshness) []TableFreshness {
+	result := []TableFreshness{}
+	for _, t := range tables {
+		if t.Skip {
+			continue
+		}
+		if f, ok := freshness[t.Database+"."+t.Name]; ok {
+			result = append(result, f)
+			continue
+		}
+		result = append(result, TableFreshness{Database: t.Database, Table: t.Name})
+	}
+	sortTableFreshness(result)
+	return result
+}
+
+func sortTableFreshness(tables []TableFreshness) {
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Database != tables[j].Database {
+			return tables[i].Database < tables[j].Database
+		}
+		return tables[i].Table < tables[j].Table
+	})
+}
+
+// freshnessStats - prometheus.Collector of age of the last backup of every table, it's refreshed with metrics of backups.
+// Metrics are exported after tables are checked once
+type freshnessStats struct {
+	mu      sync.Mutex
+	tables  []TableFreshness
+	checked bool
+	now     func() time.Time
+
+	ageDesc *prometheus.Desc
+}
+
+func newFreshnessStats() *freshnessStats {
+	return &freshnessStats{
+		now: time.Now,
+		ageDesc: prometheus.NewDesc("clickhouse_backup_table_last_backup_age_seconds",
+			"Age of the last local backup created with table, +Inf when table was never backed up.", []string{"database", "table"}, nil),
+	}
+}
+
+// refresh - read freshness of tables, previous values are kept when it fails
+func (s *freshnessStats) refresh(config Config) error {
+	tables, err := GetTableFreshness(context.Background(), config)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables, s.checked = tables, true
+	return nil
+}
+
+// Describe - implements prometheus.Collector
+func (s *freshnessStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.ageDesc
+}
+
+// Collect - implements prometheus.Collector
+func (s *freshnessStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checked {
+		return
+	}
+	for _, t := range s.tables {
+		age := math.Inf(1)
+		if t.LastBackupDate != nil {
+			age = s.now().Sub(*t.LastBackupDate).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(s.ageDesc, prometheus.GaugeValue, age, t.Database, t.Table)
+	}
+}
@@ -0,0 +1,72 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableFreshness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freshness")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	createBackup := func(name string, date time.Time, tables ...string) BackupMetadata {
+		for _, table := range tables {
+			metadataPath := path.Join(dir, "backup", name, "metadata", "db")
+			assert.NoError(t, os.MkdirAll(metadataPath, 0755))
+			assert.NoError(t, ioutil.WriteFile(path.Join(metadataPath, table+".sql"), []byte("ATTACH TABLE db."+table), 0644))
+		}
+		return BackupMetadata{BackupName: name, CreationDate: date}
+	}
+	day := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, updateTableFreshness(dir, createBackup("daily1", day, "events", "users")))
+	// failed table keeps its previous backup
+	daily2 := createBackup("daily2", day.AddDate(0, 0, 1), "events", "users")
+	daily2.FailedTables = []TableStatus{{Database: "db", Table: "users", Status: "failed"}}
+	assert.NoError(t, updateTableFreshness(dir, daily2))
+
+	freshness, err := readTableFreshness(dir)
+	assert.NoError(t, err)
+	tables := joinTableFreshness([]Table{
+		{Database: "db", Name: "users"},
+		{Database: "db", Name: "events"},
+		{Database: "db", Name: "logs"},
+		{Database: "system", Name: "query_log", Skip: true},
+	}, freshness)
+	assert.Len(t, tables, 3)
+	assert.Equal(t, "events", tables[0].Table)
+	assert.Equal(t, "daily2", tables[0].LastBackup)
+	assert.Equal(t, "logs", tables[1].Table)
+	assert.Equal(t, "", tables[1].LastBackup)
+	assert.Nil(t, tables[1].LastBackupDate)
+	assert.Equal(t, "daily1", tables[2].LastBackup)
+	assert.Equal(t, day, *tables[2].LastBackupDate)
+}
+
+func TestUpdateTableFreshnessLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freshness")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	metadataPath := path.Join(dir, "backup", "daily1", "metadata", "db")
+	assert.NoError(t, os.MkdirAll(metadataPath, 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(metadataPath, "events.sql"), []byte("ATTACH TABLE db.events"), 0644))
+	// lock of another process is held by separately opened file
+	f, err := os.OpenFile(path.Join(dir, "backup", freshnessFileName+".lock"), os.O_RDWR|os.O_CREATE, 0640)
+	assert.NoError(t, err)
+	assert.NoError(t, tryLockFile(f, true))
+	time.AfterFunc(3*freshnessLockDelay, func() { f.Close() })
+	assert.NoError(t, updateTableFreshness(dir, BackupMetadata{BackupName: "daily1", CreationDate: time.Now()}))
+
+	freshness, err := readTableFreshness(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "daily1", freshness["db.events"].LastBackup)
+	files, err := ioutil.ReadDir(path.Join(dir, "backup"))
+	assert.NoError(t, err)
+	for _, file := range files {
+		assert.NotContains(t, file.Name(), ".tmp")
+	}
+}
@@ -0,0 +1,100 @@
+// +build !windows
+
+package chbackup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// errCrossDevice - error of hard link between different filesystems
+const errCrossDevice = syscall.EXDEV
+
+// tryLockFile - lock whole file without waiting, errFileLocked is returned when another process holds the lock
+func tryLockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return errFileLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// fileOwner - uid and gid of owner of file
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// checkChangeOwner - check that process can change owner of files to uid and gid, root and processes with CAP_CHOWN
+// can give files to anyone, other processes only to themselves and their groups
+func checkChangeOwner(uid, gid int) error {
+	if os.Geteuid() == 0 || hasChownCapability() {
+		return nil
+	}
+	if uid != os.Geteuid() {
+		return fmt.Errorf("process runs as uid %d without root or CAP_CHOWN", os.Geteuid())
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	for _, g := range append(groups, os.Getegid()) {
+		if g == gid {
+			return nil
+		}
+	}
+	return fmt.Errorf("process isn't member of group %d and has no CAP_CHOWN", gid)
+}
+
+// hasChownCapability - CAP_CHOWN is in effective capabilities of process, they are read from /proc on Linux only
+func hasChownCapability() bool {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			capabilities, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+			// CAP_CHOWN is capability 0
+			return err == nil && capabilities&1 != 0
+		}
+	}
+	return false
+}
+
+// isSameDevice - check that both paths are located on the same filesystem
+func isSameDevice(path1 string, path2 string) bool {
+	info1, err := os.Stat(nearestExistingPath(path1))
+	if err != nil {
+		return false
+	}
+	info2, err := os.Stat(nearestExistingPath(path2))
+	if err != nil {
+		return false
+	}
+	stat1, ok1 := info1.Sys().(*syscall.Stat_t)
+	stat2, ok2 := info2.Sys().(*syscall.Stat_t)
+	return ok1 && ok2 && stat1.Dev == stat2.Dev
+}
+
+// getFreeSpace - return number of bytes available on filesystem where path is located
+func getFreeSpace(p string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(nearestExistingPath(p), &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
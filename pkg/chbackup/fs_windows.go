@@ -0,0 +1,66 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// errCrossDevice - error of hard link between different volumes
+const errCrossDevice = windows.ERROR_NOT_SAME_DEVICE
+
+// tryLockFile - lock file without waiting, errFileLocked is returned when another process holds the lock.
+// Locks of Windows are mandatory, so the byte far beyond the end of file is locked and content of file stays readable
+func tryLockFile(f *os.File, exclusive bool) error {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	overlapped := &windows.Overlapped{Offset: 0, OffsetHigh: 0x7fffffff}
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, overlapped); err != nil {
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return errFileLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// fileOwner - owners of files aren't changed on Windows, ClickHouse reads files created by any user
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// checkChangeOwner - owners of files aren't changed on Windows
+func checkChangeOwner(uid, gid int) error {
+	return fmt.Errorf("owners of files aren't changed on Windows")
+}
+
+// isSameDevice - check that both paths are located on the same volume
+func isSameDevice(path1 string, path2 string) bool {
+	volume1, err := filepath.Abs(nearestExistingPath(path1))
+	if err != nil {
+		return false
+	}
+	volume2, err := filepath.Abs(nearestExistingPath(path2))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(filepath.VolumeName(volume1), filepath.VolumeName(volume2))
+}
+
+// getFreeSpace - return number of bytes available for current user on volume where path is located
+func getFreeSpace(p string) (int64, error) {
+	name, err := windows.UTF16PtrFromString(nearestExistingPath(p))
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &free, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return int64(free), nil
+}
@@ -3,6 +3,8 @@ package chbackup
 import (
 	"crypto/tls"
 	"io"
+	"log"
+	"net"
 	"os"
 	"path"
 	"time"
@@ -10,16 +12,44 @@ import (
 	"github.com/jlaffaye/ftp"
 )
 
+const (
+	// ftpResumeRetries - how many times interrupted download will be resumed by REST command
+	ftpResumeRetries = 5
+)
+
 type FTP struct {
-	client *ftp.ServerConn
+	clients chan *ftp.ServerConn
+	// slots - semaphore of open connections capped at concurrency, idle connections in pool hold their slots too
+	slots  chan struct{}
 	Config *FTPConfig
 }
 
+// Connect - create pool of connections and check settings, pool of connected storage is kept, so idle connections aren't leaked
 func (f *FTP) Connect() error {
-	timeout, err := time.ParseDuration(f.Config.Timeout)
+	if f.clients != nil {
+		return nil
+	}
+	concurrency := f.Config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	f.clients = make(chan *ftp.ServerConn, concurrency)
+	f.slots = make(chan struct{}, concurrency)
+	// check settings and credentials before any work
+	c, err := f.getConnection()
 	if err != nil {
 		return err
 	}
+	f.putConnection(c)
+	return nil
+}
+
+// dial - open new connection to FTP server
+func (f *FTP) dial() (*ftp.ServerConn, error) {
+	timeout, err := time.ParseDuration(f.Config.Timeout)
+	if err != nil {
+		return nil, err
+	}
 
 	options := make([]ftp.DialOption, 0)
 
@@ -31,33 +61,97 @@ func (f *FTP) Connect() error {
 	}
 
 	if f.Config.TLS {
-		tlsConfig := tls.Config{}
-		options = append(options, ftp.DialWithTLS(&tlsConfig))
+		host, _, err := net.SplitHostPort(f.Config.Address)
+		if err != nil {
+			host = f.Config.Address
+		}
+		tlsConfig := tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: f.Config.DisableCertVerification,
+		}
+		if f.Config.TLSExplicit {
+			options = append(options, ftp.DialWithExplicitTLS(&tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithTLS(&tlsConfig))
+		}
 	}
 
 	c, err := ftp.Dial(f.Config.Address, options...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := c.Login(f.Config.Username, f.Config.Password); err != nil {
-		return err
+		c.Quit()
+		return nil, err
 	}
+	return c, nil
+}
 
-	f.client = c
+// getConnection - return idle connection from pool or open new one, it waits while concurrency connections are in use
+func (f *FTP) getConnection() (*ftp.ServerConn, error) {
+	select {
+	case c := <-f.clients:
+		return c, nil
+	default:
+	}
+	select {
+	case c := <-f.clients:
+		return c, nil
+	case f.slots <- struct{}{}:
+	}
+	c, err := f.dial()
+	if err != nil {
+		<-f.slots
+		return nil, err
+	}
+	return c, nil
+}
 
-	return nil
+// putConnection - return connection to pool, connection is closed if pool is full
+func (f *FTP) putConnection(c *ftp.ServerConn) {
+	select {
+	case f.clients <- c:
+	default:
+		f.dropConnection(c)
+	}
+}
+
+// dropConnection - close connection in unknown state instead of returning it to pool and release its slot
+func (f *FTP) dropConnection(c *ftp.ServerConn) {
+	if c != nil {
+		c.Quit()
+	}
+	<-f.slots
 }
 
 func (f *FTP) Kind() string {
 	return "FTP"
 }
 
+// Close - close all idle connections in pool
+func (f *FTP) Close() error {
+	for {
+		select {
+		case c := <-f.clients:
+			f.dropConnection(c)
+		default:
+			return nil
+		}
+	}
+}
+
 func (f *FTP) GetFile(key string) (RemoteFile, error) {
+	c, err := f.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer f.putConnection(c)
+
 	// cant list files, so check the dir
 	dir := path.Dir(key)
 
-	entries, err := f.client.List(dir)
+	entries, err := c.List(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -79,14 +173,21 @@ func (f *FTP) GetFile(key string) (RemoteFile, error) {
 }
 
 func (f *FTP) DeleteFile(key string) error {
-	if err := f.client.Delete(key); err != nil {
+	c, err := f.getConnection()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer f.putConnection(c)
+	return c.Delete(key)
 }
 
 func (f *FTP) Walk(root string, process func(RemoteFile)) error {
-	walker := f.client.Walk(root)
+	c, err := f.getConnection()
+	if err != nil {
+		return err
+	}
+	defer f.putConnection(c)
+	walker := c.Walk(root)
 
 	for walker.Next() {
 		if err := walker.Err(); err != nil {
@@ -110,11 +211,96 @@ func (f *FTP) Walk(root string, process func(RemoteFile)) error {
 }
 
 func (f *FTP) GetFileReader(key string) (io.ReadCloser, error) {
-	return f.client.Retr(key)
+	c, err := f.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Retr(key)
+	if err != nil {
+		f.putConnection(c)
+		return nil, err
+	}
+	return &ftpFileReader{
+		ftp:      f,
+		client:   c,
+		response: resp,
+		key:      key,
+	}, nil
 }
 
 func (f *FTP) PutFile(key string, r io.ReadCloser) error {
-	return f.client.Stor(key, r)
+	c, err := f.getConnection()
+	if err != nil {
+		return err
+	}
+	defer f.putConnection(c)
+	return c.Stor(key, r)
+}
+
+// ftpFileReader - hold connection while file is read and resume interrupted download from current offset by REST command
+type ftpFileReader struct {
+	ftp      *FTP
+	client   *ftp.ServerConn
+	response *ftp.Response
+	key      string
+	offset   uint64
+	retries  int
+	// err - error of failed download, its connection is in unknown state, so it's closed instead of returning to pool
+	err    error
+	closed bool
+}
+
+func (r *ftpFileReader) Read(p []byte) (int, error) {
+	if r.response == nil {
+		return 0, r.err
+	}
+	n, err := r.response.Read(p)
+	r.offset += uint64(n)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	r.err = err
+	if r.retries >= ftpResumeRetries {
+		return n, err
+	}
+	r.retries++
+	log.Printf("FTP download of '%s' interrupted at %d bytes: %v, resuming", r.key, r.offset, err)
+	r.response.Close()
+	r.client.Quit()
+	// old connection is closed, so only its slot is released by Close when resume fails
+	r.client, r.response = nil, nil
+	c, dialErr := r.ftp.dial()
+	if dialErr != nil {
+		return n, err
+	}
+	resp, retrErr := c.RetrFrom(r.key, r.offset)
+	if retrErr != nil {
+		c.Quit()
+		return n, err
+	}
+	r.err = nil
+	r.client = c
+	r.response = resp
+	return n, nil
+}
+
+// Close - return connection to pool, connection of failed download is closed and its slot is released
+func (r *ftpFileReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	var err error
+	if r.response != nil {
+		err = r.response.Close()
+	}
+	if r.err != nil || r.client == nil {
+		r.ftp.dropConnection(r.client)
+	} else {
+		r.ftp.putConnection(r.client)
+	}
+	r.client, r.response = nil, nil
+	return err
 }
 
 type ftpFile struct {
@@ -0,0 +1,135 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getOrphanedObjects - return names of objects in root of remote path which don't belong to any backup.
+// Objects left by interrupted upload or delete are: chunks of archive which is stored as one file,
+// chunks which can't be read because previous chunk is missing and schema archives without backup.
+// Manifests without archive are kept, they are listed as broken backups and removed by 'delete'.
+func getOrphanedObjects(names []string) []string {
+	archives := map[string]bool{}
+	manifests := map[string]bool{}
+	chunks := map[string]map[int]string{}
+	for _, name := range names {
+		if isArchiveName(name) {
+			archives[name] = true
+		}
+		if strings.HasSuffix(name, manifestSuffix) && isArchiveName(strings.TrimSuffix(name, manifestSuffix)) {
+			manifests[strings.TrimSuffix(name, manifestSuffix)] = true
+		}
+		if archiveName, ok := archiveChunkName(name); ok {
+			n, err := strconv.Atoi(strings.TrimPrefix(name, archiveName+"."))
+			if err != nil {
+				continue
+			}
+			if chunks[archiveName] == nil {
+				chunks[archiveName] = map[int]string{}
+			}
+			chunks[archiveName][n] = name
+		}
+	}
+	orphaned := []string{}
+	for archiveName, archiveChunks := range chunks {
+		readable := 0
+		if !archives[archiveName] {
+			for archiveChunks[readable+1] != "" {
+				readable++
+			}
+		}
+		for n, name := range archiveChunks {
+			if n > readable {
+				orphaned = append(orphaned, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, schemaSuffix) {
+			continue
+		}
+		archiveName := strings.TrimSuffix(name, schemaSuffix)
+		if !isArchiveName(archiveName) || archives[archiveName] || manifests[archiveName] || chunks[archiveName][1] != "" {
+			continue
+		}
+		orphaned = append(orphaned, name)
+	}
+	sort.Strings(orphaned)
+	return orphaned
+}
+
+// RemoveOrphanedObjects - remove objects which don't belong to any backup, return keys of removed objects
+func (bd *BackupDestination) RemoveOrphanedObjects(dryRun bool) ([]string, error) {
+	names := []string{}
+	if err := bd.Walk(bd.path, func(f RemoteFile) {
+		name := strings.TrimPrefix(strings.TrimPrefix(f.Name(), bd.path), "/")
+		if name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, name := range getOrphanedObjects(names) {
+		key := path.Join(bd.path, name)
+		if !dryRun {
+			if err := bd.checkFileLocks([]string{key}); err != nil {
+				if _, ok := err.(*ObjectLockedError); ok {
+					log.Printf("Skip %v", err)
+					continue
+				}
+				return removed, err
+			}
+			if err := bd.DeleteFile(key); err != nil {
+				return removed, fmt.Errorf("can't remove '%s': %v", name, err)
+			}
+		}
+		removed = append(removed, key)
+	}
+	return removed, nil
+}
+
+// GarbageCollectRemote - remove objects which don't belong to any backup from remote storages selected by target
+func GarbageCollectRemote(config Config, target string, dryRun bool) error {
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			fmt.Println("GarbageCollectRemote aborted: RemoteStorage set to \"none\"")
+			continue
+		}
+		bd, err := NewBackupDestination(t.Config)
+		if err != nil {
+			return err
+		}
+		if err := bd.Connect(); err != nil {
+			return fmt.Errorf("can't connect to remote storage: %v", err)
+		}
+		removed, err := bd.RemoveOrphanedObjects(dryRun)
+		bd.Close()
+		for _, key := range removed {
+			if dryRun {
+				log.Printf("Orphaned object '%s' would be removed", key)
+			} else {
+				log.Printf("Orphaned object '%s' removed", key)
+			}
+		}
+		if err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("remote target '%s': %v", t.Name, err)
+			}
+			return err
+		}
+		if len(removed) == 0 {
+			log.Printf("Remote target '%s' has no orphaned objects", t.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrphanedObjects(t *testing.T) {
+	names := []string{
+		"single.tar.gz", "single.tar.gz.json", "single.tar.gz.schema", "single.tar.gz.001",
+		"chunked.tar.gz.001", "chunked.tar.gz.002", "chunked.tar.gz.004", "chunked.tar.gz.json", "chunked.tar.gz.schema",
+		"unreadable.tar.gz.002", "unreadable.tar.gz.schema",
+		"broken.tar.gz.json", "broken.tar.gz.schema",
+		"deleted.tar.lz4.schema",
+		"legacy", "notes.txt",
+	}
+	assert.Equal(t, []string{
+		"chunked.tar.gz.004",
+		"deleted.tar.lz4.schema",
+		"single.tar.gz.001",
+		"unreadable.tar.gz.002",
+		"unreadable.tar.gz.schema",
+	}, getOrphanedObjects(names))
+	assert.Equal(t, []string{}, getOrphanedObjects([]string{"backup.tar.gz", "backup.tar.gz.json"}))
+}
@@ -2,6 +2,7 @@ package chbackup
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
 
@@ -14,6 +15,8 @@ import (
 type GCS struct {
 	client *storage.Client
 	Config *GCSConfig
+	// lowMemory - upload by smaller chunks, general.low_memory
+	lowMemory bool
 }
 
 // Connect - connect to GCS
@@ -55,6 +58,13 @@ func (gcs *GCS) Kind() string {
 	return "GCS"
 }
 
+func (gcs *GCS) Close() error {
+	if gcs.client == nil {
+		return nil
+	}
+	return gcs.client.Close()
+}
+
 func (gcs *GCS) GetFileReader(key string) (io.ReadCloser, error) {
 	ctx := context.Background()
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
@@ -68,13 +78,27 @@ func (gcs *GCS) GetFileReader(key string) (io.ReadCloser, error) {
 func (gcs *GCS) GetFileWriter(key string) io.WriteCloser {
 	ctx := context.Background()
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
-	return obj.NewWriter(ctx)
+	return gcs.newWriter(ctx, obj)
+}
+
+// newWriter - writer of object, chunk of upload is kept in memory until it's sent
+func (gcs *GCS) newWriter(ctx context.Context, obj *storage.ObjectHandle) *storage.Writer {
+	writer := obj.NewWriter(ctx)
+	if gcs.lowMemory {
+		writer.ChunkSize = lowMemoryPartSize
+	}
+	return writer
 }
 
 func (gcs *GCS) PutFile(key string, r io.ReadCloser) error {
 	ctx := context.Background()
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
-	writer := obj.NewWriter(ctx)
+	writer := gcs.newWriter(ctx, obj)
+	// manifest is rewritten when upload is finished, so it's held by HoldFile after that
+	if !isManifestKey(key) {
+		writer.TemporaryHold = gcs.Config.TemporaryHold
+		writer.EventBasedHold = gcs.Config.EventBasedHold
+	}
 	if _, err := io.Copy(writer, r); err != nil {
 		return err
 	}
@@ -99,6 +123,41 @@ func (gcs *GCS) DeleteFile(key string) error {
 	return object.Delete(ctx)
 }
 
+// HoldFile - put configured holds on uploaded object
+func (gcs *GCS) HoldFile(key string) error {
+	if !gcs.Config.TemporaryHold && !gcs.Config.EventBasedHold {
+		return nil
+	}
+	ctx := context.Background()
+	attrs := storage.ObjectAttrsToUpdate{}
+	if gcs.Config.TemporaryHold {
+		attrs.TemporaryHold = true
+	}
+	if gcs.Config.EventBasedHold {
+		attrs.EventBasedHold = true
+	}
+	_, err := gcs.client.Bucket(gcs.Config.Bucket).Object(key).Update(ctx, attrs)
+	return err
+}
+
+// GetFileLock - get holds of object and retention of locked bucket
+func (gcs *GCS) GetFileLock(key string) (string, error) {
+	ctx := context.Background()
+	objAttr, err := gcs.client.Bucket(gcs.Config.Bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case objAttr.TemporaryHold:
+		return "temporary hold", nil
+	case objAttr.EventBasedHold:
+		return "event-based hold", nil
+	case objAttr.RetentionExpirationTime.After(time.Now()):
+		return fmt.Sprintf("retention policy until %s", objAttr.RetentionExpirationTime.Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
+
 type gcsFile struct {
 	objAttr *storage.ObjectAttrs
 }
@@ -0,0 +1,162 @@
+package chbackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// HookStatusSuccess, HookStatusError - status of operation passed to after hooks
+	HookStatusSuccess = "success"
+	HookStatusError   = "error"
+)
+
+// HooksConfig - hooks section, every hook is a list of shell commands or http(s) URLs called one after another
+type HooksConfig struct {
+	BeforeCreate   []string `yaml:"before_create" envconfig:"HOOKS_BEFORE_CREATE"`
+	AfterCreate    []string `yaml:"after_create" envconfig:"HOOKS_AFTER_CREATE"`
+	BeforeUpload   []string `yaml:"before_upload" envconfig:"HOOKS_BEFORE_UPLOAD"`
+	AfterUpload    []string `yaml:"after_upload" envconfig:"HOOKS_AFTER_UPLOAD"`
+	BeforeDownload []string `yaml:"before_download" envconfig:"HOOKS_BEFORE_DOWNLOAD"`
+	AfterDownload  []string `yaml:"after_download" envconfig:"HOOKS_AFTER_DOWNLOAD"`
+	BeforeRestore  []string `yaml:"before_restore" envconfig:"HOOKS_BEFORE_RESTORE"`
+	AfterRestore   []string `yaml:"after_restore" envconfig:"HOOKS_AFTER_RESTORE"`
+	BeforeDelete   []string `yaml:"before_delete" envconfig:"HOOKS_BEFORE_DELETE"`
+	AfterDelete    []string `yaml:"after_delete" envconfig:"HOOKS_AFTER_DELETE"`
+	// Timeout - how long one command or request may run
+	Timeout string `yaml:"timeout" envconfig:"HOOKS_TIMEOUT"`
+}
+
+// get - return before and after hooks of operation
+func (h HooksConfig) get(operation string) ([]string, []string) {
+	switch operation {
+	case "create":
+		return h.BeforeCreate, h.AfterCreate
+	case "upload":
+		return h.BeforeUpload, h.AfterUpload
+	case "download":
+		return h.BeforeDownload, h.AfterDownload
+	case "restore":
+		return h.BeforeRestore, h.AfterRestore
+	case "delete":
+		return h.BeforeDelete, h.AfterDelete
+	}
+	return nil, nil
+}
+
+// HookEvent - payload of http hooks, the same values are passed to commands as CLICKHOUSE_BACKUP_* environment variables
+type HookEvent struct {
+	// Hook - name of hook like 'before_create' or 'after_upload'
+	Hook       string `json:"hook"`
+	Operation  string `json:"operation"`
+	BackupName string `json:"backup_name"`
+	// Location - 'local' or 'remote', it's set for delete only
+	Location string `json:"location,omitempty"`
+	// Target - remote target of upload and remote delete
+	Target string `json:"target,omitempty"`
+	// Status - HookStatusSuccess or HookStatusError, it's empty for before hooks
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (e HookEvent) env() []string {
+	return append(os.Environ(),
+		"CLICKHOUSE_BACKUP_HOOK="+e.Hook,
+		"CLICKHOUSE_BACKUP_OPERATION="+e.Operation,
+		"CLICKHOUSE_BACKUP_NAME="+e.BackupName,
+		"CLICKHOUSE_BACKUP_LOCATION="+e.Location,
+		"CLICKHOUSE_BACKUP_TARGET="+e.Target,
+		"CLICKHOUSE_BACKUP_STATUS="+e.Status,
+		"CLICKHOUSE_BACKUP_ERROR="+e.Error,
+	)
+}
+
+func isHookURL(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+// runHook - run shell command or POST event as JSON to URL, command must exit with 0 and URL must respond with 2xx
+func runHook(hook string, event HookEvent, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if isHookURL(hook) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, hook, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Env = event.env()
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Printf("%s: %s", event.Hook, strings.TrimSpace(string(out)))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout %s exceeded", timeout)
+	}
+	return err
+}
+
+func runHooks(hooks []string, event HookEvent, timeout time.Duration) error {
+	for _, hook := range hooks {
+		log.Printf("Run %s hook '%s'", event.Hook, hook)
+		if err := runHook(hook, event, timeout); err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %v", event.Hook, hook, err)
+		}
+	}
+	return nil
+}
+
+// startHooks - run before hooks of operation, operation must not be started when they fail.
+// Returned function runs after hooks with result of operation and returns error of operation or error of after hook
+func startHooks(config Config, event HookEvent) (func(error) error, error) {
+	before, after := config.Hooks.get(event.Operation)
+	timeout, _ := time.ParseDuration(config.Hooks.Timeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	event.Hook = "before_" + event.Operation
+	if err := runHooks(before, event, timeout); err != nil {
+		return nil, err
+	}
+	return func(err error) error {
+		afterEvent := event
+		afterEvent.Hook = "after_" + event.Operation
+		afterEvent.Status = HookStatusSuccess
+		if err != nil {
+			afterEvent.Status, afterEvent.Error = HookStatusError, err.Error()
+		}
+		if hookErr := runHooks(after, afterEvent, timeout); hookErr != nil {
+			if err != nil {
+				log.Println(hookErr)
+				return err
+			}
+			return hookErr
+		}
+		return err
+	}, nil
+}
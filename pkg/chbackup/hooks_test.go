@@ -0,0 +1,55 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	events := []HookEvent{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := HookEvent{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events = append(events, event)
+	}))
+	defer server.Close()
+	out := path.Join(dir, "out")
+	config := Config{Hooks: HooksConfig{
+		BeforeUpload: []string{fmt.Sprintf("echo \"$CLICKHOUSE_BACKUP_HOOK $CLICKHOUSE_BACKUP_NAME $CLICKHOUSE_BACKUP_TARGET\" > %s", out)},
+		AfterUpload:  []string{server.URL},
+		Timeout:      "1m",
+	}}
+
+	finishHooks, err := startHooks(config, HookEvent{Operation: "upload", BackupName: "backup", Target: "all"})
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "before_upload backup all\n", string(content))
+	assert.Empty(t, events)
+	assert.EqualError(t, finishHooks(fmt.Errorf("failed")), "failed")
+	assert.NoError(t, finishHooks(nil))
+	assert.Equal(t, []HookEvent{
+		{Hook: "after_upload", Operation: "upload", BackupName: "backup", Target: "all", Status: HookStatusError, Error: "failed"},
+		{Hook: "after_upload", Operation: "upload", BackupName: "backup", Target: "all", Status: HookStatusSuccess},
+	}, events)
+
+	// operation isn't started when before hook fails, failed after hook fails successful operation
+	config.Hooks.BeforeUpload = []string{"exit 1"}
+	_, err = startHooks(config, HookEvent{Operation: "upload", BackupName: "backup"})
+	assert.Error(t, err)
+	config.Hooks.AfterCreate = []string{"false"}
+	finishHooks, err = startHooks(config, HookEvent{Operation: "create", BackupName: "backup"})
+	assert.NoError(t, err)
+	assert.Error(t, finishHooks(nil))
+}
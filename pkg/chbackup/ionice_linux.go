@@ -0,0 +1,41 @@
+package chbackup
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	// ioprioLowestBE - the lowest priority inside best-effort class
+	ioprioLowestBE = 7
+)
+
+// withIOPriority - call fn in OS thread with IO scheduling class ionice, previous class of thread is restored after it.
+// IO priority is set per thread on Linux, so it doesn't affect other operations running at the same time
+func withIOPriority(ionice string, fn func() error) error {
+	var ioprio uintptr
+	switch ionice {
+	case IONiceIdle:
+		ioprio = ioprioClassIdle << ioprioClassShift
+	case IONiceBestEffort:
+		ioprio = ioprioClassBE<<ioprioClassShift | ioprioLowestBE
+	default:
+		return fn()
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	previous, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("can't get io priority: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprio); errno != 0 {
+		return fmt.Errorf("can't set io priority: %v", errno)
+	}
+	defer syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, previous)
+	return fn()
+}
@@ -0,0 +1,8 @@
+// +build !linux
+
+package chbackup
+
+// withIOPriority - IO scheduling class is supported on Linux only, fn is called with priority of process
+func withIOPriority(ionice string, fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,301 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// journalSuffix - journals are hidden files '.<backup_name>.<operation>.journal' in backup directory
+	journalSuffix = ".journal"
+	// journalSaveInterval - how often progress of phase is saved to journal
+	journalSaveInterval = 5 * time.Second
+)
+
+// OperationJournal - state of operation, it's saved on every phase and left when process is killed in the middle of operation
+type OperationJournal struct {
+	Operation  string `json:"operation"`
+	BackupName string `json:"backup_name"`
+	Target     string `json:"target,omitempty"`
+	// Options - options of operation, completed phases are reused only by operation with the same options
+	Options map[string]string `json:"options,omitempty"`
+	// Phase - current phase, it's the phase when operation was interrupted
+	Phase string `json:"phase"`
+	// Completed - phases completed before current one
+	Completed []string `json:"completed"`
+	// Processed - how many items of current phase are processed
+	Processed int64 `json:"processed"`
+	// RequiredBackup - backup required by uploaded archive, it's known after 'archive' phase of upload
+	RequiredBackup string `json:"required_backup,omitempty"`
+	// ArchiveSize, ArchiveChecksum - size and SHA-256 of uploaded archive, they are known after 'archive' phase of upload
+	ArchiveSize     int64     `json:"archive_size,omitempty"`
+	ArchiveChecksum string    `json:"archive_checksum,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Start           time.Time `json:"start"`
+	Updated         time.Time `json:"updated"`
+}
+
+// completed - check that phase was completed
+func (j *OperationJournal) completed(phase string) bool {
+	if j == nil {
+		return false
+	}
+	for _, p := range j.Completed {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// String - short description of interrupted operation for logs and API
+func (j *OperationJournal) String() string {
+	s := fmt.Sprintf("%s of '%s' started at %s was interrupted in phase '%s'", j.Operation, j.BackupName, j.Start.Format(APITimeFormat), j.Phase)
+	if j.Target != "" && j.Target != PrimaryTarget {
+		s = fmt.Sprintf("%s to '%s'", s, j.Target)
+	}
+	if j.Processed > 0 {
+		s = fmt.Sprintf("%s after %d items", s, j.Processed)
+	}
+	if len(j.Completed) > 0 {
+		s = fmt.Sprintf("%s, completed phases: %s", s, strings.Join(j.Completed, ", "))
+	}
+	if j.Error != "" {
+		s = fmt.Sprintf("%s, error: %s", s, j.Error)
+	}
+	return s
+}
+
+// operationJournal - journal of running operation, its file is locked until operation is finished
+type operationJournal struct {
+	sync.Mutex
+	state OperationJournal
+	file  *os.File
+	saved time.Time
+}
+
+func journalPath(dataPath, operation, backupName, target string) string {
+	name := fmt.Sprintf(".%s.%s", backupName, operation)
+	if target != "" && target != PrimaryTarget {
+		name = fmt.Sprintf("%s.%s", name, target)
+	}
+	return path.Join(dataPath, "backup", name+journalSuffix)
+}
+
+// startJournal - create and lock journal of operation, return journal of previous run of the same operation
+// if it was interrupted and had the same options, so its completed phases may be skipped
+func startJournal(dataPath, operation, backupName, target string, options map[string]string) (*operationJournal, *OperationJournal, error) {
+	journalFile := journalPath(dataPath, operation, backupName, target)
+	if err := os.MkdirAll(path.Dir(journalFile), 0750); err != nil {
+		return nil, nil, fmt.Errorf("can't create journal: %v", err)
+	}
+	f, err := os.OpenFile(journalFile, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open journal: %v", err)
+	}
+	if err := tryLockFile(f, true); err != nil {
+		f.Close()
+		if err == errFileLocked {
+			return nil, nil, fmt.Errorf("another %s of '%s' is running", operation, backupName)
+		}
+		return nil, nil, fmt.Errorf("can't lock journal: %v", err)
+	}
+	previous, err := readJournal(f)
+	if err != nil {
+		log.Printf("Journal of previous %s of '%s' is ignored: %v", operation, backupName, err)
+	}
+	j := &operationJournal{
+		state: OperationJournal{
+			Operation:  operation,
+			BackupName: backupName,
+			Target:     target,
+			Options:    options,
+			Completed:  []string{},
+			Start:      time.Now(),
+		},
+		file: f,
+	}
+	if previous == nil {
+		return j, nil, nil
+	}
+	log.Printf("Previous %s", previous)
+	if !sameOptions(previous.Options, options) {
+		log.Printf("Options of previous %s are different, start from the beginning", operation)
+		return j, nil, nil
+	}
+	return j, previous, nil
+}
+
+func sameOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// readJournal - read journal from file, return nil when it's empty
+func readJournal(f *os.File) (*OperationJournal, error) {
+	content, err := ioutil.ReadAll(f)
+	if err != nil || len(content) == 0 {
+		return nil, err
+	}
+	previous := &OperationJournal{}
+	if err := json.Unmarshal(content, previous); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func (j *operationJournal) save() error {
+	j.state.Updated = time.Now()
+	j.saved = j.state.Updated
+	content, err := json.MarshalIndent(&j.state, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.WriteAt(content, 0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// phase - complete current phase and start next one
+func (j *operationJournal) phase(name string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.state.Phase != "" {
+		j.state.Completed = append(j.state.Completed, j.state.Phase)
+	}
+	j.state.Phase = name
+	j.state.Processed = 0
+	if err := j.save(); err != nil {
+		log.Printf("can't save journal: %v", err)
+	}
+}
+
+// progress - set count of processed items of current phase, it's saved not more often than journalSaveInterval
+func (j *operationJournal) progress(processed int64) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.state.Processed = processed
+	if time.Since(j.saved) < journalSaveInterval {
+		return
+	}
+	if err := j.save(); err != nil {
+		log.Printf("can't save journal: %v", err)
+	}
+}
+
+// setRequiredBackup - save backup required by uploaded archive to reuse it when archive upload is skipped
+func (j *operationJournal) setRequiredBackup(requiredBackup string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.state.RequiredBackup = requiredBackup
+}
+
+// setArchiveChecksum - save size and checksum of uploaded archive to reuse them in manifest when archive upload is skipped
+func (j *operationJournal) setArchiveChecksum(size int64, checksum string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.state.ArchiveSize = size
+	j.state.ArchiveChecksum = checksum
+}
+
+// finish - remove journal of successful operation or keep journal with error to continue operation next time
+func (j *operationJournal) finish(err error) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if err == nil {
+		if rmErr := os.Remove(j.file.Name()); rmErr != nil {
+			log.Printf("can't remove journal: %v", rmErr)
+		}
+	} else {
+		j.state.Error = err.Error()
+		if saveErr := j.save(); saveErr != nil {
+			log.Printf("can't save journal: %v", saveErr)
+		}
+	}
+	j.file.Close()
+}
+
+// removeJournals - remove journals of interrupted operations with deleted local backup
+func removeJournals(dataPath, backupName string) {
+	for _, operation := range []string{"upload", "download"} {
+		journalFiles, _ := filepath.Glob(journalPath(dataPath, operation, backupName, "*"))
+		journalFiles = append(journalFiles, journalPath(dataPath, operation, backupName, ""))
+		for _, journalFile := range journalFiles {
+			if err := os.Remove(journalFile); err != nil && !os.IsNotExist(err) {
+				log.Printf("can't remove journal '%s': %v", journalFile, err)
+			}
+		}
+	}
+}
+
+// GetInterruptedOperations - return journals of operations which were interrupted or failed and aren't running now
+func GetInterruptedOperations(config Config) ([]OperationJournal, error) {
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return nil, ErrUnknownClickhouseDataPath
+	}
+	journalFiles, err := filepath.Glob(path.Join(dataPath, "backup", ".*"+journalSuffix))
+	if err != nil {
+		return nil, err
+	}
+	result := []OperationJournal{}
+	for _, journalFile := range journalFiles {
+		f, err := os.Open(journalFile)
+		if err != nil {
+			continue
+		}
+		if err := tryLockFile(f, false); err != nil {
+			// operation is running
+			f.Close()
+			continue
+		}
+		j, err := readJournal(f)
+		f.Close()
+		if err != nil {
+			log.Printf("can't read journal '%s': %v", journalFile, err)
+			continue
+		}
+		if j != nil {
+			result = append(result, *j)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationJournal(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataPath)
+	config := Config{ClickHouse: ClickHouseConfig{DataPath: dataPath}}
+	options := map[string]string{"table": "db.*"}
+
+	journal, previous, err := startJournal(dataPath, "upload", "backup", "", options)
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+	_, _, err = startJournal(dataPath, "upload", "backup", "", options)
+	assert.Error(t, err)
+	journal.phase("archive")
+	journal.phase("schema")
+	interrupted, err := GetInterruptedOperations(config)
+	assert.NoError(t, err)
+	assert.Empty(t, interrupted)
+
+	// process is killed, lock of journal is released
+	journal.file.Close()
+	interrupted, err = GetInterruptedOperations(config)
+	assert.NoError(t, err)
+	assert.Len(t, interrupted, 1)
+	assert.Equal(t, "schema", interrupted[0].Phase)
+
+	journal, previous, err = startJournal(dataPath, "upload", "backup", "", options)
+	assert.NoError(t, err)
+	assert.True(t, previous.completed("archive"))
+	assert.False(t, previous.completed("schema"))
+	journal.finish(nil)
+	interrupted, err = GetInterruptedOperations(config)
+	assert.NoError(t, err)
+	assert.Empty(t, interrupted)
+
+	journal, _, err = startJournal(dataPath, "upload", "backup", "", options)
+	assert.NoError(t, err)
+	journal.phase("archive")
+	journal.phase("schema")
+	journal.finish(os.ErrClosed)
+	_, previous, err = startJournal(dataPath, "upload", "backup", "", map[string]string{"table": "*"})
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+}
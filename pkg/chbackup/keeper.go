@@ -0,0 +1,103 @@
+package chbackup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// KeeperConfig - directories of ClickHouse Keeper or ZooKeeper, their files are saved to 'keeper' directory of backup
+// on create, so replication metadata can be restored when the whole cluster is rebuilt
+type KeeperConfig struct {
+	// SnapshotPath - directory of snapshots like /var/lib/clickhouse/coordination/snapshots or dataDir of ZooKeeper, not saved when it's empty
+	SnapshotPath string `yaml:"snapshot_path" envconfig:"KEEPER_SNAPSHOT_PATH"`
+	// LogPath - directory of logs like /var/lib/clickhouse/coordination/log or dataLogDir of ZooKeeper, not saved when it's empty
+	LogPath string `yaml:"log_path" envconfig:"KEEPER_LOG_PATH"`
+}
+
+const (
+	// keeperSnapshotsDir, keeperLogsDir - directories of backup with files of Keeper
+	keeperSnapshotsDir = "keeper/snapshots"
+	keeperLogsDir      = "keeper/logs"
+)
+
+// keeperDirs - directories of Keeper by directories of backup, directories which aren't configured are left out
+func keeperDirs(config KeeperConfig) map[string]string {
+	dirs := map[string]string{}
+	if config.SnapshotPath != "" {
+		dirs[keeperSnapshotsDir] = config.SnapshotPath
+	}
+	if config.LogPath != "" {
+		dirs[keeperLogsDir] = config.LogPath
+	}
+	return dirs
+}
+
+// backupKeeper - copy files of snapshot and log directories of Keeper to backup, Keeper keeps running, the newest log
+// may be copied while it's written and Keeper skips its incomplete tail on start
+func backupKeeper(config KeeperConfig, backupPath string) error {
+	for backupDir, keeperDir := range keeperDirs(config) {
+		log.Printf("Copy '%s' of Keeper", keeperDir)
+		if err := copyDir(keeperDir, path.Join(backupPath, backupDir)); err != nil {
+			return fmt.Errorf("can't copy '%s' of Keeper: %v", keeperDir, err)
+		}
+	}
+	return nil
+}
+
+// RestoreKeeper - copy snapshots and logs of Keeper from local backup to configured directories. Keeper must be stopped
+// and its directories must be empty, so state of running Keeper is never mixed with restored one
+func RestoreKeeper(config Config, backupName string) error {
+	if backupName == "" {
+		PrintLocalBackups(config, "all")
+		return fmt.Errorf("select backup for restore")
+	}
+	dirs := keeperDirs(config.Keeper)
+	if len(dirs) == 0 {
+		return fmt.Errorf("keeper.snapshot_path and keeper.log_path are not set")
+	}
+	if err := GetLocalBackup(config, backupName); err != nil {
+		return fmt.Errorf("can't restore: %v", err)
+	}
+	backupPath := path.Join(getDataPath(config), "backup", backupName)
+	for backupDir, keeperDir := range dirs {
+		if !isDir(path.Join(backupPath, backupDir)) {
+			return fmt.Errorf("backup '%s' doesn't have '%s' of Keeper", backupName, backupDir)
+		}
+		files, err := ioutil.ReadDir(keeperDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't read '%s': %v", keeperDir, err)
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("'%s' is not empty, stop Keeper and clean its directories first", keeperDir)
+		}
+	}
+	for backupDir, keeperDir := range dirs {
+		log.Printf("Restore '%s' of Keeper", keeperDir)
+		if err := copyDir(path.Join(backupPath, backupDir), keeperDir); err != nil {
+			return fmt.Errorf("can't restore '%s' of Keeper: %v", keeperDir, err)
+		}
+	}
+	log.Println("  Done.")
+	return nil
+}
+
+// copyDir - copy regular files of srcDir and its subdirectories to dstDir
+func copyDir(srcDir, dstDir string) error {
+	return filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(srcDir, filePath)
+		if err != nil {
+			return err
+		}
+		return copyFile(filePath, path.Join(dstDir, filepath.ToSlash(relativePath)))
+	})
+}
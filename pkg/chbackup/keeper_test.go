@@ -0,0 +1,32 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupKeeper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keeper")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	snapshots := path.Join(dir, "coordination", "snapshots")
+	assert.NoError(t, os.MkdirAll(path.Join(snapshots, "version-2"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(snapshots, "snapshot_100.bin.zstd"), []byte("snapshot"), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(snapshots, "version-2", "snapshot.1"), []byte("zookeeper"), 0644))
+
+	backupPath := path.Join(dir, "backup")
+	assert.NoError(t, backupKeeper(KeeperConfig{SnapshotPath: snapshots}, backupPath))
+	content, err := ioutil.ReadFile(path.Join(backupPath, keeperSnapshotsDir, "snapshot_100.bin.zstd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot", string(content))
+	content, err = ioutil.ReadFile(path.Join(backupPath, keeperSnapshotsDir, "version-2", "snapshot.1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "zookeeper", string(content))
+	assert.False(t, isDir(path.Join(backupPath, keeperLogsDir)))
+
+	assert.Error(t, backupKeeper(KeeperConfig{LogPath: path.Join(dir, "missing")}, backupPath))
+}
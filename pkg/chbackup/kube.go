@@ -0,0 +1,189 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// KubePhaseRunning, KubePhaseSucceeded, KubePhaseFailed - phases of operation in status file of kube mode
+	KubePhaseRunning   = "Running"
+	KubePhaseSucceeded = "Succeeded"
+	KubePhaseFailed    = "Failed"
+	// KubeExitRetriable - exit code of kube mode when operation may succeed if it's repeated, EX_TEMPFAIL of sysexits.h
+	KubeExitRetriable = 75
+	// kubeTerminationLog - default terminationMessagePath of container, final status is written there when it exists
+	kubeTerminationLog = "/dev/termination-log"
+	// kubeWaitInterval - how often remote storage is checked while waiting for backup
+	kubeWaitInterval = 10 * time.Second
+)
+
+// KubeCondition - condition of operation in the same format as status conditions of Kubernetes resources
+type KubeCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// KubeStatus - content of status file written by create, upload and restore in kube mode
+type KubeStatus struct {
+	Operation      string          `json:"operation"`
+	BackupName     string          `json:"backupName"`
+	Phase          string          `json:"phase"`
+	Retriable      bool            `json:"retriable,omitempty"`
+	StartTime      time.Time       `json:"startTime"`
+	CompletionTime *time.Time      `json:"completionTime,omitempty"`
+	Conditions     []KubeCondition `json:"conditions"`
+	exitCode       int
+}
+
+// kubeConditionTypes - condition type set by finished operation
+var kubeConditionTypes = map[string]string{
+	"create":         "Created",
+	"upload":         "Uploaded",
+	"create_remote":  "Uploaded",
+	"restore":        "Restored",
+	"restore_remote": "Restored",
+}
+
+// retriableErrorMessages - errors which may disappear when operation is repeated, most errors are wrapped with %v, so they are matched by text
+var retriableErrorMessages = []string{
+	"can't connect to clickhouse",
+	"can't connect to remote storage",
+	"connection refused",
+	"connection reset",
+	"i/o timeout",
+	"no such host",
+	"timeout exceeded",
+	"TLS handshake timeout",
+	"another operation is currently running",
+	"another operation in progress",
+	"not found on remote storage",
+}
+
+// IsRetriableError - true when operation failed by error of network, lock or backup which isn't uploaded yet
+func IsRetriableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	message := err.Error()
+	for _, m := range retriableErrorMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewKubeStatus - status of started operation
+func NewKubeStatus(operation, backupName string) *KubeStatus {
+	return &KubeStatus{
+		Operation:  operation,
+		BackupName: backupName,
+		Phase:      KubePhaseRunning,
+		StartTime:  time.Now().UTC(),
+		Conditions: []KubeCondition{},
+	}
+}
+
+// Finish - set phase and condition of finished operation
+func (s *KubeStatus) Finish(err error) {
+	now := time.Now().UTC()
+	s.CompletionTime = &now
+	condition := KubeCondition{
+		Type:               kubeConditionTypes[s.Operation],
+		Status:             "True",
+		Reason:             KubePhaseSucceeded,
+		LastTransitionTime: now,
+	}
+	s.Phase = KubePhaseSucceeded
+	if err != nil {
+		s.Phase = KubePhaseFailed
+		s.Retriable = IsRetriableError(err)
+		s.exitCode = ExitCode(err)
+		condition.Status = "False"
+		condition.Reason = "Fatal"
+		if s.Retriable {
+			condition.Reason = "Retriable"
+		}
+		condition.Message = err.Error()
+	}
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// ExitCode - KubeExitRetriable for failed operation which may be repeated, exit code of error class for other failed
+// operations and 0 for succeeded one
+func (s *KubeStatus) ExitCode() int {
+	switch {
+	case s.Phase != KubePhaseFailed:
+		return 0
+	case s.Retriable:
+		return KubeExitRetriable
+	}
+	return s.exitCode
+}
+
+// Write - replace '<operation>.json' in statusDir with status, finished status is also written to termination log of container
+func (s *KubeStatus) Write(statusDir string) error {
+	content, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("can't marshal status: %v", err)
+	}
+	if err := os.MkdirAll(statusDir, 0755); err != nil {
+		return fmt.Errorf("can't create status dir: %v", err)
+	}
+	fileName := path.Join(statusDir, s.Operation+".json")
+	if err := ioutil.WriteFile(fileName+".tmp", append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("can't write status: %v", err)
+	}
+	if err := os.Rename(fileName+".tmp", fileName); err != nil {
+		return fmt.Errorf("can't write status: %v", err)
+	}
+	if s.CompletionTime != nil {
+		if _, err := os.Stat(kubeTerminationLog); err == nil {
+			if err := ioutil.WriteFile(kubeTerminationLog, content, 0644); err != nil {
+				log.Printf("can't write %s: %v", kubeTerminationLog, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitForRemoteBackup - wait until backup is uploaded completely to remote storage, used by restore in init container
+// which is started before backup is ready
+func WaitForRemoteBackup(config Config, backupName string, timeout time.Duration) error {
+	if backupName == "" {
+		return fmt.Errorf("select backup for wait")
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		backups, err := getRemoteBackups(config)
+		if err != nil {
+			log.Printf("can't get remote backups: %v", err)
+		}
+		for _, b := range getCompleteBackups(backups) {
+			if trimArchiveExtension(b.Name) == backupName {
+				return nil
+			}
+		}
+		if time.Now().Add(kubeWaitInterval).After(deadline) {
+			return fmt.Errorf("backup '%s' not found on remote storage after %s", backupName, timeout)
+		}
+		log.Printf("Wait for backup '%s'", backupName)
+		time.Sleep(kubeWaitInterval)
+	}
+}
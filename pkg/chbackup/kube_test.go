@@ -0,0 +1,44 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetriableError(t *testing.T) {
+	assert.False(t, IsRetriableError(nil))
+	assert.True(t, IsRetriableError(fmt.Errorf("can't connect to clickhouse: dial tcp 127.0.0.1:9000: connect: connection refused")))
+	assert.True(t, IsRetriableError(ErrAPILocked))
+	assert.False(t, IsRetriableError(fmt.Errorf("backup 'b1' not found")))
+}
+
+func TestKubeStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	status := NewKubeStatus("upload", "b1")
+	assert.NoError(t, status.Write(dir))
+	assert.Equal(t, 0, status.ExitCode())
+	status.Finish(fmt.Errorf("can't connect to remote storage: i/o timeout"))
+	assert.NoError(t, status.Write(dir))
+	assert.Equal(t, KubeExitRetriable, status.ExitCode())
+
+	content, err := ioutil.ReadFile(path.Join(dir, "upload.json"))
+	assert.NoError(t, err)
+	var written KubeStatus
+	assert.NoError(t, json.Unmarshal(content, &written))
+	assert.Equal(t, KubePhaseFailed, written.Phase)
+	assert.Equal(t, "Uploaded", written.Conditions[0].Type)
+	assert.Equal(t, "Retriable", written.Conditions[0].Reason)
+
+	status = NewKubeStatus("create", "b1")
+	status.Finish(fmt.Errorf("unknown consistency"))
+	assert.Equal(t, ExitError, status.ExitCode())
+}
@@ -0,0 +1,56 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+)
+
+// MigrateBackupFormat - convert local backup created by previous versions to current format
+// Shadow in ClickHouse format 'shadow/<increment>/data/<db>/<table>' is moved to 'shadow/<db>/<table>' and metadata.json is written
+func MigrateBackupFormat(config Config, backupName string) error {
+	if backupName == "" {
+		PrintLocalBackups(config, "all")
+		return fmt.Errorf("select backup for migrate")
+	}
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return ErrUnknownClickhouseDataPath
+	}
+	backupPath := path.Join(dataPath, "backup", backupName)
+	if !isDir(backupPath) {
+		return fmt.Errorf("backup '%s' not found", backupName)
+	}
+	if _, err := readBackupMetadata(backupPath); err == nil {
+		log.Printf("Backup '%s' already has current format", backupName)
+		return nil
+	}
+	if reason := getLocalBackupBrokenReason(backupPath); reason != "" {
+		return fmt.Errorf("backup '%s' is broken: %s", backupName, reason)
+	}
+	log.Printf("Migrate backup '%s'", backupName)
+	shadowPath := path.Join(backupPath, "shadow")
+	if isClickhouseShadow(shadowPath) {
+		log.Println("Convert shadow")
+		migratedShadowPath := path.Join(backupPath, "shadow.migrate")
+		if err := os.MkdirAll(migratedShadowPath, dirMode(os.ModePerm)); err != nil {
+			return err
+		}
+		if err := moveShadow(shadowPath, migratedShadowPath, 1, nil); err != nil {
+			return fmt.Errorf("can't convert shadow: %v", err)
+		}
+		if err := os.Remove(shadowPath); err != nil {
+			return err
+		}
+		if err := os.Rename(migratedShadowPath, shadowPath); err != nil {
+			return err
+		}
+	}
+	metadata := getLocalBackupMetadata(backupPath)
+	if err := metadata.Save(backupPath); err != nil {
+		return err
+	}
+	log.Println("  Done.")
+	return nil
+}
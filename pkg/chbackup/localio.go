@@ -0,0 +1,83 @@
+package chbackup
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// IONiceIdle - local files are copied only when no other process uses disk
+	IONiceIdle = "idle"
+	// IONiceBestEffort - local files are copied with the lowest priority of best-effort class
+	IONiceBestEffort = "best-effort"
+)
+
+// localIO - limits of local file copy on create and restore, set from general.ionice and general.io_throttle_mbps
+var localIO = &localIOLimits{}
+
+// localIOLimits - IO priority and bandwidth shared by all goroutines copying local files
+type localIOLimits struct {
+	mu             sync.Mutex
+	ionice         string
+	bytesPerSecond int64
+	// next - time when next read is allowed
+	next time.Time
+}
+
+// validateIONice - check value of general.ionice
+func validateIONice(ionice string) error {
+	switch ionice {
+	case "", IONiceIdle, IONiceBestEffort:
+		return nil
+	}
+	return fmt.Errorf("ionice must be '%s', '%s' or empty", IONiceIdle, IONiceBestEffort)
+}
+
+// setLocalIOLimits - apply general.ionice and general.io_throttle_mbps to following local file copies
+func setLocalIOLimits(config GeneralConfig) {
+	localIO.mu.Lock()
+	defer localIO.mu.Unlock()
+	localIO.ionice = config.IONice
+	localIO.bytesPerSecond = int64(config.IOThrottleMbps) * 1024 * 1024
+}
+
+// copy - copy src to dst with IO priority and bandwidth limit of local file copy
+func (l *localIOLimits) copy(dst io.Writer, src io.Reader) error {
+	l.mu.Lock()
+	ionice := l.ionice
+	l.mu.Unlock()
+	return withIOPriority(ionice, func() error {
+		_, err := io.Copy(dst, &throttledReader{r: src, limits: l})
+		return err
+	})
+}
+
+// wait - sleep until n bytes may be read without exceeding bandwidth limit
+func (l *localIOLimits) wait(n int) {
+	l.mu.Lock()
+	if l.bytesPerSecond <= 0 || n <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+type throttledReader struct {
+	r      io.Reader
+	limits *localIOLimits
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limits.wait(n)
+	return n, err
+}
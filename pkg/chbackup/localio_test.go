@@ -0,0 +1,24 @@
+package chbackup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalIOLimitsCopy(t *testing.T) {
+	limits := &localIOLimits{bytesPerSecond: 4 * 1024 * 1024}
+	start := time.Now()
+	assert.NoError(t, limits.copy(ioutil.Discard, bytes.NewReader(make([]byte, 2*1024*1024))))
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "copy took %s", time.Since(start))
+
+	limits = &localIOLimits{}
+	start = time.Now()
+	assert.NoError(t, limits.copy(ioutil.Discard, bytes.NewReader(make([]byte, 2*1024*1024))))
+	assert.True(t, time.Since(start) < 400*time.Millisecond, "copy took %s", time.Since(start))
+	assert.Error(t, validateIONice("realtime"))
+	assert.NoError(t, validateIONice(IONiceIdle))
+}
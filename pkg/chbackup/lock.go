@@ -0,0 +1,230 @@
+package chbackup
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// lockFileName - advisory lock of all backups in backup directory, operations of one backup hold it shared and operations
+// which aren't bound to one backup hold it exclusively. Lock of backup is hidden file '.<backup_name>.lock' in the same directory,
+// it's removed by the last holder when backup doesn't exist, e.g. after delete of backup or after operation with wrong name
+const lockFileName = ".clickhouse-backup.lock"
+
+var (
+	// errFileLocked - lock of file is held by another process
+	errFileLocked = errors.New("file is locked by another process")
+	// heldLocks - lock files held by this process, they are reentrant because server runs commands of CLI in the same process,
+	// exclusive lock is reentrant for the same operation of the same backup only
+	heldLocks = struct {
+		sync.Mutex
+		files map[string]*heldLock
+	}{files: map[string]*heldLock{}}
+	// readOperations - operations which only read local backup, they run together with other reads of the same backup,
+	// other operations of backup change it and run exclusively
+	readOperations = map[string]bool{
+		"upload":         true,
+		"restore":        true,
+		"restore_keeper": true,
+		"verify":         true,
+	}
+)
+
+// heldLock - open lock file and count of its holders in this process
+type heldLock struct {
+	file      *os.File
+	exclusive bool
+	count     int
+	// owner - operation and backup name of exclusive holder
+	owner string
+	// backupPath - directory of backup of lock file, it's empty for lock of all backups
+	backupPath string
+}
+
+// lockRequest - lock file of operation in backup directory and mode of its lock
+type lockRequest struct {
+	name      string
+	exclusive bool
+	// backup - name of backup of lock file, it's empty for lock of all backups
+	backup string
+}
+
+// operationLocks - lock files of operation, lock of all backups is exclusive for operation without backup name
+func operationLocks(operation, backupName string) []lockRequest {
+	if backupName == "" {
+		return []lockRequest{{name: lockFileName, exclusive: true}}
+	}
+	return []lockRequest{
+		{name: lockFileName},
+		{name: "." + backupName + ".lock", exclusive: !readOperations[operation], backup: backupName},
+	}
+}
+
+// AcquireLock - lock all backups of data_path exclusively, another process fails to lock them until returned function is called.
+// Nothing is locked when data_path is unknown, operation fails later in this case
+func AcquireLock(config Config) (func(), error) {
+	return AcquireOperationLock(config, "", "")
+}
+
+// AcquireOperationLock - lock backup for operation, so operations of different backups and operations which only read the same
+// backup run at the same time, and operation which changes backup fails while another operation uses it. Operation without
+// backup name locks all backups exclusively
+func AcquireOperationLock(config Config, operation, backupName string) (func(), error) {
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return func() {}, nil
+	}
+	var releases []func()
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	for _, lock := range operationLocks(operation, backupName) {
+		backupPath := ""
+		if lock.backup != "" {
+			backupPath = path.Join(dataPath, "backup", lock.backup)
+		}
+		// server locks files before command of CLI locks them again for the same operation, another operation of this
+		// process, e.g. retention of create_remote, fails to lock files held exclusively by operation of another request
+		owner := operation + " " + backupName
+		releaseFile, err := lockFile(path.Join(dataPath, "backup", lock.name), lock.exclusive, owner, backupPath)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, releaseFile)
+	}
+	return release, nil
+}
+
+// lockFile - lock file without waiting, file which is already held by this process in shared mode is locked again in shared mode,
+// file held exclusively is locked again only by the same owner, empty owner never locks it again.
+// Lock file of backupPath is removed on release when backupPath doesn't exist
+func lockFile(lockFile string, exclusive bool, owner string, backupPath string) (func(), error) {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if held, ok := heldLocks.files[lockFile]; ok {
+		if held.exclusive != exclusive || (exclusive && (owner == "" || held.owner != owner)) {
+			return nil, fmt.Errorf("another operation in progress, '%s' is locked by process %d", lockFile, os.Getpid())
+		}
+		held.count++
+		return releaseLockOnce(lockFile), nil
+	}
+	if err := os.MkdirAll(path.Dir(lockFile), 0750); err != nil {
+		return nil, fmt.Errorf("can't create lock file: %v", err)
+	}
+	var f *os.File
+	for {
+		var err error
+		if f, err = os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0640); err != nil {
+			return nil, fmt.Errorf("can't open lock file: %v", err)
+		}
+		if err := tryLockFile(f, exclusive); err != nil {
+			pid, _ := ioutil.ReadAll(f)
+			f.Close()
+			if err == errFileLocked {
+				return nil, fmt.Errorf("another operation in progress, '%s' is locked by process %s", lockFile, strings.TrimSpace(string(pid)))
+			}
+			return nil, fmt.Errorf("can't lock '%s': %v", lockFile, err)
+		}
+		// file opened before it was removed by the previous holder doesn't lock anything, it's opened again
+		if isSameFile(f, lockFile) {
+			break
+		}
+		f.Close()
+	}
+	// pid of holder is written for the error message of other processes, it's the last one of shared holders
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	heldLocks.files[lockFile] = &heldLock{file: f, exclusive: exclusive, count: 1, owner: owner, backupPath: backupPath}
+	return releaseLockOnce(lockFile), nil
+}
+
+// isSameFile - open file is still located at fileName
+func isSameFile(f *os.File, fileName string) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(fileName)
+	return err == nil && os.SameFile(info, current)
+}
+
+func releaseLockOnce(lockFile string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			heldLocks.Lock()
+			defer heldLocks.Unlock()
+			held := heldLocks.files[lockFile]
+			held.count--
+			if held.count == 0 {
+				// lock file is removed only under exclusive lock, so other processes don't hold it,
+				// shared lock is left when other processes hold it too
+				if held.backupPath != "" && !isDir(held.backupPath) && tryLockFile(held.file, true) == nil {
+					os.Remove(lockFile)
+				}
+				held.file.Close()
+				delete(heldLocks.files, lockFile)
+			}
+		})
+	}
+}
+
+// lockSet - locks of lock files in memory, server checks its operations against each other by them before lock files are
+// locked, because lock files held by server are reentrant
+type lockSet struct {
+	sync.Mutex
+	shared    map[string]int
+	exclusive map[string]bool
+	// operations - count of operations holding locks
+	operations int
+}
+
+// tryLock - lock all requested locks or none of them, ErrAPIBusy is returned when maxOperations operations already
+// hold locks, count of operations isn't limited when maxOperations is 0
+func (s *lockSet) tryLock(locks []lockRequest, maxOperations int) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, lock := range locks {
+		if s.exclusive[lock.name] || (lock.exclusive && s.shared[lock.name] > 0) {
+			return ErrAPILocked
+		}
+	}
+	if maxOperations > 0 && s.operations >= maxOperations {
+		return ErrAPIBusy
+	}
+	if s.shared == nil {
+		s.shared, s.exclusive = map[string]int{}, map[string]bool{}
+	}
+	for _, lock := range locks {
+		if lock.exclusive {
+			s.exclusive[lock.name] = true
+		} else {
+			s.shared[lock.name]++
+		}
+	}
+	s.operations++
+	return nil
+}
+
+// unlock - release locks taken by tryLock
+func (s *lockSet) unlock(locks []lockRequest) {
+	s.Lock()
+	defer s.Unlock()
+	for _, lock := range locks {
+		if lock.exclusive {
+			delete(s.exclusive, lock.name)
+		} else if s.shared[lock.name]--; s.shared[lock.name] == 0 {
+			delete(s.shared, lock.name)
+		}
+	}
+	s.operations--
+}
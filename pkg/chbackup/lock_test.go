@@ -0,0 +1,108 @@
+// +build !windows
+
+package chbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := Config{ClickHouse: ClickHouseConfig{DataPath: dir}}
+	lockFile := path.Join(dir, "backup", lockFileName)
+
+	release, err := AcquireLock(config)
+	assert.NoError(t, err)
+	// commands of CLI run by server lock the same file again
+	releaseNested, err := AcquireLock(config)
+	assert.NoError(t, err)
+	releaseNested()
+	releaseNested()
+	assert.False(t, canLock(t, lockFile))
+	release()
+	assert.True(t, canLock(t, lockFile))
+}
+
+// canLock - lock file by another open file, it's the same as lock of another process
+func canLock(t *testing.T, lockFile string) bool {
+	f, err := os.Open(lockFile)
+	assert.NoError(t, err)
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil
+}
+
+// canLockShared - lock file shared by another open file
+func canLockShared(t *testing.T, lockFile string) bool {
+	f, err := os.Open(lockFile)
+	assert.NoError(t, err)
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == nil
+}
+
+func TestAcquireOperationLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := Config{ClickHouse: ClickHouseConfig{DataPath: dir}}
+	lockFile := path.Join(dir, "backup", lockFileName)
+	backupLockFile := path.Join(dir, "backup", ".backup1.lock")
+
+	release, err := AcquireOperationLock(config, "create", "backup1")
+	assert.NoError(t, err)
+	// lock of all backups is shared, lock of created backup is exclusive
+	assert.True(t, canLockShared(t, lockFile))
+	assert.False(t, canLock(t, lockFile))
+	assert.False(t, canLockShared(t, backupLockFile))
+	release()
+	assert.True(t, canLock(t, lockFile))
+	// lock file of backup which doesn't exist is removed
+	_, err = os.Stat(backupLockFile)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "backup", "backup1"), 0755))
+	release, err = AcquireOperationLock(config, "upload", "backup1")
+	assert.NoError(t, err)
+	assert.True(t, canLockShared(t, backupLockFile))
+	assert.False(t, canLock(t, backupLockFile))
+	// operation of this process in another mode fails
+	_, err = AcquireLock(config)
+	assert.Error(t, err)
+	release()
+	_, err = os.Stat(backupLockFile)
+	assert.NoError(t, err)
+
+	release, err = AcquireOperationLock(config, "download", "backup2")
+	assert.NoError(t, err)
+	// command of CLI run by server locks backup again for the same operation, another operation fails
+	releaseNested, err := AcquireOperationLock(config, "download", "backup2")
+	assert.NoError(t, err)
+	releaseNested()
+	_, err = AcquireOperationLock(config, "retention", "backup2")
+	assert.Error(t, err)
+	_, err = AcquireOperationLock(config, "upload", "backup2")
+	assert.Error(t, err)
+	release()
+	release, err = AcquireOperationLock(config, "retention", "backup2")
+	assert.NoError(t, err)
+	release()
+
+	var locks lockSet
+	assert.NoError(t, locks.tryLock(operationLocks("create", "backup1"), 0))
+	assert.NoError(t, locks.tryLock(operationLocks("download", "backup2"), 0))
+	assert.Equal(t, ErrAPILocked, locks.tryLock(operationLocks("upload", "backup1"), 0))
+	assert.Equal(t, ErrAPILocked, locks.tryLock(operationLocks("clean", ""), 0))
+	// upload of another backup waits for one of running operations
+	assert.Equal(t, ErrAPIBusy, locks.tryLock(operationLocks("upload", "backup3"), 2))
+	locks.unlock(operationLocks("create", "backup1"))
+	assert.NoError(t, locks.tryLock(operationLocks("upload", "backup1"), 2))
+	assert.NoError(t, locks.tryLock(operationLocks("restore", "backup1"), 0))
+	assert.Equal(t, ErrAPILocked, locks.tryLock(operationLocks("delete", "backup1"), 0))
+}
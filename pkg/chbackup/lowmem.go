@@ -0,0 +1,39 @@
+package chbackup
+
+const (
+	// lowMemoryBufferSize - size of ring buffers between archiver and remote storage with general.low_memory
+	lowMemoryBufferSize = 256 * 1024
+	// lowMemoryPartSize - max size of parts of multipart uploads which are kept in memory with general.low_memory
+	lowMemoryPartSize = 8 * 1024 * 1024
+	// lowMemoryUploadConcurrency - how many parts of one file are uploaded at the same time with general.low_memory
+	lowMemoryUploadConcurrency = 2
+	// maxUploadParts - max number of parts of multipart upload of S3 and COS
+	maxUploadParts = 10000
+)
+
+// withLowMemory - return copy of config with smaller parts of uploads and without parallel compression when general.low_memory is set.
+// Archives are split into chunks which fit into maxUploadParts of small parts
+func (config Config) withLowMemory() Config {
+	if !config.General.LowMemory {
+		return config
+	}
+	config.General.CompressionWorkers = 1
+	if config.General.MaxFileSize == 0 || config.General.MaxFileSize > maxUploadParts*lowMemoryPartSize {
+		config.General.MaxFileSize = maxUploadParts * lowMemoryPartSize
+	}
+	if config.S3.PartSize > lowMemoryPartSize {
+		config.S3.PartSize = lowMemoryPartSize
+	}
+	if config.COS.PartSize > lowMemoryPartSize {
+		config.COS.PartSize = lowMemoryPartSize
+	}
+	return config
+}
+
+// getBufferSize - size of ring buffers between archiver and remote storage
+func getBufferSize(general GeneralConfig) int {
+	if general.LowMemory {
+		return lowMemoryBufferSize
+	}
+	return BufferSize
+}
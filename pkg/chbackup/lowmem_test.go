@@ -0,0 +1,26 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLowMemory(t *testing.T) {
+	config := *DefaultConfig()
+	assert.Equal(t, config, config.withLowMemory())
+	assert.Equal(t, BufferSize, getBufferSize(config.General))
+
+	config.General.LowMemory = true
+	config.General.CompressionWorkers = 4
+	lowMemory := config.withLowMemory()
+	assert.Equal(t, 1, lowMemory.General.CompressionWorkers)
+	assert.Equal(t, int64(lowMemoryPartSize), lowMemory.S3.PartSize)
+	assert.Equal(t, int64(lowMemoryPartSize), lowMemory.COS.PartSize)
+	assert.Equal(t, int64(maxUploadParts*lowMemoryPartSize), lowMemory.General.MaxFileSize)
+	assert.Equal(t, lowMemoryBufferSize, getBufferSize(lowMemory.General))
+	assert.Equal(t, int64(100*1024*1024), config.S3.PartSize)
+
+	config.General.MaxFileSize = 1024 * 1024 * 1024
+	assert.Equal(t, int64(1024*1024*1024), config.withLowMemory().General.MaxFileSize)
+}
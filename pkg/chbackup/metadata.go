@@ -0,0 +1,342 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupMetadataFileName - name of file with backup metadata in the root of backup directory
+	BackupMetadataFileName = "metadata.json"
+	// backupIncompleteFileName - marker of backup which is being created or downloaded, it's removed when metadata.json is saved,
+	// so backup with marker is broken even if it has metadata and shadow like backups of previous versions
+	backupIncompleteFileName = ".incomplete"
+	// PartCountFileName - file with count of rows in directory of part
+	PartCountFileName = "count.txt"
+	// projectionDirSuffix - suffix of directory of projection inside directory of part
+	projectionDirSuffix = ".proj"
+	// UploadStateInProgress - upload_state of remote backup which is uploading now or upload was interrupted
+	UploadStateInProgress = "in progress"
+	// UploadStateUploaded - upload_state of completely uploaded remote backup
+	UploadStateUploaded = "uploaded"
+	// ChecksumsAll - all parts of backup have checksums
+	ChecksumsAll = "all"
+	// ChecksumsPartial - some parts of backup have no checksums, e.g. they were linked from backup of previous version
+	ChecksumsPartial = "partial"
+	// ChecksumsNone - backup was created by previous version which doesn't save checksums of parts
+	ChecksumsNone = "none"
+)
+
+// BackupMetadata - describe backup, written to the root of backup directory when backup is completely created or downloaded.
+// Backup without this file is considered as partial
+type BackupMetadata struct {
+	BackupName     string    `json:"backup_name"`
+	CreationDate   time.Time `json:"creation_date"`
+	RequiredBackup string    `json:"required_backup,omitempty"`
+	// Chain - required backup, backup required by it and so on up to the base backup
+	Chain       []string `json:"chain,omitempty"`
+	UploadState string   `json:"upload_state,omitempty"`
+	// Host - hostName() of server where backup was created
+	Host string `json:"host,omitempty"`
+	// ClickHouseVersion - VERSION_INTEGER of server where backup was created
+	ClickHouseVersion int `json:"clickhouse_version,omitempty"`
+	// Macros - content of system.macros of server where backup was created
+	Macros map[string]string `json:"macros,omitempty"`
+	// Consistency - 'strict' when merges were stopped and Buffer tables flushed while tables were frozen
+	Consistency string `json:"consistency,omitempty"`
+	// BarrierTime - time when all shards of cluster reached barrier in ZooKeeper before freeze, it's set when barrier is enabled
+	BarrierTime *time.Time `json:"barrier_time,omitempty"`
+	// TablePattern - tables filter of 'upload --tables', it's set in manifest only when not all tables of local backup were uploaded
+	TablePattern string `json:"table_pattern,omitempty"`
+	// Profile - backup profile which selected tables of backup
+	Profile string `json:"profile,omitempty"`
+	// SchemaOnly - backup was downloaded without data by 'download --schema'
+	SchemaOnly bool `json:"schema_only,omitempty"`
+	// ArchiveSize, ArchiveChecksum - size and SHA-256 of uploaded archive, all chunks are hashed as one stream, they are set in manifest only
+	ArchiveSize     int64  `json:"archive_size,omitempty"`
+	ArchiveChecksum string `json:"archive_checksum,omitempty"`
+	// SchemaSize, SchemaChecksum - size and SHA-256 of uploaded archive with metadata of tables
+	SchemaSize     int64  `json:"schema_size,omitempty"`
+	SchemaChecksum string `json:"schema_checksum,omitempty"`
+	// Size - size of data of all tables in backup
+	Size   int64                 `json:"size,omitempty"`
+	Tables []BackupTableMetadata `json:"tables,omitempty"`
+	// Detached - detached parts of tables saved by 'create --include-detached', they aren't counted in Size and aren't attached on restore
+	Detached []BackupTableMetadata `json:"detached,omitempty"`
+	// FailedTables - tables left out of backup by 'create --continue-on-error' with their errors
+	FailedTables []TableStatus `json:"failed_tables,omitempty"`
+}
+
+// BackupTableMetadata - table saved in backup
+type BackupTableMetadata struct {
+	Database   string    `json:"database"`
+	Table      string    `json:"table"`
+	Size       int64     `json:"size"`
+	FreezeTime time.Time `json:"freeze_time"`
+	// Corrupted - CHECK TABLE found corrupted data before freeze, it's set when clickhouse.check_tables is 'record'
+	Corrupted bool `json:"corrupted,omitempty"`
+	// Rows - count of rows of all parts, it's 0 for backups created by previous versions
+	Rows       uint64               `json:"rows,omitempty"`
+	Partitions []string             `json:"partitions"`
+	Parts      []BackupPartMetadata `json:"parts"`
+	// Projections - names of projections whose '<projection>.proj' directories are saved inside parts, they are restored with parts
+	Projections []string `json:"projections,omitempty"`
+	// TTL, ColumnsTTL - TTL expressions of table and of columns by name from CREATE query, they are saved for audit of restored data
+	TTL        string            `json:"ttl,omitempty"`
+	ColumnsTTL map[string]string `json:"columns_ttl,omitempty"`
+}
+
+// BackupPartMetadata - data part of table saved in backup
+type BackupPartMetadata struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Rows - count of rows from count.txt of part
+	Rows uint64 `json:"rows,omitempty"`
+	// Checksum - hash of checksums.txt of part, parts with the same checksum have the same data
+	Checksum string `json:"checksum,omitempty"`
+	// Disk - name of disk from system.disks where part was stored
+	Disk string `json:"disk,omitempty"`
+}
+
+// Save - write metadata to metadata.json in backupPath
+func (m *BackupMetadata) Save(backupPath string) error {
+	content, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("can't marshal %s: %v", BackupMetadataFileName, err)
+	}
+	if err := ioutil.WriteFile(path.Join(backupPath, BackupMetadataFileName), content, fileMode(0640)); err != nil {
+		return fmt.Errorf("can't write %s: %v", BackupMetadataFileName, err)
+	}
+	if err := os.Remove(path.Join(backupPath, backupIncompleteFileName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't remove %s: %v", backupIncompleteFileName, err)
+	}
+	return nil
+}
+
+// markBackupIncomplete - mark backup as broken until its metadata.json is saved
+func markBackupIncomplete(backupPath string) error {
+	if err := ioutil.WriteFile(path.Join(backupPath, backupIncompleteFileName), nil, fileMode(0640)); err != nil {
+		return fmt.Errorf("can't write %s: %v", backupIncompleteFileName, err)
+	}
+	return nil
+}
+
+// readBackupMetadata - read metadata.json from backupPath
+func readBackupMetadata(backupPath string) (*BackupMetadata, error) {
+	content, err := ioutil.ReadFile(path.Join(backupPath, BackupMetadataFileName))
+	if err != nil {
+		return nil, err
+	}
+	var m BackupMetadata
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", BackupMetadataFileName, err)
+	}
+	return &m, nil
+}
+
+// ChecksumStatus - return how many parts of backup have checksums, it's empty when backup has no parts
+func (m *BackupMetadata) ChecksumStatus() string {
+	parts, withChecksum := 0, 0
+	for _, t := range m.Tables {
+		for _, p := range t.Parts {
+			parts++
+			if p.Checksum != "" {
+				withChecksum++
+			}
+		}
+	}
+	switch {
+	case parts == 0:
+		return ""
+	case withChecksum == parts:
+		return ChecksumsAll
+	case withChecksum == 0:
+		return ChecksumsNone
+	}
+	return ChecksumsPartial
+}
+
+// getLocalBackupMetadata - return metadata of local backup or minimal metadata for backups created by previous versions
+func getLocalBackupMetadata(backupPath string) BackupMetadata {
+	if m, err := readBackupMetadata(backupPath); err == nil {
+		return *m
+	}
+	m := BackupMetadata{
+		BackupName:   path.Base(backupPath),
+		CreationDate: time.Now().UTC(),
+	}
+	if info, err := os.Stat(backupPath); err == nil {
+		m.CreationDate = info.ModTime().UTC()
+	}
+	return m
+}
+
+// getPartRows - read count of rows from count.txt of part
+func getPartRows(fileName string) (uint64, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse '%s': %v", fileName, err)
+	}
+	return rows, nil
+}
+
+// getBackupTablesMetadata - collect tables, partitions and parts sizes from shadow directory of backup
+func getBackupTablesMetadata(backupPath string) ([]BackupTableMetadata, int64, error) {
+	shadowPath := path.Join(backupPath, "shadow")
+	// shadow of backups created by previous versions is shadow/<increment>/data/<database>/<table>
+	dbNum := 0
+	if isClickhouseShadow(shadowPath) {
+		dbNum = 2
+	}
+	return collectTablesMetadata(shadowPath, dbNum)
+}
+
+// getBackupDetachedMetadata - collect tables and detached parts from detached directory of backup
+func getBackupDetachedMetadata(backupPath string) ([]BackupTableMetadata, error) {
+	detachedPath := path.Join(backupPath, "detached")
+	if !isDir(detachedPath) {
+		return nil, nil
+	}
+	tables, _, err := collectTablesMetadata(detachedPath, 0)
+	return tables, err
+}
+
+// collectTablesMetadata - collect tables, partitions and parts sizes from '<database>/<table>/<part>' directories of root,
+// database is the dbNum element of relative path
+func collectTablesMetadata(root string, dbNum int) ([]BackupTableMetadata, int64, error) {
+	tables := map[string]*BackupTableMetadata{}
+	projections := map[string]map[string]bool{}
+	var totalSize int64
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath := strings.Trim(strings.TrimPrefix(filepath.ToSlash(filePath), filepath.ToSlash(root)), "/")
+		parts := strings.Split(relativePath, "/")
+		if len(parts) < dbNum+4 {
+			return nil
+		}
+		database, _ := url.PathUnescape(parts[dbNum])
+		table, _ := url.PathUnescape(parts[dbNum+1])
+		partName := parts[dbNum+2]
+		key := database + "." + table
+		t, ok := tables[key]
+		if !ok {
+			t = &BackupTableMetadata{Database: database, Table: table, Partitions: []string{}, Parts: []BackupPartMetadata{}}
+			tables[key] = t
+		}
+		if len(t.Parts) == 0 || t.Parts[len(t.Parts)-1].Name != partName {
+			t.Parts = append(t.Parts, BackupPartMetadata{Name: partName})
+		}
+		if len(parts) == dbNum+4 && parts[dbNum+3] == PartChecksumFileName {
+			checksum, err := getPartChecksum(filepath.Dir(filePath))
+			if err != nil {
+				return err
+			}
+			t.Parts[len(t.Parts)-1].Checksum = checksum
+		}
+		// checksums.txt and count.txt of projection are in '<part>/<projection>.proj', they are counted only in size of part
+		if len(parts) > dbNum+4 && strings.HasSuffix(parts[dbNum+3], projectionDirSuffix) {
+			if projections[key] == nil {
+				projections[key] = map[string]bool{}
+			}
+			projections[key][strings.TrimSuffix(parts[dbNum+3], projectionDirSuffix)] = true
+		}
+		if len(parts) == dbNum+4 && parts[dbNum+3] == PartCountFileName {
+			rows, err := getPartRows(filePath)
+			if err != nil {
+				return err
+			}
+			t.Parts[len(t.Parts)-1].Rows = rows
+			t.Rows += rows
+		}
+		t.Parts[len(t.Parts)-1].Size += info.Size()
+		t.Size += info.Size()
+		totalSize += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]BackupTableMetadata, 0, len(tables))
+	for key, t := range tables {
+		for name := range projections[key] {
+			t.Projections = append(t.Projections, name)
+		}
+		sort.Strings(t.Projections)
+		// name of part is <partition_id>_<min_block>_<max_block>_<level>
+		partitions := map[string]bool{}
+		for _, part := range t.Parts {
+			partition := strings.Split(part.Name, "_")[0]
+			if !partitions[partition] {
+				partitions[partition] = true
+				t.Partitions = append(t.Partitions, partition)
+			}
+		}
+		sort.Strings(t.Partitions)
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Database < result[j].Database || (result[i].Database == result[j].Database && result[i].Table < result[j].Table)
+	})
+	return result, totalSize, nil
+}
+
+// isDir - check that path exists and is a directory
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// getLocalBackupBrokenReason - return why local backup can't be used or empty string if backup is complete
+// Backups created by previous versions don't have metadata.json and marker of incomplete backup,
+// they are considered complete if contain metadata and shadow
+func getLocalBackupBrokenReason(backupPath string) string {
+	if _, err := os.Stat(path.Join(backupPath, backupIncompleteFileName)); err == nil {
+		return "backup is incomplete, its create or download failed or was interrupted"
+	}
+	_, err := readBackupMetadata(backupPath)
+	if err == nil {
+		return ""
+	}
+	if !os.IsNotExist(err) {
+		return err.Error()
+	}
+	if isDir(path.Join(backupPath, "metadata")) && isDir(path.Join(backupPath, "shadow")) {
+		return ""
+	}
+	return fmt.Sprintf("%s not found, backup is incomplete", BackupMetadataFileName)
+}
+
+// removePartialBackup - remove directory of failed backup when general.cleanup_on_failure is enabled
+func removePartialBackup(config Config, backupPath string) {
+	if !config.General.CleanupOnFailure {
+		if err := markBackupIncomplete(backupPath); err != nil {
+			log.Printf("Partial backup '%s' is kept, but it's not marked as broken: %v", backupPath, err)
+			return
+		}
+		log.Printf("Partial backup '%s' is kept and marked as broken", backupPath)
+		return
+	}
+	log.Printf("Remove partial backup '%s'", backupPath)
+	if err := os.RemoveAll(backupPath); err != nil {
+		log.Printf("can't remove '%s': %v", backupPath, err)
+	}
+}
@@ -0,0 +1,85 @@
+package chbackup
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBackupTablesMetadataProjections(t *testing.T) {
+	backupPath, err := ioutil.TempDir("", "metadata")
+	assert.NoError(t, err)
+	defer os.RemoveAll(backupPath)
+	partPath := filepath.Join(backupPath, "shadow", "db", "t", "all_1_1_0")
+	files := map[string]string{
+		"checksums.txt":              "part",
+		"count.txt":                  "10",
+		"skp_idx_v_idx.idx":          "index",
+		"skp_idx_v_idx.mrk2":         "marks",
+		"p_sum.proj/checksums.txt":   "projection",
+		"p_sum.proj/count.txt":       "2",
+		"p_sum.proj/data.bin":        "data",
+		"p_count.proj/checksums.txt": "projection",
+		"p_count.proj/count.txt":     "3",
+		"p_count.proj/columns.txt":   "columns",
+		"p_count.proj/primary.idx":   "primary",
+	}
+	var size int64
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(partPath, name)), 0750))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(partPath, name), []byte(content), 0640))
+		size += int64(len(content))
+	}
+
+	tables, totalSize, err := getBackupTablesMetadata(backupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, size, totalSize)
+	assert.Len(t, tables, 1)
+	// files of projections are counted in size of part, their count.txt doesn't change count of rows
+	assert.Len(t, tables[0].Parts, 1)
+	assert.Equal(t, size, tables[0].Parts[0].Size)
+	assert.Equal(t, uint64(10), tables[0].Rows)
+	assert.NotEmpty(t, tables[0].Parts[0].Checksum)
+	assert.Equal(t, []string{"p_count", "p_sum"}, tables[0].Projections)
+}
+
+func TestGetLocalBackupBrokenReason(t *testing.T) {
+	backupPath, err := ioutil.TempDir("", "backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(backupPath)
+	assert.NoError(t, os.MkdirAll(filepath.Join(backupPath, "metadata"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(backupPath, "shadow"), 0755))
+	// backup of previous versions without metadata.json
+	assert.Equal(t, "", getLocalBackupBrokenReason(backupPath))
+
+	assert.NoError(t, markBackupIncomplete(backupPath))
+	assert.NotEqual(t, "", getLocalBackupBrokenReason(backupPath))
+	removePartialBackup(Config{}, backupPath)
+	assert.NotEqual(t, "", getLocalBackupBrokenReason(backupPath))
+
+	metadata := BackupMetadata{BackupName: "backup"}
+	assert.NoError(t, metadata.Save(backupPath))
+	assert.Equal(t, "", getLocalBackupBrokenReason(backupPath))
+}
+
+func TestDescribeLocalBackupNotFound(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "data")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataPath)
+	config := Config{ClickHouse: ClickHouseConfig{DataPath: dataPath}}
+	backupPath := filepath.Join(dataPath, "backup", "backup1")
+	assert.NoError(t, os.MkdirAll(backupPath, 0755))
+	assert.NoError(t, markBackupIncomplete(backupPath))
+
+	// broken backup isn't looked up on remote storage
+	_, err = DescribeLocalBackup(config, "backup1")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, errLocalBackupNotFound))
+	_, err = DescribeLocalBackup(config, "backup2")
+	assert.True(t, errors.Is(err, errLocalBackupNotFound))
+	assert.Equal(t, "backup 'backup2' not found", err.Error())
+}
@@ -0,0 +1,66 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"time"
+)
+
+// MetricsConfig - metrics settings section
+type MetricsConfig struct {
+	// PushgatewayURL - Prometheus Pushgateway where metrics of create, upload, download and restore commands are pushed
+	// at the end of every run, metrics aren't pushed when it's empty
+	PushgatewayURL string `yaml:"pushgateway_url" envconfig:"METRICS_PUSHGATEWAY_URL"`
+	// PushgatewayJob - job label of pushed metrics
+	PushgatewayJob string `yaml:"pushgateway_job" envconfig:"METRICS_PUSHGATEWAY_JOB"`
+	// StatsdAddress - host:port of statsd server where counters, durations and sizes of operations are sent by UDP,
+	// metrics aren't sent when it's empty
+	StatsdAddress string `yaml:"statsd_address" envconfig:"METRICS_STATSD_ADDRESS"`
+	// StatsdPrefix - prefix of names of statsd metrics
+	StatsdPrefix string `yaml:"statsd_prefix" envconfig:"METRICS_STATSD_PREFIX"`
+}
+
+// validateMetricsConfig - check values of metrics section
+func validateMetricsConfig(config MetricsConfig) error {
+	if config.PushgatewayURL != "" {
+		u, err := url.Parse(config.PushgatewayURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("metrics.pushgateway_url must be http(s) URL")
+		}
+		if config.PushgatewayJob == "" {
+			return fmt.Errorf("metrics.pushgateway_job can't be empty")
+		}
+	}
+	if config.StatsdAddress != "" {
+		if _, _, err := net.SplitHostPort(config.StatsdAddress); err != nil {
+			return fmt.Errorf("can't parse metrics.statsd_address: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReportOperation - push metrics of finished operation to Pushgateway and send them to statsd when they are configured,
+// errors of metrics are logged only
+func ReportOperation(config Config, operation, backupName string, start time.Time, err error) {
+	if pushErr := PushMetrics(config, operation, start, err); pushErr != nil {
+		log.Println(pushErr)
+	}
+	if statsdErr := sendStatsdMetrics(config, operation, backupName, start, err); statsdErr != nil {
+		log.Println(statsdErr)
+	}
+}
+
+// getOperationSize - size of local backup after operation, it's 0 when backup doesn't exist locally
+func getOperationSize(config Config, backupName string) int64 {
+	if backupName == "" {
+		return 0
+	}
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return 0
+	}
+	return getLocalBackupMetadata(path.Join(dataPath, "backup", backupName)).Size
+}
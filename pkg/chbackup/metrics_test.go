@@ -0,0 +1,32 @@
+package chbackup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMetricsConfig(t *testing.T) {
+	assert.NoError(t, validateMetricsConfig(MetricsConfig{}))
+	assert.NoError(t, validateMetricsConfig(MetricsConfig{PushgatewayURL: "http://pushgateway:9091", PushgatewayJob: "clickhouse-backup"}))
+	assert.Error(t, validateMetricsConfig(MetricsConfig{PushgatewayURL: "pushgateway:9091", PushgatewayJob: "clickhouse-backup"}))
+	assert.Error(t, validateMetricsConfig(MetricsConfig{PushgatewayURL: "http://pushgateway:9091"}))
+	assert.NoError(t, validateMetricsConfig(MetricsConfig{StatsdAddress: "localhost:8125"}))
+	assert.Error(t, validateMetricsConfig(MetricsConfig{StatsdAddress: "localhost"}))
+}
+
+func TestFormatStatsdMetrics(t *testing.T) {
+	assert.Equal(t, []string{
+		"clickhouse_backup.upload.success:1|c",
+		"clickhouse_backup.upload.last_success:1|g",
+		"clickhouse_backup.upload.duration:1500|ms",
+		"clickhouse_backup.upload.size:1024|g",
+	}, formatStatsdMetrics("clickhouse_backup", "upload", 1500*time.Millisecond, 1024, nil))
+	assert.Equal(t, []string{
+		"create.failure:1|c",
+		"create.last_success:0|g",
+		"create.duration:10|ms",
+	}, formatStatsdMetrics("", "create", 10*time.Millisecond, 0, fmt.Errorf("can't create")))
+}
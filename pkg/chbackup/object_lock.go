@@ -0,0 +1,78 @@
+package chbackup
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ObjectLockGovernance, ObjectLockCompliance - modes of S3 Object Lock retention
+	ObjectLockGovernance = "GOVERNANCE"
+	ObjectLockCompliance = "COMPLIANCE"
+)
+
+// LockedRemoteStorage - remote storage which can keep uploaded objects immutable (WORM), it's optional for RemoteStorage.
+// Locks of objects are checked before backup is removed, so backup under legal hold is not removed partially
+type LockedRemoteStorage interface {
+	// GetFileLock - return description of active lock of object like 'legal hold', empty string when object may be deleted
+	GetFileLock(key string) (string, error)
+}
+
+// HeldRemoteStorage - remote storage which puts holds on objects after upload, it's optional for RemoteStorage.
+// Manifest is rewritten when upload is finished, so it's held only after that
+type HeldRemoteStorage interface {
+	// HoldFile - put configured holds on object
+	HoldFile(key string) error
+}
+
+// isManifestKey - manifest or signature of manifest, they are uploaded again when upload is finished or manifest is rebuilt
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix) || strings.HasSuffix(key, manifestSuffix+signatureSuffix)
+}
+
+// holdManifest - put holds on final manifest of archive and its signature, storages without holds are skipped
+func (bd *BackupDestination) holdManifest(archiveKey string) error {
+	held, ok := bd.RemoteStorage.(HeldRemoteStorage)
+	if !ok {
+		return nil
+	}
+	key := archiveKey + manifestSuffix
+	if err := held.HoldFile(key); err != nil {
+		return fmt.Errorf("can't hold '%s': %v", key, err)
+	}
+	if !bd.signer.canSign() {
+		return nil
+	}
+	if err := held.HoldFile(key + signatureSuffix); err != nil {
+		return fmt.Errorf("can't hold '%s': %v", key+signatureSuffix, err)
+	}
+	return nil
+}
+
+// ObjectLockedError - object can't be deleted until its lock is released or retention period expires
+type ObjectLockedError struct {
+	Key  string
+	Lock string
+}
+
+func (e *ObjectLockedError) Error() string {
+	return fmt.Sprintf("'%s' is protected by %s and can't be deleted", e.Key, e.Lock)
+}
+
+// checkFileLocks - return ObjectLockedError for the first locked object, storages without object locks are not checked
+func (bd *BackupDestination) checkFileLocks(keys []string) error {
+	locked, ok := bd.RemoteStorage.(LockedRemoteStorage)
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		lock, err := locked.GetFileLock(key)
+		if err != nil {
+			return fmt.Errorf("can't get lock of '%s': %v", key, err)
+		}
+		if lock != "" {
+			return &ObjectLockedError{Key: strings.TrimPrefix(strings.TrimPrefix(key, bd.path), "/"), Lock: lock}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package chbackup
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// operationDurations - durations of create, upload, download and restore operations of process by status,
+	// operation started by another one, e.g. upload by create_remote, is observed too
+	operationDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of create, upload, download and restore operations.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16), // 1s .. 9h
+	}, []string{"operation", "status"})
+	// tableDurations - durations of processing of one table by steps of operations
+	tableDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "table_duration_seconds",
+		Help:      "Duration of freeze, create and restore of one table.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 16), // 100ms .. 55m
+	}, []string{"step"})
+)
+
+const (
+	// tableStepFreeze, tableStepCreate, tableStepRestore - step label of table_duration_seconds
+	tableStepFreeze  = "freeze"
+	tableStepCreate  = "create_table"
+	tableStepRestore = "restore_table"
+)
+
+// startOperationTimer - start measuring duration of operation, returned function observes it with error of operation
+func startOperationTimer(operation string) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		status := "success"
+		if err != nil {
+			status = "error"
+		}
+		operationDurations.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeTable - observe duration of step for one table started at start
+func observeTable(step string, start time.Time) {
+	tableDurations.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// maxErrorLabelLength - longer errors are truncated in error label of last_error metric
+const maxErrorLabelLength = 256
+
+// lastErrorCollector - info metric with the last error of every operation of API server which failed on its last run
+type lastErrorCollector struct {
+	status *AsyncStatus
+	desc   *prometheus.Desc
+}
+
+func newLastErrorCollector(status *AsyncStatus) *lastErrorCollector {
+	return &lastErrorCollector{
+		status: status,
+		desc: prometheus.NewDesc("clickhouse_backup_last_error",
+			"The last error of operation, it's exported while the last run of operation failed.", []string{"operation", "error"}, nil),
+	}
+}
+
+// Describe - implements prometheus.Collector
+func (c *lastErrorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect - implements prometheus.Collector
+func (c *lastErrorCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, command := range c.status.lastErrors() {
+		// label values must be valid UTF-8
+		message := []rune(strings.ToValidUTF8(command.Error, "?"))
+		if len(message) > maxErrorLabelLength {
+			message = append(message[:maxErrorLabelLength], []rune("...")...)
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, commandOperation(command.Command), string(message))
+	}
+}
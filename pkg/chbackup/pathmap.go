@@ -0,0 +1,117 @@
+package chbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dockerMount - bind mount or volume of container from 'docker inspect'
+type dockerMount struct {
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+}
+
+// validateDataPathMap - paths of clickhouse.data_path_map must be absolute, paths on host may be paths of Windows
+func validateDataPathMap(pathMap map[string]string) error {
+	for serverPath, hostPath := range pathMap {
+		if !path.IsAbs(serverPath) || !(path.IsAbs(hostPath) || filepath.IsAbs(hostPath)) {
+			return fmt.Errorf("paths of clickhouse.data_path_map must be absolute: '%s: %s'", serverPath, hostPath)
+		}
+	}
+	return nil
+}
+
+// normalizeLocalPaths - convert data_path and paths on host of data_path_map to absolute paths with forward slashes,
+// so paths of Windows are joined and split like paths reported by ClickHouse and long paths of Windows work for absolute paths only
+func normalizeLocalPaths(config *ClickHouseConfig) error {
+	if config.DataPath != "" {
+		dataPath, err := filepath.Abs(config.DataPath)
+		if err != nil {
+			return fmt.Errorf("can't get absolute path of clickhouse.data_path: %v", err)
+		}
+		config.DataPath = filepath.ToSlash(dataPath)
+	}
+	for serverPath, hostPath := range config.DataPathMap {
+		config.DataPathMap[serverPath] = filepath.ToSlash(hostPath)
+	}
+	return nil
+}
+
+// mapPath - replace the longest prefix of p found in pathMap by its value, prefix must match whole path elements
+func mapPath(p string, pathMap map[string]string) string {
+	p = path.Clean(p)
+	longest, hostPath := "", ""
+	for serverPath, h := range pathMap {
+		serverPath = path.Clean(serverPath)
+		if len(serverPath) <= len(longest) {
+			continue
+		}
+		if p == serverPath || serverPath == "/" || strings.HasPrefix(p, serverPath+"/") {
+			longest, hostPath = serverPath, h
+		}
+	}
+	if longest == "" {
+		return p
+	}
+	return path.Join(hostPath, strings.TrimPrefix(p, longest))
+}
+
+// parseDockerMounts - map of paths in container to paths on host from output of 'docker inspect --format {{json .Mounts}}'
+func parseDockerMounts(output []byte) (map[string]string, error) {
+	var mounts []dockerMount
+	if err := json.Unmarshal(output, &mounts); err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	for _, m := range mounts {
+		if m.Source != "" && m.Destination != "" {
+			result[m.Destination] = m.Source
+		}
+	}
+	return result, nil
+}
+
+// getDockerMounts - map of paths in container to paths on host read by 'docker inspect'
+func getDockerMounts(container string) (map[string]string, error) {
+	output, err := exec.Command("docker", "inspect", "--format", "{{json .Mounts}}", container).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("can't inspect container '%s': %v: %s", container, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("can't inspect container '%s': %v", container, err)
+	}
+	mounts, err := parseDockerMounts(output)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse mounts of container '%s': %v", container, err)
+	}
+	return mounts, nil
+}
+
+// hostPath - translate path reported by ClickHouse to path on host where clickhouse-backup runs.
+// Mounts of clickhouse.docker_container are used first, clickhouse.data_path_map overrides them
+func (ch *ClickHouse) hostPath(p string) (string, error) {
+	if ch.pathMap == nil {
+		ch.pathMap = map[string]string{}
+		if ch.Config.DockerContainer != "" {
+			mounts, err := getDockerMounts(ch.Config.DockerContainer)
+			if err != nil {
+				ch.pathMap = nil
+				return p, err
+			}
+			for serverPath, hostPath := range mounts {
+				ch.pathMap[serverPath] = filepath.ToSlash(hostPath)
+			}
+		}
+		for serverPath, hostPath := range ch.Config.DataPathMap {
+			ch.pathMap[serverPath] = hostPath
+		}
+	}
+	if len(ch.pathMap) == 0 {
+		return p, nil
+	}
+	return mapPath(p, ch.pathMap), nil
+}
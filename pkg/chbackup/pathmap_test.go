@@ -0,0 +1,37 @@
+package chbackup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapPath(t *testing.T) {
+	pathMap := map[string]string{
+		"/var/lib/clickhouse":       "/srv/clickhouse",
+		"/var/lib/clickhouse/cold/": "/mnt/cold",
+	}
+	assert.Equal(t, "/srv/clickhouse", mapPath("/var/lib/clickhouse/", pathMap))
+	assert.Equal(t, "/srv/clickhouse/data/db/t/all_1_1_0", mapPath("/var/lib/clickhouse/data/db/t/all_1_1_0/", pathMap))
+	assert.Equal(t, "/mnt/cold/data/db/t", mapPath("/var/lib/clickhouse/cold/data/db/t", pathMap))
+	assert.Equal(t, "/var/lib/clickhouse2/data", mapPath("/var/lib/clickhouse2/data", pathMap))
+	assert.Equal(t, "/host/var/lib/clickhouse", mapPath("/var/lib/clickhouse", map[string]string{"/": "/host"}))
+}
+
+func TestNormalizeLocalPaths(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	config := ClickHouseConfig{DataPath: "clickhouse", DataPathMap: map[string]string{"/var/lib/clickhouse": "/srv/clickhouse"}}
+	assert.NoError(t, normalizeLocalPaths(&config))
+	assert.Equal(t, filepath.ToSlash(filepath.Join(wd, "clickhouse")), config.DataPath)
+	assert.Equal(t, "/srv/clickhouse", config.DataPathMap["/var/lib/clickhouse"])
+}
+
+func TestParseDockerMounts(t *testing.T) {
+	mounts, err := parseDockerMounts([]byte(`[{"Type":"volume","Name":"ch","Source":"/var/lib/docker/volumes/ch/_data","Destination":"/var/lib/clickhouse"},{"Type":"tmpfs","Destination":"/tmp"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"/var/lib/clickhouse": "/var/lib/docker/volumes/ch/_data"}, mounts)
+	assert.Error(t, validateDataPathMap(map[string]string{"data": "/srv/clickhouse"}))
+}
@@ -1,21 +1,26 @@
 package chbackup
 
 import (
+	"fmt"
 	"io"
+	"os"
 
 	progressbar "gopkg.in/cheggaaa/pb.v1"
 )
 
+// Bar - progress bar with count of processed items, speed and time left, it's shown only when stdout is terminal
 type Bar struct {
 	pb   *progressbar.ProgressBar
 	show bool
 }
 
 func StartNewByteBar(show bool, total int64) *Bar {
-	if show {
+	if show && IsTerminal(os.Stdout) {
+		pb := progressbar.StartNew(int(total)).SetUnits(progressbar.U_BYTES)
+		pb.ShowSpeed = true
 		return &Bar{
 			show: true,
-			pb:   progressbar.StartNew(int(total)).SetUnits(progressbar.U_BYTES),
+			pb:   pb,
 		}
 	}
 	return &Bar{
@@ -24,7 +29,7 @@ func StartNewByteBar(show bool, total int64) *Bar {
 }
 
 func StartNewBar(show bool, total int) *Bar {
-	if show {
+	if show && IsTerminal(os.Stdout) {
 		return &Bar{
 			show: true,
 			pb:   progressbar.StartNew(total),
@@ -53,6 +58,22 @@ func (b *Bar) Set(current int) {
 	}
 }
 
+// SetPrefix - set text shown before bar, e.g. kind of counted items
+func (b *Bar) SetPrefix(prefix string) {
+	if b.show {
+		b.pb.Prefix(prefix)
+	}
+}
+
+// SetFiles - show count of processed files and total count of files before bar, total is omitted when it's unknown
+func (b *Bar) SetFiles(done, total int) {
+	if total > 0 {
+		b.SetPrefix(fmt.Sprintf("%d/%d files ", done, total))
+		return
+	}
+	b.SetPrefix(fmt.Sprintf("%d files ", done))
+}
+
 func (b *Bar) Increment() {
 	if b.show {
 		b.pb.Increment()
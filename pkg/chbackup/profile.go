@@ -0,0 +1,94 @@
+package chbackup
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ProfileConfig - backup profile of tenant, backups of profile contain its tables, they are stored in own prefix
+// of remote path and have own retention, so one server manages backups of several tenants independently
+type ProfileConfig struct {
+	// Tables - pattern of tables of profile like 'tenant_a.*, tenant_a_dict.*', it's used when tables aren't selected by command
+	Tables string `yaml:"tables"`
+	// Path - prefix of backups of profile in remote path, name of profile is used when it's empty
+	Path string `yaml:"path"`
+	// BackupsToKeepLocal, BackupsToKeepRemote - retention of backups of profile, retention of general section is used when it's 0
+	BackupsToKeepLocal  int `yaml:"backups_to_keep_local"`
+	BackupsToKeepRemote int `yaml:"backups_to_keep_remote"`
+}
+
+// validateProfiles - check profiles and profile selected in general section
+func validateProfiles(config *Config) error {
+	for name, profile := range config.Profiles {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("wrong name of profile '%s'", name)
+		}
+		if _, err := parseTablePattern(profile.Tables); err != nil {
+			return fmt.Errorf("profiles.%s.tables: %v", name, err)
+		}
+		if profile.BackupsToKeepLocal < 0 || profile.BackupsToKeepRemote < 0 {
+			return fmt.Errorf("profiles.%s: backups_to_keep_local and backups_to_keep_remote can't be negative", name)
+		}
+	}
+	if _, ok := config.Profiles[config.General.Profile]; config.General.Profile != "" && !ok {
+		return fmt.Errorf("profile '%s' is not found in profiles", config.General.Profile)
+	}
+	return nil
+}
+
+// ApplyProfile - select profile by name, profile of general section is selected when name is empty
+func ApplyProfile(config *Config, name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile '%s' is not found in profiles", name)
+	}
+	config.General.Profile = name
+	return nil
+}
+
+// getBackupsToKeepLocal, getBackupsToKeepRemote - retention of selected profile, retention of general section
+// is used without profile or when retention of profile is 0
+func getBackupsToKeepLocal(config Config) int {
+	if keep := config.Profiles[config.General.Profile].BackupsToKeepLocal; config.General.Profile != "" && keep != 0 {
+		return keep
+	}
+	return config.General.BackupsToKeepLocal
+}
+
+func getBackupsToKeepRemote(config Config) int {
+	if keep := config.Profiles[config.General.Profile].BackupsToKeepRemote; config.General.Profile != "" && keep != 0 {
+		return keep
+	}
+	return config.General.BackupsToKeepRemote
+}
+
+// getProfilePath - prefix of remote path of selected profile, it's empty without profile
+func getProfilePath(config Config) string {
+	if config.General.Profile == "" {
+		return ""
+	}
+	if profilePath := config.Profiles[config.General.Profile].Path; profilePath != "" {
+		return profilePath
+	}
+	return config.General.Profile
+}
+
+// getProfileTables - tables of selected profile when tablePattern is empty
+func getProfileTables(config Config, tablePattern string) string {
+	if tablePattern != "" || config.General.Profile == "" {
+		return tablePattern
+	}
+	return config.Profiles[config.General.Profile].Tables
+}
+
+// getRemotePath - remote path of backups in storage with prefixes of profile and shard
+func getRemotePath(config Config, storagePath string) string {
+	remotePath := storagePath
+	if profilePath := getProfilePath(config); profilePath != "" {
+		remotePath = path.Join(remotePath, profilePath)
+	}
+	return getShardPath(remotePath, config.General.Shard)
+}
@@ -0,0 +1,41 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiles(t *testing.T) {
+	config := Config{
+		General: GeneralConfig{BackupsToKeepLocal: 1, BackupsToKeepRemote: 7},
+		Profiles: map[string]ProfileConfig{
+			"tenant_a": {Tables: "tenant_a.*", BackupsToKeepRemote: 30},
+			"tenant_b": {Tables: "tenant_b.*", Path: "customers/tenant_b"},
+		},
+	}
+	assert.NoError(t, validateProfiles(&config))
+	assert.Equal(t, "backup/", getRemotePath(config, "backup/"))
+	assert.Equal(t, "db.*", getProfileTables(config, "db.*"))
+	assert.Equal(t, "", getProfileTables(config, ""))
+
+	assert.Error(t, ApplyProfile(&config, "tenant_c"))
+	a := config
+	assert.NoError(t, ApplyProfile(&a, "tenant_a"))
+	assert.Equal(t, "tenant_a.*", getProfileTables(a, ""))
+	assert.Equal(t, "backup/tenant_a", getRemotePath(a, "backup/"))
+	assert.Equal(t, 1, getBackupsToKeepLocal(a))
+	assert.Equal(t, 30, getBackupsToKeepRemote(a))
+
+	b := config
+	b.General.Shard = "shard1"
+	assert.NoError(t, ApplyProfile(&b, "tenant_b"))
+	assert.Equal(t, "backup/customers/tenant_b/shard1", getRemotePath(b, "backup"))
+	assert.Equal(t, 7, getBackupsToKeepRemote(b))
+
+	config.General.Profile = "tenant_c"
+	assert.Error(t, validateProfiles(&config))
+	config.General.Profile = ""
+	config.Profiles["tenant_c"] = ProfileConfig{Tables: "~["}
+	assert.Error(t, validateProfiles(&config))
+}
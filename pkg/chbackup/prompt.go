@@ -0,0 +1,141 @@
+package chbackup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// promptsDisabled - set by API server, commands run by server don't ask on terminal where server was started,
+// nobody answers there and operation would hang holding its lock
+var promptsDisabled int32
+
+// disablePrompts - commands of this process don't ask questions anymore
+func disablePrompts() {
+	atomic.StoreInt32(&promptsDisabled, 1)
+}
+
+// CanPrompt - true when command may ask on stdin, it's terminal and command isn't run by API server
+func CanPrompt() bool {
+	return atomic.LoadInt32(&promptsDisabled) == 0 && IsTerminal(os.Stdin)
+}
+
+// IsTerminal - true when f is terminal, prompts are shown only on terminal so scripts and cron jobs are not blocked
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// SelectBackup - print numbered list of local or remote backups of target to out and read number or name of backup from in,
+// the newest backup is the last one and it's selected by empty answer. Return empty name when there are no backups or input is closed
+func SelectBackup(config Config, remote bool, target string, in io.Reader, out io.Writer) (string, error) {
+	backups, err := listBackups(config, remote, target)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", nil
+	}
+	return selectBackup(backups, in, out)
+}
+
+func selectBackup(backups []Backup, in io.Reader, out io.Writer) (string, error) {
+	for i, b := range backups {
+		fmt.Fprintf(out, "%3d) %s\t%s\n", i+1, b.Name, b.Date.Format("02-01-2006 15:04:05"))
+	}
+	fmt.Fprintf(out, "Select backup by number or name [%d]: ", len(backups))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err == io.EOF && answer == "" {
+		return "", nil
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return backups[len(backups)-1].Name, nil
+	}
+	if n, err := strconv.Atoi(answer); err == nil {
+		if n < 1 || n > len(backups) {
+			return "", fmt.Errorf("backup number must be between 1 and %d", len(backups))
+		}
+		return backups[n-1].Name, nil
+	}
+	for _, b := range backups {
+		if b.Name == answer {
+			return b.Name, nil
+		}
+	}
+	return "", fmt.Errorf("backup '%s' not found", answer)
+}
+
+// Confirm - print message of destructive operation and ask for confirmation, only 'y' or 'yes' confirms operation
+func Confirm(in io.Reader, out io.Writer, message string) (bool, error) {
+	fmt.Fprintf(out, "%s\nContinue? [y/N]: ", strings.TrimRight(message, "\n"))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// DeleteMessage - description of backup removed by delete command for confirmation
+func DeleteMessage(config Config, location, backupName, target string, force bool) (string, error) {
+	message := fmt.Sprintf("Backup '%s' will be deleted from local disk", backupName)
+	if location == "remote" {
+		targets, err := GetRemoteTargets(config, target)
+		if err != nil {
+			return "", err
+		}
+		names := make([]string, 0, len(targets))
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		message = fmt.Sprintf("Backup '%s' will be deleted from remote storage of %s", backupName, strings.Join(names, ", "))
+	}
+	if force {
+		message += ", backups which require it will be broken"
+	}
+	return message, nil
+}
+
+// RestoreDropMessage - description of tables dropped by restore with --rm for confirmation, tables are taken from
+// local backup or from manifest of remote backup when it doesn't exist locally
+func RestoreDropMessage(config Config, backupName, tablePattern string) (string, error) {
+	patterns, err := parseTablePattern(tablePattern)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := DescribeLocalBackup(config, backupName)
+	if errors.Is(err, errLocalBackupNotFound) {
+		metadata, err = DescribeRemoteBackup(config, backupName, "")
+	}
+	if err != nil {
+		return "", err
+	}
+	tables := []string{}
+	for _, t := range metadata.Tables {
+		if patterns.Match(t.Database, t.Table) {
+			tables = append(tables, fmt.Sprintf("  %s.%s", t.Database, t.Table))
+		}
+	}
+	if len(metadata.Tables) == 0 {
+		if tablePattern == "" {
+			tablePattern = "*"
+		}
+		return fmt.Sprintf("Tables of backup '%s' matched by '%s' will be dropped and restored", backupName, tablePattern), nil
+	}
+	return fmt.Sprintf("%d tables will be dropped and restored from backup '%s':\n%s", len(tables), backupName, strings.Join(tables, "\n")), nil
+}
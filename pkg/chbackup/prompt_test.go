@@ -0,0 +1,73 @@
+package chbackup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBackup(t *testing.T) {
+	backups := []Backup{
+		{Name: "backup1", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "backup2", Date: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	var out bytes.Buffer
+	name, err := selectBackup(backups, strings.NewReader("1\n"), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup1", name)
+	assert.Equal(t, "  1) backup1\t01-01-2020 00:00:00\n  2) backup2\t02-01-2020 00:00:00\nSelect backup by number or name [2]: ", out.String())
+
+	name, err = selectBackup(backups, strings.NewReader("\n"), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup2", name)
+	name, err = selectBackup(backups, strings.NewReader("backup1"), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup1", name)
+	name, err = selectBackup(backups, strings.NewReader(""), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+	_, err = selectBackup(backups, strings.NewReader("3\n"), &out)
+	assert.Error(t, err)
+	_, err = selectBackup(backups, strings.NewReader("backup3\n"), &out)
+	assert.Error(t, err)
+}
+
+func TestCompletionScript(t *testing.T) {
+	assert.Equal(t, []string{"bash", "fish", "zsh"}, CompletionShells())
+	for _, shell := range CompletionShells() {
+		script, err := CompletionScript(shell)
+		assert.NoError(t, err)
+		assert.Contains(t, script, "--generate-bash-completion")
+	}
+	_, err := CompletionScript("tcsh")
+	assert.Error(t, err)
+}
+
+func TestConfirm(t *testing.T) {
+	var out bytes.Buffer
+	ok, err := Confirm(strings.NewReader("yes\n"), &out, "Backup 'backup1' will be deleted from local disk")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Backup 'backup1' will be deleted from local disk\nContinue? [y/N]: ", out.String())
+	ok, err = Confirm(strings.NewReader("\n"), &out, "")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = Confirm(strings.NewReader(""), &out, "")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	message, err := DeleteMessage(Config{}, "local", "backup1", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "Backup 'backup1' will be deleted from local disk, backups which require it will be broken", message)
+	message, err = DeleteMessage(Config{RemoteTargets: map[string]RemoteTargetConfig{"s3b": {}}}, "remote", "backup1", AllTargets, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Backup 'backup1' will be deleted from remote storage of primary, s3b", message)
+}
+
+func TestDisablePrompts(t *testing.T) {
+	disablePrompts()
+	assert.False(t, CanPrompt())
+}
@@ -0,0 +1,43 @@
+package chbackup
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushTimeout - how long push of metrics to Pushgateway may take
+const pushTimeout = 30 * time.Second
+
+// PushMetrics - push the same last_backup_* metrics as server exports for command started at start and finished with err,
+// metrics are grouped by instance and operation, so results of different commands don't replace each other
+func PushMetrics(config Config, operation string, start time.Time, err error) error {
+	if config.Metrics.PushgatewayURL == "" {
+		return nil
+	}
+	m := newMetrics()
+	m.LastBackupStart.Set(float64(start.Unix()))
+	m.LastBackupEnd.Set(float64(time.Now().Unix()))
+	m.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
+	if err != nil {
+		m.LastBackupSuccess.Set(0)
+	} else {
+		m.LastBackupSuccess.Set(1)
+	}
+	hostname, _ := os.Hostname()
+	pusher := push.New(config.Metrics.PushgatewayURL, config.Metrics.PushgatewayJob).
+		Client(&http.Client{Timeout: pushTimeout}).
+		Grouping("instance", hostname).
+		Grouping("operation", operation)
+	for _, c := range []prometheus.Collector{m.LastBackupStart, m.LastBackupEnd, m.LastBackupDuration, m.LastBackupSuccess} {
+		pusher = pusher.Collector(c)
+	}
+	if err := pusher.Push(); err != nil {
+		return fmt.Errorf("can't push metrics to pushgateway: %v", err)
+	}
+	return nil
+}
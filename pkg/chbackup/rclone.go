@@ -0,0 +1,238 @@
+package chbackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// exit codes of rclone when remote path doesn't exist
+	rcloneExitDirNotFound  = 3
+	rcloneExitFileNotFound = 4
+)
+
+// Rclone - remote storage which runs rclone binary for each operation,
+// it allows to use any provider supported by rclone
+type Rclone struct {
+	Config *RcloneConfig
+}
+
+type rcloneObject struct {
+	Path    string `json:"Path"`
+	Size    int64  `json:"Size"`
+	ModTime string `json:"ModTime"`
+	IsDir   bool   `json:"IsDir"`
+}
+
+func (r *Rclone) Kind() string {
+	return "rclone"
+}
+
+// Connect - check that rclone binary and remote are available
+func (r *Rclone) Connect() error {
+	if r.Config.Remote == "" {
+		return fmt.Errorf("rclone remote is not set")
+	}
+	if _, err := exec.LookPath(r.Config.Binary); err != nil {
+		return fmt.Errorf("can't find rclone binary '%s': %v", r.Config.Binary, err)
+	}
+	_, err := r.run(nil, "lsjson", "--max-depth", "1", r.remotePath(r.Config.Path))
+	if err != nil && !isRcloneNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Rclone) Close() error {
+	return nil
+}
+
+func (r *Rclone) GetFile(key string) (RemoteFile, error) {
+	objects, err := r.list(key, false)
+	if err != nil {
+		if isRcloneNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	for _, o := range objects {
+		if !o.IsDir {
+			return newRcloneFile(key, o), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *Rclone) DeleteFile(key string) error {
+	_, err := r.run(nil, "deletefile", r.remotePath(key))
+	return err
+}
+
+func (r *Rclone) Walk(prefix string, process func(RemoteFile)) error {
+	objects, err := r.list(prefix, true)
+	if err != nil {
+		if isRcloneNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, o := range objects {
+		process(newRcloneFile(path.Join(prefix, o.Path), o))
+	}
+	return nil
+}
+
+func (r *Rclone) GetFileReader(key string) (io.ReadCloser, error) {
+	cmd := r.command("cat", r.remotePath(key))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &rcloneReader{cmd: cmd, stdout: stdout, stderr: stderr}, nil
+}
+
+func (r *Rclone) PutFile(key string, reader io.ReadCloser) error {
+	_, err := r.run(reader, "rcat", r.remotePath(key))
+	return err
+}
+
+// list - return objects under key, files are returned relative to key
+func (r *Rclone) list(key string, recursive bool) ([]rcloneObject, error) {
+	args := []string{"lsjson", "--files-only"}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	out, err := r.run(nil, append(args, r.remotePath(key))...)
+	if err != nil {
+		return nil, err
+	}
+	var objects []rcloneObject
+	if err := json.Unmarshal(out, &objects); err != nil {
+		return nil, fmt.Errorf("can't parse rclone lsjson output: %v", err)
+	}
+	return objects, nil
+}
+
+func (r *Rclone) remotePath(key string) string {
+	return r.Config.Remote + ":" + strings.TrimPrefix(key, "/")
+}
+
+func (r *Rclone) command(args ...string) *exec.Cmd {
+	if r.Config.ConfigFile != "" {
+		args = append([]string{"--config", r.Config.ConfigFile}, args...)
+	}
+	if r.Config.Debug {
+		args = append([]string{"-vv"}, args...)
+	}
+	cmd := exec.Command(r.Config.Binary, args...)
+	if r.Config.Debug {
+		cmd.Stderr = os.Stderr
+	}
+	return cmd
+}
+
+// run - execute rclone and return its stdout
+func (r *Rclone) run(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := r.command(args...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &rcloneError{args: args, err: err, stderr: strings.TrimSpace(stderr.String())}
+	}
+	return stdout.Bytes(), nil
+}
+
+type rcloneError struct {
+	args   []string
+	err    error
+	stderr string
+}
+
+func (e *rcloneError) Error() string {
+	return fmt.Sprintf("rclone %s: %v: %s", strings.Join(e.args, " "), e.err, e.stderr)
+}
+
+func isRcloneNotFound(err error) bool {
+	rcloneErr, ok := err.(*rcloneError)
+	if !ok {
+		return false
+	}
+	exitErr, ok := rcloneErr.err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	return exitErr.ExitCode() == rcloneExitDirNotFound || exitErr.ExitCode() == rcloneExitFileNotFound
+}
+
+// rcloneReader - stdout of 'rclone cat', error of rclone is returned instead of EOF
+type rcloneReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (r *rcloneReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("rclone cat: %v: %s", waitErr, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (r *rcloneReader) Close() error {
+	if r.done {
+		return nil
+	}
+	r.done = true
+	r.stdout.Close()
+	if r.cmd.Process != nil {
+		r.cmd.Process.Kill()
+	}
+	r.cmd.Wait()
+	return nil
+}
+
+type rcloneFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func newRcloneFile(name string, o rcloneObject) *rcloneFile {
+	lastModified, _ := time.Parse(time.RFC3339Nano, o.ModTime)
+	return &rcloneFile{
+		size:         o.Size,
+		lastModified: lastModified,
+		name:         name,
+	}
+}
+
+func (f *rcloneFile) Size() int64 {
+	return f.size
+}
+
+func (f *rcloneFile) LastModified() time.Time {
+	return f.lastModified
+}
+
+func (f *rcloneFile) Name() string {
+	return f.name
+}
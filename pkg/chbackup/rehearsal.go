@@ -0,0 +1,151 @@
+package chbackup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RehearsalDatabasePrefix - prefix of temporary database created by 'restore --rehearsal'
+const RehearsalDatabasePrefix = "_restore_test_"
+
+var createTableNameRE = regexp.MustCompile("^CREATE TABLE\\s+(?:IF NOT EXISTS\\s+)?(?:`[^`]*`(?:\\.`[^`]*`)?|[^\\s(]+)(?:\\s+UUID\\s+'[^']*')?")
+
+// rehearsalDatabaseName - name of temporary database of rehearsal started at t
+func rehearsalDatabaseName(t time.Time) string {
+	return RehearsalDatabasePrefix + t.UTC().Format("20060102150405")
+}
+
+// rehearsalTableName - name of table in temporary database, tables of different databases can't clash
+func rehearsalTableName(database, table string) string {
+	return database + "." + table
+}
+
+// renameCreateTable - replace name of table in CREATE TABLE query, UUID of table in Atomic database is removed
+func renameCreateTable(query, database, table string) (string, error) {
+	loc := createTableNameRE.FindStringIndex(query)
+	if loc == nil {
+		return "", fmt.Errorf("can't find name of table in query")
+	}
+	return fmt.Sprintf("CREATE TABLE `%s`.`%s`%s", database, table, query[loc[1]:]), nil
+}
+
+// getRehearsalSchemas - MergeTree family tables of backup renamed to tables of temporary database,
+// Replicated engines are converted to plain ones so restored tables don't touch ZooKeeper paths of production tables
+func getRehearsalSchemas(schemas RestoreTables, database string, rewriteDDL bool) (RestoreTables, error) {
+	result := RestoreTables{}
+	for _, schema := range schemas {
+		if !strings.HasPrefix(schema.Query, "CREATE TABLE") {
+			continue
+		}
+		if e, err := parseMergeTreeEngine(schema.Query); err != nil || e == nil {
+			continue
+		}
+		query, err := convertEngine(schema.Query, schema.Database, schema.Table, EngineConvertPlain, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("can't convert engine of '%s.%s': %v", schema.Database, schema.Table, err)
+		}
+		if rewriteDDL {
+			if query, err = rewriteOldMergeTreeSyntax(query); err != nil {
+				return nil, fmt.Errorf("can't rewrite schema of '%s.%s': %v", schema.Database, schema.Table, err)
+			}
+		}
+		name := rehearsalTableName(schema.Database, schema.Table)
+		if query, err = renameCreateTable(query, database, name); err != nil {
+			return nil, fmt.Errorf("can't rename '%s.%s': %v", schema.Database, schema.Table, err)
+		}
+		result = append(result, RestoreTable{Database: database, Table: name, Query: query, Path: schema.Path})
+	}
+	return result, nil
+}
+
+// restoreRehearsal - restore MergeTree family tables of backup into temporary database, validate restored data
+// and drop temporary database, production tables are not changed
+func restoreRehearsal(ctx context.Context, config Config, backupName string, tablePattern string, options RestoreOptions) ([]string, error) {
+	if backupName == "" {
+		PrintLocalBackups(config, "all")
+		return nil, fmt.Errorf("select backup for restore")
+	}
+	dataPath := getDataPath(config)
+	if dataPath == "" {
+		return nil, ErrUnknownClickhouseDataPath
+	}
+	backupPath := path.Join(dataPath, "backup", backupName)
+	if getLocalBackupMetadata(backupPath).SchemaOnly {
+		return nil, fmt.Errorf("backup '%s' was downloaded without data, download it again without '--schema'", backupName)
+	}
+	metadataPath := path.Join(backupPath, "metadata")
+	if _, err := os.Stat(metadataPath); err != nil {
+		return nil, err
+	}
+	schemas, err := parseSchemaPattern(metadataPath, tablePattern)
+	if err != nil {
+		return nil, err
+	}
+	database := rehearsalDatabaseName(time.Now())
+	if schemas, err = getRehearsalSchemas(schemas, database, options.RewriteDDL); err != nil {
+		return nil, err
+	}
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("backup doesn't have MergeTree tables to restore")
+	}
+	ch := &ClickHouse{
+		Config:   &config.ClickHouse,
+		ctx:      ctx,
+		settings: config.ClickHouse.RestoreSettings,
+	}
+	if err := ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer ch.Close()
+
+	allBackupTables, err := ch.GetBackupTables(backupName)
+	if err != nil {
+		return nil, err
+	}
+	restoreTables, err := parseTablePatternForRestoreData(allBackupTables, tablePattern)
+	if err != nil {
+		return nil, err
+	}
+	created := map[string]bool{}
+	for _, schema := range schemas {
+		created[schema.Table] = true
+	}
+	tables := []BackupTable{}
+	for _, table := range restoreTables {
+		if created[rehearsalTableName(table.Database, table.Name)] {
+			tables = append(tables, table)
+		}
+	}
+
+	log.Printf("Create database '%s' for rehearsal of '%s'", database, backupName)
+	if err := ch.CreateDatabase(database); err != nil {
+		return nil, fmt.Errorf("can't create database '%s': %v", database, err)
+	}
+	defer func() {
+		log.Printf("Drop database '%s'", database)
+		if err := ch.DropDatabase(database); err != nil {
+			log.Printf("can't drop database '%s': %v", database, err)
+		}
+	}()
+	for _, schema := range schemas {
+		if err := ch.CreateTable(schema, false); err != nil {
+			return nil, fmt.Errorf("can't create table '%s.%s': %v", schema.Database, schema.Table, err)
+		}
+	}
+	// expired rows removed by TTL merges would fail validation
+	restored, err := restoreTablesData(ch, config, backupName, tables, RestoreOptions{Validate: true, StopTTLMerges: true}, func(db, table string) (string, string) {
+		return database, rehearsalTableName(db, table)
+	}, newTableReport("rehearsal", false))
+	if err != nil {
+		log.Printf("Rehearsal of '%s' failed", backupName)
+		return restored, err
+	}
+	log.Printf("Rehearsal of '%s' passed: %d tables restored and validated", backupName, len(restored))
+	return restored, nil
+}
@@ -0,0 +1,47 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameCreateTable(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "CREATE TABLE _ UUID 'f2b8c1e4-0000-4000-8000-000000000001' (id UInt64) ENGINE = MergeTree ORDER BY id",
+			expected: "CREATE TABLE `_restore_test_1`.`db.t` (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			query:    "CREATE TABLE t\n(\n    `id` UInt64\n)\nENGINE = MergeTree ORDER BY id",
+			expected: "CREATE TABLE `_restore_test_1`.`db.t`\n(\n    `id` UInt64\n)\nENGINE = MergeTree ORDER BY id",
+		},
+		{
+			query:    "CREATE TABLE `db`.`t` (id UInt64) ENGINE = MergeTree ORDER BY id",
+			expected: "CREATE TABLE `_restore_test_1`.`db.t` (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+	}
+	for _, tc := range testCases {
+		query, err := renameCreateTable(tc.query, "_restore_test_1", "db.t")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, query)
+	}
+}
+
+func TestGetRehearsalSchemas(t *testing.T) {
+	schemas := RestoreTables{
+		{Database: "db", Table: "t", Query: "CREATE TABLE t (id UInt64) ENGINE = ReplicatedMergeTree('/zk/t', '{replica}') ORDER BY id"},
+		{Database: "db", Table: "d", Query: "CREATE TABLE d (id UInt64) ENGINE = Distributed('cluster', 'db', 't')"},
+		{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW mv ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.t"},
+	}
+	result, err := getRehearsalSchemas(schemas, "_restore_test_1", false)
+	assert.NoError(t, err)
+	assert.Equal(t, RestoreTables{{
+		Database: "_restore_test_1",
+		Table:    "db.t",
+		Query:    "CREATE TABLE `_restore_test_1`.`db.t` (id UInt64) ENGINE = MergeTree() ORDER BY id",
+	}}, result)
+}
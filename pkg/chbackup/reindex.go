@@ -0,0 +1,269 @@
+package chbackup
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/djherbis/nio.v2"
+)
+
+// getRemoteArchives - return names of archives with extension ext from names of objects in root of remote path,
+// archive stored as chunks is returned once
+func getRemoteArchives(names []string, ext string) []string {
+	archives := map[string]bool{}
+	for _, name := range names {
+		if archiveName, ok := archiveChunkName(name); ok {
+			name = archiveName
+		}
+		if strings.HasSuffix(name, "."+ext) && isArchiveName(name) {
+			archives[name] = true
+		}
+	}
+	result := make([]string, 0, len(archives))
+	for name := range archives {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// getManifestChain - required backup, backup required by it and so on, chain stops at missing manifest
+func getManifestChain(manifests map[string]*BackupMetadata, backupName string) []string {
+	chain := []string{}
+	visited := map[string]bool{backupName: true}
+	m := manifests[backupName]
+	for m != nil && m.RequiredBackup != "" && !visited[m.RequiredBackup] {
+		visited[m.RequiredBackup] = true
+		chain = append(chain, m.RequiredBackup)
+		m = manifests[m.RequiredBackup]
+	}
+	return chain
+}
+
+// filterArchiveTables - keep tables which have metadata in archive, metadata.json contains all tables of local backup
+// when only tables matched by pattern were uploaded
+func filterArchiveTables(tables []BackupTableMetadata, archiveTables map[string]bool) []BackupTableMetadata {
+	result := []BackupTableMetadata{}
+	for _, t := range tables {
+		if archiveTables[path.Join(TablePathEncode(t.Database), TablePathEncode(t.Table))] {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// rebuildManifest - read the whole archive and build its manifest from metadata.json and meta.info of archive
+func (bd *BackupDestination) rebuildManifest(archiveName string) (*BackupMetadata, error) {
+	key := path.Join(bd.path, archiveName)
+	file, err := bd.getArchive(key)
+	if err != nil {
+		return nil, err
+	}
+	var metafile MetaFile
+	var backupMetadata []byte
+	var archiveTables map[string]bool
+	var hash *archiveHash
+	if err := bd.retrier.do(fmt.Sprintf("read of '%s'", key), func() error {
+		metafile = MetaFile{}
+		backupMetadata = nil
+		archiveTables = map[string]bool{}
+		hash = newArchiveHash()
+		reader := bd.openArchive(file)
+		defer reader.Close()
+		buffers := transferBuffers.get(bd.bufferSize, 1)
+		defer releaseBuffers(buffers)
+		bufReader := nio.NewReader(io.TeeReader(reader, hash), buffers[0])
+		z, _ := getArchiveReader(bd.compressionFormat)
+		if err := z.Open(bufReader, 0); err != nil {
+			return err
+		}
+		defer z.Close()
+		for {
+			f, err := z.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			header, ok := f.Header.(*tar.Header)
+			if !ok {
+				return fmt.Errorf("expected header to be *tar.Header but was %T", f.Header)
+			}
+			switch parts := strings.Split(header.Name, "/"); {
+			case header.Name == MetaFileName:
+				b, err := ioutil.ReadAll(f)
+				if err != nil {
+					return fmt.Errorf("can't read %s: %v", MetaFileName, err)
+				}
+				if err := json.Unmarshal(b, &metafile); err != nil {
+					return fmt.Errorf("can't parse %s: %v", MetaFileName, err)
+				}
+			case header.Name == BackupMetadataFileName:
+				if backupMetadata, err = ioutil.ReadAll(f); err != nil {
+					return fmt.Errorf("can't read %s: %v", BackupMetadataFileName, err)
+				}
+			case len(parts) == 3 && parts[0] == "metadata" && strings.HasSuffix(parts[2], ".sql"):
+				archiveTables[path.Join(parts[1], strings.TrimSuffix(parts[2], ".sql"))] = true
+			}
+		}
+		_, err := io.Copy(ioutil.Discard, bufReader)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	manifest := BackupMetadata{}
+	if backupMetadata != nil {
+		if err := json.Unmarshal(backupMetadata, &manifest); err != nil {
+			log.Printf("can't parse %s of '%s': %v", BackupMetadataFileName, archiveName, err)
+			manifest = BackupMetadata{}
+		}
+	}
+	manifest.BackupName = trimArchiveExtension(archiveName)
+	if manifest.CreationDate.IsZero() {
+		manifest.CreationDate = file.LastModified()
+	}
+	manifest.Tables = filterArchiveTables(manifest.Tables, archiveTables)
+	manifest.Detached = filterArchiveTables(manifest.Detached, archiveTables)
+	manifest.Size = 0
+	for _, t := range manifest.Tables {
+		manifest.Size += t.Size
+	}
+	manifest.RequiredBackup = metafile.RequiredBackup
+	manifest.Chain = nil
+	manifest.SchemaOnly = false
+	manifest.UploadState = UploadStateUploaded
+	manifest.ArchiveSize, manifest.ArchiveChecksum = hash.size, hash.checksum()
+	manifest.SchemaSize, manifest.SchemaChecksum = 0, ""
+	schemaKey := key + schemaSuffix
+	if _, err := bd.GetFile(schemaKey); err == nil {
+		schemaHash := newArchiveHash()
+		if err := bd.retrier.do(fmt.Sprintf("read of '%s'", schemaKey), func() error {
+			schemaHash = newArchiveHash()
+			reader, err := bd.GetFileReader(schemaKey)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+			_, err = io.Copy(schemaHash, reader)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		manifest.SchemaSize, manifest.SchemaChecksum = schemaHash.size, schemaHash.checksum()
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// reindexRemote - rebuild missing and corrupted manifests of archives of one remote storage, all manifests are rebuilt when all is set
+func reindexRemote(config Config, all bool, dryRun bool) (int, error) {
+	bd, err := NewBackupDestination(config)
+	if err != nil {
+		return 0, err
+	}
+	if err := bd.Connect(); err != nil {
+		return 0, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer bd.Close()
+	names := []string{}
+	others := map[string]bool{}
+	if err := bd.Walk(bd.path, func(f RemoteFile) {
+		name := strings.TrimPrefix(strings.TrimPrefix(f.Name(), bd.path), "/")
+		if name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+			if archiveName, ok := archiveChunkName(name); ok {
+				name = archiveName
+			}
+			if isArchiveName(name) && !strings.HasSuffix(name, "."+getExtension(bd.compressionFormat)) {
+				others[name] = true
+			}
+		}
+	}); err != nil {
+		return 0, err
+	}
+	for name := range others {
+		log.Printf("Skip '%s', compression_format of archive differs from config", name)
+	}
+	manifests := map[string]*BackupMetadata{}
+	rebuilt := []string{}
+	for _, archiveName := range getRemoteArchives(names, getExtension(bd.compressionFormat)) {
+		backupName := trimArchiveExtension(archiveName)
+		manifest, err := bd.getManifest(archiveName)
+		switch {
+		case err == nil && !all && manifest.UploadState == UploadStateUploaded:
+			manifests[backupName] = manifest
+			continue
+		case err == nil && !all:
+			// manifest of upload which may be still running is not replaced
+			log.Printf("Skip '%s', upload is not completed, use --all to rebuild its manifest", archiveName)
+			manifests[backupName] = manifest
+			continue
+		case err == ErrNotFound:
+			log.Printf("Manifest of '%s' is missing", archiveName)
+		case err != nil:
+			log.Printf("Manifest of '%s' is corrupted: %v", archiveName, err)
+		}
+		log.Printf("Read '%s'", archiveName)
+		if manifest, err = bd.rebuildManifest(archiveName); err != nil {
+			return 0, fmt.Errorf("can't rebuild manifest of '%s': %v", archiveName, err)
+		}
+		manifests[backupName] = manifest
+		rebuilt = append(rebuilt, archiveName)
+	}
+	for _, archiveName := range rebuilt {
+		manifest := manifests[trimArchiveExtension(archiveName)]
+		manifest.Chain = getManifestChain(manifests, manifest.BackupName)
+		if len(manifest.Chain) == 0 {
+			manifest.Chain = nil
+		}
+		if dryRun {
+			log.Printf("Manifest of '%s' would be uploaded with %d tables", archiveName, len(manifest.Tables))
+			continue
+		}
+		if err := bd.putManifest(path.Join(bd.path, archiveName), *manifest); err != nil {
+			return 0, fmt.Errorf("can't upload manifest of '%s': %v", archiveName, err)
+		}
+		if err := bd.holdManifest(path.Join(bd.path, archiveName)); err != nil {
+			return 0, err
+		}
+		log.Printf("Manifest of '%s' is uploaded with %d tables", archiveName, len(manifest.Tables))
+	}
+	return len(rebuilt), nil
+}
+
+// ReindexRemote - read archives of remote storages selected by target which have missing or corrupted manifests
+// and upload manifests rebuilt from content of archives
+func ReindexRemote(config Config, target string, all bool, dryRun bool) error {
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			fmt.Println("ReindexRemote aborted: RemoteStorage set to \"none\"")
+			continue
+		}
+		count, err := reindexRemote(t.Config, all, dryRun)
+		if err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("remote target '%s': %v", t.Name, err)
+			}
+			return err
+		}
+		if len(targets) > 1 {
+			log.Printf("Remote target '%s': %d manifests rebuilt", t.Name, count)
+		} else {
+			log.Printf("%d manifests rebuilt", count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRemoteArchives(t *testing.T) {
+	names := []string{
+		"full.tar.gz",
+		"full.tar.gz.json",
+		"full.tar.gz.schema",
+		"diff.tar.gz.001",
+		"diff.tar.gz.002",
+		"old.tar.lz4",
+		"legacy",
+	}
+	assert.Equal(t, []string{"diff.tar.gz", "full.tar.gz"}, getRemoteArchives(names, "tar.gz"))
+}
+
+func TestGetManifestChain(t *testing.T) {
+	manifests := map[string]*BackupMetadata{
+		"full":  {BackupName: "full"},
+		"diff1": {BackupName: "diff1", RequiredBackup: "full"},
+		"diff2": {BackupName: "diff2", RequiredBackup: "diff1"},
+		"diff3": {BackupName: "diff3", RequiredBackup: "missing"},
+	}
+	assert.Equal(t, []string{"diff1", "full"}, getManifestChain(manifests, "diff2"))
+	assert.Equal(t, []string{}, getManifestChain(manifests, "full"))
+	assert.Equal(t, []string{"missing"}, getManifestChain(manifests, "diff3"))
+}
+
+func TestFilterArchiveTables(t *testing.T) {
+	tables := []BackupTableMetadata{
+		{Database: "db", Table: "t1"},
+		{Database: "db", Table: "t-2"},
+		{Database: "db", Table: "t3"},
+	}
+	archiveTables := map[string]bool{"db/t1": true, "db/t%2D2": true}
+	assert.Len(t, filterArchiveTables(tables, archiveTables), 2)
+}
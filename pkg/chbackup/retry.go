@@ -0,0 +1,73 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// maxRetryPause - upper bound of pause between retries of one transfer
+const maxRetryPause = 10 * time.Minute
+
+// retrier - retry policy for transfers of single files to and from remote storage
+type retrier struct {
+	retries int
+	pause   time.Duration
+}
+
+// newRetrier - create retrier from general.retries_on_failure and general.retries_pause
+func newRetrier(config GeneralConfig) (retrier, error) {
+	pause, err := time.ParseDuration(config.RetriesPause)
+	if err != nil {
+		return retrier{}, fmt.Errorf("invalid retries_pause: %v", err)
+	}
+	return retrier{retries: config.RetriesOnFailure, pause: pause}, nil
+}
+
+// do - run fn until it succeeds, fails with non retriable error or retries are exhausted
+func (r retrier) do(operation string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= r.retries || !isRetriableError(err) {
+			return err
+		}
+		pause := r.backoff(attempt)
+		log.Printf("%s failed: %v, retry %d/%d in %s", operation, err, attempt+1, r.retries, pause)
+		time.Sleep(pause)
+	}
+}
+
+// backoff - pause grows exponentially with attempt, random jitter in the upper half of pause
+// prevents retries from many servers hitting remote storage at the same moment
+func (r retrier) backoff(attempt int) time.Duration {
+	if r.pause <= 0 {
+		return 0
+	}
+	pause := r.pause
+	for i := 0; i < attempt && pause < maxRetryPause; i++ {
+		pause *= 2
+	}
+	if pause > maxRetryPause {
+		pause = maxRetryPause
+	}
+	return pause/2 + time.Duration(rand.Int63n(int64(pause/2)+1))
+}
+
+// isRetriableError - errors of local filesystem and missing remote files won't be fixed by retry
+func isRetriableError(err error) bool {
+	if err == ErrNotFound {
+		return false
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return false
+	}
+	if _, ok := err.(*os.LinkError); ok {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetrierDo(t *testing.T) {
+	r := retrier{retries: 2}
+	attempts := 0
+	err := r.do("test", func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("503 Service Unavailable")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = r.do("test", func() error {
+		attempts++
+		return fmt.Errorf("503 Service Unavailable")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = r.do("test", func() error {
+		attempts++
+		return &os.PathError{Op: "open", Path: "/nonexistent", Err: os.ErrNotExist}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	r := retrier{retries: 10, pause: time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		pause := r.backoff(attempt)
+		assert.True(t, pause >= max/2 && pause <= max, "attempt %d: %s", attempt, pause)
+	}
+	assert.True(t, r.backoff(100) <= maxRetryPause)
+	assert.Equal(t, time.Duration(0), retrier{}.backoff(1))
+}
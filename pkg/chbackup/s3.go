@@ -2,8 +2,10 @@ package chbackup
 
 import (
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -18,8 +20,12 @@ import (
 
 // S3 - presents methods for manipulate data on s3
 type S3 struct {
-	session *session.Session
-	Config  *S3Config
+	session           *session.Session
+	Config            *S3Config
+	objectLockOnce    sync.Once
+	objectLockEnabled bool
+	// lowMemory - upload fewer parts at the same time, general.low_memory
+	lowMemory bool
 }
 
 // Connect - connect to s3
@@ -69,6 +75,10 @@ func (s *S3) Kind() string {
 	return "S3"
 }
 
+func (s *S3) Close() error {
+	return nil
+}
+
 func (s *S3) GetFileReader(key string) (io.ReadCloser, error) {
 	svc := s3.New(s.session)
 	req, resp := svc.GetObjectRequest(&s3.GetObjectInput{
@@ -85,21 +95,77 @@ func (s *S3) GetFileReader(key string) (io.ReadCloser, error) {
 func (s *S3) PutFile(key string, r io.ReadCloser) error {
 	uploader := s3manager.NewUploader(s.session)
 	uploader.Concurrency = 10
+	if s.lowMemory {
+		uploader.Concurrency = lowMemoryUploadConcurrency
+	}
 	uploader.PartSize = s.Config.PartSize
 	var sse *string
 	if s.Config.SSE != "" {
 		sse = aws.String(s.Config.SSE)
 	}
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		ACL:                  aws.String(s.Config.ACL),
 		Bucket:               aws.String(s.Config.Bucket),
 		Key:                  aws.String(key),
 		Body:                 r,
 		ServerSideEncryption: sse,
-	})
+	}
+	if s.Config.ObjectLockMode != "" {
+		input.ObjectLockMode = aws.String(s.Config.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().AddDate(0, 0, s.Config.ObjectLockDays))
+	}
+	if s.Config.ObjectLockLegalHold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+	_, err := uploader.Upload(input)
 	return err
 }
 
+// GetFileLock - get legal hold and retention of object, objects of buckets without Object Lock are not checked
+func (s *S3) GetFileLock(key string) (string, error) {
+	svc := s3.New(s.session)
+	s.objectLockOnce.Do(func() {
+		// bucket without Object Lock configuration or without permission to read it is treated as unlocked
+		_, err := svc.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+			Bucket: aws.String(s.Config.Bucket),
+		})
+		s.objectLockEnabled = err == nil
+	})
+	if !s.objectLockEnabled {
+		return "", nil
+	}
+	legalHold, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNoObjectLockError(err) {
+		return "", err
+	}
+	if err == nil && legalHold.LegalHold != nil && aws.StringValue(legalHold.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn {
+		return "legal hold", nil
+	}
+	retention, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNoObjectLockError(err) {
+		return "", err
+	}
+	if err == nil && retention.Retention != nil {
+		until := aws.TimeValue(retention.Retention.RetainUntilDate)
+		if until.After(time.Now()) {
+			return fmt.Sprintf("%s retention until %s", aws.StringValue(retention.Retention.Mode), until.Format(time.RFC3339)), nil
+		}
+	}
+	return "", nil
+}
+
+// isNoObjectLockError - object has no legal hold or no retention
+func isNoObjectLockError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "NoSuchObjectLockConfiguration" || aerr.Code() == "InvalidRequest")
+}
+
 func (s *S3) DeleteFile(key string) error {
 	params := &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
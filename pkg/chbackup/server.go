@@ -1,16 +1,21 @@
 package chbackup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,61 +23,178 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli"
-	"golang.org/x/sync/semaphore"
 	yaml "gopkg.in/yaml.v2"
 )
 
 const (
 	// APITimeFormat - clickhouse compatibility time format
 	APITimeFormat = "2006-01-02 15:04:05"
+	// unixSocketPrefix - prefix of api.listen and api.metrics_listen_addr to listen on unix domain socket
+	unixSocketPrefix = "unix://"
+	// APIVersion - version of API, it's sent in APIVersionHeader of every response
+	APIVersion = "1"
+	// APIVersionHeader - header of request with required version of API and header of response with version of API
+	APIVersionHeader = "API-Version"
+	// apiV1Prefix - prefix of versioned routes, routes without prefix are aliases of version 1
+	apiV1Prefix = "/api/v1"
+	// shutdownTimeout - how long server with changed listen address waits for in-flight requests
+	shutdownTimeout = time.Minute
 )
 
 type APIServer struct {
-	c       *cli.App
-	config  Config
-	lock    *semaphore.Weighted
-	server  *http.Server
+	c *cli.App
+	// config - current config, it's replaced by config update, handlers read it by getConfig
+	config     Config
+	configLock sync.RWMutex
+	// locks - locks of running operations of server by backup
+	locks  lockSet
+	server *http.Server
+	// restart - servers are restarted when their listen address is changed by config update
 	restart chan struct{}
 	status  *AsyncStatus
 	metrics Metrics
+	audit   *auditLog
+	// handlers - apiHandlers built from current config, they are replaced without restart of servers
+	handlers atomic.Value
+	// metricsServer - server of metrics and pprof on api.metrics_listen_addr
+	metricsServer *http.Server
+	// ctx - context of operations running in background, it's canceled on stop of server so their queries are canceled
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// apiHandlers - handlers of API server and metrics server built from config
+type apiHandlers struct {
+	api     http.Handler
+	metrics http.Handler
 	routes  []string
 }
 
 type AsyncStatus struct {
 	commands []CommandInfo
+	lastID   int
+	// audit - results of finished commands are written to audit log
+	audit *auditLog
 	sync.RWMutex
 }
 
 type CommandInfo struct {
-	Command    string `json:"command"`
-	Status     string `json:"status"`
-	Progress   string `json:"progress,omitempty"`
-	Start      string `json:"start,omitempty"`
-	Finish     string `json:"finish,omitempty"`
-	Error      string `json:"error,omitempty"`
+	// ID - job ID returned by API when operation is started
+	ID       int    `json:"id"`
+	Command  string `json:"command"`
+	Status   string `json:"status"`
+	Progress string `json:"progress,omitempty"`
+	Start    string `json:"start,omitempty"`
+	Finish   string `json:"finish,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Tables - status of every table when operation with continue_on_error failed for some of tables
+	Tables []TableStatus `json:"tables,omitempty"`
 }
 
-func (status *AsyncStatus) start(command string) {
+// start - add running command and return its job ID
+func (status *AsyncStatus) start(command string) int {
 	status.Lock()
 	defer status.Unlock()
+	status.lastID++
 	status.commands = append(status.commands, CommandInfo{
+		ID:      status.lastID,
 		Command: command,
 		Start:   time.Now().Format(APITimeFormat),
 		Status:  "in progress",
 	})
+	return status.lastID
 }
 
-func (status *AsyncStatus) stop(err error) {
+// stop - set result of command with job ID
+func (status *AsyncStatus) stop(id int, err error) {
 	status.Lock()
 	defer status.Unlock()
-	n := len(status.commands) - 1
-	s := "success"
-	if err != nil {
-		s = "error"
-		status.commands[n].Error = err.Error()
+	for n := range status.commands {
+		if status.commands[n].ID != id {
+			continue
+		}
+		s := "success"
+		if err != nil {
+			s = "error"
+			status.commands[n].Error = err.Error()
+		}
+		var tablesErr *TablesError
+		if errors.As(err, &tablesErr) {
+			status.commands[n].Tables = tablesErr.Tables
+		}
+		status.commands[n].Status = s
+		status.commands[n].Finish = time.Now().Format(APITimeFormat)
+		status.audit.job(status.commands[n])
+		return
+	}
+}
+
+// get - return command with job ID
+func (status *AsyncStatus) get(id int) (CommandInfo, bool) {
+	status.RLock()
+	defer status.RUnlock()
+	for _, c := range status.commands {
+		if c.ID == id {
+			return c, true
+		}
 	}
-	status.commands[n].Status = s
-	status.commands[n].Finish = time.Now().Format(APITimeFormat)
+	return CommandInfo{}, false
+}
+
+// interrupted - add operations interrupted by previous run of server or by killed CLI command
+func (status *AsyncStatus) interrupted(operations []OperationJournal) {
+	status.Lock()
+	defer status.Unlock()
+	for _, j := range operations {
+		command := fmt.Sprintf("%s %s", j.Operation, j.BackupName)
+		if j.Target != "" && j.Target != PrimaryTarget {
+			command = fmt.Sprintf("%s --target=%s", command, j.Target)
+		}
+		status.lastID++
+		status.commands = append(status.commands, CommandInfo{
+			ID:       status.lastID,
+			Command:  command,
+			Status:   "interrupted",
+			Progress: fmt.Sprintf("phase '%s', %d items processed", j.Phase, j.Processed),
+			Start:    j.Start.Format(APITimeFormat),
+			Finish:   j.Updated.Format(APITimeFormat),
+			Error:    j.Error,
+		})
+	}
+}
+
+// lastErrors - the last finished command of every operation when it failed, operation is the first word of command.
+// Error of operation is cleared by its next successful run
+func (status *AsyncStatus) lastErrors() []CommandInfo {
+	status.RLock()
+	defer status.RUnlock()
+	last := map[string]int{}
+	operations := []string{}
+	for n, c := range status.commands {
+		if c.Status == "in progress" {
+			continue
+		}
+		operation := commandOperation(c.Command)
+		if _, ok := last[operation]; !ok {
+			operations = append(operations, operation)
+		}
+		last[operation] = n
+	}
+	result := []CommandInfo{}
+	for _, operation := range operations {
+		if c := status.commands[last[operation]]; c.Error != "" {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// commandOperation - name of operation of command like 'create --table=db.* backup1'
+func commandOperation(command string) string {
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
 }
 
 func (status *AsyncStatus) status() []CommandInfo {
@@ -83,119 +205,454 @@ func (status *AsyncStatus) status() []CommandInfo {
 
 var (
 	ErrAPILocked = errors.New("another operation is currently running")
+	// ErrAPIBusy - api.max_operations operations of other backups are running
+	ErrAPIBusy = errors.New("another operation is currently running, api.max_operations is reached")
 )
 
 // Server - expose CLI commands as REST API
 func Server(c *cli.App, config Config) error {
+	return runServer(c, config, nil)
+}
+
+// runServer - run API server until SIGTERM is received or stop is closed
+func runServer(c *cli.App, config Config, stop <-chan struct{}) error {
+	disablePrompts()
 	api := APIServer{
 		c:       c,
 		config:  config,
-		lock:    semaphore.NewWeighted(1),
-		restart: make(chan struct{}),
+		restart: make(chan struct{}, 1),
 		status:  &AsyncStatus{},
 	}
-	api.metrics = setupMetrics()
+	api.ctx, api.cancel = context.WithCancel(context.Background())
+	api.metrics = setupMetrics(api.status)
+	audit, err := openAuditLog(config.API.AuditLog)
+	if err != nil {
+		return err
+	}
+	defer audit.Close()
+	api.audit, api.status.audit = audit, audit
+	if operations, err := GetInterruptedOperations(config); err != nil {
+		log.Printf("can't read journals of interrupted operations: %v", err)
+	} else {
+		for _, j := range operations {
+			log.Printf("Found interrupted operation: %s", &j)
+		}
+		api.status.interrupted(operations)
+	}
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 	sighup := make(chan os.Signal, 1)
 	signal.Notify(sighup, os.Interrupt, syscall.SIGHUP)
 
+	api.applyConfig(config)
+	go api.runVerify()
+	go api.runBackupMetrics()
 	for {
-		api.server = api.setupAPIServer(api.config)
-		go func() {
-			log.Printf("Starting API server on %s", api.config.API.ListenAddr)
-			if err := api.server.ListenAndServe(); err != http.ErrServerClosed {
-				log.Printf("error starting API server: %v", err)
-				os.Exit(1)
-			}
-		}()
 		select {
 		case <-api.restart:
-			log.Println("Reloading config and restarting API server")
-			api.server.Close()
-			continue
+			api.rebind()
 		case <-sighup:
-			log.Println("Reloading config and restarting API server")
-			api.server.Close()
-			continue
+			log.Println("Rebuilding API handlers")
+			api.applyConfig(api.getConfig())
 		case <-sigterm:
 			log.Println("Stopping API server")
-			return api.server.Close()
+			return api.close()
+		case <-stop:
+			log.Println("Stopping API server")
+			return api.close()
+		}
+	}
+}
+
+// tryLock - lock backup for operation of server and lock files of backup, so CLI running at the same time fails instead
+// of server. Returned function releases the lock
+func (api *APIServer) tryLock(operation, backupName string) (func(), error) {
+	locks := operationLocks(operation, backupName)
+	if err := api.locks.tryLock(locks, api.getConfig().API.MaxOperations); err != nil {
+		return nil, err
+	}
+	release, err := AcquireOperationLock(api.getConfig(), operation, backupName)
+	if err != nil {
+		api.locks.unlock(locks)
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			release()
+			api.locks.unlock(locks)
+		})
+	}, nil
+}
+
+// getConfig - return current config
+func (api *APIServer) getConfig() Config {
+	api.configLock.RLock()
+	defer api.configLock.RUnlock()
+	return api.config
+}
+
+// getProfileConfig - config of server with profile selected by 'profile' query argument of request
+func (api *APIServer) getProfileConfig(r *http.Request) (Config, error) {
+	config := api.getConfig()
+	return config, ApplyProfile(&config, r.URL.Query().Get("profile"))
+}
+
+// applyConfig - replace config and handlers, servers are restarted later only when their listen address is changed
+func (api *APIServer) applyConfig(config Config) {
+	api.configLock.Lock()
+	api.config = config
+	api.configLock.Unlock()
+	setBackupModes(config.General)
+	apiHandler, routes := api.setupAPIHandler(config)
+	api.handlers.Store(apiHandlers{
+		api:     apiHandler,
+		metrics: api.setupMetricsHandler(config),
+		routes:  routes,
+	})
+	select {
+	case api.restart <- struct{}{}:
+	default:
+	}
+}
+
+// rebind - start servers on addresses of current config, server with changed address is stopped after its in-flight requests are finished
+func (api *APIServer) rebind() {
+	config := api.getConfig()
+	api.server = rebindServer(api.server, newServer(config.API, config.API.ListenAddr), "API", func(w http.ResponseWriter, r *http.Request) {
+		api.handlers.Load().(apiHandlers).api.ServeHTTP(w, r)
+	})
+	if config.API.MetricsListenAddr == "" {
+		if api.metricsServer != nil {
+			shutdown(api.metricsServer, "metrics")
+			api.metricsServer = nil
+		}
+		return
+	}
+	api.metricsServer = rebindServer(api.metricsServer, newServer(config.API, config.API.MetricsListenAddr), "metrics", func(w http.ResponseWriter, r *http.Request) {
+		handler := api.handlers.Load().(apiHandlers).metrics
+		if handler == nil {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newServer - server on addr with timeouts and max size of headers of api section, durations are checked by validateConfig
+// and timeout which isn't set is 0, so it isn't limited
+func newServer(config APIConfig, addr string) *http.Server {
+	readTimeout, _ := time.ParseDuration(config.ReadTimeout)
+	writeTimeout, _ := time.ParseDuration(config.WriteTimeout)
+	idleTimeout, _ := time.ParseDuration(config.IdleTimeout)
+	return &http.Server{
+		Addr:           addr,
+		ReadTimeout:    readTimeout,
+		WriteTimeout:   writeTimeout,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: config.MaxHeaderBytes,
+	}
+}
+
+// rebindServer - return server which listens like next, running server is kept when its address and settings aren't changed
+func rebindServer(server *http.Server, next *http.Server, name string, handler http.HandlerFunc) *http.Server {
+	if server != nil {
+		if server.Addr == next.Addr && server.ReadTimeout == next.ReadTimeout && server.WriteTimeout == next.WriteTimeout &&
+			server.IdleTimeout == next.IdleTimeout && server.MaxHeaderBytes == next.MaxHeaderBytes {
+			return server
+		}
+		shutdown(server, name)
+	}
+	next.Handler = handler
+	serve(next, name)
+	return next
+}
+
+// shutdown - stop server after its in-flight requests are finished, it's stopped immediately after shutdownTimeout
+func shutdown(server *http.Server, name string) {
+	log.Printf("Stopping %s server on %s", name, server.Addr)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("%s server is stopped before in-flight requests are finished: %v", name, err)
+		server.Close()
+	}
+}
+
+// listen - listen on TCP address or on unix domain socket when address is 'unix://<path>', socket left by previous process is removed
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixSocketPrefix) {
+		if addr == "" {
+			addr = ":http"
+		}
+		return net.Listen("tcp", addr)
+	}
+	socketPath := strings.TrimPrefix(addr, unixSocketPrefix)
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("'%s' exists and is not a socket", socketPath)
+		}
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket '%s' is used by another process", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("can't remove socket: %v", err)
+		}
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("can't change mode of socket: %v", err)
+	}
+	return l, nil
+}
+
+// serve - start server in background, process exits when server can't be started
+func serve(server *http.Server, name string) {
+	go func() {
+		log.Printf("Starting %s server on %s", name, server.Addr)
+		l, err := listen(server.Addr)
+		if err == nil {
+			err = server.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			log.Printf("error starting %s server: %v", name, err)
+			os.Exit(1)
+		}
+	}()
+}
+
+// runVerify - verify the latest remote backup every verify.interval, interval is re-read from current config after every run,
+// run is skipped when another operation is running
+func (api *APIServer) runVerify() {
+	for {
+		interval := getVerifyInterval(api.getConfig().Verify)
+		if interval == 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(interval)
+		if getVerifyInterval(api.getConfig().Verify) == 0 {
+			continue
+		}
+		unlock, err := api.tryLock("verify", "")
+		if err != nil {
+			log.Printf("Scheduled verification is skipped: %v", err)
+			continue
+		}
+		id := api.status.start("verify")
+		backupName, err := VerifyLatestBackup(api.ctx, api.getConfig())
+		api.status.stop(id, err)
+		unlock()
+		api.metrics.LastVerifyEnd.Set(float64(time.Now().Unix()))
+		if err != nil {
+			log.Printf("Verification of '%s' failed: %v", backupName, err)
+			api.metrics.LastVerifySuccess.Set(0)
+			continue
+		}
+		log.Printf("Verification of '%s' passed", backupName)
+		api.metrics.LastVerifySuccess.Set(1)
+	}
+}
+
+// runBackupMetrics - list backups and check disks for metrics of backups and disks every api.backup_metrics_interval
+func (api *APIServer) runBackupMetrics() {
+	for {
+		interval := getBackupMetricsInterval(api.getConfig().API)
+		if interval == 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		if err := api.metrics.Backups.refresh(api.getConfig()); err != nil {
+			log.Printf("can't refresh metrics of backups: %v", err)
+		}
+		if err := api.metrics.Disks.refresh(api.getConfig()); err != nil {
+			log.Printf("can't refresh metrics of disks: %v", err)
+		}
+		if err := api.metrics.Freshness.refresh(api.getConfig()); err != nil {
+			log.Printf("can't refresh freshness of tables: %v", err)
 		}
+		time.Sleep(interval)
 	}
 }
 
-// setupAPIServer - resister API routes
-func (api *APIServer) setupAPIServer(config Config) *http.Server {
+// close - stop API server and metrics server
+func (api *APIServer) close() error {
+	api.cancel()
+	if api.metricsServer != nil {
+		api.metricsServer.Close()
+	}
+	if api.server == nil {
+		return nil
+	}
+	return api.server.Close()
+}
+
+// setupAPIHandler - resister API routes, return handler and list of routes
+func (api *APIServer) setupAPIHandler(config Config) (http.Handler, []string) {
 	r := mux.NewRouter()
-	r.Use(api.basicAuthMidleware)
+	r.Use(basicAuthMidleware(config.API))
 	r.HandleFunc("/", api.httpRootHandler).Methods("GET")
 
+	api.registerAPIRoutes(r, config)
+	api.registerAPIRoutes(r.PathPrefix(apiV1Prefix).Subrouter(), config)
+
+	var routes []string
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		t, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		if route.GetHandler() != nil {
+			routes = append(routes, t)
+		}
+		return nil
+	})
+	if config.API.MetricsListenAddr == "" {
+		registerMetricsHandlers(r, config.API.EnableMetrics, config.API.EnablePprof)
+	} else {
+		registerMetricsHandlers(r, false, false)
+	}
+	// allow_cidr is validated with config
+	networks, _ := parseAllowCIDR(config.API.AllowCIDR)
+
+	return auditMiddleware(api.audit, allowCIDRMiddleware(networks, rateLimitMiddleware(config.API.RateLimit, corsMiddleware(config.API.CORSAllowedOrigins, apiVersionMiddleware(r))))), routes
+}
+
+// registerAPIRoutes - register API routes, they are registered with '/api/v1' prefix and without prefix for compatibility
+func (api *APIServer) registerAPIRoutes(r *mux.Router, config Config) {
 	r.HandleFunc("/backup/tables", api.httpTablesHandler).Methods("GET")
+	r.HandleFunc("/backup/freshness", api.httpFreshnessHandler).Methods("GET")
 	r.HandleFunc("/backup/list", api.httpListHandler).Methods("GET")
-	r.HandleFunc("/backup/create", api.httpCreateHandler).Methods("POST")
-	r.HandleFunc("/backup/clean", api.httpCleanHandler).Methods("POST")
-	r.HandleFunc("/backup/freeze", api.httpFreezeHandler).Methods("POST")
-	r.HandleFunc("/backup/upload/{name}", api.httpUploadHandler).Methods("POST")
-	r.HandleFunc("/backup/download/{name}", api.httpDownloadHandler).Methods("POST")
-	r.HandleFunc("/backup/restore/{name}", api.httpRestoreHandler).Methods("POST")
-	r.HandleFunc("/backup/delete/{where}/{name}", api.httpDeleteHandler).Methods("POST")
+	r.HandleFunc("/backup/describe/{name}", api.httpDescribeHandler).Methods("GET")
+	r.HandleFunc("/backup/chain/{name}", api.httpChainHandler).Methods("GET")
 	r.HandleFunc("/backup/config/default", httpConfigDefaultHandler).Methods("GET")
-	r.HandleFunc("/backup/config", api.httpConfigHandler).Methods("GET")
-	r.HandleFunc("/backup/config", api.httpConfigUpdateHandler).Methods("POST")
+	// config contains credentials
+	r.HandleFunc("/backup/config", requireRole(RoleAdmin, api.httpConfigHandler)).Methods("GET")
 	r.HandleFunc("/backup/status", api.httpBackupStatusHandler).Methods("GET")
+	r.HandleFunc("/backup/status/{id}", api.httpJobStatusHandler).Methods("GET")
+	r.HandleFunc("/backup/last_error", api.httpLastErrorHandler).Methods("GET")
+	r.HandleFunc("/backup/version", api.httpVersionHandler).Methods("GET")
 
 	r.HandleFunc("/integration/actions", api.integrationBackupLog).Methods("GET")
 	r.HandleFunc("/integration/list", api.httpListHandler).Methods("GET")
 
-	r.HandleFunc("/integration/actions", api.integrationPost).Methods("POST")
+	if !config.API.ReadOnly {
+		r.HandleFunc("/backup/create", requireRole(RoleOperator, api.httpCreateHandler)).Methods("POST")
+		r.HandleFunc("/backup/create_remote", requireRole(RoleOperator, api.httpCreateRemoteHandler)).Methods("POST")
+		r.HandleFunc("/backup/clean", requireRole(RoleOperator, api.httpCleanHandler)).Methods("POST")
+		r.HandleFunc("/backup/freeze", requireRole(RoleOperator, api.httpFreezeHandler)).Methods("POST")
+		r.HandleFunc("/backup/upload/{name}", requireRole(RoleOperator, api.httpUploadHandler)).Methods("POST")
+		r.HandleFunc("/backup/download/{name}", requireRole(RoleOperator, api.httpDownloadHandler)).Methods("POST")
+		r.HandleFunc("/backup/restore/{name}", requireRole(RoleAdmin, api.httpRestoreHandler)).Methods("POST")
+		r.HandleFunc("/backup/delete/{where}/{name}", requireRole(RoleAdmin, api.httpDeleteHandler)).Methods("POST")
+		r.HandleFunc("/backup/config", requireRole(RoleAdmin, limitBody(config.API.MaxBodySize, api.httpConfigUpdateHandler))).Methods("POST")
+		r.HandleFunc("/integration/actions", requireRole(RoleOperator, limitBody(config.API.MaxBodySize, api.integrationPost))).Methods("POST")
+	}
+}
 
-	var routes []string
-	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-		t, err := route.GetPathTemplate()
-		if err != nil {
-			return err
-		}
-		routes = append(routes, t)
+// setupMetricsHandler - register metrics and pprof for api.metrics_listen_addr, it's nil when they are served by API server
+func (api *APIServer) setupMetricsHandler(config Config) http.Handler {
+	if config.API.MetricsListenAddr == "" {
 		return nil
-	})
-	api.routes = routes
+	}
+	r := mux.NewRouter()
+	r.Use(basicAuthMidleware(config.API))
 	registerMetricsHandlers(r, config.API.EnableMetrics, config.API.EnablePprof)
+	return r
+}
+
+// apiVersionMiddleware - send version of API and refuse requests which require another version
+func apiVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(APIVersionHeader, APIVersion)
+		if version := r.Header.Get(APIVersionHeader); version != "" && version != APIVersion {
+			writeError(w, http.StatusNotAcceptable, "", fmt.Errorf("API version '%s' is not supported, supported version is '%s'", version, APIVersion))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	srv := &http.Server{
-		Addr:    config.API.ListenAddr,
-		Handler: r,
+// isOriginAllowed - check origin of browser request against api.cors_allowed_origins
+func isOriginAllowed(allowedOrigins []string, origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if matched, _ := path.Match(strings.ToLower(allowed), strings.ToLower(origin)); matched {
+			return true
+		}
 	}
-	return srv
+	return false
 }
 
-func (api *APIServer) basicAuthMidleware(next http.Handler) http.Handler {
-	if api.config.API.Username == "" && api.config.API.Password == "" {
+// corsMiddleware - add CORS headers for allowed origins and answer preflight requests,
+// it wraps router because preflight requests don't match routes and must pass without credentials
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	if len(allowedOrigins) == 0 {
 		return next
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, pass, _ := r.BasicAuth()
-		query := r.URL.Query()
-		log.Println("query", query)
-		if u, exist := query["user"]; exist {
-			user = u[0]
-		}
-		if p, exist := query["pass"]; exist {
-			pass = p[0]
-		}
-		if (user != api.config.API.Username) || (pass != api.config.API.Password) {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("401 Unauthorized\n"))
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !isOriginAllowed(allowedOrigins, origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
 
+func basicAuthMidleware(config APIConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if config.Username == "" && config.Password == "" && len(config.Users) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass := requestCredentials(r)
+			role := authenticate(config, user, pass)
+			if role == "" {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"Provide username and password\"")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("401 Unauthorized\n"))
+				return
+			}
+			next.ServeHTTP(w, withRole(r, role))
+		})
+	}
+}
+
 // CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('http://127.0.0.1:7171/integration/actions?user=user&pass=pass', TSVWithNames)
 // INSERT INTO system.backup_actions (command) VALUES ('create backup_name')
 // INSERT INTO system.backup_actions (command) VALUES ('upload backup_name')
+// INSERT INTO system.backup_actions (command) VALUES ('create_remote backup_name')
+// INSERT INTO system.backup_actions (command) VALUES ('restore_remote backup_name')
+// INSERT INTO system.backup_actions (command) VALUES ('delete local backup_name')
 func (api *APIServer) integrationPost(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -212,48 +669,77 @@ func (api *APIServer) integrationPost(w http.ResponseWriter, r *http.Request) {
 	log.Println(commands)
 
 	switch commands[0] {
-	case "create", "upload", "download":
-		if locked := api.lock.TryAcquire(1); !locked {
-			log.Println(ErrAPILocked)
-			http.Error(w, ErrAPILocked.Error(), http.StatusLocked)
+	case "restore", "restore_remote", "delete":
+		if !hasRole(r, RoleAdmin) {
+			http.Error(w, fmt.Sprintf("'%s' role is required for '%s'", RoleAdmin, commands[0]), http.StatusForbidden)
 			return
 		}
-		defer api.lock.Release(1)
-		start := time.Now()
-		api.metrics.LastBackupStart.Set(float64(start.Unix()))
-		defer api.metrics.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
-		defer api.metrics.LastBackupEnd.Set(float64(time.Now().Unix()))
-
+	}
+	switch commands[0] {
+	case "create", "upload", "download", "restore", "create_remote", "restore_remote":
+		positional := integrationArgs(api.c, commands)
+		// name of new backup is generated before lock and passed to CLI, so both of them lock the same backup
+		if len(positional) == 0 && (commands[0] == "create" || commands[0] == "create_remote") {
+			positional = []string{NewBackupName()}
+			commands = append(commands, positional[0])
+		}
+		backupName := ""
+		if len(positional) > 0 {
+			backupName = positional[0]
+		}
+		unlock, err := api.tryLock(commands[0], backupName)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusLocked)
+			return
+		}
+		id := api.status.start(columns[0])
 		go func() {
-			api.status.start(columns[0])
+			// lock is held until the whole operation is finished
+			defer unlock()
+			start := time.Now()
+			api.metrics.LastBackupStart.Set(float64(start.Unix()))
 			err := api.c.Run(append([]string{"clickhouse-backup"}, commands...))
-			defer api.status.stop(err)
+			api.status.stop(id, err)
+			api.metrics.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
+			api.metrics.LastBackupEnd.Set(float64(time.Now().Unix()))
 			if err != nil {
 				api.metrics.FailedBackups.Inc()
 				api.metrics.LastBackupSuccess.Set(0)
 				log.Println(err)
 				return
 			}
+			api.metrics.SuccessfulBackups.Inc()
+			api.metrics.LastBackupSuccess.Set(1)
 		}()
-		api.metrics.SuccessfulBackups.Inc()
-		api.metrics.LastBackupSuccess.Set(1)
 		fmt.Fprintln(w, "acknowledged")
 		return
 	case "delete", "freeze", "clean":
-		if locked := api.lock.TryAcquire(1); !locked {
-			log.Println(ErrAPILocked)
-			http.Error(w, ErrAPILocked.Error(), http.StatusLocked)
+		// CLI exits on wrong arguments of delete, so they are checked before
+		positional := integrationArgs(api.c, commands)
+		if commands[0] == "delete" && !isIntegrationDeleteValid(positional) {
+			http.Error(w, "use 'delete [--force] local|remote backup_name'", http.StatusBadRequest)
+			return
+		}
+		backupName := ""
+		if commands[0] == "delete" {
+			backupName = positional[1]
+		}
+		unlock, err := api.tryLock(commands[0], backupName)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusLocked)
 			return
 		}
-		defer api.lock.Release(1)
+		defer unlock()
 		start := time.Now()
 		api.metrics.LastBackupStart.Set(float64(start.Unix()))
 		defer api.metrics.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
 		defer api.metrics.LastBackupEnd.Set(float64(time.Now().Unix()))
 
-		api.status.start(columns[0])
-		err := api.c.Run(append([]string{"clickhouse-backup"}, commands...))
-		defer api.status.stop(err)
+		id := api.status.start(columns[0])
+		err = api.c.Run(append([]string{"clickhouse-backup"}, commands...))
+		defer api.status.stop(id, err)
 		if err != nil {
 			api.metrics.FailedBackups.Inc()
 			api.metrics.LastBackupSuccess.Set(0)
@@ -271,15 +757,63 @@ func (api *APIServer) integrationPost(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// CREATE TABLE system.backup_list (name String, created DateTime, size Int64, location String) ENGINE=URL('http://127.0.0.1:7171/integration/list?user=user&pass=pass', TSVWithNames)
+// boolToUInt8 - format flag for UInt8 column of integration table
+func boolToUInt8(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// isIntegrationDeleteValid - check that positional arguments of delete are location and backup name
+func isIntegrationDeleteValid(positional []string) bool {
+	return len(positional) == 2 && (positional[0] == "local" || positional[0] == "remote")
+}
+
+// integrationArgs - positional arguments of integration command, flags of command are skipped with their values,
+// flag takes the next argument as value unless it's bool flag or its value is set by '='
+func integrationArgs(c *cli.App, commands []string) []string {
+	valueFlags := map[string]bool{}
+	if command := c.Command(commands[0]); command != nil {
+		for _, flag := range command.Flags {
+			switch flag.(type) {
+			case cli.BoolFlag, cli.BoolTFlag:
+				continue
+			}
+			for _, name := range strings.Split(flag.GetName(), ",") {
+				valueFlags[strings.TrimSpace(name)] = true
+			}
+		}
+	}
+	positional := []string{}
+	for i := 1; i < len(commands); i++ {
+		arg := commands[i]
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		if name := strings.TrimLeft(arg, "-"); !strings.Contains(name, "=") && valueFlags[name] {
+			i++
+		}
+	}
+	return positional
+}
+
+// CREATE TABLE system.backup_list (name String, created DateTime, size Int64, location String, required String, broken String, target String, table_count UInt32, upload_state String, has_required UInt8, is_broken UInt8, checksums String) ENGINE=URL('http://127.0.0.1:7171/integration/list?user=user&pass=pass', TSVWithNames)
 // ??? INSERT INTO system.backup_list (name,location) VALUES ('backup_name', 'remote') - upload backup
 // ??? INSERT INTO system.backup_list (name) VALUES ('backup_name') - create backup
 func (api *APIServer) integrationBackupLog(w http.ResponseWriter, r *http.Request) {
+	format, err := getResponseFormat(r, FormatTSV)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "actions", err)
+		return
+	}
 	commands := api.status.status()
-	fmt.Fprintln(w, "command\tstart\tfinish\tstatus\terror")
+	rows := make([][]string, 0, len(commands))
 	for _, c := range commands {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Command, c.Start, c.Finish, c.Status, c.Error)
+		rows = append(rows, []string{c.Command, c.Start, c.Finish, c.Status, c.Error})
 	}
+	sendTable(w, format, commands, []string{"command", "start", "finish", "status", "error"}, rows)
 }
 
 // httpRootHandler - display API index
@@ -289,7 +823,7 @@ func (api *APIServer) httpRootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Pragma", "no-cache")
 
 	fmt.Fprintln(w, "Documentation: https://github.com/AlexAkulov/clickhouse-backup#api-configuration")
-	for _, r := range api.routes {
+	for _, r := range api.handlers.Load().(apiHandlers).routes {
 		fmt.Fprintln(w, r)
 	}
 }
@@ -309,9 +843,13 @@ func httpConfigDefaultHandler(w http.ResponseWriter, r *http.Request) {
 
 // httpConfigDefaultHandler - display the currently running config
 func (api *APIServer) httpConfigHandler(w http.ResponseWriter, r *http.Request) {
-	config := api.config
+	config := api.getConfig()
 	config.ClickHouse.Password = "***"
 	config.API.Password = "***"
+	config.API.Users = append([]APIUser{}, config.API.Users...)
+	for i := range config.API.Users {
+		config.API.Users[i].Password = "***"
+	}
 	config.S3.SecretKey = "***"
 	config.GCS.CredentialsJSON = "***"
 	config.COS.SecretKey = "***"
@@ -328,12 +866,13 @@ func (api *APIServer) httpConfigHandler(w http.ResponseWriter, r *http.Request)
 
 // httpConfigDefaultHandler - update the currently running config
 func (api *APIServer) httpConfigUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusServiceUnavailable, "update", ErrAPILocked)
+	unlock, err := api.tryLock("config", "")
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusServiceUnavailable, "update", err)
 		return
 	}
-	defer api.lock.Release(1)
+	defer unlock()
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -347,35 +886,111 @@ func (api *APIServer) httpConfigUpdateHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if err := normalizeLocalPaths(&newConfig.ClickHouse); err != nil {
+		writeError(w, http.StatusBadRequest, "update", fmt.Errorf("error validating new config: %v", err))
+		return
+	}
 	if err := validateConfig(newConfig); err != nil {
 		writeError(w, http.StatusBadRequest, "update", fmt.Errorf("error validating new config: %v", err))
 		return
 	}
 	log.Printf("Applying new valid config")
-	api.config = *newConfig
-	api.restart <- struct{}{}
+	api.applyConfig(*newConfig)
+	sendResponse(w, http.StatusOK, struct {
+		Status    string `json:"status"`
+		Operation string `json:"operation"`
+	}{
+		Status:    "success",
+		Operation: "update",
+	})
 }
 
-// httpTablesHandler - displaylist of tables
+// httpTablesHandler - display list of tables with engine and size of active parts
 func (api *APIServer) httpTablesHandler(w http.ResponseWriter, r *http.Request) {
-	tables, err := getTables(api.config)
+	format, err := getResponseFormat(r, FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "tables", err)
+		return
+	}
+	tables, err := getTables(r.Context(), api.getConfig())
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "tables", err)
 		return
 	}
-	sendResponse(w, http.StatusOK, tables)
+	rows := make([][]string, 0, len(tables))
+	for _, t := range tables {
+		rows = append(rows, []string{
+			t.Database, t.Name, t.Engine,
+			strconv.FormatUint(t.TotalBytes, 10), strconv.FormatUint(t.TotalRows, 10), strconv.FormatUint(t.Partitions, 10),
+			t.LastModified, strconv.Itoa(boolToUInt8(t.Skip)),
+		})
+	}
+	sendTable(w, format, tables, []string{"database", "table", "engine", "total_bytes", "total_rows", "partitions", "last_modified", "skip"}, rows)
+}
+
+// httpFreshnessHandler - show the last local backup of every table, tables which were never backed up have empty backup
+func (api *APIServer) httpFreshnessHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := getResponseFormat(r, FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "freshness", err)
+		return
+	}
+	tables, err := GetTableFreshness(r.Context(), api.getConfig())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "freshness", err)
+		return
+	}
+	rows := make([][]string, 0, len(tables))
+	for _, t := range tables {
+		lastBackupDate := ""
+		if t.LastBackupDate != nil {
+			lastBackupDate = t.LastBackupDate.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{t.Database, t.Table, t.LastBackup, lastBackupDate})
+	}
+	sendTable(w, format, tables, []string{"database", "table", "last_backup", "last_backup_date"}, rows)
 }
 
 // httpTablesHandler - display list of all backups stored locally and remotely
 func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "list", err)
+		return
+	}
 	type backup struct {
-		Name     string `json:"name"`
-		Created  string `json:"created"`
-		Size     int64  `json:"size,omitempty"`
-		Location string `json:"location"`
+		Name           string `json:"name"`
+		Created        string `json:"created"`
+		Size           int64  `json:"size,omitempty"`
+		Location       string `json:"location"`
+		RequiredBackup string `json:"required_backup,omitempty"`
+		Broken         string `json:"broken,omitempty"`
+		Target         string `json:"target,omitempty"`
+		TableCount     int    `json:"table_count"`
+		UploadState    string `json:"upload_state,omitempty"`
+		Checksums      string `json:"checksums,omitempty"`
+	}
+	defaultFormat := FormatJSON
+	if strings.HasPrefix(strings.TrimPrefix(r.URL.Path, apiV1Prefix), "/integration/") {
+		defaultFormat = FormatTSV
+	}
+	format, err := getResponseFormat(r, defaultFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "list", err)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "":
+	case "cluster":
+		api.sendClusterList(w, config, format, target)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "list", fmt.Errorf("unknown scope '%s', must be 'cluster'", scope))
+		return
 	}
 	backups := make([]backup, 0)
-	localBackups, err := ListLocalBackups(api.config)
+	localBackups, err := ListLocalBackups(config)
 	if err != nil && !os.IsNotExist(err) {
 		writeError(w, http.StatusInternalServerError, "list", err)
 		return
@@ -383,64 +998,235 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 
 	for _, b := range localBackups {
 		backups = append(backups, backup{
-			Name:     b.Name,
-			Created:  b.Date.Format(APITimeFormat),
-			Location: "local",
+			Name:           b.Name,
+			Created:        b.Date.Format(APITimeFormat),
+			Location:       "local",
+			RequiredBackup: b.RequiredBackup,
+			Broken:         b.Broken,
+			TableCount:     b.TableCount,
+			Checksums:      b.Checksums,
 		})
 	}
-	if api.config.General.RemoteStorage != "none" {
-		remoteBackups, err := getRemoteBackups(api.config)
+	if config.General.RemoteStorage != "none" || target != "" {
+		targets, err := GetRemoteTargets(config, target)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "list", err)
+			return
+		}
+		for _, t := range targets {
+			remoteBackups, err := getRemoteBackups(t.Config)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "list", err)
+				return
+			}
+			targetName := ""
+			if t.Name != PrimaryTarget {
+				targetName = t.Name
+			}
+			for _, b := range remoteBackups {
+				backups = append(backups, backup{
+					Name:           b.Name,
+					Created:        b.Date.Format(APITimeFormat),
+					Size:           b.Size,
+					Location:       "remote",
+					RequiredBackup: b.RequiredBackup,
+					Broken:         b.Broken,
+					Target:         targetName,
+					TableCount:     b.TableCount,
+					UploadState:    b.UploadState,
+					Checksums:      b.Checksums,
+				})
+			}
+		}
+	}
+	rows := make([][]string, 0, len(backups))
+	for _, b := range backups {
+		rows = append(rows, []string{b.Name, b.Created, strconv.FormatInt(b.Size, 10), b.Location, b.RequiredBackup, b.Broken, b.Target,
+			strconv.Itoa(b.TableCount), b.UploadState, strconv.Itoa(boolToUInt8(b.RequiredBackup != "")), strconv.Itoa(boolToUInt8(b.Broken != "")), b.Checksums})
+	}
+	sendTable(w, format, &backups, []string{"name", "created", "size", "location", "required", "broken", "target", "table_count", "upload_state", "has_required", "is_broken", "checksums"}, rows)
+}
+
+// sendClusterList - send remote backups of all shards grouped by name
+func (api *APIServer) sendClusterList(w http.ResponseWriter, config Config, format, target string) {
+	type clusterBackup struct {
+		Name     string   `json:"name"`
+		Created  string   `json:"created"`
+		Size     int64    `json:"size"`
+		Target   string   `json:"target,omitempty"`
+		Complete bool     `json:"complete"`
+		Shards   []string `json:"shards"`
+		Broken   []string `json:"broken_shards,omitempty"`
+		Missing  []string `json:"missing_shards,omitempty"`
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "list", err)
+		return
+	}
+	backups := make([]clusterBackup, 0)
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		clusterBackups, _, err := getClusterBackups(t.Config)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "list", err)
 			return
 		}
-		for _, b := range remoteBackups {
-			backups = append(backups, backup{
+		targetName := ""
+		if t.Name != PrimaryTarget {
+			targetName = t.Name
+		}
+		for _, b := range clusterBackups {
+			backups = append(backups, clusterBackup{
 				Name:     b.Name,
 				Created:  b.Date.Format(APITimeFormat),
 				Size:     b.Size,
-				Location: "remote",
+				Target:   targetName,
+				Complete: b.Complete(),
+				Shards:   b.Shards,
+				Broken:   b.Broken,
+				Missing:  b.Missing,
 			})
 		}
 	}
-	if r.URL.Path == "/backup/list" {
-		sendResponse(w, http.StatusOK, &backups)
+	rows := make([][]string, 0, len(backups))
+	for _, b := range backups {
+		rows = append(rows, []string{b.Name, b.Created, strconv.FormatInt(b.Size, 10), b.Target, strconv.Itoa(boolToUInt8(b.Complete)),
+			strings.Join(b.Shards, ","), strings.Join(b.Broken, ","), strings.Join(b.Missing, ",")})
+	}
+	sendTable(w, format, &backups, []string{"name", "created", "size", "target", "complete", "shards", "broken_shards", "missing_shards"}, rows)
+}
+
+// httpDescribeHandler - show tables, partitions and sizes of local or remote backup
+func (api *APIServer) httpDescribeHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "describe", err)
 		return
 	}
-	fmt.Fprintln(w, "name\tcreated\tsize\tlocation")
-	for _, b := range backups {
-		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", b.Name, b.Created, b.Size, b.Location)
+	name := mux.Vars(r)["name"]
+	query := r.URL.Query()
+	location := query.Get("location")
+	var metadata *BackupMetadata
+	if location != "remote" {
+		metadata, err = DescribeLocalBackup(config, name)
+		if err == nil {
+			location = "local"
+		} else if !errors.Is(err, errLocalBackupNotFound) {
+			writeError(w, http.StatusInternalServerError, "describe", err)
+			return
+		}
+	}
+	if location != "local" {
+		location = "remote"
+		metadata, err = DescribeRemoteBackup(config, name, query.Get("target"))
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, "describe", err)
+		return
+	}
+	sendResponse(w, http.StatusOK, struct {
+		*BackupMetadata
+		Location string `json:"location"`
+	}{
+		BackupMetadata: metadata,
+		Location:       location,
+	})
+}
+
+// httpChainHandler - show backups required by backup and backups which require it
+func (api *APIServer) httpChainHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "chain", err)
+		return
+	}
+	query := r.URL.Query()
+	chain, err := GetBackupChain(config, mux.Vars(r)["name"], query.Get("location") == "remote", query.Get("target"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "chain", err)
+		return
+	}
+	sendResponse(w, http.StatusOK, chain)
+}
+
+// httpVersionHandler - show version of binary, supported remote storages and the latest local and remote backups
+func (api *APIServer) httpVersionHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := GetVersionInfo(api.getConfig(), r.URL.Query().Get("target"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "version", err)
+		return
 	}
+	sendResponse(w, http.StatusOK, info)
 }
 
 // httpCreateHandler - create a backup
 func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusLocked, "create", ErrAPILocked)
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "create", err)
+		return
+	}
+	query := r.URL.Query()
+	backupName := NewBackupName()
+	if name, exist := query["name"]; exist {
+		backupName = name[0]
+	}
+	unlock, err := api.tryLock("create", backupName)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "create", err)
 		return
 	}
-	defer api.lock.Release(1)
 	start := time.Now()
 	api.metrics.LastBackupStart.Set(float64(start.Unix()))
 	defer api.metrics.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
 	defer api.metrics.LastBackupEnd.Set(float64(time.Now().Unix()))
 
 	tablePattern := ""
-	backupName := NewBackupName()
-
-	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 	}
-	if name, exist := query["name"]; exist {
-		backupName = name[0]
+	options := CreateOptions{
+		Consistency:       query.Get("consistency"),
+		DiffFrom:          query.Get("diff-from"),
+		IncludeSystemLogs: query.Get("include_system_logs"),
+	}
+	if includeDetached := query.Get("include_detached"); includeDetached != "" {
+		v, err := strconv.ParseBool(includeDetached)
+		if err != nil {
+			unlock()
+			writeError(w, http.StatusBadRequest, "create", fmt.Errorf("can't parse include_detached: %v", err))
+			return
+		}
+		options.IncludeDetached = v
+	}
+	if _, err := parseSystemLogs(options.IncludeSystemLogs); err != nil {
+		unlock()
+		writeError(w, http.StatusBadRequest, "create", err)
+		return
+	}
+	if continueOnError := query.Get("continue_on_error"); continueOnError != "" {
+		v, err := strconv.ParseBool(continueOnError)
+		if err != nil {
+			unlock()
+			writeError(w, http.StatusBadRequest, "create", fmt.Errorf("can't parse continue_on_error: %v", err))
+			return
+		}
+		options.ContinueOnError = v
 	}
 
+	id := api.status.start("create")
 	go func() {
-		api.status.start("create")
-		err := CreateBackup(api.config, backupName, tablePattern)
-		defer api.status.stop(err)
+		// lock is held until the whole operation is finished
+		defer unlock()
+		err := CreateBackup(api.ctx, config, backupName, tablePattern, options)
+		defer api.status.stop(id, err)
+		if statsdErr := sendStatsdMetrics(config, "create", backupName, start, err); statsdErr != nil {
+			log.Println(statsdErr)
+		}
 		if err != nil {
 			api.metrics.FailedBackups.Inc()
 			api.metrics.LastBackupSuccess.Set(0)
@@ -454,29 +1240,129 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		JobID      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "create",
 		BackupName: backupName,
+		JobID:      id,
+	})
+}
+
+// httpCreateRemoteHandler - create a backup, upload it and remove old backups as one operation
+func (api *APIServer) httpCreateRemoteHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "create_remote", err)
+		return
+	}
+	query := r.URL.Query()
+	backupName := query.Get("name")
+	if backupName == "" {
+		backupName = NewBackupName()
+	}
+	unlock, err := api.tryLock("create_remote", backupName)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "create_remote", err)
+		return
+	}
+	tablePattern := query.Get("table")
+	options := CreateRemoteOptions{
+		CreateOptions: CreateOptions{
+			Consistency:       query.Get("consistency"),
+			DiffFrom:          query.Get("diff-from"),
+			IncludeSystemLogs: query.Get("include_system_logs"),
+		},
+		Target: query.Get("target"),
+	}
+	if deleteLocal := query.Get("delete_local"); deleteLocal != "" {
+		v, err := strconv.ParseBool(deleteLocal)
+		if err != nil {
+			unlock()
+			writeError(w, http.StatusBadRequest, "create_remote", fmt.Errorf("can't parse delete_local: %v", err))
+			return
+		}
+		options.DeleteLocal = v
+	}
+	if includeDetached := query.Get("include_detached"); includeDetached != "" {
+		v, err := strconv.ParseBool(includeDetached)
+		if err != nil {
+			unlock()
+			writeError(w, http.StatusBadRequest, "create_remote", fmt.Errorf("can't parse include_detached: %v", err))
+			return
+		}
+		options.CreateOptions.IncludeDetached = v
+	}
+	if _, err := parseSystemLogs(options.IncludeSystemLogs); err != nil {
+		unlock()
+		writeError(w, http.StatusBadRequest, "create_remote", err)
+		return
+	}
+	if continueOnError := query.Get("continue_on_error"); continueOnError != "" {
+		v, err := strconv.ParseBool(continueOnError)
+		if err != nil {
+			unlock()
+			writeError(w, http.StatusBadRequest, "create_remote", fmt.Errorf("can't parse continue_on_error: %v", err))
+			return
+		}
+		options.CreateOptions.ContinueOnError = v
+	}
+
+	id := api.status.start("create_remote")
+	go func() {
+		// lock is held until the whole operation is finished
+		defer unlock()
+		start := time.Now()
+		api.metrics.LastBackupStart.Set(float64(start.Unix()))
+		err := CreateRemoteBackup(api.ctx, config, backupName, tablePattern, options)
+		api.status.stop(id, err)
+		if statsdErr := sendStatsdMetrics(config, "create_remote", backupName, start, err); statsdErr != nil {
+			log.Println(statsdErr)
+		}
+		api.metrics.LastBackupDuration.Set(float64(time.Since(start).Nanoseconds()))
+		api.metrics.LastBackupEnd.Set(float64(time.Now().Unix()))
+		if err != nil {
+			api.metrics.FailedBackups.Inc()
+			api.metrics.LastBackupSuccess.Set(0)
+			log.Printf("CreateRemoteBackup error: %v", err)
+			return
+		}
+		api.metrics.SuccessfulBackups.Inc()
+		api.metrics.LastBackupSuccess.Set(1)
+	}()
+	sendResponse(w, http.StatusCreated, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+		JobID      int    `json:"job_id"`
+	}{
+		Status:     "acknowledged",
+		Operation:  "create_remote",
+		BackupName: backupName,
+		JobID:      id,
 	})
 }
 
 // httpFreezeHandler - freeze tables
 func (api *APIServer) httpFreezeHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusLocked, "freeze", ErrAPILocked)
+	unlock, err := api.tryLock("freeze", "")
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "freeze", err)
 		return
 	}
-	defer api.lock.Release(1)
-	api.status.start("freeze")
+	defer unlock()
+	id := api.status.start("freeze")
 
 	query := r.URL.Query()
 	tablePattern := ""
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 	}
-	if err := Freeze(api.config, tablePattern); err != nil {
+	err = Freeze(r.Context(), api.getConfig(), tablePattern)
+	api.status.stop(id, err)
+	if err != nil {
 		log.Printf("Freeze error: = %+v\n", err)
 		writeError(w, http.StatusInternalServerError, "freeze", err)
 		return
@@ -492,15 +1378,16 @@ func (api *APIServer) httpFreezeHandler(w http.ResponseWriter, r *http.Request)
 
 // httpCleanHandler - clean ./shadow directory
 func (api *APIServer) httpCleanHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusLocked, "clean", ErrAPILocked)
+	unlock, err := api.tryLock("clean", "")
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "clean", err)
 		return
 	}
-	defer api.lock.Release(1)
-	api.status.start("clean")
-	err := Clean(api.config)
-	api.status.stop(err)
+	defer unlock()
+	id := api.status.start("clean")
+	err = Clean(api.getConfig())
+	api.status.stop(id, err)
 	if err != nil {
 		log.Printf("Clean error: = %+v\n", err)
 		writeError(w, http.StatusInternalServerError, "clean", err)
@@ -517,17 +1404,32 @@ func (api *APIServer) httpCleanHandler(w http.ResponseWriter, r *http.Request) {
 
 // httpUploadHandler - upload a backup to remote storage
 func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "upload", err)
+		return
+	}
 	vars := mux.Vars(r)
 	diffFrom := ""
 	query := r.URL.Query()
 	if df, exist := query["diff-from"]; exist {
 		diffFrom = df[0]
 	}
+	target := query.Get("to")
+	tablePattern := query.Get("table")
 	name := vars["name"]
+	unlock, err := api.tryLock("upload", name)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "upload", err)
+		return
+	}
+	id := api.status.start("upload")
 	go func() {
-		api.status.start("upload")
-		err := Upload(api.config, name, diffFrom)
-		api.status.stop(err)
+		// lock is held until the whole operation is finished
+		defer unlock()
+		err := Upload(api.ctx, config, name, tablePattern, diffFrom, target)
+		api.status.stop(id, err)
 		if err != nil {
 			log.Printf("Upload error: %+v\n", err)
 			return
@@ -539,73 +1441,152 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		BackupName string `json:"backup_name"`
 		BackupFrom string `json:"backup_from,omitempty"`
 		Diff       bool   `json:"diff"`
+		JobID      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "upload",
 		BackupName: name,
 		BackupFrom: diffFrom,
 		Diff:       diffFrom != "",
+		JobID:      id,
 	})
 }
 
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusLocked, "restore", ErrAPILocked)
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "restore", err)
+		return
+	}
+	unlock, err := api.tryLock("restore", mux.Vars(r)["name"])
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "restore", err)
+		return
+	}
+	async, err := isAsyncRequest(r)
+	if err != nil {
+		unlock()
+		writeError(w, http.StatusBadRequest, "restore", err)
 		return
 	}
-	defer api.lock.Release(1)
 
 	vars := mux.Vars(r)
 	tablePattern := ""
-	schemaOnly := false
-	dataOnly := false
-	dropTable := false
+	options := RestoreOptions{}
 
 	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 	}
 	if _, exist := query["schema"]; exist {
-		schemaOnly = true
+		options.SchemaOnly = true
 	}
 	if _, exist := query["data"]; exist {
-		dataOnly = true
+		options.DataOnly = true
 	}
 	if _, exist := query["drop"]; exist {
-		dropTable = true
+		options.DropTable = true
 	}
 	if _, exist := query["rm"]; exist {
-		dropTable = true
+		options.DropTable = true
 	}
-	api.status.start("restore")
-	err := Restore(api.config, vars["name"], tablePattern, schemaOnly, dataOnly, dropTable)
-	api.status.stop(err)
+	if engine, exist := query["convert_engine"]; exist {
+		options.ConvertEngine = engine[0]
+	}
+	if _, exist := query["substitute_macros"]; exist {
+		options.SubstituteMacros = true
+	}
+	if _, exist := query["drop_replica"]; exist {
+		options.DropReplica = true
+	}
+	if _, exist := query["rewrite_ddl"]; exist {
+		options.RewriteDDL = true
+	}
+	if _, exist := query["validate"]; exist {
+		options.Validate = true
+	}
+	if _, exist := query["rehearsal"]; exist {
+		options.Rehearsal = true
+	}
+	if _, exist := query["continue_on_error"]; exist {
+		options.ContinueOnError = true
+	}
+	if _, exist := query["stop_ttl_merges"]; exist {
+		options.StopTTLMerges = true
+	}
+	if reshard, exist := query["reshard"]; exist {
+		options.Reshard = reshard[0]
+	}
+	id := api.status.start("restore")
+	if async {
+		go func() {
+			defer unlock()
+			_, err := Restore(api.ctx, config, vars["name"], tablePattern, options)
+			api.status.stop(id, err)
+			if err != nil {
+				log.Printf("Restore error: %+v\n", err)
+			}
+		}()
+		sendResponse(w, http.StatusOK, struct {
+			Status     string `json:"status"`
+			Operation  string `json:"operation"`
+			BackupName string `json:"backup_name"`
+			JobID      int    `json:"job_id"`
+		}{
+			Status:     "acknowledged",
+			Operation:  "restore",
+			BackupName: vars["name"],
+			JobID:      id,
+		})
+		return
+	}
+	defer unlock()
+	tables, err := Restore(r.Context(), config, vars["name"], tablePattern, options)
+	api.status.stop(id, err)
 	if err != nil {
 		log.Printf("Download error: %+v\n", err)
 		writeError(w, http.StatusInternalServerError, "restore", err)
 		return
 	}
 	sendResponse(w, http.StatusOK, struct {
-		Status     string `json:"status"`
-		Operation  string `json:"operation"`
-		BackupName string `json:"backup_name"`
+		Status     string   `json:"status"`
+		Operation  string   `json:"operation"`
+		BackupName string   `json:"backup_name"`
+		Tables     []string `json:"tables"`
 	}{
 		Status:     "success",
 		Operation:  "restore",
 		BackupName: vars["name"],
+		Tables:     tables,
 	})
 }
 
 // httpDownloadHandler - download a backup from remote to local storage
 func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "download", err)
+		return
+	}
 	vars := mux.Vars(r)
 	name := vars["name"]
+	query := r.URL.Query()
+	tablePattern := query.Get("table")
+	_, schemaOnly := query["schema"]
+	unlock, err := api.tryLock("download", name)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "download", err)
+		return
+	}
+	id := api.status.start("download")
 	go func() {
-		api.status.start("download")
-		err := Download(api.config, name)
-		api.status.stop(err)
+		// lock is held until the whole operation is finished
+		defer unlock()
+		err := Download(api.ctx, config, name, tablePattern, schemaOnly)
+		api.status.stop(id, err)
 		if err != nil {
 			log.Printf("Download error: %+v\n", err)
 			return
@@ -615,33 +1596,76 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		JobID      int    `json:"job_id"`
 	}{
 		Status:     "acknowledged",
 		Operation:  "download",
 		BackupName: name,
+		JobID:      id,
 	})
 }
 
 // httpDeleteHandler - delete a backup from local or remote storage
 func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	if locked := api.lock.TryAcquire(1); !locked {
-		log.Println(ErrAPILocked)
-		writeError(w, http.StatusLocked, "delete", ErrAPILocked)
+	config, err := api.getProfileConfig(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "delete", err)
+		return
+	}
+	unlock, err := api.tryLock("delete", mux.Vars(r)["name"])
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusLocked, "delete", err)
 		return
 	}
-	defer api.lock.Release(1)
-	api.status.start("delete")
-	var err error
 	vars := mux.Vars(r)
-	switch vars["where"] {
-	case "local":
-		err = RemoveBackupLocal(api.config, vars["name"])
-	case "remote":
-		err = RemoveBackupRemote(api.config, vars["name"])
-	default:
-		err = fmt.Errorf("Backup location must be 'local' or 'remote'")
+	if vars["where"] != "local" && vars["where"] != "remote" {
+		unlock()
+		writeError(w, http.StatusBadRequest, "delete", fmt.Errorf("Backup location must be 'local' or 'remote'"))
+		return
+	}
+	async, err := isAsyncRequest(r)
+	if err != nil {
+		unlock()
+		writeError(w, http.StatusBadRequest, "delete", err)
+		return
+	}
+	_, force := r.URL.Query()["force"]
+	target := r.URL.Query().Get("target")
+	remove := func() error {
+		if vars["where"] == "local" {
+			return RemoveBackupLocal(config, vars["name"], force)
+		}
+		return RemoveBackupRemote(config, vars["name"], target, force)
+	}
+	id := api.status.start("delete")
+	if async {
+		go func() {
+			defer unlock()
+			err := remove()
+			api.status.stop(id, err)
+			if err != nil {
+				log.Printf("delete backup error: %+v\n", err)
+			}
+		}()
+		sendResponse(w, http.StatusOK, struct {
+			Status     string `json:"status"`
+			Operation  string `json:"operation"`
+			BackupName string `json:"backup_name"`
+			Location   string `json:"location"`
+			JobID      int    `json:"job_id"`
+		}{
+			Status:     "acknowledged",
+			Operation:  "delete",
+			BackupName: vars["name"],
+			Location:   vars["where"],
+			JobID:      id,
+		})
+		return
 	}
-	api.status.stop(err)
+	defer unlock()
+	err = remove()
+	api.status.stop(id, err)
 	if err != nil {
 		log.Printf("delete backup error: %+v\n", err)
 		writeError(w, http.StatusInternalServerError, "delete", err)
@@ -661,7 +1685,76 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (api *APIServer) httpBackupStatusHandler(w http.ResponseWriter, r *http.Request) {
-	sendResponse(w, http.StatusOK, api.status.status())
+	format, err := getResponseFormat(r, FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "status", err)
+		return
+	}
+	commands := api.status.status()
+	rows := make([][]string, 0, len(commands))
+	for _, c := range commands {
+		rows = append(rows, commandRow(c))
+	}
+	sendTable(w, format, commands, commandColumns, rows)
+}
+
+// httpLastErrorHandler - show the last error of every operation which failed on its last run, 'operation' argument selects one operation
+func (api *APIServer) httpLastErrorHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := getResponseFormat(r, FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "last_error", err)
+		return
+	}
+	operation := r.URL.Query().Get("operation")
+	commands := []CommandInfo{}
+	rows := [][]string{}
+	for _, c := range api.status.lastErrors() {
+		if operation == "" || commandOperation(c.Command) == operation {
+			commands = append(commands, c)
+			rows = append(rows, commandRow(c))
+		}
+	}
+	sendTable(w, format, commands, commandColumns, rows)
+}
+
+// commandColumns - columns of commands in TSV and CSV responses of status endpoints
+var commandColumns = []string{"id", "command", "status", "progress", "start", "finish", "error"}
+
+func commandRow(c CommandInfo) []string {
+	return []string{strconv.Itoa(c.ID), c.Command, c.Status, c.Progress, c.Start, c.Finish, c.Error}
+}
+
+// httpJobStatusHandler - show status of operation by job ID returned when operation was started
+func (api *APIServer) httpJobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "status", fmt.Errorf("wrong job id: %v", err))
+		return
+	}
+	format, err := getResponseFormat(r, FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "status", err)
+		return
+	}
+	command, ok := api.status.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "status", fmt.Errorf("job %d not found", id))
+		return
+	}
+	sendTable(w, format, command, commandColumns, [][]string{commandRow(command)})
+}
+
+// isAsyncRequest - check 'async' query argument of operation which is synchronous by default
+func isAsyncRequest(r *http.Request) (bool, error) {
+	async := r.URL.Query().Get("async")
+	if async == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(async)
+	if err != nil {
+		return false, fmt.Errorf("can't parse async: %v", err)
+	}
+	return v, nil
 }
 
 func registerMetricsHandlers(r *mux.Router, enablemetrics bool, enablepprof bool) {
@@ -695,10 +1788,28 @@ type Metrics struct {
 	LastBackupDuration prometheus.Gauge
 	SuccessfulBackups  prometheus.Counter
 	FailedBackups      prometheus.Counter
+	LastVerifySuccess  prometheus.Gauge
+	LastVerifyEnd      prometheus.Gauge
+	// BufferPoolUsed, BufferPoolLimit - size of buffers of uploads and downloads in use and general.buffer_memory_limit
+	BufferPoolUsed  prometheus.GaugeFunc
+	BufferPoolLimit prometheus.GaugeFunc
+	// Transfer - bytes and speed of uploads and downloads by remote storage
+	Transfer prometheus.Collector
+	// OperationDurations, TableDurations - histograms of durations of operations and of processing of tables
+	OperationDurations *prometheus.HistogramVec
+	TableDurations     *prometheus.HistogramVec
+	// LastError - the last error of every operation which failed on its last run, it's set by setupMetrics
+	LastError prometheus.Collector
+	// Backups - count of local and remote backups and age of the oldest and the newest remote backups
+	Backups *backupStats
+	// Disks - free space of disks and backup directory and size of shadow directories
+	Disks *diskStats
+	// Freshness - age of the last backup of every table
+	Freshness *freshnessStats
 }
 
-// setupMetrics - resister prometheus metrics
-func setupMetrics() Metrics {
+// newMetrics - create metrics without registration
+func newMetrics() Metrics {
 	m := Metrics{}
 	m.LastBackupDuration = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "clickhouse_backup",
@@ -730,6 +1841,45 @@ func setupMetrics() Metrics {
 		Name:      "failed_backups",
 		Help:      "Number of Failed Backups.",
 	})
+	m.LastVerifySuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_verify_success",
+		Help:      "Last scheduled restore verification success boolean: 0=failed, 1=success, 2=unknown.",
+	})
+	m.LastVerifyEnd = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_verify_end",
+		Help:      "Last scheduled restore verification end timestamp.",
+	})
+	m.BufferPoolUsed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "buffer_pool_used_bytes",
+		Help:      "Size of buffers of uploads and downloads in use.",
+	}, func() float64 {
+		used, _ := transferBuffers.usage()
+		return float64(used)
+	})
+	m.BufferPoolLimit = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "buffer_pool_limit_bytes",
+		Help:      "Limit of size of buffers of uploads and downloads, 0=unlimited.",
+	}, func() float64 {
+		_, limit := transferBuffers.usage()
+		return float64(limit)
+	})
+	m.Transfer = transferMetrics
+	m.OperationDurations = operationDurations
+	m.TableDurations = tableDurations
+	m.Backups = newBackupStats()
+	m.Disks = newDiskStats()
+	m.Freshness = newFreshnessStats()
+	return m
+}
+
+// setupMetrics - resister prometheus metrics, last errors are taken from status of commands
+func setupMetrics(status *AsyncStatus) Metrics {
+	m := newMetrics()
+	m.LastError = newLastErrorCollector(status)
 	prometheus.MustRegister(
 		m.LastBackupDuration,
 		m.LastBackupStart,
@@ -737,7 +1887,19 @@ func setupMetrics() Metrics {
 		m.LastBackupSuccess,
 		m.SuccessfulBackups,
 		m.FailedBackups,
+		m.LastVerifySuccess,
+		m.LastVerifyEnd,
+		m.BufferPoolUsed,
+		m.BufferPoolLimit,
+		m.Transfer,
+		m.OperationDurations,
+		m.TableDurations,
+		m.LastError,
+		m.Backups,
+		m.Disks,
+		m.Freshness,
 	)
 	m.LastBackupSuccess.Set(2) // 0=failed, 1=success, 2=unknown
+	m.LastVerifySuccess.Set(2)
 	return m
 }
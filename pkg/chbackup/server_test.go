@@ -0,0 +1,206 @@
+package chbackup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := corsMiddleware([]string{"https://dashboard.example.com", "https://*.internal.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/backup/list", nil)
+		r.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			r.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(http.MethodGet, "https://dashboard.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = request(http.MethodOptions, "https://ops.internal.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://ops.internal.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+
+	w = request(http.MethodGet, "https://evil.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodOptions, "https://evil.example.com").Code)
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := path.Join(dir, "api.sock")
+
+	l, err := listen(unixSocketPrefix + socketPath)
+	assert.NoError(t, err)
+	_, err = listen(unixSocketPrefix + socketPath)
+	assert.Error(t, err)
+	assert.NoError(t, l.Close())
+
+	// socket left by killed process
+	stale, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+	l, err = listen(unixSocketPrefix + socketPath)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+
+	assert.NoError(t, ioutil.WriteFile(socketPath, []byte("data"), 0640))
+	_, err = listen(unixSocketPrefix + socketPath)
+	assert.Error(t, err)
+}
+
+func TestRebindServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rebind")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	started := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	get := func(socketPath, uri string) (*http.Response, error) {
+		client := http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		}}
+		return client.Get("http://localhost" + uri)
+	}
+	waitSocket := func(socketPath string) {
+		for i := 0; i < 100; i++ {
+			if conn, err := net.Dial("unix", socketPath); err == nil {
+				conn.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	first, second := path.Join(dir, "first.sock"), path.Join(dir, "second.sock")
+
+	server := rebindServer(nil, newServer(APIConfig{}, unixSocketPrefix+first), "API", handler)
+	waitSocket(first)
+	assert.Equal(t, server, rebindServer(server, newServer(APIConfig{}, unixSocketPrefix+first), "API", handler))
+	slow := make(chan error)
+	go func() {
+		resp, err := get(first, "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		slow <- err
+	}()
+	<-started
+	// in-flight request is finished before old server is stopped
+	server = rebindServer(server, newServer(APIConfig{}, unixSocketPrefix+second), "API", handler)
+	assert.NoError(t, <-slow)
+	waitSocket(second)
+	resp, err := get(second, "/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	_, err = get(first, "/")
+	assert.Error(t, err)
+
+	// server is restarted on the same address when its timeouts are changed
+	restarted := rebindServer(server, newServer(APIConfig{ReadTimeout: "1m", IdleTimeout: "2m"}, unixSocketPrefix+second), "API", handler)
+	assert.NotEqual(t, server, restarted)
+	assert.Equal(t, time.Minute, restarted.ReadTimeout)
+	assert.Equal(t, 2*time.Minute, restarted.IdleTimeout)
+	assert.Equal(t, time.Duration(0), restarted.WriteTimeout)
+	waitSocket(second)
+	resp, err = get(second, "/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	restarted.Close()
+}
+
+func TestAPIVersionMiddleware(t *testing.T) {
+	handler := apiVersionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/backup/list", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, APIVersion, w.Header().Get(APIVersionHeader))
+	r := httptest.NewRequest(http.MethodGet, "/backup/list", nil)
+	r.Header.Set(APIVersionHeader, "2")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestLastErrors(t *testing.T) {
+	status := &AsyncStatus{}
+	status.stop(status.start("create backup1"), fmt.Errorf("can't freeze"))
+	status.stop(status.start("upload backup1"), fmt.Errorf("can't upload"))
+	status.stop(status.start("create backup2"), nil)
+	status.start("upload backup2")
+	lastErrors := status.lastErrors()
+	assert.Len(t, lastErrors, 1)
+	assert.Equal(t, "upload backup1", lastErrors[0].Command)
+	assert.Equal(t, "can't upload", lastErrors[0].Error)
+
+	status.stop(status.start("upload --diff-from=backup1 backup2"), fmt.Errorf("can't upload: %s", strings.Repeat("x", 300)))
+	ch := make(chan prometheus.Metric, 10)
+	newLastErrorCollector(status).Collect(ch)
+	assert.Len(t, ch, 1)
+}
+
+func TestIntegrationCreateLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := Config{ClickHouse: ClickHouseConfig{DataPath: dir}}
+	created := make(chan string, 1)
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name:  "create",
+		Flags: []cli.Flag{cli.StringFlag{Name: "table, tables, t"}, cli.BoolFlag{Name: "kube"}},
+		// backup is locked by CLI like in operationAction, server holds the same locks
+		Action: func(c *cli.Context) error {
+			release, err := AcquireOperationLock(config, "create", c.Args().First())
+			assert.NoError(t, err)
+			if err == nil {
+				release()
+			}
+			created <- c.Args().First()
+			return err
+		},
+	}}
+	api := APIServer{c: app, config: config, status: &AsyncStatus{}, metrics: newMetrics()}
+	w := httptest.NewRecorder()
+	api.integrationPost(w, httptest.NewRequest("POST", "/integration/actions", strings.NewReader("command\ncreate -t db.t --kube\n")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	backupName := <-created
+	assert.NotEqual(t, "", backupName)
+	assert.NotEqual(t, "db.t", backupName)
+
+	assert.Equal(t, []string{"db.t"}, integrationArgs(app, []string{"create", "--kube", "db.t"}))
+	assert.Equal(t, []string{"backup1"}, integrationArgs(app, []string{"create", "--tables=db.t", "backup1"}))
+	assert.Equal(t, []string{}, integrationArgs(app, []string{"create", "-t", "db.t"}))
+}
@@ -0,0 +1,15 @@
+package chbackup
+
+import "errors"
+
+const (
+	// ServiceName - name of Windows service of API server
+	ServiceName = "clickhouse-backup"
+	// ServiceInstall, ServiceUninstall, ServiceRun - values of 'server --service', ServiceRun is used by service control manager
+	ServiceInstall   = "install"
+	ServiceUninstall = "uninstall"
+	ServiceRun       = "run"
+)
+
+// ErrServiceNotSupported - 'server --service' is used on OS other than Windows
+var ErrServiceNotSupported = errors.New("service mode is supported on Windows only")
@@ -0,0 +1,22 @@
+// +build !windows
+
+package chbackup
+
+import (
+	"github.com/urfave/cli"
+)
+
+// RunService - services are supported on Windows only, use systemd or another supervisor to run 'server' on other OS
+func RunService(c *cli.App, config Config) error {
+	return ErrServiceNotSupported
+}
+
+// InstallService - services are supported on Windows only
+func InstallService(configPath string) error {
+	return ErrServiceNotSupported
+}
+
+// UninstallService - services are supported on Windows only
+func UninstallService() error {
+	return ErrServiceNotSupported
+}
@@ -0,0 +1,109 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// apiService - API server run by service control manager of Windows
+type apiService struct {
+	c      *cli.App
+	config Config
+}
+
+// Execute - start API server and stop it on Stop and Shutdown requests of service control manager
+func (s *apiService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(s.c, s.config, stop)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("API server failed: %v", err)
+				return false, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				if err := <-done; err != nil {
+					log.Printf("API server failed: %v", err)
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// RunService - run API server as Windows service, it must be started by service control manager
+func RunService(c *cli.App, config Config) error {
+	if err := svc.Run(ServiceName, &apiService{c: c, config: config}); err != nil {
+		return fmt.Errorf("can't run service '%s': %v", ServiceName, err)
+	}
+	return nil
+}
+
+// InstallService - register Windows service which runs API server of this executable with configPath and starts automatically
+func InstallService(configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can't get path of executable: %v", err)
+	}
+	if configPath, err = filepath.Abs(configPath); err != nil {
+		return fmt.Errorf("can't get absolute path of config: %v", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("can't connect to service control manager: %v", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(ServiceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service '%s' already exists", ServiceName)
+	}
+	s, err := m.CreateService(ServiceName, exe, mgr.Config{
+		DisplayName: "ClickHouse Backup API",
+		Description: "API server of clickhouse-backup",
+		StartType:   mgr.StartAutomatic,
+	}, "--config", configPath, "server", "--service="+ServiceRun)
+	if err != nil {
+		return fmt.Errorf("can't create service '%s': %v", ServiceName, err)
+	}
+	defer s.Close()
+	log.Printf("Service '%s' is installed, start it by 'sc start %s'", ServiceName, ServiceName)
+	return nil
+}
+
+// UninstallService - remove Windows service of API server, running service is removed after it's stopped
+func UninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("can't connect to service control manager: %v", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(ServiceName)
+	if err != nil {
+		return fmt.Errorf("service '%s' is not installed", ServiceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("can't remove service '%s': %v", ServiceName, err)
+	}
+	log.Printf("Service '%s' is removed", ServiceName)
+	return nil
+}
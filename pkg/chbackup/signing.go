@@ -0,0 +1,185 @@
+package chbackup
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// signatureSuffix - suffix of signature of remote backup manifest, signature of 'backup.tar.gz.json' is 'backup.tar.gz.json.sig'
+	signatureSuffix = ".sig"
+	// SignatureHMACSHA256, SignatureEd25519 - algorithms of manifest signature
+	SignatureHMACSHA256 = "hmac-sha256"
+	SignatureEd25519    = "ed25519"
+)
+
+// SigningConfig - signing section, manifest of uploaded backup is signed by HMAC-SHA256 with hmac_key or by Ed25519 private key
+// and verified on download, manifest contains SHA-256 of archive, so tampered or truncated archive is detected too
+type SigningConfig struct {
+	// HMACKey - shared secret of HMAC-SHA256 signature, the same key signs and verifies
+	HMACKey string `yaml:"hmac_key" envconfig:"SIGNING_HMAC_KEY"`
+	// PrivateKeyFile - PEM file with Ed25519 private key in PKCS #8 format to sign manifests on upload
+	PrivateKeyFile string `yaml:"private_key_file" envconfig:"SIGNING_PRIVATE_KEY_FILE"`
+	// PublicKeyFile - PEM file with Ed25519 public key in PKIX format to verify manifests on download,
+	// public key of PrivateKeyFile is used when it's empty
+	PublicKeyFile string `yaml:"public_key_file" envconfig:"SIGNING_PUBLIC_KEY_FILE"`
+	// Required - refuse download of backups without signature
+	Required bool `yaml:"required" envconfig:"SIGNING_REQUIRED"`
+}
+
+// manifestSigner - sign and verify content of manifests
+type manifestSigner struct {
+	hmacKey    []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	required   bool
+}
+
+// newManifestSigner - load keys of signing section, nil is returned when signing isn't configured
+func newManifestSigner(config SigningConfig) (*manifestSigner, error) {
+	if config.HMACKey == "" && config.PrivateKeyFile == "" && config.PublicKeyFile == "" {
+		if config.Required {
+			return nil, fmt.Errorf("signing.required is set but neither hmac_key nor public_key_file is set")
+		}
+		return nil, nil
+	}
+	if config.HMACKey != "" && (config.PrivateKeyFile != "" || config.PublicKeyFile != "") {
+		return nil, fmt.Errorf("signing.hmac_key can't be used with private_key_file and public_key_file")
+	}
+	s := &manifestSigner{required: config.Required}
+	if config.HMACKey != "" {
+		s.hmacKey = []byte(config.HMACKey)
+		return s, nil
+	}
+	if config.PrivateKeyFile != "" {
+		block, err := readPEM(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse '%s': %v", config.PrivateKeyFile, err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not Ed25519 private key", config.PrivateKeyFile)
+		}
+		s.privateKey = privateKey
+		s.publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+	if config.PublicKeyFile != "" {
+		block, err := readPEM(config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse '%s': %v", config.PublicKeyFile, err)
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not Ed25519 public key", config.PublicKeyFile)
+		}
+		s.publicKey = publicKey
+	}
+	return s, nil
+}
+
+func readPEM(fileName string) ([]byte, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read key: %v", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("'%s' is not PEM file", fileName)
+	}
+	return block.Bytes, nil
+}
+
+// canSign - check that manifests are signed on upload, hosts with public key only can verify but can't sign
+func (s *manifestSigner) canSign() bool {
+	return s != nil && (s.hmacKey != nil || s.privateKey != nil)
+}
+
+// sign - return content of signature file '<algorithm>:<base64 signature>'
+func (s *manifestSigner) sign(content []byte) []byte {
+	if s.hmacKey != nil {
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write(content)
+		return []byte(SignatureHMACSHA256 + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil)) + "\n")
+	}
+	return []byte(SignatureEd25519 + ":" + base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, content)) + "\n")
+}
+
+// verify - check signature of content, signature is nil when manifest isn't signed
+func (s *manifestSigner) verify(content []byte, signature []byte) error {
+	if signature == nil {
+		if s.required {
+			return fmt.Errorf("manifest is not signed")
+		}
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(signature)), ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("can't parse signature of manifest")
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("can't parse signature of manifest: %v", err)
+	}
+	valid := false
+	switch {
+	case parts[0] == SignatureHMACSHA256 && s.hmacKey != nil:
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write(content)
+		valid = hmac.Equal(sig, mac.Sum(nil))
+	case parts[0] == SignatureEd25519 && s.publicKey != nil:
+		valid = ed25519.Verify(s.publicKey, content, sig)
+	default:
+		return fmt.Errorf("manifest is signed by %s, key of this algorithm isn't configured", parts[0])
+	}
+	if !valid {
+		return fmt.Errorf("signature of manifest doesn't match, backup may be tampered")
+	}
+	return nil
+}
+
+// archiveHash - SHA-256 and size of archive stream
+type archiveHash struct {
+	hash hash.Hash
+	size int64
+}
+
+func newArchiveHash() *archiveHash {
+	return &archiveHash{hash: sha256.New()}
+}
+
+func (h *archiveHash) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	return h.hash.Write(p)
+}
+
+func (h *archiveHash) checksum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// check - compare archive with size and checksum saved to manifest, archives uploaded by previous versions aren't checked
+func (h *archiveHash) check(name string, size int64, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	if h.size != size || h.checksum() != checksum {
+		return fmt.Errorf("'%s' doesn't match manifest: size %d, sha256 %s, expected size %d, sha256 %s", name, h.size, h.checksum(), size, checksum)
+	}
+	return nil
+}
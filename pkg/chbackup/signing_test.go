@@ -0,0 +1,79 @@
+package chbackup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestSignerHMAC(t *testing.T) {
+	signer, err := newManifestSigner(SigningConfig{})
+	assert.NoError(t, err)
+	assert.False(t, signer.canSign())
+	_, err = newManifestSigner(SigningConfig{Required: true})
+	assert.Error(t, err)
+
+	signer, err = newManifestSigner(SigningConfig{HMACKey: "secret", Required: true})
+	assert.NoError(t, err)
+	assert.True(t, signer.canSign())
+	manifest := []byte(`{"backup_name":"backup"}`)
+	signature := signer.sign(manifest)
+	assert.NoError(t, signer.verify(manifest, signature))
+	assert.Error(t, signer.verify([]byte(`{"backup_name":"other"}`), signature))
+	assert.Error(t, signer.verify(manifest, nil))
+
+	other, err := newManifestSigner(SigningConfig{HMACKey: "other"})
+	assert.NoError(t, err)
+	assert.Error(t, other.verify(manifest, signature))
+	assert.NoError(t, other.verify(manifest, nil))
+}
+
+func TestManifestSignerEd25519(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+	privateFile, publicFile := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	assert.NoError(t, ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600))
+	assert.NoError(t, ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644))
+
+	signer, err := newManifestSigner(SigningConfig{PrivateKeyFile: privateFile})
+	assert.NoError(t, err)
+	assert.True(t, signer.canSign())
+	verifier, err := newManifestSigner(SigningConfig{PublicKeyFile: publicFile})
+	assert.NoError(t, err)
+	assert.False(t, verifier.canSign())
+
+	manifest := []byte(`{"backup_name":"backup"}`)
+	signature := signer.sign(manifest)
+	assert.NoError(t, verifier.verify(manifest, signature))
+	assert.Error(t, verifier.verify([]byte(`{"backup_name":"other"}`), signature))
+	_, err = newManifestSigner(SigningConfig{PrivateKeyFile: publicFile})
+	assert.Error(t, err)
+	_, err = newManifestSigner(SigningConfig{HMACKey: "secret", PublicKeyFile: publicFile})
+	assert.Error(t, err)
+}
+
+func TestArchiveHash(t *testing.T) {
+	hash := newArchiveHash()
+	hash.Write([]byte("archive"))
+	checksum := hash.checksum()
+	assert.NoError(t, hash.check("backup.tar", 7, checksum))
+	assert.NoError(t, hash.check("backup.tar", 0, ""))
+	assert.Error(t, hash.check("backup.tar", 8, checksum))
+	other := newArchiveHash()
+	other.Write([]byte("archivE"))
+	assert.Error(t, other.check("backup.tar", 7, checksum))
+}
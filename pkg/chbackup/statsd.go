@@ -0,0 +1,62 @@
+package chbackup
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdTimeout - how long sending of one packet to statsd may take
+const statsdTimeout = 5 * time.Second
+
+// formatStatsdMetrics - lines of statsd protocol for finished operation: success or failure counter, duration in milliseconds
+// and size of backup in bytes when it's known
+func formatStatsdMetrics(prefix, operation string, duration time.Duration, size int64, err error) []string {
+	name := strings.Trim(prefix, ".")
+	if name != "" {
+		name += "."
+	}
+	name += operation
+	result := 1
+	lines := []string{}
+	if err != nil {
+		result = 0
+		lines = append(lines, name+".failure:1|c")
+	} else {
+		lines = append(lines, name+".success:1|c")
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s.last_success:%d|g", name, result),
+		fmt.Sprintf("%s.duration:%d|ms", name, duration.Milliseconds()),
+	)
+	if size > 0 {
+		lines = append(lines, fmt.Sprintf("%s.size:%d|g", name, size))
+	}
+	return lines
+}
+
+// sendStatsdMetrics - send metrics of operation started at start and finished with err to metrics.statsd_address
+func sendStatsdMetrics(config Config, operation, backupName string, start time.Time, err error) error {
+	if config.Metrics.StatsdAddress == "" {
+		return nil
+	}
+	duration := time.Since(start)
+	var size int64
+	if err == nil {
+		size = getOperationSize(config, backupName)
+	}
+	conn, dialErr := net.DialTimeout("udp", config.Metrics.StatsdAddress, statsdTimeout)
+	if dialErr != nil {
+		return fmt.Errorf("can't connect to statsd: %v", dialErr)
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(statsdTimeout)); err != nil {
+		return fmt.Errorf("can't send metrics to statsd: %v", err)
+	}
+	packet := strings.Join(formatStatsdMetrics(config.Metrics.StatsdPrefix, operation, duration, size, err), "\n")
+	if _, err := conn.Write([]byte(packet)); err != nil {
+		return fmt.Errorf("can't send metrics to statsd: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package chbackup
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RemoteStorageParams - settings of remote storage used by BackupDestination
+type RemoteStorageParams struct {
+	Path              string
+	CompressionFormat string
+	CompressionLevel  int
+	// Concurrency - how many files of backup directory are transferred or deleted at the same time, 1 when it's not set
+	Concurrency int
+}
+
+// RemoteStorageFactory - create remote storage from config, storage is connected later by Connect
+type RemoteStorageFactory func(config Config) (RemoteStorage, RemoteStorageParams, error)
+
+var (
+	remoteStoragesMutex sync.RWMutex
+	remoteStorages      = map[string]RemoteStorageFactory{}
+)
+
+// RegisterRemoteStorage - make remote storage available by name in general.remote_storage.
+// Custom backends should call it from init() of package which is imported by main package
+func RegisterRemoteStorage(name string, factory RemoteStorageFactory) {
+	remoteStoragesMutex.Lock()
+	defer remoteStoragesMutex.Unlock()
+	if factory == nil {
+		panic("RegisterRemoteStorage: factory is nil")
+	}
+	if _, exists := remoteStorages[name]; exists {
+		panic(fmt.Sprintf("RegisterRemoteStorage: remote storage '%s' is already registered", name))
+	}
+	remoteStorages[name] = factory
+}
+
+// RemoteStorages - return sorted names of registered remote storages
+func RemoteStorages() []string {
+	remoteStoragesMutex.RLock()
+	defer remoteStoragesMutex.RUnlock()
+	names := make([]string, 0, len(remoteStorages))
+	for name := range remoteStorages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getRemoteStorageFactory(name string) (RemoteStorageFactory, bool) {
+	remoteStoragesMutex.RLock()
+	defer remoteStoragesMutex.RUnlock()
+	factory, ok := remoteStorages[name]
+	return factory, ok
+}
+
+func init() {
+	RegisterRemoteStorage("azblob", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &AzureBlob{Config: &config.AzureBlob, lowMemory: config.General.LowMemory}, RemoteStorageParams{
+			Path:              config.AzureBlob.Path,
+			CompressionFormat: config.AzureBlob.CompressionFormat,
+			CompressionLevel:  config.AzureBlob.CompressionLevel,
+		}, nil
+	})
+	RegisterRemoteStorage("s3", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &S3{Config: &config.S3, lowMemory: config.General.LowMemory}, RemoteStorageParams{
+			Path:              config.S3.Path,
+			CompressionFormat: config.S3.CompressionFormat,
+			CompressionLevel:  config.S3.CompressionLevel,
+		}, nil
+	})
+	RegisterRemoteStorage("gcs", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &GCS{Config: &config.GCS, lowMemory: config.General.LowMemory}, RemoteStorageParams{
+			Path:              config.GCS.Path,
+			CompressionFormat: config.GCS.CompressionFormat,
+			CompressionLevel:  config.GCS.CompressionLevel,
+		}, nil
+	})
+	RegisterRemoteStorage("cos", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &COS{Config: &config.COS}, RemoteStorageParams{
+			Path:              config.COS.Path,
+			CompressionFormat: config.COS.CompressionFormat,
+			CompressionLevel:  config.COS.CompressionLevel,
+		}, nil
+	})
+	RegisterRemoteStorage("ftp", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &FTP{Config: &config.FTP}, RemoteStorageParams{
+			Path:              config.FTP.Path,
+			CompressionFormat: config.FTP.CompressionFormat,
+			CompressionLevel:  config.FTP.CompressionLevel,
+			Concurrency:       config.FTP.Concurrency,
+		}, nil
+	})
+	RegisterRemoteStorage("rclone", func(config Config) (RemoteStorage, RemoteStorageParams, error) {
+		return &Rclone{Config: &config.Rclone}, RemoteStorageParams{
+			Path:              config.Rclone.Path,
+			CompressionFormat: config.Rclone.CompressionFormat,
+			CompressionLevel:  config.Rclone.CompressionLevel,
+		}, nil
+	})
+}
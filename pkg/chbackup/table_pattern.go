@@ -0,0 +1,126 @@
+package chbackup
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// tablePatterns - parsed comma separated list of table patterns used by create, upload, download and restore.
+// Pattern is glob matched with 'database.table' (db.*, *.events_2020*) or regular expression prefixed with '~' (~^db\.events_\d+$),
+// pattern prefixed with '!' excludes matched tables (!db.huge_table, !~^logs\.)
+type tablePatterns struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// parseTablePattern - parse comma separated list of table patterns, empty pattern matches all tables,
+// list of exclusions only matches all tables except excluded ones
+func parseTablePattern(tablePattern string) (tablePatterns, error) {
+	result := tablePatterns{}
+	for _, pattern := range splitTablePatterns(tablePattern) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		exclude := strings.HasPrefix(pattern, "!")
+		if exclude {
+			if pattern = strings.TrimSpace(pattern[1:]); pattern == "" {
+				return tablePatterns{}, fmt.Errorf("bad table pattern '!': excluded pattern is empty")
+			}
+		}
+		match, err := parseOneTablePattern(pattern)
+		if err != nil {
+			return tablePatterns{}, err
+		}
+		if exclude {
+			result.exclude = append(result.exclude, match)
+		} else {
+			result.include = append(result.include, match)
+		}
+	}
+	if len(result.include) == 0 {
+		result.include = append(result.include, func(string) bool { return true })
+	}
+	return result, nil
+}
+
+// splitTablePatterns - split list of table patterns by commas which aren't escaped by backslash and aren't inside of
+// brackets, braces or parentheses, so regular expressions like ~^db\.t_\d{1,3}$ or ~^db\.(a,b)$ are kept whole
+func splitTablePatterns(tablePattern string) []string {
+	var result []string
+	depth, inClass, escaped, start := 0, false, false, 0
+	for i, c := range tablePattern {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(' || c == '{':
+			depth++
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			result = append(result, tablePattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, tablePattern[start:])
+}
+
+func parseOneTablePattern(pattern string) (func(string) bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad table pattern '%s': %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad table pattern '%s': %v", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := filepath.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// Match - check that database.table is matched by any of patterns and isn't matched by any of exclusions
+func (p tablePatterns) Match(database, table string) bool {
+	name := fmt.Sprintf("%s.%s", database, table)
+	for _, match := range p.exclude {
+		if match(name) {
+			return false
+		}
+	}
+	for _, match := range p.include {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchBackupFile - check that file from backup directory belongs to matched table,
+// files which don't belong to any table are always matched
+func (p tablePatterns) MatchBackupFile(relativePath string) bool {
+	parts := strings.Split(filepath.ToSlash(relativePath), "/")
+	var database, table string
+	switch {
+	case parts[0] == "metadata" && len(parts) == 3:
+		database, table = parts[1], strings.TrimSuffix(parts[2], ".sql")
+	case (parts[0] == "shadow" || parts[0] == "detached") && len(parts) >= 3:
+		database, table = parts[1], parts[2]
+	default:
+		return true
+	}
+	database, _ = url.PathUnescape(database)
+	table, _ = url.PathUnescape(table)
+	return p.Match(database, table)
+}
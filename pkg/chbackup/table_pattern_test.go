@@ -0,0 +1,69 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTablePatterns(t *testing.T) {
+	patterns, err := parseTablePattern("db1.*, ~^db2\\.events_\\d+$")
+	assert.NoError(t, err)
+	assert.True(t, patterns.Match("db1", "table"))
+	assert.True(t, patterns.Match("db2", "events_2020"))
+	assert.False(t, patterns.Match("db2", "events_last"))
+	assert.True(t, patterns.MatchBackupFile("metadata/db1/table.sql"))
+	assert.False(t, patterns.MatchBackupFile("shadow/db2/events_last/all_1_1_0/data.bin"))
+	assert.False(t, patterns.MatchBackupFile("detached/db2/events_last/broken_all_1_1_0/data.bin"))
+	assert.True(t, patterns.MatchBackupFile("detached/db1/table/all_1_1_0/data.bin"))
+	assert.True(t, patterns.MatchBackupFile("metadata.json"))
+
+	all, err := parseTablePattern("")
+	assert.NoError(t, err)
+	assert.True(t, all.Match("any", "table"))
+
+	_, err = parseTablePattern("~[")
+	assert.Error(t, err)
+	_, err = parseTablePattern("db.*, !")
+	assert.Error(t, err)
+
+	excluded, err := parseTablePattern("!logs.*, !~^db\\.tmp_")
+	assert.NoError(t, err)
+	assert.True(t, excluded.Match("db", "table"))
+	assert.False(t, excluded.Match("logs", "table"))
+	assert.False(t, excluded.Match("db", "tmp_1"))
+
+	excluded, err = parseTablePattern("db.*, !db.huge_table")
+	assert.NoError(t, err)
+	assert.True(t, excluded.Match("db", "table"))
+	assert.False(t, excluded.Match("db", "huge_table"))
+	assert.False(t, excluded.Match("other", "table"))
+	assert.False(t, excluded.MatchBackupFile("shadow/db/huge_table/all_1_1_0/data.bin"))
+
+	assert.Equal(t, []string{"~^db\\.t_\\d{1,3}$", " ~^db\\.(a,b)$", " db.[a,]*", " db.x\\,y"}, splitTablePatterns("~^db\\.t_\\d{1,3}$, ~^db\\.(a,b)$, db.[a,]*, db.x\\,y"))
+	regexps, err := parseTablePattern("~^db\\.t_\\d{1,3}$, other.*")
+	assert.NoError(t, err)
+	assert.True(t, regexps.Match("db", "t_12"))
+	assert.False(t, regexps.Match("db", "t_1234"))
+	assert.True(t, regexps.Match("other", "t"))
+}
+
+func TestSystemLogs(t *testing.T) {
+	patterns, err := parseSystemLogs("query_log, system.metric_log,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"query_log", "metric_log"}, patterns)
+	_, err = parseSystemLogs("tables")
+	assert.Error(t, err)
+	_, err = parseSystemLogs("default.events_log")
+	assert.Error(t, err)
+	_, err = parseSystemLogs("[_log")
+	assert.Error(t, err)
+
+	config := &ClickHouseConfig{SkipTables: []string{"system.*", "logs.*"}, IncludeSystemLogs: []string{"query_log", "trace*_log"}}
+	assert.False(t, isSkippedTable(config, "system", "query_log"))
+	assert.False(t, isSkippedTable(config, "system", "trace_log"))
+	assert.True(t, isSkippedTable(config, "system", "metric_log"))
+	assert.True(t, isSkippedTable(config, "system", "tables"))
+	assert.True(t, isSkippedTable(config, "logs", "query_log"))
+	assert.False(t, isSkippedTable(config, "default", "events"))
+}
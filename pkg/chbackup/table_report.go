@@ -0,0 +1,154 @@
+package chbackup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+const (
+	// TableStatusOK, TableStatusFailed - status of table in report of operation run with --continue-on-error
+	TableStatusOK     = "ok"
+	TableStatusFailed = "failed"
+)
+
+// TableStatus - result of operation for one table
+type TableStatus struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TablesError - operation run with --continue-on-error failed for some of tables and succeeded for others,
+// Tables contains status of every processed table
+type TablesError struct {
+	Operation string
+	Tables    []TableStatus
+}
+
+// Failed - tables which failed
+func (e *TablesError) Failed() []TableStatus {
+	failed := []TableStatus{}
+	for _, t := range e.Tables {
+		if t.Status == TableStatusFailed {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+func (e *TablesError) Error() string {
+	failed := e.Failed()
+	return fmt.Sprintf("%s failed for %d of %d tables: %s", e.Operation, len(failed), len(e.Tables), joinTableErrors(failed))
+}
+
+// Print - write status of every table and summary
+func (e *TablesError) Print(w io.Writer) {
+	for _, t := range e.Tables {
+		fmt.Fprintf(w, "[%s] %s.%s", strings.ToUpper(t.Status), t.Database, t.Table)
+		if t.Error != "" {
+			fmt.Fprintf(w, "\t%s", t.Error)
+		}
+		fmt.Fprintln(w)
+	}
+	failed := len(e.Failed())
+	fmt.Fprintf(w, "%d tables succeeded, %d failed\n", len(e.Tables)-failed, failed)
+}
+
+func joinTableErrors(tables []TableStatus) string {
+	messages := make([]string, 0, len(tables))
+	for _, t := range tables {
+		messages = append(messages, fmt.Sprintf("'%s.%s': %s", t.Database, t.Table, t.Error))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// tableReport - status of tables processed by operation, errors of tables abort operation unless continueOnError is set
+type tableReport struct {
+	operation       string
+	continueOnError bool
+	mu              sync.Mutex
+	tables          []TableStatus
+	index           map[string]int
+}
+
+func newTableReport(operation string, continueOnError bool) *tableReport {
+	return &tableReport{operation: operation, continueOnError: continueOnError, index: map[string]int{}}
+}
+
+// succeed - mark table as processed, status of table which already failed is not changed
+func (r *tableReport) succeed(database, table string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.index[database+"."+table]; !ok {
+		r.index[database+"."+table] = len(r.tables)
+		r.tables = append(r.tables, TableStatus{Database: database, Table: table, Status: TableStatusOK})
+	}
+}
+
+// fail - mark table as failed and return err when operation must be aborted, otherwise error is logged and nil is returned.
+// The first error of table is kept
+func (r *tableReport) fail(database, table string, err error) error {
+	if !r.continueOnError {
+		return err
+	}
+	log.Printf("'%s.%s' is skipped: %v", database, table, err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := TableStatus{Database: database, Table: table, Status: TableStatusFailed, Error: err.Error()}
+	i, ok := r.index[database+"."+table]
+	switch {
+	case !ok:
+		r.index[database+"."+table] = len(r.tables)
+		r.tables = append(r.tables, status)
+	case r.tables[i].Status != TableStatusFailed:
+		r.tables[i] = status
+	}
+	return nil
+}
+
+// failed - table already failed, it's skipped by next steps of operation
+func (r *tableReport) failed(database, table string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.index[database+"."+table]
+	return ok && r.tables[i].Status == TableStatusFailed
+}
+
+// failedTables - tables which failed
+func (r *tableReport) failedTables() []TableStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return (&TablesError{Tables: r.tables}).Failed()
+}
+
+// filter - remove failed tables from names '<database>.<table>'
+func (r *tableReport) filter(names []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := []string{}
+	for _, name := range names {
+		i, ok := r.index[name]
+		if !ok || r.tables[i].Status != TableStatusFailed {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// err - TablesError when some of tables failed, error without report when all tables failed
+func (r *tableReport) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := &TablesError{Operation: r.operation, Tables: append([]TableStatus{}, r.tables...)}
+	switch len(e.Failed()) {
+	case 0:
+		return nil
+	case len(e.Tables):
+		return fmt.Errorf("%s failed for all tables: %s", r.operation, joinTableErrors(e.Failed()))
+	}
+	return e
+}
@@ -0,0 +1,36 @@
+package chbackup
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableReport(t *testing.T) {
+	report := newTableReport("restore", false)
+	err := errors.New("can't create table 'db.t2'")
+	assert.Equal(t, err, report.fail("db", "t2", err))
+
+	report = newTableReport("restore", true)
+	report.succeed("db", "t1")
+	assert.NoError(t, report.fail("db", "t2", err))
+	assert.NoError(t, report.fail("db", "t2", errors.New("table is not created")))
+	report.succeed("db", "t2")
+	assert.True(t, report.failed("db", "t2"))
+	assert.False(t, report.failed("db", "t1"))
+	assert.Equal(t, []string{"db.t1"}, report.filter([]string{"db.t1", "db.t2"}))
+
+	tablesErr := report.err()
+	assert.Equal(t, "restore failed for 1 of 2 tables: 'db.t2': can't create table 'db.t2'", tablesErr.Error())
+	assert.Equal(t, ExitPartialFailure, ExitCode(tablesErr))
+	var out bytes.Buffer
+	tablesErr.(*TablesError).Print(&out)
+	assert.Equal(t, "[OK] db.t1\n[FAILED] db.t2\tcan't create table 'db.t2'\n1 tables succeeded, 1 failed\n", out.String())
+
+	report = newTableReport("create", true)
+	assert.NoError(t, report.err())
+	assert.NoError(t, report.fail("db", "t1", err))
+	assert.Equal(t, "create failed for all tables: 'db.t1': can't create table 'db.t2'", report.err().Error())
+}
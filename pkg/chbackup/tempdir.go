@@ -0,0 +1,57 @@
+package chbackup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// tempFilePrefix - prefix of all temporary files, it's used to find files left by interrupted operations
+	tempFilePrefix = "clickhouse-backup-"
+	// orphanedTempFileAge - temporary files are removed right after use, so older files are left by killed processes
+	orphanedTempFileAge = time.Hour
+)
+
+// getTempDir - return general.temp_dir or system temporary directory if it's not set
+func getTempDir(config GeneralConfig) string {
+	if config.TempDir != "" {
+		return config.TempDir
+	}
+	return os.TempDir()
+}
+
+// createTempFile - create temporary file in tempDir, caller must remove it
+func createTempFile(tempDir string, name string) (*os.File, error) {
+	if err := os.MkdirAll(tempDir, dirMode(0750)); err != nil {
+		return nil, fmt.Errorf("can't create temp_dir: %v", err)
+	}
+	return ioutil.TempFile(tempDir, tempFilePrefix+name+"-")
+}
+
+// CleanTempDir - remove temporary files left in general.temp_dir by interrupted operations
+func CleanTempDir(config Config) error {
+	tempDir := getTempDir(config.General)
+	entries, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't read temp_dir: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), tempFilePrefix) || time.Since(entry.ModTime()) < orphanedTempFileAge {
+			continue
+		}
+		tempFile := filepath.Join(tempDir, entry.Name())
+		log.Printf("Remove orphaned temporary file '%s'", tempFile)
+		if err := os.RemoveAll(tempFile); err != nil {
+			return fmt.Errorf("can't remove '%s': %v", tempFile, err)
+		}
+	}
+	return nil
+}
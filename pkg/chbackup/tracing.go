@@ -0,0 +1,247 @@
+package chbackup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracingExportTimeout - how long export of spans of one operation to OTLP receiver may take
+const tracingExportTimeout = 30 * time.Second
+
+// TracingConfig - tracing settings section, spans of create, upload, download and restore are exported by OTLP/HTTP with JSON encoding
+type TracingConfig struct {
+	// OTLPEndpoint - base URL of OTLP/HTTP receiver like http://tempo:4318, spans are posted to '<endpoint>/v1/traces',
+	// tracing is disabled when it's empty
+	OTLPEndpoint string `yaml:"otlp_endpoint" envconfig:"TRACING_OTLP_ENDPOINT"`
+	// ServiceName - service.name attribute of exported spans
+	ServiceName string `yaml:"service_name" envconfig:"TRACING_SERVICE_NAME"`
+	// Headers - headers of export requests, e.g. authorization of receiver
+	Headers map[string]string `yaml:"headers" envconfig:"TRACING_HEADERS"`
+}
+
+// validateTracingConfig - check values of tracing section
+func validateTracingConfig(config TracingConfig) error {
+	if config.OTLPEndpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(config.OTLPEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("tracing.otlp_endpoint must be http(s) URL")
+	}
+	if config.ServiceName == "" {
+		return fmt.Errorf("tracing.service_name can't be empty")
+	}
+	return nil
+}
+
+// span - timed step of traced operation, methods of nil span do nothing, so code works the same way when tracing is disabled
+type span struct {
+	trace      *trace
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// trace - spans of one operation, they are exported when root span is finished
+type trace struct {
+	sync.Mutex
+	config  TracingConfig
+	traceID string
+	root    *span
+	spans   []*span
+}
+
+// spanContextKey - key of span of running operation in context, operations and steps started with this context are its children
+type spanContextKey struct{}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// spanFromContext - span of operation which runs with ctx, nil when operation isn't traced
+func spanFromContext(ctx context.Context) *span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// startTrace - start span of operation, it's root span of new trace unless ctx belongs to another traced operation.
+// Returned context must be passed to steps of operation. Returned function finishes span with error of operation,
+// trace is exported when root span is finished. Operations running at the same time have separate traces
+func startTrace(ctx context.Context, config Config, operation string, backupName string) (context.Context, func(err error)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if parent := spanFromContext(ctx); parent != nil {
+		s := parent.trace.newSpan(operation, parent.spanID, []string{"backup", backupName})
+		return context.WithValue(ctx, spanContextKey{}, s), s.finish
+	}
+	if config.Tracing.OTLPEndpoint == "" {
+		return ctx, func(error) {}
+	}
+	t := &trace{config: config.Tracing, traceID: randomHex(16)}
+	t.root = t.newSpan(operation, "", []string{"backup", backupName})
+	return context.WithValue(ctx, spanContextKey{}, t.root), func(err error) {
+		t.root.finish(err)
+		if err := t.export(); err != nil {
+			log.Printf("can't export trace: %v", err)
+		}
+	}
+}
+
+// startSpan - start child span of span of operation of ctx, attributes are pairs of key and value.
+// It returns nil when operation isn't traced
+func startSpan(ctx context.Context, name string, attributes ...string) *span {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	return parent.trace.newSpan(name, parent.spanID, attributes)
+}
+
+func (t *trace) newSpan(name, parentID string, attributes []string) *span {
+	s := &span{
+		trace:      t,
+		spanID:     randomHex(8),
+		parentID:   parentID,
+		name:       name,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+	return s
+}
+
+// finish - set end time and error of span, span is exported with its trace
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.trace.Lock()
+	s.trace.spans = append(s.trace.spans, s)
+	s.trace.Unlock()
+}
+
+// otlpAttribute, otlpSpan - spans in JSON encoding of OTLP
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func newOTLPAttributes(attributes map[string]string) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for k, v := range attributes {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		result = append(result, a)
+	}
+	return result
+}
+
+// marshal - request body of OTLP/HTTP export with all finished spans of trace
+func (t *trace) marshal() ([]byte, error) {
+	t.Lock()
+	defer t.Unlock()
+	spans := make([]otlpSpan, 0, len(t.spans))
+	for _, s := range t.spans {
+		o := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        newOTLPAttributes(s.attributes),
+		}
+		o.Status.Code = 1 // STATUS_CODE_OK
+		if s.err != nil {
+			o.Status.Code = 2 // STATUS_CODE_ERROR
+			o.Status.Message = s.err.Error()
+		}
+		spans = append(spans, o)
+	}
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": newOTLPAttributes(map[string]string{"service.name": t.config.ServiceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "clickhouse-backup"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+}
+
+// export - post spans of trace to OTLP receiver
+func (t *trace) export() error {
+	body, err := t.marshal()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(t.config.OTLPEndpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: tracingExportTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OTLP receiver returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
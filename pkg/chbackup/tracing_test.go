@@ -0,0 +1,92 @@
+package chbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTracingConfig(t *testing.T) {
+	assert.NoError(t, validateTracingConfig(TracingConfig{}))
+	assert.NoError(t, validateTracingConfig(TracingConfig{OTLPEndpoint: "http://tempo:4318", ServiceName: "clickhouse-backup"}))
+	assert.Error(t, validateTracingConfig(TracingConfig{OTLPEndpoint: "tempo:4318", ServiceName: "clickhouse-backup"}))
+	assert.Error(t, validateTracingConfig(TracingConfig{OTLPEndpoint: "http://tempo:4318"}))
+}
+
+func TestTraceExport(t *testing.T) {
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		authorization = r.Header.Get("Authorization")
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &request))
+	}))
+	defer server.Close()
+
+	config := Config{Tracing: TracingConfig{OTLPEndpoint: server.URL, ServiceName: "clickhouse-backup", Headers: map[string]string{"Authorization": "Bearer token"}}}
+	assert.Nil(t, startSpan(context.Background(), "freeze"))
+	ctx, finishTrace := startTrace(context.Background(), config, "create_remote", "backup1")
+	startSpan(ctx, "freeze", "table", "default.t1").finish(nil)
+	_, finishUpload := startTrace(ctx, config, "upload", "backup1")
+	finishUpload(fmt.Errorf("can't upload"))
+	finishTrace(fmt.Errorf("can't upload"))
+	assert.Nil(t, startSpan(context.Background(), "freeze"))
+
+	assert.Equal(t, "Bearer token", authorization)
+	if assert.Len(t, request.ResourceSpans, 1) && assert.Len(t, request.ResourceSpans[0].ScopeSpans, 1) {
+		spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+		if assert.Len(t, spans, 3) {
+			root := spans[2]
+			assert.Equal(t, "create_remote", root.Name)
+			assert.Empty(t, root.ParentSpanID)
+			assert.Equal(t, 2, root.Status.Code)
+			assert.Equal(t, "freeze", spans[0].Name)
+			assert.Equal(t, root.SpanID, spans[0].ParentSpanID)
+			assert.Equal(t, root.TraceID, spans[0].TraceID)
+			assert.Equal(t, 1, spans[0].Status.Code)
+			assert.Equal(t, "upload", spans[1].Name)
+			assert.Equal(t, "can't upload", spans[1].Status.Message)
+		}
+	}
+}
+
+func TestConcurrentTraces(t *testing.T) {
+	exported := make(chan int, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &request))
+		exported <- len(request.ResourceSpans[0].ScopeSpans[0].Spans)
+	}))
+	defer server.Close()
+
+	config := Config{Tracing: TracingConfig{OTLPEndpoint: server.URL, ServiceName: "clickhouse-backup"}}
+	// operations of different backups running at the same time have own traces
+	upload, finishUpload := startTrace(context.Background(), config, "upload", "backup1")
+	download, finishDownload := startTrace(context.Background(), config, "download", "backup2")
+	assert.NotEqual(t, spanFromContext(upload).trace, spanFromContext(download).trace)
+	finishUpload(nil)
+	startSpan(download, "download file", "key", "backup2.tar.gz").finish(nil)
+	finishDownload(nil)
+	assert.Equal(t, 1, <-exported)
+	assert.Equal(t, 2, <-exported)
+}
@@ -0,0 +1,135 @@
+package chbackup
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// transferUpload, transferDownload - direction label of transfer metrics
+	transferUpload   = "upload"
+	transferDownload = "download"
+	// transferSpeedWindow - period over which current speed of transfers is measured
+	transferSpeedWindow = 10 * time.Second
+)
+
+// transferMetrics - bytes uploaded to and downloaded from remote storages by all transfers of process
+var transferMetrics = newTransferStats()
+
+// transferStats - prometheus.Collector of total bytes and current speed of transfers by storage and direction
+type transferStats struct {
+	mu       sync.Mutex
+	counters map[transferKey]*transferCounter
+	now      func() time.Time
+
+	uploadDesc   *prometheus.Desc
+	downloadDesc *prometheus.Desc
+	speedDesc    *prometheus.Desc
+}
+
+type transferKey struct {
+	storage   string
+	direction string
+}
+
+// transferCounter - bytes of storage and direction, speed is bytes per second of the last finished window
+type transferCounter struct {
+	total       int64
+	windowStart time.Time
+	windowBytes int64
+	speed       float64
+}
+
+func newTransferStats() *transferStats {
+	return &transferStats{
+		counters: map[transferKey]*transferCounter{},
+		now:      time.Now,
+		uploadDesc: prometheus.NewDesc("clickhouse_backup_upload_bytes_total",
+			"Bytes uploaded to remote storage.", []string{"storage"}, nil),
+		downloadDesc: prometheus.NewDesc("clickhouse_backup_download_bytes_total",
+			"Bytes downloaded from remote storage.", []string{"storage"}, nil),
+		speedDesc: prometheus.NewDesc("clickhouse_backup_transfer_speed_bytes_per_second",
+			"Bytes per second transferred to or from remote storage during the last 10 seconds.", []string{"storage", "direction"}, nil),
+	}
+}
+
+// add - count n bytes transferred in direction by storage
+func (s *transferStats) add(storage, direction string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := transferKey{storage, direction}
+	c, ok := s.counters[key]
+	if !ok {
+		c = &transferCounter{windowStart: s.now()}
+		s.counters[key] = c
+	}
+	c.rotate(s.now())
+	c.total += int64(n)
+	c.windowBytes += int64(n)
+}
+
+// rotate - finish window when it's over, speed of transfer which stalled drops to 0 after the next window
+func (c *transferCounter) rotate(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < transferSpeedWindow {
+		return
+	}
+	c.speed = float64(c.windowBytes) / elapsed.Seconds()
+	c.windowStart = now
+	c.windowBytes = 0
+}
+
+// Describe - implements prometheus.Collector
+func (s *transferStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.uploadDesc
+	ch <- s.downloadDesc
+	ch <- s.speedDesc
+}
+
+// Collect - implements prometheus.Collector
+func (s *transferStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, c := range s.counters {
+		c.rotate(s.now())
+		desc := s.uploadDesc
+		if key.direction == transferDownload {
+			desc = s.downloadDesc
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(c.total), key.storage)
+		ch <- prometheus.MustNewConstMetric(s.speedDesc, prometheus.GaugeValue, c.speed, key.storage, key.direction)
+	}
+}
+
+// countingReader - count bytes read from reader by transferStats
+type countingReader struct {
+	io.ReadCloser
+	stats     *transferStats
+	storage   string
+	direction string
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.stats.add(r.storage, r.direction, n)
+	}
+	return n, err
+}
+
+// PutFile - upload r to remote storage, uploaded bytes are counted by transferMetrics
+func (bd *BackupDestination) PutFile(key string, r io.ReadCloser) error {
+	return bd.RemoteStorage.PutFile(key, &countingReader{r, transferMetrics, bd.storageName, transferUpload})
+}
+
+// GetFileReader - open object of remote storage, downloaded bytes are counted by transferMetrics
+func (bd *BackupDestination) GetFileReader(key string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReader(key)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReader{r, transferMetrics, bd.storageName, transferDownload}, nil
+}
@@ -0,0 +1,43 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferStats(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	stats := newTransferStats()
+	stats.now = func() time.Time { return now }
+
+	r := &countingReader{ioutil.NopCloser(strings.NewReader("0123456789")), stats, "s3", transferUpload}
+	_, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	stats.add("gcs", transferDownload, 2000)
+	now = now.Add(transferSpeedWindow)
+	stats.add("gcs", transferDownload, 5)
+
+	upload, download := stats.counters[transferKey{"s3", transferUpload}], stats.counters[transferKey{"gcs", transferDownload}]
+	assert.Equal(t, int64(10), upload.total)
+	assert.Equal(t, int64(2005), download.total)
+	assert.Equal(t, 200.0, download.speed)
+
+	ch := make(chan prometheus.Metric, 10)
+	stats.Collect(ch)
+	assert.Len(t, ch, 4)
+	assert.Equal(t, 1.0, upload.speed)
+
+	// speed of stalled transfer drops to 0 after the window which is finished
+	now = now.Add(transferSpeedWindow)
+	stats.Collect(make(chan prometheus.Metric, 10))
+	assert.Equal(t, 0.5, download.speed)
+	now = now.Add(transferSpeedWindow)
+	stats.Collect(make(chan prometheus.Metric, 10))
+	assert.Equal(t, 0.0, download.speed)
+	assert.Equal(t, int64(2005), download.total)
+}
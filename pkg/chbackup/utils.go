@@ -1,9 +1,10 @@
 package chbackup
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -16,12 +17,25 @@ import (
 	"time"
 
 	"github.com/mholt/archiver"
+	"golang.org/x/sync/errgroup"
 )
 
 type Backup struct {
-	Name string
-	Size int64
-	Date time.Time
+	Name           string
+	Size           int64
+	Date           time.Time
+	RequiredBackup string
+	Broken         string
+	// TableCount - count of tables in metadata of backup, 0 when metadata can't be read
+	TableCount int
+	// UploadState - upload_state of remote backup
+	UploadState string
+	// Checksums - ChecksumsAll, ChecksumsPartial or ChecksumsNone depending on how many parts have checksums
+	Checksums string
+	// ArchiveChecksum - SHA-256 of archive from manifest of remote backup
+	ArchiveChecksum string
+	// TablePattern - tables filter of 'upload --tables' from manifest of remote backup
+	TablePattern string
 }
 
 func cleanDir(dir string) error {
@@ -64,30 +78,128 @@ func isClickhouseShadow(path string) bool {
 	return true
 }
 
-func moveShadow(shadowPath, backupPath string) error {
-	if err := filepath.Walk(shadowPath, func(filePath string, info os.FileInfo, err error) error {
-		relativePath := strings.Trim(strings.TrimPrefix(filePath, shadowPath), "/")
+// moveShadow - move files of all increments in shadowPath to backupPath, files are moved in concurrency goroutines
+// Files of parts unchanged since diff-from backup are linked from it when linker is set
+func moveShadow(shadowPath, backupPath string, concurrency int, linker *diffFromLinker) error {
+	if err := moveShadowFiles(shadowPath, "", backupPath, concurrency, linker); err != nil {
+		return err
+	}
+	return cleanDir(shadowPath)
+}
+
+// getShadowParts - return '<database>/<table>/<part>' of parts frozen to increment name or to all increments when name is empty
+func getShadowParts(shadowPath, name string) ([]string, error) {
+	if name == "" {
+		name = "*"
+	}
+	dirs, err := filepath.Glob(filepath.Join(shadowPath, name, "data", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		pathParts := strings.Split(filepath.ToSlash(dir), "/")
+		parts = append(parts, strings.Join(pathParts[len(pathParts)-3:], "/"))
+	}
+	return parts, nil
+}
+
+// moveNamedShadow - move files of tables frozen WITH NAME from shadowPath/name to backupPath, other increments are kept
+func moveNamedShadow(shadowPath, name, backupPath string, concurrency int, linker *diffFromLinker) error {
+	if err := moveShadowFiles(shadowPath, name, backupPath, concurrency, linker); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(shadowPath, name))
+}
+
+// moveShadowFiles - move files of increment name or of all increments when name is empty from shadowPath to backupPath
+func moveShadowFiles(shadowPath, name, backupPath string, concurrency int, linker *diffFromLinker) error {
+	type move struct {
+		src  string
+		dst  string
+		link bool
+	}
+	moves := []move{}
+	root := filepath.Join(shadowPath, name)
+	if err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// shadow isn't created when all frozen tables are empty
+			if filePath == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		relativePath := strings.Trim(strings.TrimPrefix(filepath.ToSlash(filePath), shadowPath), "/")
 		pathParts := strings.SplitN(relativePath, "/", 3)
 		if len(pathParts) != 3 {
 			return nil
 		}
 		dstFilePath := filepath.Join(backupPath, pathParts[2])
 		if info.IsDir() {
-			return os.MkdirAll(dstFilePath, os.ModePerm)
+			if err := os.MkdirAll(dstFilePath, dirMode(os.ModePerm)); err != nil {
+				return err
+			}
+			return preserveXattrs(filePath, dstFilePath)
 		}
 		if !info.Mode().IsRegular() {
 			log.Printf("'%s' is not a regular file, skipping", filePath)
 			return nil
 		}
-		return os.Rename(filePath, dstFilePath)
+		if linker != nil {
+			previousFile, ok, err := linker.source(filePath, pathParts[2])
+			if err != nil {
+				return err
+			}
+			if ok {
+				moves = append(moves, move{src: previousFile, dst: dstFilePath, link: true})
+				return nil
+			}
+		}
+		moves = append(moves, move{src: filePath, dst: dstFilePath})
+		return nil
 	}); err != nil {
 		return err
 	}
-	return cleanDir(shadowPath)
+	return runParallel(concurrency, len(moves), func(i int) error {
+		if moves[i].link {
+			return linkFile(moves[i].src, moves[i].dst)
+		}
+		if err := os.Rename(moves[i].src, moves[i].dst); err != nil {
+			if !isCrossDeviceError(err) {
+				return err
+			}
+			// backup directory is mounted from another filesystem, rename is impossible
+			return copyFile(moves[i].src, moves[i].dst)
+		}
+		return nil
+	})
+}
+
+// runParallel - call fn for every index from 0 to n-1 in not more than concurrency goroutines,
+// no new calls are started after first error and this error is returned
+func runParallel(concurrency int, n int, fn func(i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	g, ctx := errgroup.WithContext(context.Background())
+	workers := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return g.Wait()
+		}
+		i := i
+		g.Go(func() error {
+			defer func() { <-workers }()
+			return fn(i)
+		})
+	}
+	return g.Wait()
 }
 
 func copyFile(srcFile string, dstFile string) error {
-	if err := os.MkdirAll(path.Dir(dstFile), os.ModePerm); err != nil {
+	if err := os.MkdirAll(path.Dir(dstFile), dirMode(os.ModePerm)); err != nil {
 		return err
 	}
 	src, err := os.Open(srcFile)
@@ -95,26 +207,107 @@ func copyFile(srcFile string, dstFile string) error {
 		return err
 	}
 	defer src.Close()
-	dst, err := os.Create(dstFile)
+	dst, err := createFile(dstFile)
 	if err != nil {
 		return err
 	}
 	defer dst.Close()
-	_, err = io.Copy(dst, src)
-	return err
+	if err := localIO.copy(dst, src); err != nil {
+		return err
+	}
+	return preserveXattrs(srcFile, dstFile)
+}
+
+// isCrossDeviceError - check that link or rename failed because source and destination are on different filesystems
+func isCrossDeviceError(err error) bool {
+	if linkErr, ok := err.(*os.LinkError); ok {
+		return linkErr.Err == errCrossDevice
+	}
+	return false
 }
 
+// linkFile - create hard link dstFile to srcFile and fallback to copy when they are on different filesystems
+func linkFile(srcFile string, dstFile string) error {
+	err := os.Link(srcFile, dstFile)
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+	return copyFile(srcFile, dstFile)
+}
+
+// nearestExistingPath - return path itself or its nearest existing parent directory
+func nearestExistingPath(p string) string {
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p
+		}
+		p = parent
+	}
+}
+
+// checkFreeSpace - return error when filesystem where path is located has less than required bytes available
+func checkFreeSpace(p string, required int64) error {
+	if required <= 0 {
+		return nil
+	}
+	free, err := getFreeSpace(p)
+	if err != nil {
+		return fmt.Errorf("can't get free space on '%s': %v", p, err)
+	}
+	if free < required {
+		return fmt.Errorf("not enough free space on '%s': required %s, available %s", p, FormatBytes(required), FormatBytes(free))
+	}
+	return nil
+}
+
+// getDirSize - return total size of regular files in directory
+func getDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// GetBackupsToDelete - return backups older than keep newest ones, backups required by kept backups are not deleted
 func GetBackupsToDelete(backups []Backup, keep int) []Backup {
 	if len(backups) > keep {
 		sort.SliceStable(backups, func(i, j int) bool {
 			return backups[i].Date.After(backups[j].Date)
 		})
-		return backups[keep:]
+		required := map[string]string{}
+		for _, b := range backups {
+			required[trimArchiveExtension(b.Name)] = trimArchiveExtension(b.RequiredBackup)
+		}
+		keepRequired := map[string]bool{}
+		for _, b := range backups[:keep] {
+			for r := required[trimArchiveExtension(b.Name)]; r != "" && !keepRequired[r]; r = required[r] {
+				keepRequired[r] = true
+			}
+		}
+		result := []Backup{}
+		for _, b := range backups[keep:] {
+			if !keepRequired[trimArchiveExtension(b.Name)] {
+				result = append(result, b)
+			}
+		}
+		return result
 	}
 	return []Backup{}
 }
 
-func getArchiveWriter(format string, level int) (archiver.Writer, error) {
+// getArchiveWriter - return writer of archive, workers limits count of cores used by gzip compression, 0 means default
+func getArchiveWriter(format string, level int, workers int) (archiver.Writer, error) {
 	switch format {
 	case "tar":
 		return &archiver.Tar{}, nil
@@ -123,6 +316,12 @@ func getArchiveWriter(format string, level int) (archiver.Writer, error) {
 	case "bzip2":
 		return &archiver.TarBz2{CompressionLevel: level, Tar: archiver.NewTar()}, nil
 	case "gzip":
+		if workers == 1 {
+			return &archiver.TarGz{CompressionLevel: level, Tar: archiver.NewTar(), SingleThreaded: true}, nil
+		}
+		if workers > 1 {
+			return &tarGzWorkers{Tar: archiver.NewTar(), level: level, workers: workers}, nil
+		}
 		return &archiver.TarGz{CompressionLevel: level, Tar: archiver.NewTar()}, nil
 	case "sz":
 		return &archiver.TarSz{Tar: archiver.NewTar()}, nil
@@ -132,6 +331,12 @@ func getArchiveWriter(format string, level int) (archiver.Writer, error) {
 	return nil, fmt.Errorf("wrong compression_format, supported: 'lz4', 'bzip2', 'gzip', 'sz', 'xz'")
 }
 
+// archiveFileName - name of file in archive relative to localPath, names use forward slashes on every OS
+// so archives created on Windows are extracted on Linux and vice versa
+func archiveFileName(localPath, filePath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(filePath), filepath.ToSlash(localPath)), "/")
+}
+
 func getExtension(format string) string {
 	switch format {
 	case "tar":
@@ -218,14 +423,21 @@ func writeError(w http.ResponseWriter, statusCode int, operation string, err err
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
 	w.Header().Set("Pragma", "no-cache")
+	var tables []TableStatus
+	var tablesErr *TablesError
+	if errors.As(err, &tablesErr) {
+		tables = tablesErr.Tables
+	}
 	out, _ := json.Marshal(struct {
-		Status    string `json:"status"`
-		Operation string `json:"operation,omitempty"`
-		Error     string `json:"error"`
+		Status    string        `json:"status"`
+		Operation string        `json:"operation,omitempty"`
+		Error     string        `json:"error"`
+		Tables    []TableStatus `json:"tables,omitempty"`
 	}{
 		Status:    "error",
 		Operation: operation,
 		Error:     err.Error(),
+		Tables:    tables,
 	})
 	fmt.Fprintln(w, string(out))
 }
@@ -1,7 +1,13 @@
 package chbackup
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -30,4 +36,79 @@ func TestGetBackupsToDelete(t *testing.T) {
 	}
 	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 3))
 	assert.Equal(t, []Backup{}, GetBackupsToDelete([]Backup{testData[0]}, 3))
+
+	chainData := []Backup{
+		{Name: "full", Date: timeParse("2019-01-28T19-50-12")},
+		{Name: "inc1", Date: timeParse("2019-02-28T19-50-12"), RequiredBackup: "full"},
+		{Name: "inc2", Date: timeParse("2019-03-28T19-50-12"), RequiredBackup: "inc1"},
+		{Name: "old", Date: timeParse("2018-12-28T19-50-12")},
+	}
+	assert.Equal(t, []Backup{chainData[3]}, GetBackupsToDelete(chainData, 1))
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	tmpDir := os.TempDir()
+	assert.NoError(t, checkFreeSpace(tmpDir, 0))
+	assert.NoError(t, checkFreeSpace(filepath.Join(tmpDir, "not", "exists"), 1))
+	assert.Error(t, checkFreeSpace(tmpDir, 1<<62))
+}
+
+func TestArchiveFileName(t *testing.T) {
+	localPath := filepath.Join(os.TempDir(), "backup", "b1")
+	assert.Equal(t, "shadow/db/t/all_1_1_0/data.bin", archiveFileName(localPath, filepath.Join(localPath, "shadow", "db", "t", "all_1_1_0", "data.bin")))
+	assert.Equal(t, "metadata.json", archiveFileName(filepath.ToSlash(localPath), filepath.Join(localPath, "metadata.json")))
+}
+
+func TestRunParallel(t *testing.T) {
+	var calls, running, maxRunning int32
+	err := runParallel(3, 10, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), calls)
+	assert.True(t, maxRunning <= 3)
+
+	err = runParallel(1, 10, func(i int) error {
+		if i == 2 {
+			return fmt.Errorf("table %d", i)
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "table 2")
+}
+
+func TestGetShadowParts(t *testing.T) {
+	shadowPath, err := ioutil.TempDir("", "shadow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(shadowPath)
+	for _, dir := range []string{"1/data/db/events/all_1_1_0", "2/data/db/events/all_2_2_0", "clickhouse_backup_b1/data/db/logs/202001_1_1_0"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(shadowPath, dir), 0750))
+	}
+	parts, err := getShadowParts(shadowPath, "clickhouse_backup_b1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db/logs/202001_1_1_0"}, parts)
+	parts, err = getShadowParts(shadowPath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db/events/all_1_1_0", "db/events/all_2_2_0", "db/logs/202001_1_1_0"}, parts)
+	parts, err = getShadowParts(filepath.Join(shadowPath, "missing"), "")
+	assert.NoError(t, err)
+	assert.Empty(t, parts)
+}
+
+func TestParseBackupNames(t *testing.T) {
+	assert.Equal(t, []string{"shard2", "shard3"}, parseBackupNames(" shard2,,shard3 "))
+	assert.Nil(t, parseBackupNames(""))
+
+	_, err := restore(context.Background(), Config{}, "shard1", "", RestoreOptions{Reshard: "shard2", Validate: true})
+	assert.Error(t, err)
 }
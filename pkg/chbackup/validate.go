@@ -0,0 +1,80 @@
+package chbackup
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// TableValidation - restored table compared with metadata of backup
+type TableValidation struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	// ExpectedRows - rows of table in backup, rows are not compared when it's 0 for backups created by previous versions
+	ExpectedRows uint64 `json:"expected_rows"`
+	// RestoredRows - difference of rows of active parts after and before restore
+	RestoredRows uint64 `json:"restored_rows"`
+	// Parts - count of parts of backup with checksums, MissingParts - count of them not found in active parts after restore
+	Parts        int `json:"parts"`
+	MissingParts int `json:"missing_parts"`
+}
+
+// Mismatch - describe difference of restored table and backup, it's empty when table is restored completely
+func (v TableValidation) Mismatch() string {
+	mismatch := []string{}
+	if v.ExpectedRows > 0 && v.RestoredRows != v.ExpectedRows {
+		mismatch = append(mismatch, fmt.Sprintf("%d rows restored, %d rows in backup", v.RestoredRows, v.ExpectedRows))
+	}
+	if v.MissingParts > 0 {
+		mismatch = append(mismatch, fmt.Sprintf("%d of %d parts not found", v.MissingParts, v.Parts))
+	}
+	return strings.Join(mismatch, ", ")
+}
+
+// validateRestoredTable - compare rows and checksums of parts of table after restore with metadata of backup,
+// rowsBefore - rows of table before restore
+func validateRestoredTable(ch *ClickHouse, table BackupTableMetadata, rowsBefore uint64) (TableValidation, error) {
+	v := TableValidation{Database: table.Database, Table: table.Table, ExpectedRows: table.Rows}
+	rows, paths, err := ch.GetTableParts(table.Database, table.Table)
+	if err != nil {
+		return v, err
+	}
+	if rows > rowsBefore {
+		v.RestoredRows = rows - rowsBefore
+	}
+	checksums := map[string]bool{}
+	for _, p := range paths {
+		checksum, err := getPartChecksum(p)
+		if err != nil {
+			return v, err
+		}
+		checksums[checksum] = true
+	}
+	for _, part := range table.Parts {
+		if part.Checksum == "" {
+			continue
+		}
+		v.Parts++
+		if !checksums[part.Checksum] {
+			v.MissingParts++
+		}
+	}
+	return v, nil
+}
+
+// reportValidation - log result of every table, return error when any table doesn't match backup
+func reportValidation(validations []TableValidation) error {
+	failed := []string{}
+	for _, v := range validations {
+		if mismatch := v.Mismatch(); mismatch != "" {
+			log.Printf("Validation of '%s.%s' failed: %s", v.Database, v.Table, mismatch)
+			failed = append(failed, fmt.Sprintf("%s.%s", v.Database, v.Table))
+			continue
+		}
+		log.Printf("Validation of '%s.%s' passed: %d rows, %d parts", v.Database, v.Table, v.RestoredRows, v.Parts)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("restored data doesn't match backup: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
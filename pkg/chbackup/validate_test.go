@@ -0,0 +1,16 @@
+package chbackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableValidationMismatch(t *testing.T) {
+	assert.Empty(t, TableValidation{ExpectedRows: 10, RestoredRows: 10, Parts: 2}.Mismatch())
+	// backups created by previous versions don't have rows
+	assert.Empty(t, TableValidation{RestoredRows: 10}.Mismatch())
+	assert.Equal(t, "5 rows restored, 10 rows in backup, 1 of 2 parts not found", TableValidation{ExpectedRows: 10, RestoredRows: 5, Parts: 2, MissingParts: 1}.Mismatch())
+	assert.Error(t, reportValidation([]TableValidation{{Database: "db", Table: "t", ExpectedRows: 1}}))
+	assert.NoError(t, reportValidation([]TableValidation{{Database: "db", Table: "t", ExpectedRows: 1, RestoredRows: 1}}))
+}
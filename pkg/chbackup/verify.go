@@ -0,0 +1,113 @@
+package chbackup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// VerifyConfig - scheduled restore verification settings section, verification is run by server
+type VerifyConfig struct {
+	// Interval - how often the latest remote backup is downloaded and restored by rehearsal, verification is disabled when it's empty
+	Interval string `yaml:"interval" envconfig:"VERIFY_INTERVAL"`
+	// Target - remote target where the latest backup is taken from, 'primary' when it's empty
+	Target string `yaml:"target" envconfig:"VERIFY_TARGET"`
+	// Tables - pattern of tables restored by rehearsal, all tables when it's empty
+	Tables string `yaml:"tables" envconfig:"VERIFY_TABLES"`
+	// KeepBackup - keep downloaded backups after verification, they are removed when it's false
+	KeepBackup bool `yaml:"keep_backup" envconfig:"VERIFY_KEEP_BACKUP"`
+}
+
+// validateVerifyConfig - check values of verify section, target must be defined in remote_targets
+func validateVerifyConfig(config VerifyConfig, remoteTargets map[string]RemoteTargetConfig) error {
+	if config.Interval != "" {
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return fmt.Errorf("can't parse verify.interval: %v", err)
+		}
+		if interval < time.Minute {
+			return fmt.Errorf("verify.interval must be at least 1m")
+		}
+	}
+	if _, ok := remoteTargets[config.Target]; !ok && config.Target != "" && config.Target != PrimaryTarget {
+		return fmt.Errorf("verify.target '%s' is not defined in remote_targets", config.Target)
+	}
+	if _, err := parseTablePattern(config.Tables); err != nil {
+		return fmt.Errorf("can't parse verify.tables: %v", err)
+	}
+	return nil
+}
+
+// getVerifyInterval - interval of scheduled verification, 0 when verification is disabled
+func getVerifyInterval(config VerifyConfig) time.Duration {
+	interval, _ := time.ParseDuration(config.Interval)
+	return interval
+}
+
+// getLatestBackup - the newest backup which is not broken, backups are sorted by date
+func getLatestBackup(backups []Backup) (Backup, bool) {
+	complete := getCompleteBackups(backups)
+	if len(complete) == 0 {
+		return Backup{}, false
+	}
+	return complete[len(complete)-1], true
+}
+
+// VerifyLatestBackup - download the latest backup of verify.target unless it exists locally and restore it by rehearsal,
+// downloaded backups are removed after verification unless verify.keep_backup is set. Return name of verified backup
+func VerifyLatestBackup(ctx context.Context, config Config) (string, error) {
+	targets, err := GetRemoteTargets(config, config.Verify.Target)
+	if err != nil {
+		return "", err
+	}
+	targetConfig := targets[0].Config
+	if targetConfig.General.RemoteStorage == "none" {
+		return "", fmt.Errorf("remote storage of verify.target is 'none'")
+	}
+	remoteBackups, err := getRemoteBackups(targetConfig)
+	if err != nil {
+		return "", fmt.Errorf("can't get remote backups: %v", err)
+	}
+	latest, ok := getLatestBackup(remoteBackups)
+	if !ok {
+		return "", fmt.Errorf("remote target '%s' doesn't have complete backups", targets[0].Name)
+	}
+	backupName := trimArchiveExtension(latest.Name)
+	localBackups, err := ListLocalBackups(config)
+	if err != nil {
+		return backupName, err
+	}
+	existing := map[string]bool{}
+	for _, b := range localBackups {
+		existing[b.Name] = true
+	}
+	log.Printf("Verify '%s' from remote target '%s'", backupName, targets[0].Name)
+	if !existing[backupName] {
+		if err := Download(ctx, targetConfig, backupName, config.Verify.Tables, false); err != nil {
+			return backupName, fmt.Errorf("can't download '%s': %v", backupName, err)
+		}
+	}
+	_, err = Restore(ctx, config, backupName, config.Verify.Tables, RestoreOptions{Rehearsal: true})
+	if !config.Verify.KeepBackup {
+		removeDownloadedBackups(config, existing)
+	}
+	return backupName, err
+}
+
+// removeDownloadedBackups - remove local backups which don't exist in existing, i.e. downloaded backup and backups required by it
+func removeDownloadedBackups(config Config, existing map[string]bool) {
+	localBackups, err := ListLocalBackups(config)
+	if err != nil {
+		log.Printf("can't list local backups: %v", err)
+		return
+	}
+	for _, b := range localBackups {
+		if existing[b.Name] {
+			continue
+		}
+		if err := RemoveBackupLocal(config, b.Name, true); err != nil {
+			log.Printf("can't remove '%s': %v", b.Name, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package chbackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLatestBackup(t *testing.T) {
+	now := time.Now()
+	backups := []Backup{
+		{Name: "full.tar.gz", Date: now.Add(-2 * time.Hour)},
+		{Name: "diff.tar.gz", Date: now.Add(-time.Hour), RequiredBackup: "full"},
+		{Name: "broken.tar.gz", Date: now, Broken: "upload is not completed"},
+	}
+	latest, ok := getLatestBackup(backups)
+	assert.True(t, ok)
+	assert.Equal(t, "diff.tar.gz", latest.Name)
+	_, ok = getLatestBackup(backups[2:])
+	assert.False(t, ok)
+}
+
+func TestValidateVerifyConfig(t *testing.T) {
+	targets := map[string]RemoteTargetConfig{"dr": {}}
+	assert.NoError(t, validateVerifyConfig(VerifyConfig{}, nil))
+	assert.NoError(t, validateVerifyConfig(VerifyConfig{Interval: "24h", Target: "dr"}, targets))
+	assert.Error(t, validateVerifyConfig(VerifyConfig{Interval: "10s"}, nil))
+	assert.Error(t, validateVerifyConfig(VerifyConfig{Target: "missing"}, targets))
+	assert.Equal(t, time.Duration(0), getVerifyInterval(VerifyConfig{}))
+}
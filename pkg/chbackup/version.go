@@ -0,0 +1,71 @@
+package chbackup
+
+import (
+	"fmt"
+	"os"
+)
+
+// BuildInfo - version of binary, it's set by main package from build flags
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// Build - version of running binary
+var Build = BuildInfo{Version: "unknown", GitCommit: "unknown", BuildDate: "unknown"}
+
+// LatestBackup - name and creation time of the newest backup which is not broken
+type LatestBackup struct {
+	Name    string `json:"name"`
+	Created string `json:"created"`
+	Target  string `json:"target,omitempty"`
+}
+
+// VersionInfo - build version, supported remote storages and the latest backups of host
+type VersionInfo struct {
+	BuildInfo
+	RemoteStorages     []string      `json:"remote_storages"`
+	LatestLocalBackup  *LatestBackup `json:"latest_local_backup"`
+	LatestRemoteBackup *LatestBackup `json:"latest_remote_backup"`
+}
+
+// GetVersionInfo - version of binary with the latest local backup and the latest backup of remote targets selected by target,
+// latest_remote_backup is null when remote storage is 'none'
+func GetVersionInfo(config Config, target string) (*VersionInfo, error) {
+	info := &VersionInfo{
+		BuildInfo:      Build,
+		RemoteStorages: RemoteStorages(),
+	}
+	localBackups, err := ListLocalBackups(config)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't list local backups: %v", err)
+	}
+	if latest, ok := getLatestBackup(localBackups); ok {
+		info.LatestLocalBackup = &LatestBackup{Name: latest.Name, Created: latest.Date.Format(APITimeFormat)}
+	}
+	targets, err := GetRemoteTargets(config, target)
+	if err != nil {
+		return nil, err
+	}
+	var latestRemote Backup
+	for _, t := range targets {
+		if t.Config.General.RemoteStorage == "none" {
+			continue
+		}
+		remoteBackups, err := getRemoteBackups(t.Config)
+		if err != nil {
+			return nil, fmt.Errorf("can't get remote backups of '%s': %v", t.Name, err)
+		}
+		latest, ok := getLatestBackup(remoteBackups)
+		if !ok || (info.LatestRemoteBackup != nil && !latest.Date.After(latestRemote.Date)) {
+			continue
+		}
+		latestRemote = latest
+		info.LatestRemoteBackup = &LatestBackup{Name: trimArchiveExtension(latest.Name), Created: latest.Date.Format(APITimeFormat)}
+		if t.Name != PrimaryTarget {
+			info.LatestRemoteBackup.Target = t.Name
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,50 @@
+package chbackup
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// copyXattrs - copy extended attributes of src to dst, they include POSIX ACLs (system.posix_acl_*) and SELinux context
+// (security.selinux). Nothing is copied when filesystem doesn't support extended attributes
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil {
+		if err == syscall.ENOTSUP {
+			return nil
+		}
+		return fmt.Errorf("can't list extended attributes of '%s': %v", src, err)
+	}
+	if size == 0 {
+		return nil
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Listxattr(src, names); err != nil {
+		return fmt.Errorf("can't list extended attributes of '%s': %v", src, err)
+	}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		if err := copyXattr(src, dst, string(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyXattr(src, dst, name string) error {
+	size, err := syscall.Getxattr(src, name, nil)
+	if err != nil {
+		return fmt.Errorf("can't get extended attribute '%s' of '%s': %v", name, src, err)
+	}
+	value := make([]byte, size)
+	if size, err = syscall.Getxattr(src, name, value); err != nil {
+		return fmt.Errorf("can't get extended attribute '%s' of '%s': %v", name, src, err)
+	}
+	if err := syscall.Setxattr(dst, name, value[:size], 0); err != nil && err != syscall.ENOTSUP {
+		return fmt.Errorf("can't set extended attribute '%s' of '%s': %v", name, dst, err)
+	}
+	return nil
+}
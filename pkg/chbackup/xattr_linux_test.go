@@ -0,0 +1,34 @@
+package chbackup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattrs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src, dst := path.Join(dir, "data.bin"), path.Join(dir, "copy", "data.bin")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("data"), 0644))
+	if err := syscall.Setxattr(src, "user.clickhouse_backup", []byte("label"), 0); err != nil {
+		t.Skipf("extended attributes aren't supported: %v", err)
+	}
+
+	assert.NoError(t, copyFile(src, dst))
+	_, err = syscall.Getxattr(dst, "user.clickhouse_backup", make([]byte, 16))
+	assert.Equal(t, syscall.ENODATA, err)
+
+	setBackupModes(GeneralConfig{PreserveXattrs: true})
+	defer setBackupModes(GeneralConfig{})
+	assert.NoError(t, copyFile(src, dst))
+	value := make([]byte, 16)
+	size, err := syscall.Getxattr(dst, "user.clickhouse_backup", value)
+	assert.NoError(t, err)
+	assert.Equal(t, "label", string(value[:size]))
+}
@@ -0,0 +1,8 @@
+// +build !linux
+
+package chbackup
+
+// copyXattrs - extended attributes are copied on Linux only
+func copyXattrs(src, dst string) error {
+	return nil
+}
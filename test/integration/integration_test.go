@@ -249,6 +249,7 @@ func TestIntegrationS3(t *testing.T) {
 	r.NoError(dockerCP("config-s3.yml", "/etc/clickhouse-backup/config.yml"))
 	testRestoreLegacyBackupFormat(t)
 	testCommon(t)
+	testProjectionsAndIndices(t)
 }
 
 func TestIntegrationGCS(t *testing.T) {
@@ -336,6 +337,49 @@ func testCommon(t *testing.T) {
 	r.NoError(dockerExec("clickhouse-backup", "delete", "remote", "increment.tar.gz"))
 }
 
+// testProjectionsAndIndices - projections and data skipping indices are restored with schema and parts of table
+func testProjectionsAndIndices(t *testing.T) {
+	ch := &TestClickHouse{}
+	r := require.New(t)
+	r.NoError(ch.connect())
+	version, err := ch.chbackup.GetVersion()
+	r.NoError(err)
+	if version < 21006000 {
+		fmt.Printf("Skip projections, they are not supported by ClickHouse %d\n", version)
+		return
+	}
+	r.NoError(ch.dropDatabase(dbName))
+	data := TestDataStruct{
+		Database: dbName,
+		Table:    "projections",
+		Schema: "(id UInt64, category String, amount Float64, INDEX category_idx category TYPE set(100) GRANULARITY 1, " +
+			"PROJECTION by_category (SELECT category, sum(amount) GROUP BY category)) ENGINE = MergeTree() ORDER BY id",
+		Rows: []map[string]interface{}{
+			{"id": uint64(1), "category": "a", "amount": 1.0},
+			{"id": uint64(2), "category": "b", "amount": 2.0},
+		},
+		Fields:  []string{"id", "category", "amount"},
+		OrderBy: "id",
+	}
+	r.NoError(ch.createTestData(data))
+	r.NoError(dockerExec("clickhouse-backup", "create", "-t", dbName+".projections", "projections"))
+	r.NoError(ch.dropDatabase(dbName))
+	r.NoError(dockerExec("clickhouse-backup", "restore", "projections"))
+	r.NoError(ch.checkData(t, data))
+
+	var query []string
+	r.NoError(ch.chbackup.GetConn().Select(&query, fmt.Sprintf("SELECT create_table_query FROM system.tables WHERE database = '%s' AND name = 'projections'", dbName)))
+	r.Len(query, 1)
+	r.Contains(query[0], "INDEX category_idx")
+	r.Contains(query[0], "PROJECTION by_category")
+	var projectionParts []uint64
+	r.NoError(ch.chbackup.GetConn().Select(&projectionParts, fmt.Sprintf("SELECT count() FROM system.projection_parts WHERE database = '%s' AND table = 'projections' AND active", dbName)))
+	r.NotZero(projectionParts[0])
+
+	r.NoError(ch.dropDatabase(dbName))
+	r.NoError(dockerExec("/bin/rm", "-rf", "/var/lib/clickhouse/backup/projections"))
+}
+
 type TestClickHouse struct {
 	chbackup *chbackup.ClickHouse
 }